@@ -0,0 +1,471 @@
+// Package backup exports every entity of the store as JSON lines and restores them, keeping
+// their keys. The first line is a Header, every other line a Record. Values keep their datastore
+// type, see Value, so a restore stores the entities exactly as they were.
+//
+// Uploaded files aren't part of a backup, only their records are; the media archive carries the
+// files, see the mediaarchive package.
+package backup
+
+import (
+	originalDataStore "cloud.google.com/go/datastore"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/search_api"
+	"github.com/jcarm010/kodimerce/uploads"
+	"google.golang.org/api/iterator"
+	"io"
+	"time"
+)
+
+const (
+	Format  = "kodimerce-backup"
+	Version = 1
+
+	ConflictSkip      = "skip"      // entities that exist already are left as they are
+	ConflictOverwrite = "overwrite" // entities that exist already are replaced
+	ConflictRemap     = "remap"     // entities with an id that is taken get a new one, references to them follow
+
+	batchSize = 100
+)
+
+// Kinds are the kinds in a backup, in the order they are written. Kinds come after the kinds they
+// reference, so remapped ids are known by the time the references are restored.
+var Kinds = []string{
+	entities.EntityServerSettings,
+	entities.EntityUser,
+	entities.EntityBlob,
+	entities.EntityCategory,
+	entities.EntityProduct,
+	entities.EntityCategoryProduct,
+	entities.EntityPost,
+	entities.EntityPage,
+	entities.EntityGallery,
+	entities.EntityOrder,
+}
+
+// Secrets are the properties left out of backups made without secrets, by kind.
+var Secrets = map[string][]string{
+	entities.EntityUser: {"password_hash"},
+	entities.EntityServerSettings: {
+		"PayPalApiClientSecret",
+		"SmartyStreetsAuthToken",
+		"SMTPPassword",
+		"SendGridKey",
+		"OIDCClientSecret",
+		"MetricsToken",
+		"SigningSecret",
+		"CronToken",
+	},
+}
+
+// references are the properties holding ids of other entities, by kind, along with the kind they
+// reference. Orders keep the product snapshots they were placed with as they are.
+var references = map[string]map[string]string{
+	entities.EntityCategoryProduct: {"category_id": entities.EntityCategory, "product_id": entities.EntityProduct},
+	entities.EntityOrder:           {"product_ids": entities.EntityProduct},
+}
+
+var ErrInvalidBackup = errors.New("The file is not a backup.")
+
+// store is where Import restores the entities.
+type store interface {
+	GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error
+	PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error)
+	PutSchemaVersion(ctx context.Context, version *entities.SchemaVersion) error
+	RebuildReferences(ctx context.Context) error
+}
+
+type datastoreStore struct{}
+
+func (datastoreStore) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	return datastore.GetMulti(ctx, keys, dst)
+}
+
+func (datastoreStore) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	return datastore.PutMulti(ctx, keys, src)
+}
+
+func (datastoreStore) PutSchemaVersion(ctx context.Context, version *entities.SchemaVersion) error {
+	return entities.PutSchemaVersion(ctx, version)
+}
+
+func (datastoreStore) RebuildReferences(ctx context.Context) error {
+	return uploads.RebuildReferences(ctx)
+}
+
+type Header struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	Secrets  bool      `json:"secrets"` // false when the Secrets were left out
+	Kinds    []string  `json:"kinds"`
+}
+
+type Record struct {
+	Key        *Key        `json:"key"`
+	Properties []*Property `json:"properties"`
+}
+
+type ExportOptions struct {
+	ExcludeSecrets bool
+}
+
+type ImportOptions struct {
+	Conflict string // ConflictSkip when empty
+}
+
+type ExportResult struct {
+	Entities map[string]int `json:"entities"` // by kind
+}
+
+type KindResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Remapped int `json:"remapped"` // imported with a new id
+}
+
+type ImportResult struct {
+	Kinds map[string]*KindResult `json:"kinds"`
+}
+
+// ValidConflict tells whether conflict is one of the conflict policies.
+func ValidConflict(conflict string) bool {
+	return conflict == ConflictSkip || conflict == ConflictOverwrite || conflict == ConflictRemap
+}
+
+// Export writes the header and every entity of the Kinds to w.
+func Export(ctx context.Context, w io.Writer, options ExportOptions) (*ExportResult, error) {
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(&Header{
+		Format:   Format,
+		Version:  Version,
+		Exported: time.Now(),
+		Secrets:  !options.ExcludeSecrets,
+		Kinds:    Kinds,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExportResult{Entities: map[string]int{}}
+	for _, kind := range Kinds {
+		t := datastore.Run(ctx, datastore.NewQuery(kind))
+		for {
+			var properties originalDataStore.PropertyList
+			key, err := t.Next(&properties)
+			if err == iterator.Done {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if options.ExcludeSecrets {
+				properties = withoutSecrets(kind, properties)
+			}
+
+			encoded, err := encodeProperties(properties)
+			if err != nil {
+				return nil, fmt.Errorf("%s %v: %s", kind, key, err)
+			}
+
+			err = encoder.Encode(&Record{Key: encodeKey(key), Properties: encoded})
+			if err != nil {
+				return nil, err
+			}
+
+			result.Entities[kind]++
+		}
+	}
+
+	return result, nil
+}
+
+func withoutSecrets(kind string, properties originalDataStore.PropertyList) originalDataStore.PropertyList {
+	kept := make(originalDataStore.PropertyList, 0, len(properties))
+	for _, property := range properties {
+		if !isSecret(kind, property.Name) {
+			kept = append(kept, property)
+		}
+	}
+
+	return kept
+}
+
+func isSecret(kind string, name string) bool {
+	for _, secret := range Secrets[kind] {
+		if secret == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Import restores the entities of a backup read from r. Entities that exist already are handled
+// by the conflict policy of the options. When the backup was made without secrets, overwritten
+// entities keep the secrets they had.
+func Import(ctx context.Context, r io.Reader, options ImportOptions) (*ImportResult, error) {
+	return importInto(ctx, datastoreStore{}, r, options)
+}
+
+func importInto(ctx context.Context, s store, r io.Reader, options ImportOptions) (*ImportResult, error) {
+	if options.Conflict == "" {
+		options.Conflict = ConflictSkip
+	}
+
+	if !ValidConflict(options.Conflict) {
+		return nil, fmt.Errorf("Unknown conflict policy %q.", options.Conflict)
+	}
+
+	decoder := json.NewDecoder(r)
+	header := &Header{}
+	err := decoder.Decode(header)
+	if err != nil || header.Format != Format {
+		return nil, ErrInvalidBackup
+	}
+
+	if header.Version > Version {
+		return nil, fmt.Errorf("Backups of version %d are not supported, the latest is %d.", header.Version, Version)
+	}
+
+	known := map[string]bool{}
+	for _, kind := range Kinds {
+		known[kind] = true
+	}
+
+	restore := &restorer{
+		store:   s,
+		header:  header,
+		options: options,
+		ids:     map[string]map[int64]int64{},
+		result:  &ImportResult{Kinds: map[string]*KindResult{}},
+	}
+
+	batch := make([]*Record, 0, batchSize)
+	for line := 2; ; line++ {
+		record := &Record{}
+		err = decoder.Decode(record)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line, err)
+		}
+
+		if record.Key == nil || !known[record.Key.Kind] {
+			return nil, fmt.Errorf("Line %d: not an entity of a backup.", line)
+		}
+
+		if len(batch) > 0 && (len(batch) == batchSize || batch[0].Key.Kind != record.Key.Kind) {
+			err = restore.put(ctx, batch)
+			if err != nil {
+				return nil, err
+			}
+
+			batch = batch[:0]
+		}
+
+		batch = append(batch, record)
+	}
+
+	if len(batch) > 0 {
+		err = restore.put(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(restore.result.Kinds) > 0 {
+		// the backup may predate migrations that ran since, they are idempotent so they all run again
+		err = s.PutSchemaVersion(ctx, &entities.SchemaVersion{})
+		if err != nil {
+			log.Errorf(ctx, "Error resetting the schema version: %+v", err)
+		}
+	}
+
+	if restore.result.Kinds[entities.EntityBlob] != nil {
+		err = search_api.NewClient(ctx).Rebuild()
+		if err != nil {
+			log.Errorf(ctx, "Error rebuilding the upload index: %+v", err)
+		}
+	}
+
+	for _, kind := range []string{entities.EntityProduct, entities.EntityPost, entities.EntityPage, entities.EntityGallery} {
+		if restore.result.Kinds[kind] != nil {
+			err = s.RebuildReferences(ctx)
+			if err != nil {
+				log.Errorf(ctx, "Error rebuilding the upload references: %+v", err)
+			}
+
+			break
+		}
+	}
+
+	return restore.result, nil
+}
+
+type restorer struct {
+	store   store
+	header  *Header
+	options ImportOptions
+	ids     map[string]map[int64]int64 // remapped ids, by kind
+	result  *ImportResult
+}
+
+// put restores a batch of records of the same kind.
+func (r *restorer) put(ctx context.Context, batch []*Record) error {
+	kind := batch[0].Key.Kind
+	result := r.result.Kinds[kind]
+	if result == nil {
+		result = &KindResult{}
+		r.result.Kinds[kind] = result
+	}
+
+	keys := make([]*datastore.Key, len(batch))
+	lists := make([]originalDataStore.PropertyList, len(batch))
+	for index, record := range batch {
+		properties, err := decodeProperties(record.Properties)
+		if err != nil {
+			return fmt.Errorf("%s %v: %s", kind, record.Key, err)
+		}
+
+		lists[index] = r.remapReferences(kind, properties)
+		keys[index] = (*datastore.Key)(decodeKey(record.Key))
+		if kind == entities.EntityCategoryProduct {
+			keys[index].Name = categoryProductName(lists[index], keys[index].Name)
+		}
+	}
+
+	existing := make([]originalDataStore.PropertyList, len(batch))
+	err := r.store.GetMulti(ctx, keys, existing)
+	errs, _ := err.(originalDataStore.MultiError)
+	if err != nil && errs == nil {
+		return err
+	}
+
+	putKeys := make([]*datastore.Key, 0, len(batch))
+	putLists := make([]originalDataStore.PropertyList, 0, len(batch))
+	oldIds := make([]int64, 0, len(batch))
+	for index, key := range keys {
+		exists := true
+		if errs != nil && errs[index] == datastore.ErrNoSuchEntity {
+			exists = false
+		} else if errs != nil && errs[index] != nil {
+			return errs[index]
+		}
+
+		properties := lists[index]
+		oldId := int64(0)
+		switch {
+		case !exists:
+		case r.options.Conflict == ConflictOverwrite:
+			if !r.header.Secrets {
+				properties = append(properties, secretsOf(kind, existing[index])...)
+			}
+		case r.options.Conflict == ConflictRemap && key.Name == "":
+			oldId = key.ID
+			key = datastore.NewIncompleteKey(ctx, kind, (*datastore.Key)(key.Parent))
+		default:
+			result.Skipped++
+			continue
+		}
+
+		putKeys = append(putKeys, key)
+		putLists = append(putLists, properties)
+		oldIds = append(oldIds, oldId)
+	}
+
+	if len(putKeys) == 0 {
+		return nil
+	}
+
+	putKeys, err = r.store.PutMulti(ctx, putKeys, putLists)
+	if err != nil {
+		return err
+	}
+
+	for index, key := range putKeys {
+		result.Imported++
+		if oldIds[index] != 0 {
+			result.Remapped++
+			if r.ids[kind] == nil {
+				r.ids[kind] = map[int64]int64{}
+			}
+
+			r.ids[kind][oldIds[index]] = key.ID
+		}
+	}
+
+	return nil
+}
+
+// remapReferences points the references of an entity to the new ids of the entities they reference.
+func (r *restorer) remapReferences(kind string, properties originalDataStore.PropertyList) originalDataStore.PropertyList {
+	for index, property := range properties {
+		referenced, ok := references[kind][property.Name]
+		if !ok || len(r.ids[referenced]) == 0 {
+			continue
+		}
+
+		switch value := property.Value.(type) {
+		case int64:
+			properties[index].Value = r.remapId(referenced, value)
+		case []interface{}:
+			for i, item := range value {
+				if id, ok := item.(int64); ok {
+					value[i] = r.remapId(referenced, id)
+				}
+			}
+		}
+	}
+
+	return properties
+}
+
+func (r *restorer) remapId(kind string, id int64) int64 {
+	if newId, ok := r.ids[kind][id]; ok {
+		return newId
+	}
+
+	return id
+}
+
+// categoryProductName is the name a category product is stored with, made from its ids like
+// entities.SetCategoryProducts does.
+func categoryProductName(properties originalDataStore.PropertyList, name string) string {
+	var categoryId, productId interface{}
+	for _, property := range properties {
+		switch property.Name {
+		case "category_id":
+			categoryId = property.Value
+		case "product_id":
+			productId = property.Value
+		}
+	}
+
+	if categoryId == nil || productId == nil {
+		return name
+	}
+
+	return fmt.Sprintf("%v_%v", categoryId, productId)
+}
+
+func secretsOf(kind string, properties originalDataStore.PropertyList) originalDataStore.PropertyList {
+	secrets := make(originalDataStore.PropertyList, 0)
+	for _, property := range properties {
+		if isSecret(kind, property.Name) {
+			secrets = append(secrets, property)
+		}
+	}
+
+	return secrets
+}
@@ -0,0 +1,266 @@
+package backup
+
+import (
+	"bytes"
+	originalDataStore "cloud.google.com/go/datastore"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	entities          map[string]originalDataStore.PropertyList
+	nextId            int64
+	referenceRebuilds int
+}
+
+func keyString(key *datastore.Key) string {
+	return fmt.Sprintf("%s/%d/%s", key.Kind, key.ID, key.Name)
+}
+
+func newStore() *fakeStore {
+	return &fakeStore{entities: map[string]originalDataStore.PropertyList{}, nextId: 1000}
+}
+
+func (f *fakeStore) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	lists := dst.([]originalDataStore.PropertyList)
+	errs := make(originalDataStore.MultiError, len(keys))
+	missing := false
+	for index, key := range keys {
+		properties, exists := f.entities[keyString(key)]
+		if !exists {
+			errs[index] = datastore.ErrNoSuchEntity
+			missing = true
+			continue
+		}
+
+		lists[index] = properties
+	}
+
+	if missing {
+		return errs
+	}
+
+	return nil
+}
+
+func (f *fakeStore) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	lists := src.([]originalDataStore.PropertyList)
+	for index, key := range keys {
+		if key.ID == 0 && key.Name == "" {
+			f.nextId++
+			key.ID = f.nextId
+		}
+
+		f.entities[keyString(key)] = lists[index]
+	}
+
+	return keys, nil
+}
+
+func (f *fakeStore) PutSchemaVersion(ctx context.Context, version *entities.SchemaVersion) error {
+	return nil
+}
+
+func (f *fakeStore) RebuildReferences(ctx context.Context) error {
+	f.referenceRebuilds++
+	return nil
+}
+
+func record(t *testing.T, kind string, id int64, name string, properties ...originalDataStore.Property) *Record {
+	encoded, err := encodeProperties(properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Record{Key: &Key{Kind: kind, Id: id, Name: name}, Properties: encoded}
+}
+
+func backupFile(t *testing.T, secrets bool, records ...*Record) *bytes.Buffer {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	if err := encoder.Encode(&Header{Format: Format, Version: Version, Secrets: secrets, Kinds: Kinds}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &buf
+}
+
+func property(name string, value interface{}) originalDataStore.Property {
+	return originalDataStore.Property{Name: name, Value: value}
+}
+
+func value(properties originalDataStore.PropertyList, name string) interface{} {
+	for _, p := range properties {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+
+	return nil
+}
+
+func TestValuesRoundTrip(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 30, 0, 123, time.UTC)
+	parent := &originalDataStore.Key{Kind: "order", ID: 7}
+	properties := originalDataStore.PropertyList{
+		{Name: "nothing", Value: nil},
+		{Name: "count", Value: int64(1) << 60},
+		{Name: "price", Value: 12.5},
+		{Name: "active", Value: true, NoIndex: true},
+		{Name: "name", Value: "Red shirt"},
+		{Name: "created", Value: now},
+		{Name: "data", Value: []byte{0, 1, 255}},
+		{Name: "owner", Value: &originalDataStore.Key{Kind: "user", Name: "ana@example.com", Parent: parent}},
+		{Name: "where", Value: originalDataStore.GeoPoint{Lat: 1.5, Lng: -2}},
+		{Name: "ids", Value: []interface{}{int64(1), "two", nil}},
+		{Name: "address", Value: &originalDataStore.Entity{Properties: []originalDataStore.Property{{Name: "city", Value: "Miami"}}}},
+	}
+
+	encoded, err := encodeProperties(properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := json.Marshal(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var read []*Property
+	if err := json.Unmarshal(bts, &read); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeProperties(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, properties) {
+		t.Errorf("got %+v, want %+v", decoded, properties)
+	}
+
+	if _, err := encodeValue(int32(1)); err == nil {
+		t.Errorf("an unsupported type was encoded")
+	}
+}
+
+func TestWithoutSecrets(t *testing.T) {
+	properties := originalDataStore.PropertyList{property("CompanyName", "Shop"), property("SMTPPassword", "hunter2")}
+	kept := withoutSecrets(entities.EntityServerSettings, properties)
+	if len(kept) != 1 || kept[0].Name != "CompanyName" {
+		t.Errorf("got %+v", kept)
+	}
+
+	if kept := withoutSecrets(entities.EntityProduct, properties); len(kept) != 2 {
+		t.Errorf("secrets of another kind were removed: %+v", kept)
+	}
+}
+
+func TestImportSkipsExisting(t *testing.T) {
+	store := newStore()
+	store.entities["product/1/"] = originalDataStore.PropertyList{property("name", "Current")}
+	file := backupFile(t, true,
+		record(t, entities.EntityProduct, 1, "", property("name", "Backed up")),
+		record(t, entities.EntityProduct, 2, "", property("name", "New")),
+	)
+
+	result, err := importInto(context.Background(), store, file, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := result.Kinds[entities.EntityProduct]; *got != (KindResult{Imported: 1, Skipped: 1}) {
+		t.Errorf("got %+v", got)
+	}
+
+	if value(store.entities["product/1/"], "name") != "Current" || value(store.entities["product/2/"], "name") != "New" {
+		t.Errorf("got %+v", store.entities)
+	}
+
+	if store.referenceRebuilds != 1 {
+		t.Errorf("rebuilt the upload references %d times after restoring products, want once", store.referenceRebuilds)
+	}
+}
+
+func TestImportOverwriteKeepsSecrets(t *testing.T) {
+	store := newStore()
+	store.entities["server-settings/0/settings"] = originalDataStore.PropertyList{property("CompanyName", "Old"), property("SMTPPassword", "hunter2")}
+	file := backupFile(t, false, record(t, entities.EntityServerSettings, 0, "settings", property("CompanyName", "New")))
+
+	if _, err := importInto(context.Background(), store, file, ImportOptions{Conflict: ConflictOverwrite}); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := store.entities["server-settings/0/settings"]
+	if value(settings, "CompanyName") != "New" || value(settings, "SMTPPassword") != "hunter2" {
+		t.Errorf("got %+v", settings)
+	}
+}
+
+func TestImportRemapsReferences(t *testing.T) {
+	store := newStore()
+	store.entities["product/5/"] = originalDataStore.PropertyList{property("name", "Someone else's product")}
+	file := backupFile(t, true,
+		record(t, entities.EntityCategory, 3, "", property("name", "Shirts")),
+		record(t, entities.EntityProduct, 5, "", property("name", "Red shirt")),
+		record(t, entities.EntityCategoryProduct, 0, "3_5", property("category_id", int64(3)), property("product_id", int64(5))),
+		record(t, entities.EntityOrder, 9, "", property("product_ids", []interface{}{int64(5), int64(6)})),
+	)
+
+	result, err := importInto(context.Background(), store, file, ImportOptions{Conflict: ConflictRemap})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := result.Kinds[entities.EntityProduct]; *got != (KindResult{Imported: 1, Remapped: 1}) {
+		t.Fatalf("got %+v", got)
+	}
+
+	if value(store.entities["product/5/"], "name") != "Someone else's product" || value(store.entities["product/1001/"], "name") != "Red shirt" {
+		t.Errorf("the product was not imported under a new id: %+v", store.entities)
+	}
+
+	link, exists := store.entities["category_product/0/3_1001"]
+	if !exists || value(link, "product_id") != int64(1001) || value(link, "category_id") != int64(3) {
+		t.Errorf("the category product was not remapped: %+v", store.entities)
+	}
+
+	if ids := value(store.entities["order/9/"], "product_ids"); !reflect.DeepEqual(ids, []interface{}{int64(1001), int64(6)}) {
+		t.Errorf("got order product ids %v", ids)
+	}
+}
+
+func TestImportRejectsInvalidFiles(t *testing.T) {
+	store := newStore()
+	tests := map[string]string{
+		"not json":      "hello",
+		"other format":  `{"format":"other","version":1}`,
+		"newer version": `{"format":"kodimerce-backup","version":99}`,
+		"unknown kind":  `{"format":"kodimerce-backup","version":1}` + "\n" + `{"key":{"kind":"secret_stuff","id":1},"properties":[]}`,
+		"bad value":     `{"format":"kodimerce-backup","version":1}` + "\n" + `{"key":{"kind":"product","id":1},"properties":[{"name":"a","type":"weird"}]}`,
+	}
+
+	for name, content := range tests {
+		if _, err := importInto(context.Background(), store, strings.NewReader(content), ImportOptions{}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	if _, err := importInto(context.Background(), store, backupFile(t, true), ImportOptions{Conflict: "merge"}); err == nil {
+		t.Errorf("an unknown conflict policy was accepted")
+	}
+}
@@ -0,0 +1,233 @@
+package backup
+
+import (
+	originalDataStore "cloud.google.com/go/datastore"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Value types, the datastore types a property can hold.
+const (
+	TypeNull   = "null"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeString = "string"
+	TypeTime   = "time"
+	TypeBlob   = "blob"
+	TypeKey    = "key"
+	TypeGeo    = "geo"
+	TypeEntity = "entity"
+	TypeArray  = "array"
+)
+
+// Key is a datastore key. Keys have either an Id or a Name.
+type Key struct {
+	Kind      string `json:"kind"`
+	Id        int64  `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Parent    *Key   `json:"parent,omitempty"`
+}
+
+// Value is a property value along with its type, so numbers, times and blobs come back as they
+// were stored.
+type Value struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"value,omitempty"`
+}
+
+type Property struct {
+	Name    string `json:"name"`
+	NoIndex bool   `json:"noindex,omitempty"`
+	Value
+}
+
+// Entity is the value of a property holding a struct.
+type Entity struct {
+	Key        *Key        `json:"key,omitempty"`
+	Properties []*Property `json:"properties"`
+}
+
+func encodeKey(key *originalDataStore.Key) *Key {
+	if key == nil {
+		return nil
+	}
+
+	return &Key{
+		Kind:      key.Kind,
+		Id:        key.ID,
+		Name:      key.Name,
+		Namespace: key.Namespace,
+		Parent:    encodeKey(key.Parent),
+	}
+}
+
+func decodeKey(key *Key) *originalDataStore.Key {
+	if key == nil {
+		return nil
+	}
+
+	return &originalDataStore.Key{
+		Kind:      key.Kind,
+		ID:        key.Id,
+		Name:      key.Name,
+		Namespace: key.Namespace,
+		Parent:    decodeKey(key.Parent),
+	}
+}
+
+func encodeProperties(properties []originalDataStore.Property) ([]*Property, error) {
+	encoded := make([]*Property, 0, len(properties))
+	for _, property := range properties {
+		value, err := encodeValue(property.Value)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %s", property.Name, err)
+		}
+
+		encoded = append(encoded, &Property{Name: property.Name, NoIndex: property.NoIndex, Value: value})
+	}
+
+	return encoded, nil
+}
+
+func decodeProperties(properties []*Property) (originalDataStore.PropertyList, error) {
+	decoded := make(originalDataStore.PropertyList, 0, len(properties))
+	for _, property := range properties {
+		value, err := decodeValue(property.Value)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %s", property.Name, err)
+		}
+
+		decoded = append(decoded, originalDataStore.Property{Name: property.Name, NoIndex: property.NoIndex, Value: value})
+	}
+
+	return decoded, nil
+}
+
+func encodeValue(v interface{}) (Value, error) {
+	var t string
+	var data interface{} = v
+	switch value := v.(type) {
+	case nil:
+		return Value{Type: TypeNull}, nil
+	case int64:
+		t = TypeInt
+	case float64:
+		t = TypeFloat
+	case bool:
+		t = TypeBool
+	case string:
+		t = TypeString
+	case time.Time:
+		t = TypeTime
+	case []byte:
+		t = TypeBlob
+	case *originalDataStore.Key:
+		t, data = TypeKey, encodeKey(value)
+	case originalDataStore.GeoPoint:
+		t = TypeGeo
+	case *originalDataStore.Entity:
+		properties, err := encodeProperties(value.Properties)
+		if err != nil {
+			return Value{}, err
+		}
+
+		t, data = TypeEntity, &Entity{Key: encodeKey(value.Key), Properties: properties}
+	case []interface{}:
+		values := make([]Value, 0, len(value))
+		for _, item := range value {
+			encoded, err := encodeValue(item)
+			if err != nil {
+				return Value{}, err
+			}
+
+			values = append(values, encoded)
+		}
+
+		t, data = TypeArray, values
+	default:
+		return Value{}, fmt.Errorf("unsupported type %T", v)
+	}
+
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{Type: t, Data: bts}, nil
+}
+
+func decodeValue(value Value) (interface{}, error) {
+	var err error
+	switch value.Type {
+	case TypeNull:
+		return nil, nil
+	case TypeInt:
+		var v int64
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeFloat:
+		var v float64
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeBool:
+		var v bool
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeString:
+		var v string
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeTime:
+		var v time.Time
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeBlob:
+		var v []byte
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeKey:
+		var v Key
+		err = json.Unmarshal(value.Data, &v)
+		return decodeKey(&v), err
+	case TypeGeo:
+		var v originalDataStore.GeoPoint
+		err = json.Unmarshal(value.Data, &v)
+		return v, err
+	case TypeEntity:
+		var v Entity
+		err = json.Unmarshal(value.Data, &v)
+		if err != nil {
+			return nil, err
+		}
+
+		properties, err := decodeProperties(v.Properties)
+		if err != nil {
+			return nil, err
+		}
+
+		return &originalDataStore.Entity{Key: decodeKey(v.Key), Properties: properties}, nil
+	case TypeArray:
+		var values []Value
+		err = json.Unmarshal(value.Data, &values)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded := make([]interface{}, 0, len(values))
+		for _, item := range values {
+			v, err := decodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+
+			decoded = append(decoded, v)
+		}
+
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", value.Type)
+	}
+}
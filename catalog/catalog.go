@@ -0,0 +1,78 @@
+// Package catalog moves products in and out of the store in bulk: a CSV file the catalog can be
+// edited in and imported back from, and the product feeds shopping channels read.
+package catalog
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/entities"
+	"sort"
+)
+
+// Currency is the currency prices are in, the one PayPal charges in.
+const Currency = "USD"
+
+// Catalog is every product along with the categories they are in.
+type Catalog struct {
+	Products   []*entities.Product
+	Categories []*entities.Category
+	// ProductCategories are the categories of each product, by product id.
+	ProductCategories map[int64][]*entities.Category
+}
+
+// Load reads the catalog, products sorted by name.
+func Load(ctx context.Context) (*Catalog, error) {
+	products, err := entities.ListProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := entities.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryProducts, err := entities.GetCategoryProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categoriesById := map[int64]*entities.Category{}
+	for _, category := range categories {
+		categoriesById[category.Id] = category
+	}
+
+	productCategories := map[int64][]*entities.Category{}
+	for _, cp := range categoryProducts {
+		if category, exists := categoriesById[cp.CategoryId]; exists {
+			productCategories[cp.ProductId] = append(productCategories[cp.ProductId], category)
+		}
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].Name < products[j].Name
+	})
+
+	return &Catalog{Products: products, Categories: categories, ProductCategories: productCategories}, nil
+}
+
+// CategoryByName returns the category called name, nil when there is none.
+func (c *Catalog) CategoryByName(name string) *entities.Category {
+	for _, category := range c.Categories {
+		if category.Name == name {
+			return category
+		}
+	}
+
+	return nil
+}
+
+// CategoryNames are the names of the categories of a product.
+func (c *Catalog) CategoryNames(productId int64) []string {
+	names := make([]string, 0)
+	for _, category := range c.ProductCategories[productId] {
+		names = append(names, category.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}
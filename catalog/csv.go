@@ -0,0 +1,592 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/productsearch"
+	"github.com/jcarm010/kodimerce/uploads"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Columns of the product CSV, in the order they are exported. Files may have any of them in any
+// order; path, or name to derive the path from, is what rows are matched to products by.
+const (
+	ColumnPath           = "path"
+	ColumnName           = "name"
+	ColumnPrice          = "price"
+	ColumnQuantity       = "quantity"
+	ColumnInfinite       = "infinite"
+	ColumnActive         = "active"
+	ColumnCategories     = "categories"
+	ColumnPricingOptions = "pricing_options"
+	ColumnPictures       = "pictures"
+	ColumnDescription    = "description"
+)
+
+// ListSeparator separates the values of the columns holding lists: categories, pictures and
+// pricing options, which are written as label=price.
+const ListSeparator = "|"
+
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+)
+
+var Columns = []string{
+	ColumnPath,
+	ColumnName,
+	ColumnPrice,
+	ColumnQuantity,
+	ColumnInfinite,
+	ColumnActive,
+	ColumnCategories,
+	ColumnPricingOptions,
+	ColumnPictures,
+	ColumnDescription,
+}
+
+var ErrInvalidRows = errors.New("Some rows are invalid, nothing was imported.")
+
+// field reads and writes the product field of a column. Categories aren't a product field, the
+// plan handles them.
+type field struct {
+	get func(p *entities.Product) string
+	set func(p *entities.Product, value string) error
+}
+
+var fields = map[string]*field{
+	ColumnName: {
+		get: func(p *entities.Product) string { return p.Name },
+		set: func(p *entities.Product, value string) error {
+			if value == "" {
+				return errors.New("the name can't be empty")
+			}
+
+			p.Name = value
+			return nil
+		},
+	},
+	ColumnPrice: {
+		get: func(p *entities.Product) string { return formatPrice(p.PriceCents) },
+		set: func(p *entities.Product, value string) (err error) {
+			p.PriceCents, err = parsePrice(value)
+			return err
+		},
+	},
+	ColumnQuantity: {
+		get: func(p *entities.Product) string { return strconv.Itoa(p.Quantity) },
+		set: func(p *entities.Product, value string) (err error) {
+			if value == "" {
+				p.Quantity = 0
+				return nil
+			}
+
+			p.Quantity, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	ColumnInfinite: {
+		get: func(p *entities.Product) string { return strconv.FormatBool(p.IsInfinite) },
+		set: func(p *entities.Product, value string) (err error) {
+			p.IsInfinite, err = parseBool(value)
+			return err
+		},
+	},
+	ColumnActive: {
+		get: func(p *entities.Product) string { return strconv.FormatBool(p.Active) },
+		set: func(p *entities.Product, value string) (err error) {
+			p.Active, err = parseBool(value)
+			return err
+		},
+	},
+	ColumnPricingOptions: {
+		get: func(p *entities.Product) string {
+			options := make([]string, 0, len(p.PricingOptions))
+			for _, option := range p.PricingOptions {
+				options = append(options, option.Label+"="+formatPrice(option.PriceCents))
+			}
+
+			return strings.Join(options, ListSeparator)
+		},
+		set: func(p *entities.Product, value string) error {
+			options := make([]entities.PricingOption, 0)
+			for _, item := range splitList(value) {
+				index := strings.LastIndex(item, "=")
+				if index <= 0 {
+					return fmt.Errorf("%q is not a label=price pricing option", item)
+				}
+
+				priceCents, err := parsePrice(item[index+1:])
+				if err != nil {
+					return err
+				}
+
+				options = append(options, entities.PricingOption{Label: strings.TrimSpace(item[:index]), PriceCents: priceCents})
+			}
+
+			if p.OrderByCheapestFirst {
+				sort.Sort(entities.ByCheapestPrice(options))
+			}
+
+			p.PricingOptions = options
+			p.HasPricingOptions = len(options) > 0
+			return nil
+		},
+	},
+	ColumnPictures: {
+		get: func(p *entities.Product) string { return strings.Join(p.Pictures, ListSeparator) },
+		set: func(p *entities.Product, value string) error {
+			p.Pictures = splitList(value)
+			return nil
+		},
+	},
+	ColumnDescription: {
+		get: func(p *entities.Product) string { return string(p.Description) },
+		set: func(p *entities.Product, value string) error {
+			p.Description = template.HTML(value)
+			return nil
+		},
+	},
+}
+
+// File is a product CSV file as it was read.
+type File struct {
+	Columns []string
+	Rows    []*Row
+}
+
+type Row struct {
+	Line   int
+	Values map[string]string // by column, only the columns of the file
+}
+
+// Change is a column of a product that an import changes.
+type Change struct {
+	Column string `json:"column"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// RowPlan is what an import does with a row.
+type RowPlan struct {
+	Line    int       `json:"line"`
+	Path    string    `json:"path"`
+	Action  string    `json:"action"`
+	Changes []*Change `json:"changes"`
+
+	product    *entities.Product
+	categories []string // nil when the file has no categories column
+}
+
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Plan is what importing a file does, the diff a dry run shows.
+type Plan struct {
+	Rows          []*RowPlan  `json:"rows"`
+	Errors        []*RowError `json:"errors"`
+	NewCategories []string    `json:"new_categories"`
+	Created       int         `json:"created"`
+	Updated       int         `json:"updated"`
+	Unchanged     int         `json:"unchanged"`
+	Applied       bool        `json:"applied"`
+}
+
+// Export writes every product of the catalog as a row of the product CSV.
+func Export(w io.Writer, c *Catalog) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(Columns)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range c.Products {
+		record := make([]string, 0, len(Columns))
+		for _, column := range Columns {
+			switch column {
+			case ColumnPath:
+				record = append(record, product.Path)
+			case ColumnCategories:
+				record = append(record, strings.Join(c.CategoryNames(product.Id), ListSeparator))
+			default:
+				record = append(record, fields[column].get(product))
+			}
+		}
+
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadCSV reads a product CSV. The first row names the columns.
+func ReadCSV(r io.Reader) (*File, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("The file is empty.")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Columns: make([]string, 0, len(header)), Rows: make([]*Row, 0)}
+	seen := map[string]bool{}
+	for index, column := range header {
+		if index == 0 {
+			// spreadsheets save csv files with a byte order mark
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !isColumn(column) {
+			return nil, fmt.Errorf("Unknown column %q, expected %s.", column, strings.Join(Columns, ", "))
+		}
+
+		if seen[column] {
+			return nil, fmt.Errorf("Column %q is there twice.", column)
+		}
+
+		seen[column] = true
+		file.Columns = append(file.Columns, column)
+	}
+
+	if !seen[ColumnPath] && !seen[ColumnName] {
+		return nil, errors.New("The file needs a path or a name column.")
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := &Row{Line: line, Values: map[string]string{}}
+		empty := true
+		for index, value := range record {
+			value = strings.TrimSpace(value)
+			row.Values[file.Columns[index]] = value
+			empty = empty && value == ""
+		}
+
+		if !empty {
+			file.Rows = append(file.Rows, row)
+		}
+	}
+
+	return file, nil
+}
+
+// NewPlan works out what importing file into the catalog does. Rows update the product at their
+// path, or create one when there is none, changing only the columns the file has.
+func NewPlan(c *Catalog, file *File) *Plan {
+	plan := &Plan{Rows: make([]*RowPlan, 0, len(file.Rows)), Errors: make([]*RowError, 0), NewCategories: make([]string, 0)}
+	byPath := map[string]*entities.Product{}
+	for _, product := range c.Products {
+		byPath[product.Path] = product
+	}
+
+	lines := map[string]int{}
+	newCategories := map[string]bool{}
+	for _, row := range file.Rows {
+		rowPlan, err := planRow(c, byPath, row)
+		if err == nil && lines[rowPlan.Path] != 0 {
+			err = fmt.Errorf("Path %s is on line %d already.", rowPlan.Path, lines[rowPlan.Path])
+		}
+
+		if err != nil {
+			plan.Errors = append(plan.Errors, &RowError{Line: row.Line, Message: err.Error()})
+			continue
+		}
+
+		lines[rowPlan.Path] = row.Line
+		for _, name := range rowPlan.categories {
+			if c.CategoryByName(name) == nil && !newCategories[name] {
+				newCategories[name] = true
+				plan.NewCategories = append(plan.NewCategories, name)
+			}
+		}
+
+		switch rowPlan.Action {
+		case ActionCreate:
+			plan.Created++
+		case ActionUpdate:
+			plan.Updated++
+		default:
+			plan.Unchanged++
+		}
+
+		plan.Rows = append(plan.Rows, rowPlan)
+	}
+
+	return plan
+}
+
+func planRow(c *Catalog, byPath map[string]*entities.Product, row *Row) (*RowPlan, error) {
+	// paths of existing products are matched as they are, new ones are written the way the admin
+	// writes them
+	path := row.Values[ColumnPath]
+	existing := byPath[path]
+	if existing == nil {
+		if path == "" {
+			path = row.Values[ColumnName]
+		}
+
+		path = entities.ProductPath(path)
+		existing = byPath[path]
+	}
+
+	if path == "" {
+		return nil, errors.New("The row has no path nor name.")
+	}
+
+	product := &entities.Product{}
+	if existing != nil {
+		*product = *existing
+	} else {
+		if row.Values[ColumnName] == "" {
+			return nil, fmt.Errorf("There is no product at %s, new products need a name.", path)
+		}
+
+		err := checkPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		product = entities.NewProduct(row.Values[ColumnName])
+		product.Path = path
+	}
+
+	rowPlan := &RowPlan{Line: row.Line, Path: path, Action: ActionUnchanged, Changes: make([]*Change, 0), product: product}
+	for _, column := range Columns {
+		value, present := row.Values[column]
+		if !present || column == ColumnPath {
+			continue
+		}
+
+		from := ""
+		if column == ColumnCategories {
+			rowPlan.categories = splitList(value)
+			sort.Strings(rowPlan.categories)
+			if existing != nil {
+				from = strings.Join(c.CategoryNames(existing.Id), ListSeparator)
+			}
+
+			rowPlan.addChange(column, from, strings.Join(rowPlan.categories, ListSeparator))
+			continue
+		}
+
+		err := fields[column].set(product, value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s.", column, err)
+		}
+
+		if existing != nil {
+			from = fields[column].get(existing)
+		}
+
+		rowPlan.addChange(column, from, fields[column].get(product))
+	}
+
+	switch {
+	case existing == nil:
+		rowPlan.Action = ActionCreate
+	case len(rowPlan.Changes) > 0:
+		rowPlan.Action = ActionUpdate
+	}
+
+	return rowPlan, nil
+}
+
+// checkPath rejects paths product urls can't have. Numbers are left to product ids, the product
+// page looks them up as ids.
+func checkPath(path string) error {
+	if strings.ContainsAny(path, "/?#%\\") || strings.IndexFunc(path, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("Path %s can't have spaces nor any of / ? # %% \\.", path)
+	}
+
+	if _, err := strconv.ParseInt(path, 10, 64); err == nil {
+		return fmt.Errorf("Path %s is a number, numbers are product ids.", path)
+	}
+
+	return nil
+}
+
+func (r *RowPlan) addChange(column string, from string, to string) {
+	if from != to {
+		r.Changes = append(r.Changes, &Change{Column: column, From: from, To: to})
+	}
+}
+
+// Apply creates and updates the products of a plan, along with the categories it names that
+// don't exist yet. Plans with errors aren't applied at all.
+func Apply(ctx context.Context, c *Catalog, plan *Plan) error {
+	if len(plan.Errors) > 0 {
+		return ErrInvalidRows
+	}
+
+	categoryIds := map[string]int64{}
+	for _, category := range c.Categories {
+		categoryIds[category.Name] = category.Id
+	}
+
+	for _, name := range plan.NewCategories {
+		category, err := entities.CreateCategory(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		categoryIds[name] = category.Id
+	}
+
+	for _, row := range plan.Rows {
+		if row.Action == ActionUnchanged {
+			continue
+		}
+
+		product := row.product
+		if row.Action == ActionCreate {
+			created, err := entities.CreateProduct(ctx, product.Name)
+			if err != nil {
+				return err
+			}
+
+			product.Id = created.Id
+		}
+
+		err := entities.UpdateProduct(ctx, product)
+		if err != nil {
+			return err
+		}
+
+		err = uploads.IndexReferences(ctx, uploads.ReferenceProduct, product.Id)
+		if err != nil {
+			log.Errorf(ctx, "Error indexing the uploads product %v shows: %+v", product.Id, err)
+		}
+
+		if row.categories != nil {
+			err = setCategories(ctx, c, product.Id, row.categories, categoryIds)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	plan.Applied = true
+	err := productsearch.Rebuild(ctx)
+	if err != nil {
+		log.Errorf(ctx, "Error rebuilding product search: %+v", err)
+	}
+
+	return nil
+}
+
+// setCategories puts a product in the categories called names, and takes it out of the others.
+func setCategories(ctx context.Context, c *Catalog, productId int64, names []string, categoryIds map[string]int64) error {
+	wanted := map[int64]bool{}
+	for _, name := range names {
+		wanted[categoryIds[name]] = true
+	}
+
+	unset := make([]*entities.CategoryProduct, 0)
+	for _, category := range c.ProductCategories[productId] {
+		if wanted[category.Id] {
+			delete(wanted, category.Id)
+			continue
+		}
+
+		unset = append(unset, &entities.CategoryProduct{CategoryId: category.Id, ProductId: productId})
+	}
+
+	set := make([]*entities.CategoryProduct, 0, len(wanted))
+	for categoryId := range wanted {
+		set = append(set, &entities.CategoryProduct{CategoryId: categoryId, ProductId: productId})
+	}
+
+	if len(unset) > 0 {
+		err := entities.UnsetCategoryProducts(ctx, unset)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(set) > 0 {
+		return entities.SetCategoryProducts(ctx, set)
+	}
+
+	return nil
+}
+
+func isColumn(name string) bool {
+	for _, column := range Columns {
+		if column == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ListSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func formatPrice(priceCents int64) string {
+	return fmt.Sprintf("%.2f", float64(priceCents)/100)
+}
+
+func parsePrice(value string) (int64, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "$")
+	if value == "" {
+		return 0, nil
+	}
+
+	price, err := strconv.ParseFloat(value, 64)
+	// prices are stored in cents, so they must fit an int64 once multiplied by 100
+	if err != nil || math.IsNaN(price) || price < 0 || price > math.MaxInt64/100 {
+		return 0, fmt.Errorf("%q is not a price", value)
+	}
+
+	return int64(math.Round(price * 100)), nil
+}
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "false", "no", "0":
+		return false, nil
+	case "true", "yes", "1":
+		return true, nil
+	}
+
+	return false, fmt.Errorf("%q is not true or false", value)
+}
@@ -0,0 +1,153 @@
+package catalog
+
+import (
+	"bytes"
+	"github.com/jcarm010/kodimerce/entities"
+	"strings"
+	"testing"
+)
+
+func testCatalog() *Catalog {
+	return &Catalog{
+		Products: []*entities.Product{
+			{Id: 1, Name: "Red Mug", Path: "red-mug", PriceCents: 1000, Active: true, Quantity: 3},
+			{Id: 2, Name: "Old Product", Path: "2", PriceCents: 500},
+		},
+		Categories:        []*entities.Category{{Id: 10, Name: "Mugs"}},
+		ProductCategories: map[int64][]*entities.Category{1: {{Id: 10, Name: "Mugs"}}},
+	}
+}
+
+func readTestCSV(t *testing.T, text string) *File {
+	file, err := ReadCSV(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestReadCSVLines(t *testing.T) {
+	file := readTestCSV(t, "\ufeffPath,Description\nred-mug,\"two\nlines\"\n,\n\nblue-mug,one line\n")
+	if len(file.Rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(file.Rows))
+	}
+
+	if file.Rows[0].Line != 2 || file.Rows[1].Line != 6 {
+		t.Errorf("got lines %d and %d, want 2 and 6", file.Rows[0].Line, file.Rows[1].Line)
+	}
+
+	if file.Rows[0].Values[ColumnDescription] != "two\nlines" {
+		t.Errorf("got %q", file.Rows[0].Values[ColumnDescription])
+	}
+}
+
+func TestReadCSVRejectsHeaders(t *testing.T) {
+	tests := map[string]string{
+		"":                 "The file is empty.",
+		"path,colour\n":    `Unknown column "colour"`,
+		"path,Path\n":      `Column "path" is there twice.`,
+		"price,quantity\n": "The file needs a path or a name column.",
+	}
+
+	for text, want := range tests {
+		_, err := ReadCSV(strings.NewReader(text))
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: got %v, want %s", text, err, want)
+		}
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	file := readTestCSV(t, "path,name,price,categories\n"+
+		"red-mug,Red Mug,12.50,Mugs|Gifts\n"+
+		"2,Old Product,5.00,\n"+
+		",Blue Mug,8,\n"+
+		"Green Mug,Green Mug,8,\n")
+	plan := NewPlan(testCatalog(), file)
+	if len(plan.Errors) != 0 {
+		t.Fatalf("got errors %+v", plan.Errors[0])
+	}
+
+	if plan.Created != 2 || plan.Updated != 1 || plan.Unchanged != 1 {
+		t.Errorf("got %d created, %d updated and %d unchanged", plan.Created, plan.Updated, plan.Unchanged)
+	}
+
+	paths := []string{}
+	for _, row := range plan.Rows {
+		paths = append(paths, row.Path)
+	}
+
+	if strings.Join(paths, " ") != "red-mug 2 blue-mug green-mug" {
+		t.Errorf("got paths %v", paths)
+	}
+
+	if len(plan.NewCategories) != 1 || plan.NewCategories[0] != "Gifts" {
+		t.Errorf("got new categories %v", plan.NewCategories)
+	}
+
+	changes := plan.Rows[0].Changes
+	if len(changes) != 2 || changes[0].Column != ColumnPrice || changes[0].From != "10.00" || changes[0].To != "12.50" {
+		t.Errorf("got changes %+v", changes)
+	}
+}
+
+func TestNewPlanRejectsRows(t *testing.T) {
+	file := readTestCSV(t, "path,name,price\n"+
+		"shoes/red,Red Shoes,1\n"+
+		"shoes?red,Red Shoes,1\n"+
+		"123,Numbered,1\n"+
+		"a\tb,Tabbed,1\n"+
+		"missing,,1\n"+
+		"red-mug,Red Mug,NaN\n"+
+		"red-mug,Red Mug,-1\n"+
+		"red-mug,Red Mug,1e300\n"+
+		"new-mug,New Mug,1\n"+
+		"New Mug,New Mug,2\n")
+	plan := NewPlan(testCatalog(), file)
+	lines := []int{}
+	for _, rowError := range plan.Errors {
+		lines = append(lines, rowError.Line)
+	}
+
+	want := []int{2, 3, 4, 5, 6, 7, 8, 9, 11}
+	if len(lines) != len(want) {
+		t.Fatalf("got errors on lines %v, want %v", lines, want)
+	}
+
+	for index := range want {
+		if lines[index] != want[index] {
+			t.Fatalf("got errors on lines %v, want %v", lines, want)
+		}
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	tests := map[string]int64{"": 0, "$12.5": 1250, "0.015": 2, " 3 ": 300}
+	for value, want := range tests {
+		got, err := parsePrice(value)
+		if err != nil || got != want {
+			t.Errorf("parsePrice(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"NaN", "nan", "Inf", "-1", "1e17", "ten"} {
+		if _, err := parsePrice(value); err == nil {
+			t.Errorf("parsePrice(%q) didn't fail", value)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := Export(buf, testCatalog())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := readTestCSV(t, buf.String())
+	plan := NewPlan(testCatalog(), file)
+	if len(plan.Errors) != 0 || plan.Unchanged != 2 {
+		t.Errorf("importing an export changes the catalog: %+v", plan)
+	}
+}
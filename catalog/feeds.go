@@ -0,0 +1,215 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// GoogleNamespace is the namespace of the product attributes of a Google Merchant feed.
+	GoogleNamespace = "http://base.google.com/ns/1.0"
+
+	maxDescriptionLength = 5000
+	maxAdditionalImages  = 10
+)
+
+// FeedItem is a product as shopping channels list it.
+type FeedItem struct {
+	Id                   string
+	Title                string
+	Description          string
+	Link                 string
+	ImageLink            string
+	AdditionalImageLinks []string
+	InStock              bool
+	Price                string
+	Brand                string
+	ProductType          string
+}
+
+// FeedItems are the products of the catalog shopping channels can sell: the active ones that
+// don't redirect somewhere else. Links start with hostRoot, such as https://example.com.
+func FeedItems(c *Catalog, hostRoot string, brand string) []*FeedItem {
+	hostRoot = strings.TrimSuffix(hostRoot, "/")
+	items := make([]*FeedItem, 0)
+	for _, product := range c.Products {
+		if !product.Active || product.HasRedirect {
+			continue
+		}
+
+		item := &FeedItem{
+			Id:          strconv.FormatInt(product.Id, 10),
+			Title:       product.Name,
+			Description: feedDescription(product),
+			Link:        hostRoot + "/product/" + url.PathEscape(product.Path),
+			InStock:     !product.OutOfStock(),
+			Price:       fmt.Sprintf("%s %s", formatPrice(product.GetPriceCents()), Currency),
+			Brand:       brand,
+		}
+
+		for index, picture := range product.Pictures {
+			switch {
+			case index == 0:
+				item.ImageLink = absoluteUrl(hostRoot, picture)
+			case len(item.AdditionalImageLinks) < maxAdditionalImages:
+				item.AdditionalImageLinks = append(item.AdditionalImageLinks, absoluteUrl(hostRoot, picture))
+			}
+		}
+
+		if categories := c.ProductCategories[product.Id]; len(categories) > 0 {
+			item.ProductType = categories[0].Name
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func feedDescription(product *entities.Product) string {
+	description := strings.Join(strings.Fields(search.StripTags(string(product.Description))), " ")
+	if description == "" {
+		description = product.MetaDescription
+	}
+
+	if description == "" {
+		description = product.Name
+	}
+
+	if runes := []rune(description); len(runes) > maxDescriptionLength {
+		description = string(runes[:maxDescriptionLength])
+	}
+
+	return description
+}
+
+func absoluteUrl(hostRoot string, url string) string {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url
+	}
+
+	if strings.HasPrefix(url, "//") {
+		return "https:" + url
+	}
+
+	return hostRoot + "/" + strings.TrimPrefix(url, "/")
+}
+
+type merchantFeed struct {
+	XMLName xml.Name        `xml:"rss"`
+	Version string          `xml:"version,attr"`
+	G       string          `xml:"xmlns:g,attr"`
+	Channel merchantChannel `xml:"channel"`
+}
+
+type merchantChannel struct {
+	Title       string          `xml:"title"`
+	Link        string          `xml:"link"`
+	Description string          `xml:"description"`
+	Items       []*merchantItem `xml:"item"`
+}
+
+type merchantItem struct {
+	Id                   string   `xml:"g:id"`
+	Title                string   `xml:"g:title"`
+	Description          string   `xml:"g:description"`
+	Link                 string   `xml:"g:link"`
+	ImageLink            string   `xml:"g:image_link,omitempty"`
+	AdditionalImageLinks []string `xml:"g:additional_image_link"`
+	Availability         string   `xml:"g:availability"`
+	Price                string   `xml:"g:price"`
+	Condition            string   `xml:"g:condition"`
+	Brand                string   `xml:"g:brand,omitempty"`
+	ProductType          string   `xml:"g:product_type,omitempty"`
+	IdentifierExists     string   `xml:"g:identifier_exists"`
+}
+
+// WriteGoogleMerchant writes items as a Google Merchant Center RSS feed.
+func WriteGoogleMerchant(w io.Writer, title string, hostRoot string, items []*FeedItem) error {
+	feed := &merchantFeed{
+		Version: "2.0",
+		G:       GoogleNamespace,
+		Channel: merchantChannel{
+			Title:       title,
+			Link:        hostRoot,
+			Description: fmt.Sprintf("Products of %s", title),
+			Items:       make([]*merchantItem, 0, len(items)),
+		},
+	}
+
+	for _, item := range items {
+		availability := "out_of_stock"
+		if item.InStock {
+			availability = "in_stock"
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, &merchantItem{
+			Id:                   item.Id,
+			Title:                item.Title,
+			Description:          item.Description,
+			Link:                 item.Link,
+			ImageLink:            item.ImageLink,
+			AdditionalImageLinks: item.AdditionalImageLinks,
+			Availability:         availability,
+			Price:                item.Price,
+			Condition:            "new",
+			Brand:                item.Brand,
+			ProductType:          item.ProductType,
+			// products have no GTIN or MPN
+			IdentifierExists: "no",
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
+
+// WriteFacebookCatalog writes items as a Facebook catalog data feed, a CSV file.
+func WriteFacebookCatalog(w io.Writer, items []*FeedItem) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"id", "title", "description", "availability", "condition", "price", "link", "image_link", "additional_image_link", "brand", "product_type"})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		availability := "out of stock"
+		if item.InStock {
+			availability = "in stock"
+		}
+
+		err = writer.Write([]string{
+			item.Id,
+			item.Title,
+			item.Description,
+			availability,
+			"new",
+			item.Price,
+			item.Link,
+			item.ImageLink,
+			strings.Join(item.AdditionalImageLinks, ","),
+			item.Brand,
+			item.ProductType,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFeedItems(t *testing.T) {
+	c := testCatalog()
+	c.Products[0].Path = "café mug"
+	c.Products[0].Pictures = []string{"/gallery/upload/key1", "https://cdn.com/b.jpg", "//cdn.com/c.jpg"}
+	items := FeedItems(c, "https://shop.com", "Shop")
+	if len(items) != 1 {
+		t.Fatalf("got %d items, only the active product should be there", len(items))
+	}
+
+	item := items[0]
+	if item.Link != "https://shop.com/product/caf%C3%A9%20mug" {
+		t.Errorf("got link %s", item.Link)
+	}
+
+	if item.ImageLink != "https://shop.com/gallery/upload/key1" {
+		t.Errorf("got image link %s", item.ImageLink)
+	}
+
+	if strings.Join(item.AdditionalImageLinks, " ") != "https://cdn.com/b.jpg https://cdn.com/c.jpg" {
+		t.Errorf("got additional image links %v", item.AdditionalImageLinks)
+	}
+
+	if item.Price != "10.00 USD" || !item.InStock || item.ProductType != "Mugs" {
+		t.Errorf("got %+v", item)
+	}
+}
+
+func TestWriteFeeds(t *testing.T) {
+	items := FeedItems(testCatalog(), "https://shop.com", "Shop")
+	buf := &bytes.Buffer{}
+	err := WriteGoogleMerchant(buf, "Shop", "https://shop.com", items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "<g:link>https://shop.com/product/red-mug</g:link>") {
+		t.Errorf("got %s", buf.String())
+	}
+
+	buf.Reset()
+	err = WriteFacebookCatalog(buf, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "https://shop.com/product/red-mug") {
+		t.Errorf("got %s", buf.String())
+	}
+}
@@ -0,0 +1,235 @@
+// Command kodimerce runs the store outside of App Engine, or one of its jobs.
+//
+//	kodimerce [serve]   serves the store on $PORT, 8080 by default, runs the jobs on their schedule,
+//	                    delivers queued email and serves the metrics on $METRICS_ADDR when it is set
+//	kodimerce jobs      lists the jobs
+//	kodimerce backup    writes a backup of every entity, see below
+//	kodimerce restore   restores a backup
+//	kodimerce migrate   applies the data migrations that haven't run yet
+//	kodimerce <job>     runs a job once, such as abandoned-checkouts
+//
+// Backups go to stdout unless -o names a file, and -no-secrets leaves password hashes and api keys
+// out of them:
+//
+//	kodimerce backup [-no-secrets] [-o file]
+//	kodimerce restore [-conflict skip|overwrite|remap] file|-
+//
+// Migrations can stop at a version, count what they would change with -dry-run, and -status lists
+// them along with the schema version:
+//
+//	kodimerce migrate [-dry-run] [-to version] [-status]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	_ "github.com/jcarm010/kodimerce"
+	"github.com/jcarm010/kodimerce/backup"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/jobs"
+	"github.com/jcarm010/kodimerce/km"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/migrations"
+	"io"
+	"net/http"
+	"os"
+)
+
+// site is what the commands run against.
+type site interface {
+	RunJob(ctx context.Context, name string, trigger string) (*entities.JobRun, error)
+	ExportBackup(ctx context.Context, w io.Writer, options backup.ExportOptions) (*backup.ExportResult, error)
+	ImportBackup(ctx context.Context, r io.Reader, options backup.ImportOptions) (*backup.ImportResult, error)
+	GetSchemaVersion(ctx context.Context) (*entities.SchemaVersion, error)
+}
+
+type datastoreSite struct{}
+
+func (datastoreSite) RunJob(ctx context.Context, name string, trigger string) (*entities.JobRun, error) {
+	return jobs.Run(ctx, name, trigger)
+}
+
+func (datastoreSite) ExportBackup(ctx context.Context, w io.Writer, options backup.ExportOptions) (*backup.ExportResult, error) {
+	return backup.Export(ctx, w, options)
+}
+
+func (datastoreSite) ImportBackup(ctx context.Context, r io.Reader, options backup.ImportOptions) (*backup.ImportResult, error) {
+	return backup.Import(ctx, r, options)
+}
+
+func (datastoreSite) GetSchemaVersion(ctx context.Context) (*entities.SchemaVersion, error) {
+	return entities.GetSchemaVersion(ctx)
+}
+
+func main() {
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	ctx := context.Background()
+	s := datastoreSite{}
+	switch command {
+	case "serve":
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+
+		jobs.StartScheduler(ctx)
+		emailer.StartWorker(ctx)
+		km.StartMetricsListener(ctx)
+		log.Infof(ctx, "Listening on port %s", port)
+		err := http.ListenAndServe(":"+port, nil)
+		if err != nil {
+			log.Errorf(ctx, "Server stopped: %+v", err)
+			os.Exit(1)
+		}
+	case "jobs":
+		for _, job := range jobs.List() {
+			fmt.Printf("%-24s %s\n", job.Name, job.Description)
+		}
+	case "backup":
+		err := runBackup(ctx, s, os.Args[2:])
+		if err != nil {
+			log.Errorf(ctx, "Backup failed: %+v", err)
+			os.Exit(1)
+		}
+	case "restore":
+		err := runRestore(ctx, s, os.Args[2:])
+		if err != nil {
+			log.Errorf(ctx, "Restore failed: %+v", err)
+			os.Exit(1)
+		}
+	case "migrate":
+		err := runMigrate(ctx, s, os.Args[2:])
+		if err != nil {
+			log.Errorf(ctx, "Migrations failed: %+v", err)
+			os.Exit(1)
+		}
+	default:
+		run, err := s.RunJob(ctx, command, jobs.TriggerCLI)
+		if err == jobs.ErrJobNotFound {
+			fmt.Fprintf(os.Stderr, "Unknown command %q, expected serve, jobs, backup, restore, migrate or the name of a job\n", command)
+			os.Exit(2)
+		}
+
+		if run != nil {
+			bts, _ := json.Marshal(run)
+			fmt.Println(string(bts))
+		}
+
+		if err != nil || run.Status == entities.JobRunStatusFailed {
+			log.Errorf(ctx, "Job %s did not finish: %+v", command, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func runBackup(ctx context.Context, s site, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	noSecrets := flags.Bool("no-secrets", false, "leave password hashes and api keys out of the backup")
+	output := flags.String("o", "", "file to write the backup to, stdout by default")
+	_ = flags.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = file.Close()
+		}()
+
+		w = file
+	}
+
+	result, err := s.ExportBackup(ctx, w, backup.ExportOptions{ExcludeSecrets: *noSecrets})
+	if err != nil {
+		return err
+	}
+
+	bts, _ := json.Marshal(result)
+	fmt.Fprintln(os.Stderr, string(bts))
+	return nil
+}
+
+func runRestore(ctx context.Context, s site, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	conflict := flags.String("conflict", backup.ConflictSkip, "what to do with entities that exist already: skip, overwrite or remap")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: kodimerce restore [-conflict skip|overwrite|remap] file")
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = file.Close()
+		}()
+
+		r = file
+	}
+
+	result, err := s.ImportBackup(ctx, r, backup.ImportOptions{Conflict: *conflict})
+	if err != nil {
+		return err
+	}
+
+	bts, _ := json.Marshal(result)
+	fmt.Println(string(bts))
+	return nil
+}
+
+func runMigrate(ctx context.Context, s site, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "count what the migrations would change without changing it")
+	to := flags.Int("to", 0, "last migration to apply, all of them by default")
+	status := flags.Bool("status", false, "print the schema version and the migrations instead")
+	_ = flags.Parse(args)
+
+	if *status {
+		version, err := s.GetSchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("schema version %d, latest %d\n", version.Version, migrations.Latest())
+		for _, migration := range migrations.List() {
+			state := "pending"
+			switch {
+			case migration.Version <= version.Version:
+				state = "applied"
+			case migration.Version == version.Migrating:
+				state = fmt.Sprintf("interrupted after %d entities", version.Processed)
+			}
+
+			fmt.Printf("%04d %-24s %s\n", migration.Version, migration.Name, state)
+		}
+
+		return nil
+	}
+
+	ctx = migrations.WithOptions(ctx, migrations.Options{DryRun: *dryRun, To: *to})
+	run, err := s.RunJob(ctx, "migrate", jobs.TriggerCLI)
+	if run != nil {
+		fmt.Println(run.Result)
+		if err == nil && run.Status == entities.JobRunStatusFailed {
+			err = errors.New(run.Error)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jcarm010/kodimerce/backup"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/jobs"
+	"github.com/jcarm010/kodimerce/migrations"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeSite struct {
+	runJob           func(ctx context.Context, name string, trigger string) (*entities.JobRun, error)
+	exportBackup     func(w io.Writer, options backup.ExportOptions) (*backup.ExportResult, error)
+	importBackup     func(r io.Reader, options backup.ImportOptions) (*backup.ImportResult, error)
+	getSchemaVersion func() (*entities.SchemaVersion, error)
+}
+
+func (f *fakeSite) RunJob(ctx context.Context, name string, trigger string) (*entities.JobRun, error) {
+	return f.runJob(ctx, name, trigger)
+}
+
+func (f *fakeSite) ExportBackup(ctx context.Context, w io.Writer, options backup.ExportOptions) (*backup.ExportResult, error) {
+	return f.exportBackup(w, options)
+}
+
+func (f *fakeSite) ImportBackup(ctx context.Context, r io.Reader, options backup.ImportOptions) (*backup.ImportResult, error) {
+	return f.importBackup(r, options)
+}
+
+func (f *fakeSite) GetSchemaVersion(ctx context.Context) (*entities.SchemaVersion, error) {
+	return f.getSchemaVersion()
+}
+
+func TestRunBackup(t *testing.T) {
+	var options backup.ExportOptions
+	s := &fakeSite{exportBackup: func(w io.Writer, o backup.ExportOptions) (*backup.ExportResult, error) {
+		options = o
+		_, err := io.WriteString(w, "{\"kind\":\"Product\"}\n")
+		return &backup.ExportResult{Entities: map[string]int{"Product": 1}}, err
+	}}
+
+	output := filepath.Join(t.TempDir(), "site.jsonl")
+	if err := runBackup(context.Background(), s, []string{"-no-secrets", "-o", output}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !options.ExcludeSecrets {
+		t.Error("-no-secrets was not passed on")
+	}
+
+	bts, err := ioutil.ReadFile(output)
+	if err != nil || string(bts) != "{\"kind\":\"Product\"}\n" {
+		t.Errorf("got %q, %v", bts, err)
+	}
+}
+
+func TestRunBackupUnwritableFile(t *testing.T) {
+	s := &fakeSite{exportBackup: func(w io.Writer, o backup.ExportOptions) (*backup.ExportResult, error) {
+		t.Error("exported without a file to write to")
+		return &backup.ExportResult{}, nil
+	}}
+
+	output := filepath.Join(t.TempDir(), "missing", "site.jsonl")
+	if err := runBackup(context.Background(), s, []string{"-o", output}); err == nil {
+		t.Error("expected an error for a file that can't be created")
+	}
+}
+
+func TestRunRestore(t *testing.T) {
+	var options backup.ImportOptions
+	var read string
+	s := &fakeSite{importBackup: func(r io.Reader, o backup.ImportOptions) (*backup.ImportResult, error) {
+		options = o
+		bts, err := ioutil.ReadAll(r)
+		read = string(bts)
+		return &backup.ImportResult{}, err
+	}}
+
+	input := filepath.Join(t.TempDir(), "site.jsonl")
+	if err := ioutil.WriteFile(input, []byte("{\"kind\":\"Product\"}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runRestore(context.Background(), s, []string{"-conflict", backup.ConflictRemap, input}); err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Conflict != backup.ConflictRemap || read != "{\"kind\":\"Product\"}\n" {
+		t.Errorf("got conflict %q reading %q", options.Conflict, read)
+	}
+
+	if err := runRestore(context.Background(), s, []string{filepath.Join(t.TempDir(), "missing.jsonl")}); !os.IsNotExist(err) {
+		t.Errorf("got %v for a missing file", err)
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	var options migrations.Options
+	s := &fakeSite{runJob: func(ctx context.Context, name string, trigger string) (*entities.JobRun, error) {
+		if name != "migrate" || trigger != jobs.TriggerCLI {
+			t.Errorf("ran %s from %s", name, trigger)
+		}
+
+		options = migrations.OptionsFrom(ctx)
+		return &entities.JobRun{Status: entities.JobRunStatusSucceeded, Result: "{}"}, nil
+	}}
+
+	if err := runMigrate(context.Background(), s, []string{"-dry-run", "-to", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if options != (migrations.Options{DryRun: true, To: 2}) {
+		t.Errorf("got %+v", options)
+	}
+}
+
+func TestRunMigrateFailedRun(t *testing.T) {
+	s := &fakeSite{runJob: func(ctx context.Context, name string, trigger string) (*entities.JobRun, error) {
+		return &entities.JobRun{Status: entities.JobRunStatusFailed, Error: "Migration 2 failed."}, nil
+	}}
+
+	if err := runMigrate(context.Background(), s, nil); err == nil || err.Error() != "Migration 2 failed." {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRunMigrateStatus(t *testing.T) {
+	readErr := errors.New("Datastore unavailable.")
+	s := &fakeSite{
+		runJob: func(ctx context.Context, name string, trigger string) (*entities.JobRun, error) {
+			t.Error("-status ran the migrations")
+			return nil, nil
+		},
+		getSchemaVersion: func() (*entities.SchemaVersion, error) {
+			return nil, readErr
+		},
+	}
+
+	if err := runMigrate(context.Background(), s, []string{"-status"}); err != readErr {
+		t.Errorf("got %v", err)
+	}
+
+	s.getSchemaVersion = func() (*entities.SchemaVersion, error) {
+		return &entities.SchemaVersion{Version: 1, Migrating: 2, Processed: 50}, nil
+	}
+
+	if err := runMigrate(context.Background(), s, []string{"-status"}); err != nil {
+		t.Error(err)
+	}
+}
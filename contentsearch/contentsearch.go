@@ -0,0 +1,405 @@
+// Package contentsearch is the site-wide search over published blog posts, pages and galleries.
+// Each kind of content is indexed as its own document type so results can be narrowed to one.
+package contentsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypePost    = "post"
+	TypePage    = "page"
+	TypeGallery = "gallery"
+
+	FieldType      = "type"
+	FieldPublished = "published"
+
+	SnippetLength = 200
+	MaxLimit      = 50
+)
+
+// IndexTTL is how long the content index is used before it is loaded again from the datastore.
+var IndexTTL = 10 * time.Minute
+
+var defaultSearch = newContentSearch(datastoreContent{})
+
+// contentStore is where the posts, pages and galleries are read from.
+type contentStore interface {
+	GetPost(ctx context.Context, id int64) (*entities.Post, error)
+	GetPage(ctx context.Context, id int64) (*entities.Page, error)
+	GetGallery(ctx context.Context, id int64) (*entities.Gallery, error)
+	ListPosts(ctx context.Context, published bool, limit int) ([]*entities.Post, error)
+	ListPages(ctx context.Context, published bool, limit int) ([]*entities.Page, error)
+	ListGalleries(ctx context.Context, published bool, limit int) ([]*entities.Gallery, error)
+}
+
+type datastoreContent struct{}
+
+func (datastoreContent) GetPost(ctx context.Context, id int64) (*entities.Post, error) {
+	return entities.GetPost(ctx, id)
+}
+
+func (datastoreContent) GetPage(ctx context.Context, id int64) (*entities.Page, error) {
+	return entities.GetPage(ctx, id)
+}
+
+func (datastoreContent) GetGallery(ctx context.Context, id int64) (*entities.Gallery, error) {
+	return entities.GetGallery(ctx, id)
+}
+
+func (datastoreContent) ListPosts(ctx context.Context, published bool, limit int) ([]*entities.Post, error) {
+	return entities.ListPosts(ctx, published, limit)
+}
+
+func (datastoreContent) ListPages(ctx context.Context, published bool, limit int) ([]*entities.Page, error) {
+	return entities.ListPages(ctx, published, limit)
+}
+
+func (datastoreContent) ListGalleries(ctx context.Context, published bool, limit int) ([]*entities.Gallery, error) {
+	return entities.ListGalleries(ctx, published, limit)
+}
+
+// contentSearch is the index of the published content of a store.
+type contentSearch struct {
+	store  contentStore
+	loader *search.Loader
+}
+
+func newContentSearch(store contentStore) *contentSearch {
+	c := &contentSearch{store: store}
+	index := search.NewMemoryIndex(map[string]float64{
+		"title":       4,
+		"description": 2,
+		"body":        1,
+	})
+
+	c.loader = search.NewLoader(index, IndexTTL, c.load)
+	return c
+}
+
+// Content is the part of a post, page or gallery the results are shown with.
+type Content struct {
+	Type        string    `json:"type"`
+	Id          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Url         string    `json:"url"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	Published   time.Time `json:"published"`
+	Body        string    `json:"-"` // plain text, for the snippets
+}
+
+// Hit is a result with the words matching the query highlighted.
+type Hit struct {
+	*Content
+	Title   template.HTML `json:"title"`
+	Snippet template.HTML `json:"snippet"`
+}
+
+type Results struct {
+	Hits   []*Hit              `json:"hits"`
+	Total  int                 `json:"total"`
+	Cursor string              `json:"cursor"`
+	Types  []search.FacetCount `json:"types"`
+}
+
+// Options are the search parameters a visitor can send.
+type Options struct {
+	Text   string
+	Type   string // one of the Type constants, every type when empty
+	Limit  int
+	Cursor string
+}
+
+// ParseOptions reads the options from the query string: q, type, limit and cursor.
+func ParseOptions(values map[string][]string) (*Options, error) {
+	get := func(name string) string {
+		if len(values[name]) == 0 {
+			return ""
+		}
+
+		return strings.TrimSpace(values[name][0])
+	}
+
+	options := &Options{
+		Text:   get("q"),
+		Type:   get("type"),
+		Cursor: get("cursor"),
+	}
+
+	switch options.Type {
+	case "", TypePost, TypePage, TypeGallery:
+	default:
+		return nil, fmt.Errorf("Invalid type: %s", options.Type)
+	}
+
+	if limit := get("limit"); limit != "" {
+		var err error
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit <= 0 {
+			return nil, fmt.Errorf("Invalid limit: %s", limit)
+		}
+
+		if options.Limit > MaxLimit {
+			options.Limit = MaxLimit
+		}
+	}
+
+	return options, nil
+}
+
+// Search returns the content matching the options, most relevant and then newest first. Nothing
+// matches an empty query, there is no point listing the whole site.
+func Search(ctx context.Context, options *Options) (*Results, error) {
+	return defaultSearch.search(ctx, options)
+}
+
+func (c *contentSearch) search(ctx context.Context, options *Options) (*Results, error) {
+	results := &Results{Hits: make([]*Hit, 0), Types: make([]search.FacetCount, 0)}
+	if len(search.Tokenize(options.Text)) == 0 {
+		return results, nil
+	}
+
+	q := &search.Query{
+		Text:   options.Text,
+		Facets: []string{FieldType},
+		Sort:   []search.Sort{{Field: search.ScoreField, Desc: true}, {Field: FieldPublished, Desc: true}},
+		Limit:  options.Limit,
+		Cursor: options.Cursor,
+	}
+
+	if options.Type != "" {
+		q.Filters = map[string][]string{FieldType: {options.Type}}
+	}
+
+	result, err := c.loader.Search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	results.Total = result.Total
+	results.Cursor = result.Cursor
+	if types := result.Facets[FieldType]; types != nil {
+		results.Types = types
+	}
+
+	for _, hit := range result.Hits {
+		content := hit.Source.(*Content)
+		snippetText := content.Body
+		if snippetText == "" {
+			snippetText = content.Description
+		}
+
+		results.Hits = append(results.Hits, &Hit{
+			Content: content,
+			Title:   search.Highlight(content.Title, options.Text),
+			Snippet: search.Snippet(snippetText, options.Text, SnippetLength),
+		})
+	}
+
+	return results, nil
+}
+
+// Rebuild loads every published post, page and gallery into the index again.
+func Rebuild(ctx context.Context) error {
+	return defaultSearch.loader.Rebuild(ctx)
+}
+
+// IndexPost updates the document of a post, removing it when it isn't published.
+func IndexPost(ctx context.Context, postId int64) error {
+	return defaultSearch.indexPost(ctx, postId)
+}
+
+func (c *contentSearch) indexPost(ctx context.Context, postId int64) error {
+	post, err := c.store.GetPost(ctx, postId)
+	if err == entities.ErrPostNotFound {
+		return c.loader.Delete(documentId(TypePost, postId))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.index(postContent(post), post.Published)
+}
+
+// IndexPage updates the document of a page, removing it when it isn't published.
+func IndexPage(ctx context.Context, pageId int64) error {
+	return defaultSearch.indexPage(ctx, pageId)
+}
+
+func (c *contentSearch) indexPage(ctx context.Context, pageId int64) error {
+	page, err := c.store.GetPage(ctx, pageId)
+	if err == entities.ErrPageNotFound {
+		return c.loader.Delete(documentId(TypePage, pageId))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.index(pageContent(page), searchablePage(page))
+}
+
+// IndexGallery updates the document of a gallery, removing it when it isn't published.
+func IndexGallery(ctx context.Context, galleryId int64) error {
+	return defaultSearch.indexGallery(ctx, galleryId)
+}
+
+func (c *contentSearch) indexGallery(ctx context.Context, galleryId int64) error {
+	gallery, err := c.store.GetGallery(ctx, galleryId)
+	if err == entities.ErrGalleryNotFound {
+		return c.loader.Delete(documentId(TypeGallery, galleryId))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.index(galleryContent(gallery), gallery.Published)
+}
+
+func (c *contentSearch) index(content *Content, published bool) error {
+	if !published {
+		return c.loader.Delete(documentId(content.Type, content.Id))
+	}
+
+	return c.loader.Put(contentDocument(content))
+}
+
+func (c *contentSearch) load(ctx context.Context) ([]*search.Document, error) {
+	posts, err := c.store.ListPosts(ctx, true, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := c.store.ListPages(ctx, true, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	galleries, err := c.store.ListGalleries(ctx, true, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]*search.Document, 0, len(posts)+len(pages)+len(galleries))
+	for _, post := range posts {
+		docs = append(docs, contentDocument(postContent(post)))
+	}
+
+	for _, page := range pages {
+		if searchablePage(page) {
+			docs = append(docs, contentDocument(pageContent(page)))
+		}
+	}
+
+	for _, gallery := range galleries {
+		docs = append(docs, contentDocument(galleryContent(gallery)))
+	}
+
+	return docs, nil
+}
+
+// searchablePage tells whether a page has content of its own, redirects don't.
+func searchablePage(page *entities.Page) bool {
+	return page.Published && page.Provider != entities.ProviderRedirectPage
+}
+
+func postContent(post *entities.Post) *Content {
+	description := post.ShortDescription
+	if description == "" {
+		description = post.MetaDescription
+	}
+
+	return &Content{
+		Type:        TypePost,
+		Id:          post.Id,
+		Title:       post.Title,
+		Url:         "/" + post.Path,
+		Description: description,
+		Image:       post.Banner,
+		Published:   post.PublishedDate,
+		Body:        plainText(string(post.Content)),
+	}
+}
+
+func pageContent(page *entities.Page) *Content {
+	body := []string{plainText(string(page.Content))}
+	image := ""
+	if page.Provider == entities.ProviderCustomPage && page.DynamicPage != nil {
+		if page.DynamicPage.HasBanner && page.DynamicPage.Banner != nil {
+			image = page.DynamicPage.Banner.Path
+		}
+
+		for _, row := range page.DynamicPage.Rows {
+			if row.RowSimpleComponent == nil {
+				continue
+			}
+
+			body = append(body, row.RowSimpleComponent.Header, plainText(string(row.RowSimpleComponent.Description)))
+		}
+	}
+
+	return &Content{
+		Type:        TypePage,
+		Id:          page.Id,
+		Title:       page.Title,
+		Url:         "/" + page.Path,
+		Description: page.MetaDescription,
+		Image:       image,
+		Published:   page.PublishedDate,
+		Body:        strings.TrimSpace(strings.Join(body, " ")),
+	}
+}
+
+func galleryContent(gallery *entities.Gallery) *Content {
+	body := []string{plainText(string(gallery.Description))}
+	for _, image := range gallery.Images {
+		body = append(body, image.AltTag)
+	}
+
+	return &Content{
+		Type:        TypeGallery,
+		Id:          gallery.Id,
+		Title:       gallery.Title,
+		Url:         "/gallery/" + gallery.Path,
+		Description: gallery.MetaDescription,
+		Image:       gallery.FirstImage().Url,
+		Published:   gallery.PublishedDate,
+		Body:        strings.TrimSpace(strings.Join(body, " ")),
+	}
+}
+
+func contentDocument(content *Content) *search.Document {
+	return &search.Document{
+		Id: documentId(content.Type, content.Id),
+		Text: map[string]string{
+			"title":       content.Title,
+			"description": content.Description,
+			"body":        content.Body,
+		},
+		Keywords: map[string][]string{
+			FieldType: {content.Type},
+		},
+		Numbers: map[string]float64{
+			FieldPublished: search.Time(content.Published),
+		},
+		Source: content,
+	}
+}
+
+func documentId(contentType string, id int64) string {
+	return contentType + ":" + strconv.FormatInt(id, 10)
+}
+
+func plainText(html string) string {
+	return strings.Join(strings.Fields(search.StripTags(html)), " ")
+}
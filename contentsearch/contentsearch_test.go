@@ -0,0 +1,154 @@
+package contentsearch
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/entities"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeContent struct {
+	posts     map[int64]*entities.Post
+	pages     map[int64]*entities.Page
+	galleries map[int64]*entities.Gallery
+}
+
+func (f *fakeContent) GetPost(ctx context.Context, id int64) (*entities.Post, error) {
+	if post, exists := f.posts[id]; exists {
+		return post, nil
+	}
+
+	return nil, entities.ErrPostNotFound
+}
+
+func (f *fakeContent) GetPage(ctx context.Context, id int64) (*entities.Page, error) {
+	if page, exists := f.pages[id]; exists {
+		return page, nil
+	}
+
+	return nil, entities.ErrPageNotFound
+}
+
+func (f *fakeContent) GetGallery(ctx context.Context, id int64) (*entities.Gallery, error) {
+	if gallery, exists := f.galleries[id]; exists {
+		return gallery, nil
+	}
+
+	return nil, entities.ErrGalleryNotFound
+}
+
+func (f *fakeContent) ListPosts(ctx context.Context, published bool, limit int) ([]*entities.Post, error) {
+	return []*entities.Post{f.posts[1], f.posts[2]}, nil
+}
+
+func (f *fakeContent) ListPages(ctx context.Context, published bool, limit int) ([]*entities.Page, error) {
+	return []*entities.Page{f.pages[3], f.pages[4]}, nil
+}
+
+func (f *fakeContent) ListGalleries(ctx context.Context, published bool, limit int) ([]*entities.Gallery, error) {
+	return []*entities.Gallery{f.galleries[5]}, nil
+}
+
+func newContent(t *testing.T) (*contentSearch, *fakeContent) {
+	now := time.Now()
+	content := &fakeContent{
+		posts: map[int64]*entities.Post{
+			1: {Id: 1, Title: "Caring for leather boots", Path: "leather-care", Published: true, PublishedDate: now.Add(-time.Hour), Content: "<p>Use wax on <b>boots</b> twice a year.</p>"},
+			2: {Id: 2, Title: "Spring sale", Path: "spring-sale", Published: true, PublishedDate: now, ShortDescription: "Boots and shirts on sale"},
+		},
+		pages: map[int64]*entities.Page{
+			3: {Id: 3, Title: "About", Path: "about", Published: true, PublishedDate: now, Content: "We have sold boots since 1990."},
+			4: {Id: 4, Title: "Old boots", Path: "old-boots", Published: true, Provider: entities.ProviderRedirectPage},
+		},
+		galleries: map[int64]*entities.Gallery{
+			5: {Id: 5, Title: "Workshop", Path: "workshop", Published: true, PublishedDate: now, Images: []*entities.Image{{Url: "/img/1.jpg", AltTag: "Stitching boots"}}},
+		},
+	}
+
+	c := newContentSearch(content)
+	if err := c.loader.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, content
+}
+
+func urls(results *Results) []string {
+	list := make([]string, 0, len(results.Hits))
+	for _, hit := range results.Hits {
+		list = append(list, hit.Url)
+	}
+
+	return list
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions(map[string][]string{"q": {" boots "}, "type": {TypePost}, "limit": {"500"}})
+	if err != nil || options.Text != "boots" || options.Type != TypePost || options.Limit != MaxLimit {
+		t.Errorf("got %+v, %v", options, err)
+	}
+
+	if _, err := ParseOptions(map[string][]string{"type": {"product"}}); err == nil {
+		t.Errorf("an unknown type was accepted")
+	}
+
+	if _, err := ParseOptions(map[string][]string{"limit": {"-1"}}); err == nil {
+		t.Errorf("a negative limit was accepted")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	c, _ := newContent(t)
+	results, err := c.search(context.Background(), &Options{Text: "boots"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/leather-care", "/spring-sale", "/gallery/workshop", "/about"}
+	if got := urls(results); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if results.Hits[0].Title != "Caring for leather <mark>boots</mark>" || !strings.Contains(string(results.Hits[0].Snippet), "wax on <mark>boots</mark>") {
+		t.Errorf("got title %q and snippet %q", results.Hits[0].Title, results.Hits[0].Snippet)
+	}
+
+	if !strings.Contains(string(results.Hits[1].Snippet), "<mark>Boots</mark> and shirts") {
+		t.Errorf("a post without a body should show its description, got %q", results.Hits[1].Snippet)
+	}
+
+	results, _ = c.search(context.Background(), &Options{Text: "boots", Type: TypePage})
+	if got := urls(results); !reflect.DeepEqual(got, []string{"/about"}) {
+		t.Errorf("got %v for pages", got)
+	}
+
+	results, _ = c.search(context.Background(), &Options{Text: "  "})
+	if results.Total != 0 || len(results.Hits) != 0 {
+		t.Errorf("an empty query matched %d results", results.Total)
+	}
+}
+
+func TestIndexContent(t *testing.T) {
+	c, content := newContent(t)
+	content.posts[2].Published = false
+	delete(content.galleries, 5)
+	content.pages[4].Provider = entities.ProviderCustomPage
+	content.pages[4].Content = "Our first boots"
+
+	for _, err := range []error{
+		c.indexPost(context.Background(), 2),
+		c.indexGallery(context.Background(), 5),
+		c.indexPage(context.Background(), 4),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, _ := c.search(context.Background(), &Options{Text: "boots"})
+	if got := urls(results); !reflect.DeepEqual(got, []string{"/leather-care", "/old-boots", "/about"}) {
+		t.Errorf("got %v", got)
+	}
+}
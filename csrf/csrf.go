@@ -0,0 +1,50 @@
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"golang.org/x/net/context"
+)
+
+const (
+	CookieName = "km-csrf"
+	HeaderName = "X-CSRF-Token"
+	FormField  = "csrf_token"
+)
+
+type contextKey struct{}
+
+// NewToken returns a random, url safe token.
+func NewToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Equal compares two tokens in constant time. Empty tokens never match.
+func Equal(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// WithToken returns a copy of ctx that carries the token for the current request.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, contextKey{}, token)
+}
+
+// TokenFromContext returns the token stored with WithToken, or an empty string.
+func TokenFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	token, _ := ctx.Value(contextKey{}).(string)
+	return token
+}
@@ -0,0 +1,46 @@
+package csrf
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestNewTokenIsRandom(t *testing.T) {
+	first, second := NewToken(), NewToken()
+	if len(first) != 43 {
+		t.Errorf("token %q is %d characters, want 32 bytes url encoded", first, len(first))
+	}
+
+	if first == second {
+		t.Error("two tokens are the same")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"token", "token", true},
+		{"token", "other", false},
+		{"token", "token2", false},
+		{"", "", false},
+		{"token", "", false},
+	}
+
+	for _, test := range tests {
+		if got := Equal(test.a, test.b); got != test.want {
+			t.Errorf("Equal(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestTokenFromContext(t *testing.T) {
+	if token := TokenFromContext(context.Background()); token != "" {
+		t.Errorf("got %q from a context without a token", token)
+	}
+
+	if token := TokenFromContext(WithToken(context.Background(), "token")); token != "token" {
+		t.Errorf("got %q, want token", token)
+	}
+}
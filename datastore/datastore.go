@@ -3,26 +3,42 @@ package datastore
 import (
 	"cloud.google.com/go/datastore"
 	"context"
+	"github.com/jcarm010/kodimerce/metrics"
+	"google.golang.org/api/iterator"
 	"os"
+	"sync"
+	"time"
 )
 
 var (
 	dataStoreClient *datastore.Client
+	clientOnce      sync.Once
 	ErrNoSuchEntity = datastore.ErrNoSuchEntity
 )
 
 type Key datastore.Key
 type PendingKey datastore.PendingKey
 type Transaction struct {
-	t *datastore.Transaction
+	t   *datastore.Transaction
+	ctx context.Context
 }
 
-func init() {
-	var err error
-	dataStoreClient, err = datastore.NewClient(context.Background(), os.Getenv("GOOGLE_CLOUD_PROJECT"))
-	if err != nil {
-		panic(err)
-	}
+// Iterator is the result of a query run with Run. Every call to Next is observed as a datastore call.
+type Iterator struct {
+	t *datastore.Iterator
+}
+
+// client connects on first use, so packages that only build keys and entities load without a project.
+func client() *datastore.Client {
+	clientOnce.Do(func() {
+		var err error
+		dataStoreClient, err = datastore.NewClient(context.Background(), os.Getenv("GOOGLE_CLOUD_PROJECT"))
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	return dataStoreClient
 }
 
 func (k *Key) IntID() int64 {
@@ -59,6 +75,12 @@ func (t *Transaction) Get(key *Key, dst interface{}) (err error) {
 	return t.t.Get((*datastore.Key)(key), dst)
 }
 
+// GetAll runs q within the transaction, q must be an ancestor query.
+func (t *Transaction) GetAll(q *datastore.Query, dst interface{}) ([]*Key, error) {
+	dKeys, err := client().GetAll(t.ctx, q.Transaction(t.t), dst)
+	return getOwnKeys(dKeys), err
+}
+
 func (t *Transaction) Commit() (err error) {
 	_, err = t.t.Commit()
 	return err
@@ -92,47 +114,79 @@ func NewQuery(kind string) *datastore.Query {
 }
 
 func GetAll(ctx context.Context, q *datastore.Query, dst interface{}) (keys []*Key, err error) {
-	dKeys, err := dataStoreClient.GetAll(ctx, q, dst)
+	defer observe("get_all", time.Now(), &err)
+	dKeys, err := client().GetAll(ctx, q, dst)
 	keys = getOwnKeys(dKeys)
 	return keys, err
 }
 
+// AllocateKey completes an incomplete key without storing anything, so transactions can put
+// new entities and know their id.
+func AllocateKey(ctx context.Context, key *Key) (*Key, error) {
+	keys, err := client().AllocateIDs(ctx, []*datastore.Key{(*datastore.Key)(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	return (*Key)(keys[0]), nil
+}
+
 func Get(ctx context.Context, key *Key, dst interface{}) (err error) {
-	return dataStoreClient.Get(ctx, (*datastore.Key)(key), dst)
+	defer observe("get", time.Now(), &err)
+	return client().Get(ctx, (*datastore.Key)(key), dst)
 }
 
 func GetMulti(ctx context.Context, keys []*Key, dst interface{}) (err error) {
+	defer observe("get_multi", time.Now(), &err)
 	dKeys := getDataStoreKeys(keys)
-	return dataStoreClient.GetMulti(ctx, dKeys, dst)
+	return client().GetMulti(ctx, dKeys, dst)
 }
 
 func Put(ctx context.Context, key *Key, src interface{}) (*Key, error) {
-	k, err := dataStoreClient.Put(ctx, (*datastore.Key)(key), src)
+	start := time.Now()
+	k, err := client().Put(ctx, (*datastore.Key)(key), src)
+	observe("put", start, &err)
 	return (*Key)(k), err
 }
 
 func PutMulti(ctx context.Context, keys []*Key, src interface{}) (ret []*Key, err error) {
+	defer observe("put_multi", time.Now(), &err)
 	dKeys := getDataStoreKeys(keys)
-	dKeys, err = dataStoreClient.PutMulti(ctx, dKeys, src)
+	dKeys, err = client().PutMulti(ctx, dKeys, src)
 	keys = getOwnKeys(dKeys)
 	return keys, err
 }
 
-func Delete(ctx context.Context, key *Key) error {
-	return dataStoreClient.Delete(ctx, (*datastore.Key)(key))
+func Delete(ctx context.Context, key *Key) (err error) {
+	defer observe("delete", time.Now(), &err)
+	return client().Delete(ctx, (*datastore.Key)(key))
 }
 
 func DeleteMulti(ctx context.Context, keys []*Key) (err error) {
-	return dataStoreClient.DeleteMulti(ctx, getDataStoreKeys(keys))
+	defer observe("delete_multi", time.Now(), &err)
+	return client().DeleteMulti(ctx, getDataStoreKeys(keys))
+}
+
+func Run(ctx context.Context, q *datastore.Query) *Iterator {
+	metrics.DatastoreCalls.Inc("run")
+	return &Iterator{t: client().Run(ctx, q)}
 }
 
-func Run(ctx context.Context, q *datastore.Query) *datastore.Iterator {
-	return dataStoreClient.Run(ctx, q)
+// Next loads the next result into dst and returns its key. It returns iterator.Done when there are no more results.
+func (t *Iterator) Next(dst interface{}) (key *datastore.Key, err error) {
+	defer observe("next", time.Now(), &err)
+	return t.t.Next(dst)
+}
+
+// Cursor returns a cursor for the iterator's current location.
+func (t *Iterator) Cursor() (datastore.Cursor, error) {
+	return t.t.Cursor()
 }
 
 func Count(ctx context.Context, q *datastore.Query) (n int, err error) {
+	defer observe("count", time.Now(), &err)
 
-	return dataStoreClient.Count(ctx, q)
+	return client().Count(ctx, q)
 }
 
 func DecodeCursor(s string) (datastore.Cursor, error) {
@@ -163,12 +217,18 @@ func DecodeCursor(s string) (datastore.Cursor, error) {
 // Transaction.Get will append when unmarshalling slice fields, so it is not
 // necessarily idempotent.
 func RunInTransaction(ctx context.Context, f func(tx *Transaction) error) (err error) {
-	_, err = dataStoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
-		return f(&Transaction{tx})
+	defer observe("transaction", time.Now(), &err)
+	_, err = client().RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(&Transaction{t: tx, ctx: ctx})
 	})
 	return err
 }
 
+// observe records a datastore call. It takes a pointer so it can be deferred before the error is known.
+func observe(op string, start time.Time, err *error) {
+	metrics.ObserveDatastore(op, start, *err, ErrNoSuchEntity, iterator.Done)
+}
+
 func getOwnPendingKeys(keys []*datastore.PendingKey) []*PendingKey {
 	ownKeys := make([]*PendingKey, len(keys))
 	for i, key := range keys {
@@ -0,0 +1,66 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"github.com/jcarm010/kodimerce/metrics"
+	"google.golang.org/api/iterator"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func metricsText() string {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestObserve(t *testing.T) {
+	var err error
+	observe("test_ok", time.Now(), &err)
+	err = ErrNoSuchEntity
+	observe("test_missing", time.Now(), &err)
+	err = iterator.Done
+	observe("test_done", time.Now(), &err)
+	err = errors.New("Datastore unavailable.")
+	observe("test_failed", time.Now(), &err)
+
+	text := metricsText()
+	for _, line := range []string{
+		`km_datastore_calls_total{op="test_ok"} 1`,
+		`km_datastore_calls_total{op="test_missing"} 1`,
+		`km_datastore_calls_total{op="test_done"} 1`,
+		`km_datastore_calls_total{op="test_failed"} 1`,
+		`km_datastore_errors_total{op="test_failed"} 1`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("missing %s in:\n%s", line, text)
+		}
+	}
+
+	for _, op := range []string{"test_ok", "test_missing", "test_done"} {
+		if strings.Contains(text, `km_datastore_errors_total{op="`+op+`"}`) {
+			t.Errorf("%s counted as an error:\n%s", op, text)
+		}
+	}
+}
+
+func TestKeysDontConnect(t *testing.T) {
+	parent := NewKey(context.Background(), "Category", "", 3, nil)
+	key := NewKey(context.Background(), "Product", "shirt", 0, parent)
+	if key.StringID() != "shirt" || key.Parent.ID != 3 || NewIncompleteKey(context.Background(), "Order", nil).IntID() != 0 {
+		t.Errorf("got %+v", key)
+	}
+
+	keys := getOwnKeys(getDataStoreKeys([]*Key{key, parent}))
+	if len(keys) != 2 || keys[0] != key || keys[1] != parent {
+		t.Errorf("got %v", keys)
+	}
+
+	if dataStoreClient != nil {
+		t.Error("building keys connected to the datastore")
+	}
+}
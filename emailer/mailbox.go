@@ -0,0 +1,185 @@
+package emailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailboxLimit is how many emails an in-memory mailbox keeps. Older ones are dropped first.
+const MailboxLimit = 500
+
+var ErrMailNotFound = errors.New("Mail not found.")
+
+// MailboxEntry is an email captured by a Mailbox.
+type MailboxEntry struct {
+	Id      string    `json:"id"`
+	Date    time.Time `json:"date"`
+	Message *Message  `json:"message"`
+}
+
+// Mailbox is a Sender that never delivers anything. It captures every email, in memory or as
+// files in a directory, so developers can see what the shop sends.
+type Mailbox struct {
+	dir     string
+	mu      sync.Mutex
+	entries []*MailboxEntry
+	counter int64
+}
+
+func NewMailbox(dir string) *Mailbox {
+	return &Mailbox{dir: dir}
+}
+
+func (m *Mailbox) Name() string {
+	return BackendMailbox
+}
+
+func (m *Mailbox) Send(ctx context.Context, message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counter++
+	now := time.Now()
+	entry := &MailboxEntry{
+		Id:      fmt.Sprintf("%d-%d", now.UnixNano(), m.counter),
+		Date:    now,
+		Message: message,
+	}
+
+	if m.dir == "" {
+		m.entries = append(m.entries, entry)
+		if len(m.entries) > MailboxLimit {
+			m.entries = m.entries[len(m.entries)-MailboxLimit:]
+		}
+
+		return nil
+	}
+
+	err := os.MkdirAll(m.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	bts, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(m.dir, entry.Id+".json"), bts, 0644)
+	if err != nil {
+		return err
+	}
+
+	var eml bytes.Buffer
+	_, err = buildMIME(message).WriteTo(&eml)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(m.dir, entry.Id+".eml"), eml.Bytes(), 0644)
+}
+
+// List returns the captured emails, newest first.
+func (m *Mailbox) List() ([]*MailboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]*MailboxEntry, 0)
+	if m.dir == "" {
+		entries = append(entries, m.entries...)
+	} else {
+		files, err := ioutil.ReadDir(m.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+
+			entry, err := m.readEntry(strings.TrimSuffix(file.Name(), ".json"))
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Date.After(entries[j].Date)
+	})
+
+	return entries, nil
+}
+
+func (m *Mailbox) Get(id string) (*MailboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir != "" {
+		return m.readEntry(id)
+	}
+
+	for _, entry := range m.entries {
+		if entry.Id == id {
+			return entry, nil
+		}
+	}
+
+	return nil, ErrMailNotFound
+}
+
+// Clear removes every captured email.
+func (m *Mailbox) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = nil
+	if m.dir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") || strings.HasSuffix(file.Name(), ".eml") {
+			if err := os.Remove(filepath.Join(m.dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Mailbox) readEntry(id string) (*MailboxEntry, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrMailNotFound
+	}
+
+	bts, err := ioutil.ReadFile(filepath.Join(m.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil, ErrMailNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := &MailboxEntry{}
+	err = json.Unmarshal(bts, entry)
+	return entry, err
+}
@@ -0,0 +1,81 @@
+package emailer
+
+import (
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMailboxInMemory(t *testing.T) {
+	mailbox := NewMailbox("")
+	for i := 0; i < MailboxLimit+5; i++ {
+		if err := mailbox.Send(context.Background(), validMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := mailbox.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != MailboxLimit {
+		t.Fatalf("got %d entries, want %d", len(entries), MailboxLimit)
+	}
+
+	entry, err := mailbox.Get(entries[0].Id)
+	if err != nil || entry != entries[0] {
+		t.Errorf("Get(%s) = %v, %v", entries[0].Id, entry, err)
+	}
+
+	if err := mailbox.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mailbox.Get(entries[0].Id); err != ErrMailNotFound {
+		t.Errorf("got %v after clearing, want ErrMailNotFound", err)
+	}
+}
+
+func TestMailboxDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailbox := NewMailbox(dir)
+	if err := mailbox.Send(context.Background(), validMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := mailbox.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Message.Subject != "Your order" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	eml, err := ioutil.ReadFile(filepath.Join(dir, entries[0].Id+".eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(eml), "Subject: Your order") {
+		t.Errorf("the eml file has no subject:\n%s", eml)
+	}
+
+	for _, id := range []string{"", "../secrets", "a.b", `a\b`, "missing"} {
+		if _, err := mailbox.Get(id); err != ErrMailNotFound {
+			t.Errorf("Get(%q) = %v, want ErrMailNotFound", id, err)
+		}
+	}
+
+	if err := mailbox.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("%d files left after clearing", len(files))
+	}
+}
@@ -0,0 +1,216 @@
+package emailer
+
+import (
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"golang.org/x/net/context"
+	"strings"
+	"time"
+)
+
+var (
+	// MaxAttempts is how many times a message is tried before it is dead-lettered.
+	MaxAttempts = 8
+	// RetryBase is the wait after the first failure. Every further failure doubles it up to RetryMax.
+	RetryBase = time.Minute
+	RetryMax  = 6 * time.Hour
+	// SendLease is how long a worker may take to deliver a message before another worker retries it.
+	SendLease = 5 * time.Minute
+	// PollInterval is how often the worker looks for due messages when nothing wakes it up.
+	PollInterval = 30 * time.Second
+
+	wakeUp = make(chan struct{}, 1)
+)
+
+// queue is where ProcessQueue finds the due messages and how it sends them.
+type queue interface {
+	ListDueEmailMessages(ctx context.Context, now time.Time, limit int) ([]int64, error)
+	ClaimEmailMessage(ctx context.Context, id int64, lease time.Duration) (*entities.EmailMessage, bool, error)
+	UpdateEmailMessage(ctx context.Context, message *entities.EmailMessage) error
+	SendMessage(ctx context.Context, message *Message) error
+}
+
+// datastoreQueue is the queue of the running server, sending through the configured Sender.
+type datastoreQueue struct{}
+
+func (datastoreQueue) ListDueEmailMessages(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	return entities.ListDueEmailMessages(ctx, now, limit)
+}
+
+func (datastoreQueue) ClaimEmailMessage(ctx context.Context, id int64, lease time.Duration) (*entities.EmailMessage, bool, error) {
+	return entities.ClaimEmailMessage(ctx, id, lease)
+}
+
+func (datastoreQueue) UpdateEmailMessage(ctx context.Context, message *entities.EmailMessage) error {
+	return entities.UpdateEmailMessage(ctx, message)
+}
+
+func (datastoreQueue) SendMessage(ctx context.Context, message *Message) error {
+	return SendMessage(ctx, message)
+}
+
+// Enqueue stores an html email in the outbound queue and wakes up the worker. The email is delivered
+// in the background, so a failing provider doesn't fail the request that produced the email.
+func Enqueue(ctx context.Context, from string, to string, subject string, body string, bcc string) (*entities.EmailMessage, error) {
+	return EnqueueMessage(ctx, &Message{
+		From:    from,
+		To:      SplitAddresses(to),
+		Bcc:     SplitAddresses(bcc),
+		Subject: subject,
+		HTML:    body,
+	})
+}
+
+// EnqueueMessage is like Enqueue for a message with a text part or a reply-to address.
+// Attachments and custom headers are not kept in the queue.
+func EnqueueMessage(ctx context.Context, m *Message) (*entities.EmailMessage, error) {
+	message := entities.NewEmailMessage(m.From, strings.Join(m.To, ","), m.Subject, m.HTML, strings.Join(m.Bcc, ","))
+	message.Text = m.Text
+	message.ReplyTo = m.ReplyTo
+	err := entities.CreateEmailMessage(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.EmailsSent.Inc("queued")
+	log.Infof(ctx, "Queued email[%d] to %s: %s", message.Id, message.To, message.Subject)
+	select {
+	case wakeUp <- struct{}{}:
+	default:
+	}
+
+	return message, nil
+}
+
+// Resend puts a message back in the queue with a fresh attempt count, whatever its status.
+func Resend(ctx context.Context, id int64) (*entities.EmailMessage, error) {
+	message, err := entities.GetEmailMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Status = entities.EmailMessageStatusQueued
+	message.Attempts = 0
+	message.NextAttempt = time.Now()
+	err = entities.UpdateEmailMessage(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case wakeUp <- struct{}{}:
+	default:
+	}
+
+	return message, nil
+}
+
+// ProcessQueue delivers up to limit due messages and returns how many were sent and how many failed.
+func ProcessQueue(ctx context.Context, limit int) (int, int, error) {
+	return processQueue(ctx, datastoreQueue{}, limit)
+}
+
+func processQueue(ctx context.Context, q queue, limit int) (int, int, error) {
+	ids, err := q.ListDueEmailMessages(ctx, time.Now(), limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sent, failed := 0, 0
+	for _, id := range ids {
+		message, claimed, err := q.ClaimEmailMessage(ctx, id, SendLease)
+		if err != nil {
+			log.Errorf(ctx, "Error claiming email[%d]: %+v", id, err)
+			continue
+		}
+
+		if !claimed {
+			continue
+		}
+
+		if deliver(ctx, q, message) {
+			sent++
+		} else {
+			failed++
+		}
+	}
+
+	return sent, failed, nil
+}
+
+func deliver(ctx context.Context, q queue, message *entities.EmailMessage) bool {
+	now := time.Now()
+	err := q.SendMessage(ctx, &Message{
+		From:    message.From,
+		To:      SplitAddresses(message.To),
+		Bcc:     SplitAddresses(message.Bcc),
+		ReplyTo: message.ReplyTo,
+		Subject: message.Subject,
+		HTML:    message.Body,
+		Text:    message.Text,
+	})
+	message.Attempts++
+	attempt := entities.EmailDeliveryAttempt{Date: now}
+	if err == nil {
+		message.Status = entities.EmailMessageStatusSent
+		message.SentDate = now
+		message.LastError = ""
+	} else {
+		attempt.Error = err.Error()
+		message.LastError = err.Error()
+		if message.Attempts >= MaxAttempts {
+			message.Status = entities.EmailMessageStatusDead
+			metrics.EmailsSent.Inc("dead")
+			log.Errorf(ctx, "Email[%d] to %s dead-lettered after %d attempts: %+v", message.Id, message.To, message.Attempts, err)
+		} else {
+			message.Status = entities.EmailMessageStatusQueued
+			message.NextAttempt = now.Add(RetryDelay(message.Attempts))
+			log.Warningf(ctx, "Email[%d] to %s failed, retrying at %v: %+v", message.Id, message.To, message.NextAttempt, err)
+		}
+	}
+
+	message.Deliveries = append(message.Deliveries, attempt)
+	if updateErr := q.UpdateEmailMessage(ctx, message); updateErr != nil {
+		log.Errorf(ctx, "Error saving outcome of email[%d]: %+v", message.Id, updateErr)
+	}
+
+	return err == nil
+}
+
+// RetryDelay returns how long to wait before the next try after the given number of failed attempts.
+func RetryDelay(attempts int) time.Duration {
+	delay := RetryBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= RetryMax {
+			return RetryMax
+		}
+	}
+
+	return delay
+}
+
+// StartWorker delivers queued messages in the background until ctx is done. The worker runs every
+// PollInterval, and right away when a message is enqueued.
+func StartWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(PollInterval)
+		defer ticker.Stop()
+		for {
+			sent, failed, err := ProcessQueue(ctx, 50)
+			if err != nil {
+				log.Errorf(ctx, "Error processing email queue: %+v", err)
+			} else if sent+failed > 0 {
+				log.Infof(ctx, "Email queue processed: %d sent, %d failed", sent, failed)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wakeUp:
+			}
+		}
+	}()
+}
@@ -0,0 +1,102 @@
+package emailer
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	base, max := RetryBase, RetryMax
+	t.Cleanup(func() { RetryBase, RetryMax = base, max })
+	RetryBase = time.Minute
+	RetryMax = time.Hour
+
+	tests := map[int]time.Duration{
+		0:  time.Minute,
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		6:  32 * time.Minute,
+		7:  time.Hour,
+		50: time.Hour,
+	}
+
+	for attempts, want := range tests {
+		if got := RetryDelay(attempts); got != want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// fakeQueue serves messages from memory, sending fails for down@example.com.
+type fakeQueue struct {
+	messages map[int64]*entities.EmailMessage
+	taken    map[int64]bool
+	updated  map[int64]bool
+}
+
+func (f *fakeQueue) ListDueEmailMessages(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	return []int64{1, 2, 3, 4}, nil
+}
+
+func (f *fakeQueue) ClaimEmailMessage(ctx context.Context, id int64, lease time.Duration) (*entities.EmailMessage, bool, error) {
+	return f.messages[id], !f.taken[id], nil
+}
+
+func (f *fakeQueue) UpdateEmailMessage(ctx context.Context, message *entities.EmailMessage) error {
+	f.updated[message.Id] = true
+	return nil
+}
+
+func (f *fakeQueue) SendMessage(ctx context.Context, message *Message) error {
+	if message.To[0] == "down@example.com" {
+		return errors.New("Connection refused.")
+	}
+
+	return nil
+}
+
+func TestProcessQueue(t *testing.T) {
+	attempts := MaxAttempts
+	t.Cleanup(func() {
+		MaxAttempts = attempts
+	})
+
+	MaxAttempts = 3
+	messages := map[int64]*entities.EmailMessage{
+		1: entities.NewEmailMessage("shop@shop.com", "ok@example.com", "Sent", "<p>1</p>", ""),
+		2: entities.NewEmailMessage("shop@shop.com", "down@example.com", "Retried", "<p>2</p>", ""),
+		3: entities.NewEmailMessage("shop@shop.com", "down@example.com", "Dead", "<p>3</p>", ""),
+		4: entities.NewEmailMessage("shop@shop.com", "ok@example.com", "Taken", "<p>4</p>", ""),
+	}
+
+	messages[3].Attempts = 2
+	for id, message := range messages {
+		message.Id = id
+	}
+
+	q := &fakeQueue{messages: messages, taken: map[int64]bool{4: true}, updated: map[int64]bool{}}
+	sent, failed, err := processQueue(context.Background(), q, 10)
+	if err != nil || sent != 1 || failed != 2 {
+		t.Fatalf("got %d sent, %d failed, %v", sent, failed, err)
+	}
+
+	if message := messages[1]; message.Status != entities.EmailMessageStatusSent || message.Attempts != 1 || message.SentDate.IsZero() {
+		t.Errorf("sent message: %+v", message)
+	}
+
+	if message := messages[2]; message.Status != entities.EmailMessageStatusQueued || message.LastError != "Connection refused." || !message.NextAttempt.After(time.Now()) {
+		t.Errorf("retried message: %+v", message)
+	}
+
+	if message := messages[3]; message.Status != entities.EmailMessageStatusDead || message.Attempts != 3 || len(message.Deliveries) != 1 {
+		t.Errorf("dead message: %+v", message)
+	}
+
+	if q.updated[4] || messages[4].Attempts != 0 {
+		t.Errorf("a message claimed by another worker was delivered")
+	}
+}
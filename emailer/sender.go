@@ -0,0 +1,146 @@
+package emailer
+
+import (
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+const (
+	BackendSendGrid = "sendgrid"
+	BackendSMTP     = "smtp"
+	BackendMailbox  = "mailbox"
+)
+
+// Message is a provider independent email. Addresses may include a display name, as in "Shop <orders@shop.com>".
+type Message struct {
+	From        string            `json:"from"`
+	To          []string          `json:"to"`
+	Cc          []string          `json:"cc,omitempty"`
+	Bcc         []string          `json:"bcc,omitempty"`
+	ReplyTo     string            `json:"reply_to,omitempty"`
+	Subject     string            `json:"subject"`
+	HTML        string            `json:"html,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+// Validate checks that every address of the message parses and that its custom headers don't
+// set recipients or break out of their line.
+func (m *Message) Validate() error {
+	addresses := []string{m.From}
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		addresses = append(addresses, list...)
+	}
+
+	if m.ReplyTo != "" {
+		addresses = append(addresses, m.ReplyTo)
+	}
+
+	for _, address := range addresses {
+		_, _, err := parseAddress(address)
+		if err != nil {
+			return err
+		}
+	}
+
+	for field, value := range m.Headers {
+		if reservedHeaders[textproto.CanonicalMIMEHeaderKey(field)] {
+			return fmt.Errorf("Header %s can't be set, use the recipients of the message.", field)
+		}
+
+		if strings.ContainsAny(field+value, "\r\n") {
+			return fmt.Errorf("Header %s can't span lines.", field)
+		}
+	}
+
+	return nil
+}
+
+// Sender delivers messages through an email provider.
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, message *Message) error
+}
+
+// reservedHeaders are written from the fields of a message, custom headers can't add recipients.
+var reservedHeaders = map[string]bool{"To": true, "Cc": true, "Bcc": true}
+
+var (
+	mailboxMu sync.Mutex
+	mailboxes = map[string]*Mailbox{}
+)
+
+// CurrentSender returns the sender configured in the server settings.
+func CurrentSender(settings entities.ServerSettings) Sender {
+	backend := settings.EmailBackend
+	if backend == "" {
+		backend = BackendSMTP
+		if settings.SendGridKey != "" {
+			backend = BackendSendGrid
+		}
+	}
+
+	switch backend {
+	case BackendSendGrid:
+		return NewSendGridSender(settings.SendGridKey)
+	case BackendMailbox:
+		return GetMailbox(settings.MailboxDir)
+	}
+
+	return &SMTPSender{
+		Host:     settings.SMTPServer,
+		Port:     settings.SMTPPort,
+		Username: settings.SMTPUserName,
+		Password: settings.SMTPPassword,
+		TLSMode:  settings.SMTPTLSMode,
+	}
+}
+
+// GetMailbox returns the mailbox that keeps its emails in dir, or in memory when dir is empty.
+// The same mailbox is returned for the same dir so the viewer sees what was sent.
+func GetMailbox(dir string) *Mailbox {
+	mailboxMu.Lock()
+	defer mailboxMu.Unlock()
+	mailbox, exists := mailboxes[dir]
+	if !exists {
+		mailbox = NewMailbox(dir)
+		mailboxes[dir] = mailbox
+	}
+
+	return mailbox
+}
+
+// SplitAddresses splits a comma separated list of addresses, dropping empty entries.
+func SplitAddresses(addresses string) []string {
+	list := make([]string, 0)
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if address != "" {
+			list = append(list, address)
+		}
+	}
+
+	return list
+}
+
+// parseAddress splits an address such as "Shop <orders@shop.com>" in its name and email.
+func parseAddress(address string) (string, string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid email address %q: %s", address, err)
+	}
+
+	return parsed.Name, parsed.Address, nil
+}
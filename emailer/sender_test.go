@@ -0,0 +1,107 @@
+package emailer
+
+import (
+	"bytes"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+)
+
+func validMessage() *Message {
+	return &Message{
+		From:    "Shop <orders@shop.com>",
+		To:      []string{"ana@example.com"},
+		Bcc:     []string{"archive@shop.com"},
+		Subject: "Your order",
+		HTML:    "<p>Thanks</p>",
+		Headers: map[string]string{"X-Order": "42"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]func(m *Message){
+		"bad from":         func(m *Message) { m.From = "Shop orders@shop.com" },
+		"empty from":       func(m *Message) { m.From = "" },
+		"bad to":           func(m *Message) { m.To = []string{"ana@example.com\r\nBcc: eve@evil.com"} },
+		"bad cc":           func(m *Message) { m.Cc = []string{"not an address"} },
+		"bad reply to":     func(m *Message) { m.ReplyTo = "@" },
+		"to header":        func(m *Message) { m.Headers["to"] = "eve@evil.com" },
+		"cc header":        func(m *Message) { m.Headers["CC"] = "eve@evil.com" },
+		"bcc header":       func(m *Message) { m.Headers["Bcc"] = "eve@evil.com" },
+		"multiline header": func(m *Message) { m.Headers["X-Note"] = "a\r\nBcc: eve@evil.com" },
+	}
+
+	if err := validMessage().Validate(); err != nil {
+		t.Fatalf("a valid message failed: %s", err)
+	}
+
+	for name, change := range tests {
+		message := validMessage()
+		change(message)
+		if err := message.Validate(); err == nil {
+			t.Errorf("%s: the message passed", name)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	name, email, err := parseAddress("Shop <orders@shop.com>")
+	if err != nil || name != "Shop" || email != "orders@shop.com" {
+		t.Errorf("got %q, %q, %v", name, email, err)
+	}
+
+	_, _, err = parseAddress("orders")
+	if err == nil {
+		t.Error("an invalid address parsed")
+	}
+}
+
+func TestSplitAddresses(t *testing.T) {
+	got := SplitAddresses(" a@shop.com, ,b@shop.com,")
+	if strings.Join(got, " ") != "a@shop.com b@shop.com" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestBuildMIMELeavesOutBcc(t *testing.T) {
+	buf := &bytes.Buffer{}
+	_, err := buildMIME(validMessage()).WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := buf.String()
+	if strings.Contains(text, "archive@shop.com") {
+		t.Error("the bcc recipient is in the headers")
+	}
+
+	for _, header := range []string{"To: ana@example.com", "X-Order: 42", "Subject: Your order"} {
+		if !strings.Contains(text, header) {
+			t.Errorf("%q is missing", header)
+		}
+	}
+}
+
+func TestNewSendGridMail(t *testing.T) {
+	mail := newSendGridMail(validMessage())
+	personalization := mail.Personalizations[0]
+	if mail.From.Email != "orders@shop.com" || mail.From.Name != "Shop" {
+		t.Errorf("got from %+v", mail.From)
+	}
+
+	if len(personalization.To) != 1 || len(personalization.Bcc) != 1 || personalization.Cc != nil {
+		t.Errorf("got %+v", personalization)
+	}
+}
+
+func TestSendersRejectInvalidMessages(t *testing.T) {
+	message := validMessage()
+	message.Headers["Bcc"] = "eve@evil.com"
+	senders := []Sender{&SMTPSender{Host: "127.0.0.1", Port: 1}, &SendGridSender{Endpoint: "http://127.0.0.1:1"}}
+	for _, sender := range senders {
+		err := sender.Send(context.Background(), message)
+		if err == nil || !strings.HasPrefix(err.Error(), "Header Bcc") {
+			t.Errorf("%s: got %v", sender.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package emailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jcarm010/kodimerce/metrics"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const SendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+var sendGridClient = &http.Client{
+	Timeout:   time.Second * 10,
+	Transport: metrics.InstrumentTransport("sendgrid", nil),
+}
+
+// SendGridSender delivers messages through the SendGrid v3 Mail Send API.
+type SendGridSender struct {
+	APIKey   string
+	Endpoint string
+	Client   *http.Client
+}
+
+func NewSendGridSender(apiKey string) *SendGridSender {
+	return &SendGridSender{
+		APIKey:   apiKey,
+		Endpoint: SendGridEndpoint,
+		Client:   sendGridClient,
+	}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content  string `json:"content"`
+	Type     string `json:"type,omitempty"`
+	Filename string `json:"filename"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+func (s *SendGridSender) Name() string {
+	return BackendSendGrid
+}
+
+func (s *SendGridSender) Send(ctx context.Context, message *Message) error {
+	err := message.Validate()
+	if err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(newSendGridMail(message))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.Endpoint, bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("sendgrid responded %d: %s", res.StatusCode, body)
+	}
+
+	return nil
+}
+
+func newSendGridMail(message *Message) *sendGridMail {
+	mail := &sendGridMail{
+		Personalizations: []sendGridPersonalization{{
+			To:  sendGridAddresses(message.To),
+			Cc:  sendGridAddresses(message.Cc),
+			Bcc: sendGridAddresses(message.Bcc),
+		}},
+		From:    sendGridAddressFrom(message.From),
+		Subject: message.Subject,
+		Content: make([]sendGridContent, 0, 2),
+		Headers: message.Headers,
+	}
+
+	if message.ReplyTo != "" {
+		replyTo := sendGridAddressFrom(message.ReplyTo)
+		mail.ReplyTo = &replyTo
+	}
+
+	// SendGrid requires text/plain to come before text/html
+	if message.Text != "" {
+		mail.Content = append(mail.Content, sendGridContent{Type: "text/plain", Value: message.Text})
+	}
+
+	if message.HTML != "" {
+		mail.Content = append(mail.Content, sendGridContent{Type: "text/html", Value: message.HTML})
+	}
+
+	for _, attachment := range message.Attachments {
+		mail.Attachments = append(mail.Attachments, sendGridAttachment{
+			Content:  base64.StdEncoding.EncodeToString(attachment.Content),
+			Type:     attachment.ContentType,
+			Filename: attachment.Filename,
+		})
+	}
+
+	return mail
+}
+
+func sendGridAddressFrom(address string) sendGridAddress {
+	// addresses were validated before
+	name, email, _ := parseAddress(address)
+	return sendGridAddress{Email: email, Name: name}
+}
+
+func sendGridAddresses(addresses []string) []sendGridAddress {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	list := make([]sendGridAddress, len(addresses))
+	for index, address := range addresses {
+		list[index] = sendGridAddressFrom(address)
+	}
+
+	return list
+}
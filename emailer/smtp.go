@@ -0,0 +1,194 @@
+package emailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"golang.org/x/net/context"
+	"gopkg.in/gomail.v2"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	// SMTPTLSAuto uses implicit TLS on port 465 and STARTTLS elsewhere when the server offers it.
+	SMTPTLSAuto = ""
+	// SMTPTLSStartTLS requires the server to upgrade the connection with STARTTLS.
+	SMTPTLSStartTLS = "starttls"
+	// SMTPTLSImplicit opens a TLS connection right away, usually on port 465.
+	SMTPTLSImplicit = "tls"
+	// SMTPTLSNone never encrypts the connection. Only meant for local relays and test servers.
+	SMTPTLSNone = "none"
+)
+
+var ErrStartTLSUnsupported = errors.New("smtp server does not support STARTTLS")
+
+// SMTPSender delivers messages to an SMTP server.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLSMode  string
+	Timeout  time.Duration
+}
+
+func (s *SMTPSender) Name() string {
+	return BackendSMTP
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message *Message) error {
+	err := message.Validate()
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	_, err = buildMIME(message).WriteTo(&body)
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]string, 0, len(message.To)+len(message.Cc)+len(message.Bcc))
+	for _, list := range [][]string{message.To, message.Cc, message.Bcc} {
+		for _, address := range list {
+			_, email, _ := parseAddress(address)
+			recipients = append(recipients, email)
+		}
+	}
+
+	_, from, _ := parseAddress(message.From)
+	log.Infof(ctx, "Sending smtp email to %v through %s as %s", recipients, s.Host, s.Username)
+	start := time.Now()
+	err = s.send(from, recipients, body.Bytes())
+	metrics.ObserveOutbound("smtp", start, err)
+	return err
+}
+
+func (s *SMTPSender) send(from string, recipients []string, body []byte) error {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = time.Second * 30
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+	implicit := s.TLSMode == SMTPTLSImplicit || (s.TLSMode == SMTPTLSAuto && s.Port == 465)
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if implicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	defer client.Close()
+	if !implicit && s.TLSMode != SMTPTLSNone {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if s.TLSMode == SMTPTLSStartTLS {
+			return ErrStartTLSUnsupported
+		}
+	}
+
+	if s.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		if err = client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s: %v", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(body); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// buildMIME renders message as a MIME document. Bcc recipients are left out of the headers.
+func buildMIME(message *Message) *gomail.Message {
+	m := gomail.NewMessage()
+	for field, value := range message.Headers {
+		m.SetHeader(field, value)
+	}
+
+	m.SetHeader("From", message.From)
+	m.SetHeader("To", message.To...)
+	if len(message.Cc) > 0 {
+		m.SetHeader("Cc", message.Cc...)
+	}
+
+	if message.ReplyTo != "" {
+		m.SetHeader("Reply-To", message.ReplyTo)
+	}
+
+	m.SetHeader("Subject", message.Subject)
+	m.SetDateHeader("Date", time.Now())
+	switch {
+	case message.Text != "" && message.HTML != "":
+		m.SetBody("text/plain", message.Text)
+		m.AddAlternative("text/html", message.HTML)
+	case message.Text != "":
+		m.SetBody("text/plain", message.Text)
+	default:
+		m.SetBody("text/html", message.HTML)
+	}
+
+	for _, attachment := range message.Attachments {
+		content := attachment.Content
+		settings := []gomail.FileSetting{
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+		}
+
+		if attachment.ContentType != "" {
+			settings = append(settings, gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}))
+		}
+
+		m.Attach(attachment.Filename, settings...)
+	}
+
+	return m
+}
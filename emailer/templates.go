@@ -0,0 +1,345 @@
+package emailer
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"golang.org/x/net/context"
+	htmlTemplate "html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	textTemplate "text/template"
+	"time"
+)
+
+// TemplatesDir holds the default templates. Every email has an html template named after the email
+// and, in a .txt file, a subject template named "<name>-subject" and a text template named "<name>-text".
+var TemplatesDir = "emailer/templates"
+
+// OverridesTTL is how long overrides loaded from the datastore are cached before they are reloaded,
+// so edits made through another instance show up without a restart.
+var OverridesTTL = time.Minute
+
+var ErrTemplateNotFound = errors.New("Email template not found.")
+
+// TemplateData is what every email template is rendered with.
+type TemplateData struct {
+	CompanyName     string
+	ConfirmationUrl string
+	OrderUrl        string
+	HostRoot        string
+	ContactEmail    string
+	Order           *entities.Order
+	RecoveryUrl     string // set on abandoned checkout reminders only
+	UnsubscribeUrl  string // set on marketing emails only
+}
+
+// Rendered is an email template executed against its data.
+type Rendered struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// TemplateSource is the source of the three parts of an email template.
+type TemplateSource struct {
+	Name       string `json:"name"`
+	Subject    string `json:"subject"`
+	HTML       string `json:"html"`
+	Text       string `json:"text"`
+	Overridden bool   `json:"overridden"`
+}
+
+// Template is a parsed email template.
+type Template struct {
+	subject *textTemplate.Template
+	html    *htmlTemplate.Template
+	text    *textTemplate.Template
+}
+
+var (
+	defaultsOnce   sync.Once
+	defaults       map[string]*Template
+	defaultSources map[string]*TemplateSource
+	defaultsErr    error
+	overrides      = &overrideCache{store: datastoreTemplates{}}
+)
+
+// templateStore is where the overrides are kept.
+type templateStore interface {
+	ListEmailTemplates(ctx context.Context) ([]*entities.EmailTemplate, error)
+}
+
+type datastoreTemplates struct{}
+
+func (datastoreTemplates) ListEmailTemplates(ctx context.Context) ([]*entities.EmailTemplate, error) {
+	return entities.ListEmailTemplates(ctx)
+}
+
+// overrideCache holds the parsed overrides for OverridesTTL.
+type overrideCache struct {
+	mu        sync.Mutex
+	store     templateStore
+	templates map[string]*Template
+	loaded    time.Time
+}
+
+// ParseTemplate parses the parts of a template. It is used to validate overrides before they are saved.
+func ParseTemplate(name string, subject string, html string, text string) (*Template, error) {
+	t := &Template{}
+	var err error
+	t.subject, err = textTemplate.New(name + "-subject").Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	t.html, err = htmlTemplate.New(name).Parse(html)
+	if err != nil {
+		return nil, err
+	}
+
+	t.text, err = textTemplate.New(name + "-text").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Template) Render(data interface{}) (*Rendered, error) {
+	var subject, html, text bytes.Buffer
+	err := t.subject.Execute(&subject, data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.html.Execute(&html, data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.text.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rendered{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()),
+	}, nil
+}
+
+// loadDefaults parses the templates in TemplatesDir once. The sources are parsed without html
+// escaping as well so the admin can start editing an override from the default.
+func loadDefaults() error {
+	defaultsOnce.Do(func() {
+		htmlSources, err := textTemplate.ParseGlob(filepath.Join(TemplatesDir, "*.html"))
+		if err != nil {
+			defaultsErr = err
+			return
+		}
+
+		textSources, err := textTemplate.ParseGlob(filepath.Join(TemplatesDir, "*.txt"))
+		if err != nil {
+			defaultsErr = err
+			return
+		}
+
+		defaults = map[string]*Template{}
+		defaultSources = map[string]*TemplateSource{}
+		for _, t := range htmlSources.Templates() {
+			name := t.Name()
+			if t.Tree == nil || strings.Contains(name, ".") {
+				continue
+			}
+
+			source := &TemplateSource{
+				Name: name,
+				HTML: t.Tree.Root.String(),
+			}
+
+			if subject := textSources.Lookup(name + "-subject"); subject != nil && subject.Tree != nil {
+				source.Subject = subject.Tree.Root.String()
+			}
+
+			if text := textSources.Lookup(name + "-text"); text != nil && text.Tree != nil {
+				source.Text = text.Tree.Root.String()
+			}
+
+			parsed, err := ParseTemplate(name, source.Subject, source.HTML, source.Text)
+			if err != nil {
+				defaultsErr = err
+				return
+			}
+
+			defaults[name] = parsed
+			defaultSources[name] = source
+		}
+	})
+
+	return defaultsErr
+}
+
+// TemplateNames returns the names of every email template, sorted.
+func TemplateNames() ([]string, error) {
+	err := loadDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetTemplateSource returns the source of the template currently in use, the override if there is one.
+func GetTemplateSource(ctx context.Context, name string) (*TemplateSource, error) {
+	defaultSource, err := GetDefaultTemplateSource(name)
+	if err != nil {
+		return nil, err
+	}
+
+	override, err := entities.GetEmailTemplate(ctx, name)
+	if err == entities.ErrEmailTemplateNotFound {
+		return defaultSource, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &TemplateSource{
+		Name:       name,
+		Subject:    override.Subject,
+		HTML:       override.HTML,
+		Text:       override.Text,
+		Overridden: true,
+	}, nil
+}
+
+func GetDefaultTemplateSource(name string) (*TemplateSource, error) {
+	err := loadDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	source, exists := defaultSources[name]
+	if !exists {
+		return nil, ErrTemplateNotFound
+	}
+
+	copied := *source
+	return &copied, nil
+}
+
+// SaveTemplateOverride validates and stores an override for the template with the same name.
+func SaveTemplateOverride(ctx context.Context, override *entities.EmailTemplate) error {
+	_, err := GetDefaultTemplateSource(override.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = ParseTemplate(override.Name, override.Subject, override.HTML, override.Text)
+	if err != nil {
+		return err
+	}
+
+	err = entities.SaveEmailTemplate(ctx, override)
+	if err != nil {
+		return err
+	}
+
+	overrides.invalidate()
+	return nil
+}
+
+// DeleteTemplateOverride makes the template go back to its default.
+func DeleteTemplateOverride(ctx context.Context, name string) error {
+	err := entities.DeleteEmailTemplate(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	overrides.invalidate()
+	return nil
+}
+
+func (o *overrideCache) invalidate() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.templates = nil
+}
+
+func (o *overrideCache) get(ctx context.Context, name string) (*Template, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.templates == nil || time.Since(o.loaded) > OverridesTTL {
+		stored, err := o.store.ListEmailTemplates(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		o.templates = map[string]*Template{}
+		o.loaded = time.Now()
+		for _, override := range stored {
+			parsed, err := ParseTemplate(override.Name, override.Subject, override.HTML, override.Text)
+			if err != nil {
+				log.Errorf(ctx, "Ignoring invalid override of email template %s: %+v", override.Name, err)
+				continue
+			}
+
+			o.templates[override.Name] = parsed
+		}
+	}
+
+	return o.templates[name], nil
+}
+
+// RenderTemplate renders the template called name, using its override when there is one.
+func RenderTemplate(ctx context.Context, name string, data interface{}) (*Rendered, error) {
+	return renderTemplate(ctx, overrides, name, data)
+}
+
+func renderTemplate(ctx context.Context, overrides *overrideCache, name string, data interface{}) (*Rendered, error) {
+	err := loadDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	t, exists := defaults[name]
+	if !exists {
+		return nil, ErrTemplateNotFound
+	}
+
+	override, err := overrides.get(ctx, name)
+	if err != nil {
+		log.Errorf(ctx, "Error loading email template overrides, using the default %s: %+v", name, err)
+	} else if override != nil {
+		t = override
+	}
+
+	return t.Render(data)
+}
+
+// EnqueueTemplate renders the template called name and queues it.
+func EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	rendered, err := RenderTemplate(ctx, name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return EnqueueMessage(ctx, &Message{
+		From:    from,
+		To:      SplitAddresses(to),
+		Bcc:     SplitAddresses(bcc),
+		Subject: rendered.Subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+	})
+}
@@ -0,0 +1,126 @@
+package emailer
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// tests run from the package directory
+	TemplatesDir = "templates"
+}
+
+func testData() *TemplateData {
+	return &TemplateData{
+		CompanyName:     "Bolts & Nuts",
+		ConfirmationUrl: "https://shop.com/order?id=7",
+		HostRoot:        "https://shop.com",
+		ContactEmail:    "help@shop.com",
+		Order:           &entities.Order{Id: 7, Carrier: "UPS", TrackingNumber: "1Z999"},
+		RecoveryUrl:     "https://shop.com/checkout?recover=1",
+		UnsubscribeUrl:  "https://shop.com/unsubscribe?t=1",
+	}
+}
+
+type fakeTemplates struct {
+	stored []*entities.EmailTemplate
+	err    error
+}
+
+func (f fakeTemplates) ListEmailTemplates(ctx context.Context) ([]*entities.EmailTemplate, error) {
+	return f.stored, f.err
+}
+
+func newOverrides(stored []*entities.EmailTemplate, err error) *overrideCache {
+	return &overrideCache{store: fakeTemplates{stored: stored, err: err}}
+}
+
+func TestDefaultTemplatesRender(t *testing.T) {
+	overrides := newOverrides(nil, nil)
+	names, err := TemplateNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) == 0 {
+		t.Fatal("no default templates were loaded")
+	}
+
+	for _, name := range names {
+		rendered, err := renderTemplate(context.Background(), overrides, name, testData())
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if rendered.Subject == "" || rendered.HTML == "" || rendered.Text == "" {
+			t.Errorf("%s: a part rendered empty: %+v", name, rendered)
+		}
+
+		if strings.Contains(rendered.Subject, "\n") {
+			t.Errorf("%s: the subject has a new line: %q", name, rendered.Subject)
+		}
+	}
+}
+
+func TestDefaultSourceParses(t *testing.T) {
+	source, err := GetDefaultTemplateSource("email-order-shipped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseTemplate(source.Name, source.Subject, source.HTML, source.Text)
+	if err != nil {
+		t.Fatalf("the default source doesn't parse: %s", err)
+	}
+
+	rendered, err := parsed.Render(testData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rendered.HTML, "Bolts &amp; Nuts") || !strings.Contains(rendered.Text, "1Z999") {
+		t.Errorf("the source renders differently than the default:\n%s\n%s", rendered.HTML, rendered.Text)
+	}
+
+	if _, err := GetDefaultTemplateSource("email-missing"); err != ErrTemplateNotFound {
+		t.Errorf("got %v for a missing template", err)
+	}
+}
+
+func TestRenderTemplateUsesOverrides(t *testing.T) {
+	overrides := newOverrides([]*entities.EmailTemplate{
+		{Name: "email-order", Subject: "Order {{.Order.Id}}", HTML: "<p>{{.CompanyName}}</p>", Text: "{{.CompanyName}}"},
+		{Name: "email-order-shipped", Subject: "{{.Broken", HTML: "", Text: ""},
+	}, nil)
+
+	rendered, err := renderTemplate(context.Background(), overrides, "email-order", testData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Rendered{Subject: "Order 7", HTML: "<p>Bolts &amp; Nuts</p>", Text: "Bolts & Nuts"}
+	if *rendered != *want {
+		t.Errorf("got %+v, want %+v", rendered, want)
+	}
+
+	rendered, err = renderTemplate(context.Background(), overrides, "email-order-shipped", testData())
+	if err != nil || !strings.Contains(rendered.Text, "1Z999") {
+		t.Errorf("an invalid override should fall back to the default, got %+v, %v", rendered, err)
+	}
+}
+
+func TestRenderTemplateFallsBackWhenOverridesFail(t *testing.T) {
+	overrides := newOverrides(nil, errors.New("Datastore unavailable."))
+	rendered, err := renderTemplate(context.Background(), overrides, "email-order-shipped", testData())
+	if err != nil || !strings.Contains(rendered.Text, "1Z999") {
+		t.Errorf("got %+v, %v", rendered, err)
+	}
+
+	if _, err := renderTemplate(context.Background(), overrides, "email-missing", testData()); err != ErrTemplateNotFound {
+		t.Errorf("got %v for a missing template", err)
+	}
+}
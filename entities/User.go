@@ -3,8 +3,10 @@ package entities
 import (
 	"errors"
 	"github.com/google/uuid"
+	"github.com/jcarm010/kodimerce/csrf"
 	"github.com/jcarm010/kodimerce/datastore"
 	"golang.org/x/net/context"
+	"time"
 )
 
 const (
@@ -14,6 +16,7 @@ const (
 
 var (
 	ErrUserAlreadyExists = errors.New("User already exists.")
+	ErrSessionExpired    = errors.New("Session expired.")
 )
 
 type User struct {
@@ -31,14 +34,18 @@ func NewUser(email string) *User {
 }
 
 type UserSession struct {
-	Email        string `json:"email" datastore:"email"`
-	SessionToken string `json:"session_token" datastore:"-"`
+	Email        string    `json:"email" datastore:"email"`
+	SessionToken string    `json:"session_token" datastore:"-"`
+	CSRFToken    string    `json:"-" datastore:"csrf_token,noindex"`
+	Created      time.Time `json:"created" datastore:"created,noindex"`
 }
 
 func NewUserSession(sessionToken string, email string) *UserSession {
 	return &UserSession{
 		SessionToken: sessionToken,
 		Email:        email,
+		CSRFToken:    csrf.NewToken(),
+		Created:      time.Now(),
 	}
 }
 
@@ -98,7 +105,9 @@ func CreateUserSession(ctx context.Context, email string) (*UserSession, error)
 	return userSession, nil
 }
 
-func GetUserSession(ctx context.Context, sessionToken string) (*UserSession, error) {
+// GetUserSession returns the session with sessionToken. Sessions older than the ttl fail with
+// ErrSessionExpired, a ttl of 0 never expires them.
+func GetUserSession(ctx context.Context, sessionToken string, ttl time.Duration) (*UserSession, error) {
 	key := datastore.NewKey(ctx, EntityUserSession, sessionToken, 0, nil)
 	userSession := &UserSession{}
 	err := datastore.Get(ctx, key, userSession)
@@ -106,6 +115,65 @@ func GetUserSession(ctx context.Context, sessionToken string) (*UserSession, err
 		return nil, err
 	}
 
+	if userSession.expired(ttl, time.Now()) {
+		return nil, ErrSessionExpired
+	}
+
 	userSession.SessionToken = sessionToken
 	return userSession, nil
 }
+
+func (s *UserSession) expired(ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && !s.Created.IsZero() && now.Sub(s.Created) > ttl
+}
+
+// ExpireUserSessions deletes the sessions older than ttl. Sessions created before sessions had a
+// creation date are stamped with the current time so they expire a ttl from now.
+func ExpireUserSessions(ctx context.Context, ttl time.Duration) (expired int, stamped int, err error) {
+	sessions := make([]*UserSession, 0)
+	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityUserSession), &sessions)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	expiredKeys := make([]*datastore.Key, 0)
+	stampedKeys := make([]*datastore.Key, 0)
+	stampedSessions := make([]*UserSession, 0)
+	for index, session := range sessions {
+		if session.Created.IsZero() {
+			session.Created = now
+			stampedKeys = append(stampedKeys, keys[index])
+			stampedSessions = append(stampedSessions, session)
+		} else if session.expired(ttl, now) {
+			expiredKeys = append(expiredKeys, keys[index])
+		}
+	}
+
+	// datastore takes at most 500 entities per batch
+	for start := 0; start < len(expiredKeys); start += 500 {
+		end := start + 500
+		if end > len(expiredKeys) {
+			end = len(expiredKeys)
+		}
+
+		err = datastore.DeleteMulti(ctx, expiredKeys[start:end])
+		if err != nil {
+			return start, 0, err
+		}
+	}
+
+	for start := 0; start < len(stampedKeys); start += 500 {
+		end := start + 500
+		if end > len(stampedKeys) {
+			end = len(stampedKeys)
+		}
+
+		_, err = datastore.PutMulti(ctx, stampedKeys[start:end], stampedSessions[start:end])
+		if err != nil {
+			return len(expiredKeys), start, err
+		}
+	}
+
+	return len(expiredKeys), len(stampedKeys), nil
+}
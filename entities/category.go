@@ -134,6 +134,33 @@ func GetCategoryProducts(ctx context.Context) ([]*CategoryProduct, error) {
 	return categoryProducts, nil
 }
 
+// GetProductCategories returns the categories a product is in.
+func GetProductCategories(ctx context.Context, productId int64) ([]*Category, error) {
+	categoryProducts := make([]*CategoryProduct, 0)
+	_, err := datastore.GetAll(ctx, datastore.NewQuery(EntityCategoryProduct).Filter("product_id=", productId), &categoryProducts)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*datastore.Key, len(categoryProducts))
+	for index, cp := range categoryProducts {
+		keys[index] = datastore.NewKey(ctx, EntityCategory, "", cp.CategoryId, nil)
+	}
+
+	categories := make([]*Category, len(keys))
+	err = datastore.GetMulti(ctx, keys, categories)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, category := range categories {
+		category.Id = keys[index].IntID()
+		category.SetMissingDefaults()
+	}
+
+	return categories, nil
+}
+
 func ListCategoriesByName(ctx context.Context, name string) ([]*Category, error) {
 	categories := make([]*Category, 0)
 	query := datastore.NewQuery(EntityCategory)
@@ -0,0 +1,183 @@
+package entities
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const (
+	EntityEmailMessage        = "email_message"
+	EmailMessageStatusQueued  = "queued"
+	EmailMessageStatusSent    = "sent"
+	EmailMessageStatusDead    = "dead"
+	EmailMessageStatusSending = "sending"
+)
+
+var (
+	ErrEmailMessageNotFound = errors.New("Email message not found.")
+)
+
+// EmailMessage is an email waiting in, or already processed by, the outbound queue.
+// Deliveries records every try so admins can see why a message was dead-lettered.
+type EmailMessage struct {
+	Id          int64                  `datastore:"-" json:"id"`
+	From        string                 `datastore:"from,noindex" json:"from"`
+	To          string                 `datastore:"to" json:"to"`
+	Bcc         string                 `datastore:"bcc,noindex" json:"bcc"`
+	ReplyTo     string                 `datastore:"reply_to,noindex" json:"reply_to"`
+	Subject     string                 `datastore:"subject,noindex" json:"subject"`
+	Body        string                 `datastore:"body,noindex" json:"body,omitempty"`
+	Text        string                 `datastore:"text,noindex" json:"text,omitempty"`
+	Status      string                 `datastore:"status" json:"status"`
+	Attempts    int                    `datastore:"attempts,noindex" json:"attempts"`
+	LastError   string                 `datastore:"last_error,noindex" json:"last_error"`
+	NextAttempt time.Time              `datastore:"next_attempt" json:"next_attempt"`
+	Created     time.Time              `datastore:"created" json:"created"`
+	SentDate    time.Time              `datastore:"sent_date,noindex" json:"sent_date"`
+	Deliveries  []EmailDeliveryAttempt `datastore:"deliveries,noindex" json:"deliveries"`
+}
+
+type EmailDeliveryAttempt struct {
+	Date  time.Time `datastore:"date,noindex" json:"date"`
+	Error string    `datastore:"error,noindex" json:"error"`
+}
+
+func NewEmailMessage(from string, to string, subject string, body string, bcc string) *EmailMessage {
+	now := time.Now()
+	return &EmailMessage{
+		From:        from,
+		To:          to,
+		Bcc:         bcc,
+		Subject:     subject,
+		Body:        body,
+		Status:      EmailMessageStatusQueued,
+		NextAttempt: now,
+		Created:     now,
+		Deliveries:  make([]EmailDeliveryAttempt, 0),
+	}
+}
+
+func CreateEmailMessage(ctx context.Context, message *EmailMessage) error {
+	key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, EntityEmailMessage, nil), message)
+	if err != nil {
+		return err
+	}
+
+	message.Id = key.IntID()
+	return nil
+}
+
+func GetEmailMessage(ctx context.Context, id int64) (*EmailMessage, error) {
+	message := &EmailMessage{}
+	err := datastore.Get(ctx, datastore.NewKey(ctx, EntityEmailMessage, "", id, nil), message)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrEmailMessageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	message.Id = id
+	return message, nil
+}
+
+func UpdateEmailMessage(ctx context.Context, message *EmailMessage) error {
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, EntityEmailMessage, "", message.Id, nil), message)
+	return err
+}
+
+// ClaimEmailMessage moves a due message to the sending status inside a transaction so that two
+// workers never deliver the same message. The claim is a lease: if the worker dies before recording
+// the outcome the message becomes due again once lease has passed. It returns false when the
+// message is not due or was already taken.
+func ClaimEmailMessage(ctx context.Context, id int64, lease time.Duration) (*EmailMessage, bool, error) {
+	key := datastore.NewKey(ctx, EntityEmailMessage, "", id, nil)
+	message := &EmailMessage{}
+	claimed := false
+	err := datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		claimed = false
+		err := transaction.Get(key, message)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !message.isDue(now) {
+			return nil
+		}
+
+		message.Status = EmailMessageStatusSending
+		message.NextAttempt = now.Add(lease)
+		_, err = transaction.Put(key, message)
+		claimed = err == nil
+		return err
+	})
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil, false, ErrEmailMessageNotFound
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	message.Id = id
+	return message, claimed, nil
+}
+
+func (m *EmailMessage) isDue(now time.Time) bool {
+	if m.Status != EmailMessageStatusQueued && m.Status != EmailMessageStatusSending {
+		return false
+	}
+
+	return !m.NextAttempt.After(now)
+}
+
+// ListEmailMessages returns the newest messages first, optionally only the ones with status.
+func ListEmailMessages(ctx context.Context, status string, limit int) ([]*EmailMessage, error) {
+	messages := make([]*EmailMessage, 0)
+	q := datastore.NewQuery(EntityEmailMessage)
+	if status != "" {
+		q = q.Filter("status=", status)
+	}
+
+	q = q.Order("-created").Limit(limit)
+	keys, err := datastore.GetAll(ctx, q, &messages)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, key := range keys {
+		messages[index].Id = key.IntID()
+	}
+
+	return messages, nil
+}
+
+// ListDueEmailMessages returns the ids of queued messages whose next attempt is due, followed by
+// messages whose sending lease expired.
+func ListDueEmailMessages(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	ids := make([]int64, 0)
+	for _, status := range []string{EmailMessageStatusQueued, EmailMessageStatusSending} {
+		if len(ids) >= limit {
+			break
+		}
+
+		q := datastore.NewQuery(EntityEmailMessage).
+			Filter("status=", status).
+			Filter("next_attempt<=", now).
+			Order("next_attempt").
+			Limit(limit - len(ids)).
+			KeysOnly()
+
+		keys, err := datastore.GetAll(ctx, q, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			ids = append(ids, key.IntID())
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,61 @@
+package entities
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const EntityEmailTemplate = "email_template"
+
+var (
+	ErrEmailTemplateNotFound = errors.New("Email template not found.")
+)
+
+// EmailTemplate overrides the default email template with the same name.
+type EmailTemplate struct {
+	Name      string    `datastore:"-" json:"name"`
+	Subject   string    `datastore:"subject,noindex" json:"subject"`
+	HTML      string    `datastore:"html,noindex" json:"html"`
+	Text      string    `datastore:"text,noindex" json:"text"`
+	Updated   time.Time `datastore:"updated,noindex" json:"updated"`
+	UpdatedBy string    `datastore:"updated_by,noindex" json:"updated_by"`
+}
+
+func GetEmailTemplate(ctx context.Context, name string) (*EmailTemplate, error) {
+	emailTemplate := &EmailTemplate{}
+	err := datastore.Get(ctx, datastore.NewKey(ctx, EntityEmailTemplate, name, 0, nil), emailTemplate)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrEmailTemplateNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	emailTemplate.Name = name
+	return emailTemplate, nil
+}
+
+func ListEmailTemplates(ctx context.Context) ([]*EmailTemplate, error) {
+	emailTemplates := make([]*EmailTemplate, 0)
+	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityEmailTemplate), &emailTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, key := range keys {
+		emailTemplates[index].Name = key.StringID()
+	}
+
+	return emailTemplates, nil
+}
+
+func SaveEmailTemplate(ctx context.Context, emailTemplate *EmailTemplate) error {
+	emailTemplate.Updated = time.Now()
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, EntityEmailTemplate, emailTemplate.Name, 0, nil), emailTemplate)
+	return err
+}
+
+func DeleteEmailTemplate(ctx context.Context, name string) error {
+	return datastore.Delete(ctx, datastore.NewKey(ctx, EntityEmailTemplate, name, 0, nil))
+}
@@ -0,0 +1,37 @@
+package entities
+
+import (
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"strings"
+	"time"
+)
+
+const EntityEmailUnsubscribe = "email_unsubscribe"
+
+// EmailUnsubscribe is an address that asked not to get marketing emails, such as abandoned checkout
+// reminders. Order notifications are still sent to it. It is keyed by the lower cased address.
+type EmailUnsubscribe struct {
+	Email   string    `datastore:"-" json:"email"`
+	Created time.Time `datastore:"created,noindex" json:"created"`
+}
+
+func emailUnsubscribeKey(ctx context.Context, email string) *datastore.Key {
+	return datastore.NewKey(ctx, EntityEmailUnsubscribe, strings.ToLower(strings.TrimSpace(email)), 0, nil)
+}
+
+func IsEmailUnsubscribed(ctx context.Context, email string) (bool, error) {
+	err := datastore.Get(ctx, emailUnsubscribeKey(ctx, email), &EmailUnsubscribe{})
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func UnsubscribeEmail(ctx context.Context, email string) error {
+	_, err := datastore.Put(ctx, emailUnsubscribeKey(ctx, email), &EmailUnsubscribe{Created: time.Now()})
+	return err
+}
@@ -149,6 +149,23 @@ func UpdateGallery(ctx context.Context, gallery *Gallery) error {
 	return nil
 }
 
+func GetGallery(ctx context.Context, galleryId int64) (*Gallery, error) {
+	key := datastore.NewKey(ctx, EntityGallery, "", galleryId, nil)
+	gallery := &Gallery{}
+	err := datastore.Get(ctx, key, gallery)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrGalleryNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	gallery.SetMissingDefaults()
+	gallery.Id = key.IntID()
+	return gallery, nil
+}
+
 func GetGalleryByPath(ctx context.Context, path string) (*Gallery, error) {
 	galleries := make([]*Gallery, 0)
 	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityGallery).
@@ -0,0 +1,154 @@
+package entities
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const (
+	EntityJobLock = "job_lock"
+	EntityJobRun  = "job_run"
+
+	JobRunStatusRunning   = "running"
+	JobRunStatusSucceeded = "succeeded"
+	JobRunStatusFailed    = "failed"
+)
+
+var (
+	ErrJobLocked = errors.New("Job is already running.")
+	ErrJobNotDue = errors.New("Job ran recently.")
+)
+
+// JobLock is the lease an instance holds while it runs a job, so the job never runs twice at the
+// same time across instances. It is keyed by the job name and remembers the job's last run.
+type JobLock struct {
+	Job          string    `datastore:"-" json:"job"`
+	Owner        string    `datastore:"owner,noindex" json:"owner"`
+	Expires      time.Time `datastore:"expires,noindex" json:"expires"`
+	LastStarted  time.Time `datastore:"last_started,noindex" json:"last_started"`
+	LastFinished time.Time `datastore:"last_finished,noindex" json:"last_finished"`
+	LastStatus   string    `datastore:"last_status,noindex" json:"last_status"`
+}
+
+// JobRun is the history of one run of a job.
+type JobRun struct {
+	Id       int64     `datastore:"-" json:"id"`
+	Job      string    `datastore:"job" json:"job"`
+	Trigger  string    `datastore:"trigger,noindex" json:"trigger"`
+	Owner    string    `datastore:"owner,noindex" json:"owner"`
+	Status   string    `datastore:"status,noindex" json:"status"`
+	Result   string    `datastore:"result,noindex" json:"result"`
+	Error    string    `datastore:"error,noindex" json:"error"`
+	Started  time.Time `datastore:"started" json:"started"`
+	Finished time.Time `datastore:"finished,noindex" json:"finished"`
+}
+
+// AcquireJobLock takes the lock of job for owner until lease runs out. It fails with ErrJobLocked
+// when someone else holds the lock and with ErrJobNotDue when the job started less than
+// minInterval ago.
+func AcquireJobLock(ctx context.Context, job string, owner string, lease time.Duration, minInterval time.Duration) (*JobLock, error) {
+	key := datastore.NewKey(ctx, EntityJobLock, job, 0, nil)
+	lock := &JobLock{}
+	err := datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		*lock = JobLock{}
+		err := transaction.Get(key, lock)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		now := time.Now()
+		if lock.Owner != "" && lock.Owner != owner && lock.Expires.After(now) {
+			return ErrJobLocked
+		}
+
+		if minInterval > 0 && now.Sub(lock.LastStarted) < minInterval {
+			return ErrJobNotDue
+		}
+
+		lock.Owner = owner
+		lock.Expires = now.Add(lease)
+		lock.LastStarted = now
+		_, err = transaction.Put(key, lock)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Job = job
+	return lock, nil
+}
+
+// ReleaseJobLock gives back the lock of job held by owner and records how the run went.
+func ReleaseJobLock(ctx context.Context, job string, owner string, status string) error {
+	key := datastore.NewKey(ctx, EntityJobLock, job, 0, nil)
+	return datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		lock := &JobLock{}
+		err := transaction.Get(key, lock)
+		if err != nil {
+			return err
+		}
+
+		if lock.Owner != owner {
+			// the lease ran out and someone else took over
+			return nil
+		}
+
+		lock.Owner = ""
+		lock.Expires = time.Time{}
+		lock.LastFinished = time.Now()
+		lock.LastStatus = status
+		_, err = transaction.Put(key, lock)
+		return err
+	})
+}
+
+func ListJobLocks(ctx context.Context) (map[string]*JobLock, error) {
+	locks := make([]*JobLock, 0)
+	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityJobLock), &locks)
+	if err != nil {
+		return nil, err
+	}
+
+	byJob := map[string]*JobLock{}
+	for index, key := range keys {
+		locks[index].Job = key.StringID()
+		byJob[key.StringID()] = locks[index]
+	}
+
+	return byJob, nil
+}
+
+func CreateJobRun(ctx context.Context, run *JobRun) error {
+	key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, EntityJobRun, nil), run)
+	if err != nil {
+		return err
+	}
+
+	run.Id = key.IntID()
+	return nil
+}
+
+func UpdateJobRun(ctx context.Context, run *JobRun) error {
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, EntityJobRun, "", run.Id, nil), run)
+	return err
+}
+
+// ListJobRuns returns the latest runs of job, newest first.
+func ListJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error) {
+	runs := make([]*JobRun, 0)
+	q := datastore.NewQuery(EntityJobRun).Filter("job =", job).Order("-started").Limit(limit)
+	keys, err := datastore.GetAll(ctx, q, &runs)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, key := range keys {
+		runs[index].Id = key.IntID()
+	}
+
+	return runs, nil
+}
@@ -2,101 +2,115 @@ package entities
 
 import (
 	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/search"
 	"github.com/jcarm010/kodimerce/search_api"
 	"golang.org/x/net/context"
 	"google.golang.org/api/iterator"
-	"strconv"
 	"strings"
 )
 
 type BlobResponse struct {
-	Blobs  []*search_api.BlobInfo `json:"blobs"`
-	Cursor string                 `json:"cursor"`
-	Total  int                    `json:"total"`
+	Blobs   []*search_api.BlobInfo `json:"blobs"`
+	Cursor  string                 `json:"cursor"`
+	Total   int                    `json:"total"`
+	Folders []search.FacetCount    `json:"folders,omitempty"` // only when searching or filtering
+	Tags    []search.FacetCount    `json:"tags,omitempty"`    // only when searching or filtering
+}
+
+// UploadMetadata is what admins can edit about an upload.
+type UploadMetadata struct {
+	Title   string
+	Alt     string
+	Caption string
+	Folder  string
+	Tags    []string
 }
 
 const EntityBlob = "file_uploads"
 
+func init() {
+	search_api.SetLoader(ListAllUploads)
+}
+
+// InitSearchAPI loads every upload into the search index again.
 func InitSearchAPI(ctx context.Context) error {
+	return search_api.NewClient(ctx).Rebuild()
+}
+
+// ListAllUploads returns every upload, such as to load them into the search index.
+func ListAllUploads(ctx context.Context) ([]*search_api.BlobInfo, error) {
 	blobs := make([]*search_api.BlobInfo, 0)
 	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityBlob), &blobs)
 	if err != nil {
 		index := strings.Index(err.Error(), "datastore: cannot load field")
 		if index != 0 {
-			return err
+			return nil, err
 		}
 	}
 
-	searchClient := search_api.NewClient(ctx)
 	for i, blob := range blobs {
-		sizeString := strconv.Itoa(int(blob.Size))
-		searchBlob := search_api.SearchBlob{
-			BlobKey:      keys[i].StringID(),
-			ContentType:  blob.ContentType,
-			CreationTime: blob.CreationTime,
-			Filename:     blob.Filename,
-			MD5:          blob.MD5,
-			ObjectName:   blob.ObjectName,
-			Size:         sizeString,
-		}
-
-		err = searchClient.Init(searchBlob)
-		if err != nil {
-			return err
-		}
+		blob.BlobKey = keys[i].StringID()
 	}
 
-	return nil
+	return blobs, nil
 }
 
-func ListUploads(ctx context.Context, cursorStr string, limit int, search string) (*BlobResponse, error) {
-	search = "" //todo: search needs to be implemented without AppEngine search
+// ListUploads pages through the uploads, or through the ones matching searchText and filters
+// when there are any.
+func ListUploads(ctx context.Context, cursorStr string, limit int, searchText string, filters search_api.BlobFilters) (*BlobResponse, error) {
 	blobs := make([]*search_api.BlobInfo, 0)
 	var err error
 	var total int
-	if search != "" {
+	if searchText != "" || !filters.Empty() {
 		searchClient := search_api.NewClient(ctx)
-		blobs, cursorStr, total, err = searchClient.GetBlobs(search, limit, cursorStr)
+		results, err := searchClient.GetBlobs(searchText, filters, limit, cursorStr)
 		if err != nil {
 			return nil, err
 		}
 
-	} else {
-		total, err = datastore.Count(ctx, datastore.NewQuery(EntityBlob))
-		if err != nil {
-			return nil, err
-		}
+		return &BlobResponse{
+			Blobs:   results.Blobs,
+			Cursor:  results.Cursor,
+			Total:   results.Total,
+			Folders: results.Folders,
+			Tags:    results.Tags,
+		}, nil
+	}
 
-		query := datastore.NewQuery(EntityBlob).Limit(limit)
-		if cursorStr != "" {
-			cursor, err := datastore.DecodeCursor(cursorStr)
+	total, err = datastore.Count(ctx, datastore.NewQuery(EntityBlob))
+	if err != nil {
+		return nil, err
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	query := datastore.NewQuery(EntityBlob).Limit(limit)
+	if cursorStr != "" {
+		cursor, err := datastore.DecodeCursor(cursorStr)
 
-			query = query.Start(cursor)
+		if err != nil {
+			return nil, err
 		}
 
-		t := datastore.Run(ctx, query)
-		for {
-			var blob search_api.BlobInfo
-			key, err := t.Next(&blob)
-			if err == iterator.Done {
-				break
-			}
-
-			if err != nil {
-				return nil, err
-			}
+		query = query.Start(cursor)
+	}
 
-			blob.BlobKey = key.Name
-			blobs = append(blobs, &blob)
+	t := datastore.Run(ctx, query)
+	for {
+		var blob search_api.BlobInfo
+		key, err := t.Next(&blob)
+		if err == iterator.Done {
+			break
 		}
 
-		if cursor, err := t.Cursor(); err == nil {
-			cursorStr = cursor.String()
+		if err != nil {
+			return nil, err
 		}
+
+		blob.BlobKey = key.Name
+		blobs = append(blobs, &blob)
+	}
+
+	if cursor, err := t.Cursor(); err == nil {
+		cursorStr = cursor.String()
 	}
 
 	blobResp := BlobResponse{
@@ -147,5 +161,89 @@ func GetUploadByName(ctx context.Context, name string) (*search_api.BlobInfo, er
 func PutUpload(ctx context.Context, info *search_api.BlobInfo) error {
 	key := datastore.NewKey(ctx, EntityBlob, info.BlobKey, 0, nil)
 	_, err := datastore.Put(ctx, key, info)
-	return err
-}
\ No newline at end of file
+	if err != nil {
+		return err
+	}
+
+	return search_api.NewClient(ctx).PutBlob(info)
+}
+
+// ListUploadObjectNames returns the storage object name and the key of every upload.
+func ListUploadObjectNames(ctx context.Context) (names map[string]bool, keys map[string]bool, err error) {
+	blobs, err := ListAllUploads(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names = map[string]bool{}
+	keys = map[string]bool{}
+	for _, blob := range blobs {
+		names[blob.ObjectName] = true
+		keys[blob.BlobKey] = true
+	}
+
+	return names, keys, nil
+}
+
+// GetUploadByMD5 returns an upload with the given content hash, nil when there is none.
+func GetUploadByMD5(ctx context.Context, md5Hash string) (*search_api.BlobInfo, error) {
+	blobs := make([]*search_api.BlobInfo, 0)
+	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityBlob).Filter("md5_hash=", md5Hash).Limit(1), &blobs)
+	if err != nil {
+		index := strings.Index(err.Error(), "datastore: cannot load field")
+		if index != 0 {
+			return nil, err
+		}
+	}
+
+	if len(blobs) == 0 {
+		return nil, nil
+	}
+
+	blobs[0].BlobKey = keys[0].StringID()
+	return blobs[0], nil
+}
+
+// UpdateUploadMetadata replaces the editable metadata of an upload.
+func UpdateUploadMetadata(ctx context.Context, key string, metadata *UploadMetadata) (*search_api.BlobInfo, error) {
+	k := datastore.NewKey(ctx, EntityBlob, key, 0, nil)
+	blob := &search_api.BlobInfo{}
+	err := datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		*blob = search_api.BlobInfo{}
+		err := transaction.Get(k, blob)
+		if err != nil && !strings.HasPrefix(err.Error(), "datastore: cannot load field") {
+			return err
+		}
+
+		blob.Title = metadata.Title
+		blob.Alt = metadata.Alt
+		blob.Caption = metadata.Caption
+		blob.Folder = metadata.Folder
+		blob.Tags = metadata.Tags
+		_, err = transaction.Put(k, blob)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	blob.BlobKey = key
+	return blob, search_api.NewClient(ctx).PutBlob(blob)
+}
+
+// CountUploadsOfObject returns how many uploads have their file stored as objectName. Imported
+// archives can record a file that was already uploaded under a second key.
+func CountUploadsOfObject(ctx context.Context, objectName string) (int, error) {
+	return datastore.Count(ctx, datastore.NewQuery(EntityBlob).Filter("gs_object_name=", objectName))
+}
+
+// DeleteUpload deletes an upload from the datastore and from the search index, not its file.
+func DeleteUpload(ctx context.Context, key string) error {
+	err := datastore.Delete(ctx, datastore.NewKey(ctx, EntityBlob, key, 0, nil))
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	return search_api.NewClient(ctx).DeleteIndex(key)
+}
@@ -0,0 +1,74 @@
+package entities
+
+import (
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const EntityOIDCLogin = "oidc_login"
+
+// OIDCLoginTimeout is how long the identity provider has to redirect back before the login expires.
+const OIDCLoginTimeout = 10 * time.Minute
+
+// OIDCLogin keeps the PKCE verifier and nonce of a login that was sent to the identity
+// provider until the provider redirects back with the same state.
+type OIDCLogin struct {
+	State        string    `json:"state" datastore:"-"`
+	CodeVerifier string    `json:"-" datastore:"code_verifier,noindex"`
+	Nonce        string    `json:"-" datastore:"nonce,noindex"`
+	Created      time.Time `json:"created" datastore:"created"`
+}
+
+func CreateOIDCLogin(ctx context.Context, login *OIDCLogin) error {
+	login.Created = time.Now()
+	key := datastore.NewKey(ctx, EntityOIDCLogin, login.State, 0, nil)
+	_, err := datastore.Put(ctx, key, login)
+	return err
+}
+
+// ConsumeOIDCLogin returns the login stored for state and deletes it so that it can only be used once.
+func ConsumeOIDCLogin(ctx context.Context, state string) (*OIDCLogin, error) {
+	key := datastore.NewKey(ctx, EntityOIDCLogin, state, 0, nil)
+	login := &OIDCLogin{}
+	err := datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		err := transaction.Get(key, login)
+		if err != nil {
+			return err
+		}
+
+		return transaction.Delete(key)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	login.State = state
+	return login, nil
+}
+
+// DeleteExpiredOIDCLogins deletes the logins that started before before, the ones whose user never
+// came back from the identity provider.
+func DeleteExpiredOIDCLogins(ctx context.Context, before time.Time) (int, error) {
+	q := datastore.NewQuery(EntityOIDCLogin).Filter("created<", before).KeysOnly()
+	keys, err := datastore.GetAll(ctx, q, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// datastore takes at most 500 entities per batch
+	for start := 0; start < len(keys); start += 500 {
+		end := start + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		err = datastore.DeleteMulti(ctx, keys[start:end])
+		if err != nil {
+			return start, err
+		}
+	}
+
+	return len(keys), nil
+}
@@ -2,10 +2,12 @@ package entities
 
 import (
 	originalDataStore "cloud.google.com/go/datastore"
+	"errors"
 	"fmt"
 	"github.com/dustin/gojson"
 	"github.com/jcarm010/kodimerce/datastore"
 	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
 	"html/template"
 	"strings"
 	"time"
@@ -18,32 +20,49 @@ const (
 	OrderStatusProcessing = "processing"
 	OrderStatusShipped    = "shipped"
 	OrderStatusProcessed  = "processed"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusRefunded   = "refunded"
+	OrderStatusCancelled  = "cancelled"
+)
+
+var (
+	ErrOrderNotStarted       = errors.New("The order is no longer in checkout.")
+	ErrRecoveryEmailRecorded = errors.New("Another reminder was recorded for the order.")
 )
 
 type Order struct {
-	Id              int64             `datastore:"-" json:"id"`
-	ShippingName    string            `datastore:"shipping_name" json:"shipping_name"`
-	ShippingLine1   string            `datastore:"shipping_line_1,noindex" json:"shipping_line_1"`
-	ShippingLine2   string            `datastore:"shipping_line_2,noindex" json:"shipping_line_2"`
-	City            string            `datastore:"city" json:"city"`
-	State           string            `datastore:"state" json:"state"`
-	PostalCode      string            `datastore:"postal_code" json:"postal_code"`
-	CountryCode     string            `datastore:"country_code" json:"country_code"`
-	Email           string            `datastore:"email" json:"email"`
-	Phone           string            `datastore:"phone" json:"phone"`
-	ProductIds      []int64           `datastore:"product_ids,noindex" json:"product_ids"`
-	Quantities      []int64           `datastore:"quantities,noindex" json:"quantities"`
-	Status          string            `datastore:"status" json:"status"`
-	CheckoutStep    string            `datastore:"checkout_step" json:"checkout_step"`
-	Created         time.Time         `datastore:"created" json:"created"`
-	PaypalPaymentId string            `datastore:"paypal_payment_id" json:"paypal_payment_id"`
-	PaypalPayerId   string            `datastore:"paypal_payer_id" json:"paypal_payer_id"`
-	AddressVerified bool              `datastore:"address_verified" json:"address_verified"`
-	Products        []*Product        `datastore:"-" json:"products"`
-	ProductsSerial  []byte            `datastore:"products_serial,noindex" json:"-"`
-	NoShipping      bool              `datastore:"no_shipping" json:"no_shipping"`
-	ProductDetails  []*ProductDetails `datastore:"-" json:"product_details"`
-	TaxPercent      float64           `datastore:"tax_percent" json:"tax_percent"`
+	Id              int64                `datastore:"-" json:"id"`
+	ShippingName    string               `datastore:"shipping_name" json:"shipping_name"`
+	ShippingLine1   string               `datastore:"shipping_line_1,noindex" json:"shipping_line_1"`
+	ShippingLine2   string               `datastore:"shipping_line_2,noindex" json:"shipping_line_2"`
+	City            string               `datastore:"city" json:"city"`
+	State           string               `datastore:"state" json:"state"`
+	PostalCode      string               `datastore:"postal_code" json:"postal_code"`
+	CountryCode     string               `datastore:"country_code" json:"country_code"`
+	Email           string               `datastore:"email" json:"email"`
+	Phone           string               `datastore:"phone" json:"phone"`
+	ProductIds      []int64              `datastore:"product_ids,noindex" json:"product_ids"`
+	Quantities      []int64              `datastore:"quantities,noindex" json:"quantities"`
+	Status          string               `datastore:"status" json:"status"`
+	CheckoutStep    string               `datastore:"checkout_step" json:"checkout_step"`
+	Created         time.Time            `datastore:"created" json:"created"`
+	PaypalPaymentId string               `datastore:"paypal_payment_id" json:"paypal_payment_id"`
+	PaypalPayerId   string               `datastore:"paypal_payer_id" json:"paypal_payer_id"`
+	AddressVerified bool                 `datastore:"address_verified" json:"address_verified"`
+	Products        []*Product           `datastore:"-" json:"products"`
+	ProductsSerial  []byte               `datastore:"products_serial,noindex" json:"-"`
+	NoShipping      bool                 `datastore:"no_shipping" json:"no_shipping"`
+	ProductDetails  []*ProductDetails    `datastore:"-" json:"product_details"`
+	TaxPercent      float64              `datastore:"tax_percent" json:"tax_percent"`
+	Carrier         string               `datastore:"carrier,noindex" json:"carrier"`
+	TrackingNumber  string               `datastore:"tracking_number" json:"tracking_number"`
+	TrackingUrl     string               `datastore:"tracking_url,noindex" json:"tracking_url"`
+	Notifications   []*OrderNotification `datastore:"-" json:"notifications"`
+
+	RecoveryEmailsSent  int       `datastore:"recovery_emails_sent" json:"recovery_emails_sent"`
+	LastRecoveryEmail   time.Time `datastore:"last_recovery_email,noindex" json:"last_recovery_email"`
+	RecoveryClickedDate time.Time `datastore:"recovery_clicked_date,noindex" json:"recovery_clicked_date"`
+	Recovered           bool      `datastore:"recovered" json:"recovered"`
 }
 
 func (o *Order) Load(ps []originalDataStore.Property) error {
@@ -60,6 +79,18 @@ func (o *Order) Load(ps []originalDataStore.Property) error {
 				return err
 			}
 		}
+
+		if ps.Name == "notifications" {
+			valueBts, ok := ps.Value.([]byte)
+			if !ok {
+				continue
+			}
+
+			err := json.Unmarshal(valueBts, &o.Notifications)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -71,6 +102,11 @@ func (o *Order) Save() ([]originalDataStore.Property, error) {
 		return nil, err
 	}
 
+	notificationsBts, err := json.Marshal(o.Notifications)
+	if err != nil {
+		return nil, err
+	}
+
 	properties, err := originalDataStore.SaveStruct(o)
 	if err != nil {
 		return nil, err
@@ -80,6 +116,10 @@ func (o *Order) Save() ([]originalDataStore.Property, error) {
 		Name:    "product_details",
 		Value:   productDetailsBts,
 		NoIndex: true,
+	}, originalDataStore.Property{
+		Name:    "notifications",
+		Value:   notificationsBts,
+		NoIndex: true,
 	})
 
 	return properties, nil
@@ -90,6 +130,15 @@ func (o *Order) StatusCapitalized() string {
 }
 
 func (o *Order) OrderSummaryHtml() template.HTML {
+	return template.HTML(o.orderSummary("<br>"))
+}
+
+// OrderSummaryText is the plain-text version of OrderSummaryHtml, used by text emails.
+func (o *Order) OrderSummaryText() string {
+	return o.orderSummary("\n")
+}
+
+func (o *Order) orderSummary(lineBreak string) string {
 	productSummaries := ""
 	for index, product := range o.Products {
 
@@ -116,16 +165,16 @@ func (o *Order) OrderSummaryHtml() template.HTML {
 			loc = "- " + o.ProductDetails[index].PickupLocation
 		}
 
-		productSummaries += fmt.Sprintf("%s x %v %s %s %s<br>", name, o.Quantities[index], date, t, loc)
+		productSummaries += fmt.Sprintf("%s x %v %s %s %s%s", name, o.Quantities[index], date, t, loc, lineBreak)
 	}
-	return template.HTML(fmt.Sprintf(
-		"Order#: %v<br>"+
-			"Order Total: %v<br>"+
-			"Name: %s<br>"+
-			"Email: %s<br>"+
-			"Phone: %s<br>"+
-			"Address: %s<br>"+
-			"Product Summary:<br>%s",
+	return fmt.Sprintf(
+		"Order#: %v"+lineBreak+
+			"Order Total: %v"+lineBreak+
+			"Name: %s"+lineBreak+
+			"Email: %s"+lineBreak+
+			"Phone: %s"+lineBreak+
+			"Address: %s"+lineBreak+
+			"Product Summary:"+lineBreak+"%s",
 		o.Id,
 		o.OrderTotal(),
 		o.ShippingName,
@@ -133,7 +182,7 @@ func (o *Order) OrderSummaryHtml() template.HTML {
 		o.Phone,
 		fmt.Sprintf("%s, %s, %s, %s, %s, %s", o.ShippingLine1, o.ShippingLine2, o.City, o.PostalCode, o.State, o.CountryCode),
 		productSummaries,
-	))
+	)
 }
 
 func (o *Order) OrderTotal() float64 {
@@ -272,3 +321,144 @@ func ListOrders(ctx context.Context) ([]*Order, error) {
 
 	return orders, nil
 }
+
+// RecordRecoveryEmail counts an abandoned checkout reminder sent at sentAt to an order that had
+// been sent sentBefore of them. It fails with ErrOrderNotStarted when the order left checkout and
+// with ErrRecoveryEmailRecorded when another reminder was counted since the order was read.
+func RecordRecoveryEmail(ctx context.Context, orderId int64, sentBefore int, sentAt time.Time) error {
+	return updateStartedOrder(ctx, orderId, func(order *Order) error {
+		if order.RecoveryEmailsSent != sentBefore {
+			return ErrRecoveryEmailRecorded
+		}
+
+		order.RecoveryEmailsSent++
+		order.LastRecoveryEmail = sentAt
+		return nil
+	})
+}
+
+// RecordRecoveryClick records the first time the link in a reminder was followed. It fails with
+// ErrOrderNotStarted when the order left checkout.
+func RecordRecoveryClick(ctx context.Context, orderId int64, clickedAt time.Time) error {
+	return updateStartedOrder(ctx, orderId, func(order *Order) error {
+		if order.RecoveryClickedDate.IsZero() {
+			order.RecoveryClickedDate = clickedAt
+		}
+
+		return nil
+	})
+}
+
+// updateStartedOrder re-reads a started order in a transaction before update changes it, so
+// changes made to the order since it was read elsewhere are kept.
+func updateStartedOrder(ctx context.Context, orderId int64, update func(order *Order) error) error {
+	key := datastore.NewKey(ctx, EntityOrder, "", orderId, nil)
+	return datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		order := &Order{}
+		err := transaction.Get(key, order)
+		if err != nil {
+			return err
+		}
+
+		if order.Status != OrderStatusStarted {
+			return ErrOrderNotStarted
+		}
+
+		err = update(order)
+		if err != nil {
+			return err
+		}
+
+		_, err = transaction.Put(key, order)
+		return err
+	})
+}
+
+// ListAbandonedOrders returns the started orders created between createdAfter and createdBefore.
+func ListAbandonedOrders(ctx context.Context, createdAfter time.Time, createdBefore time.Time) ([]*Order, error) {
+	q := datastore.NewQuery(EntityOrder).
+		Filter("status =", OrderStatusStarted).
+		Filter("created >", createdAfter).
+		Filter("created <=", createdBefore).
+		Order("created")
+
+	return listOrders(ctx, q)
+}
+
+// ListStartedOrdersBatch returns up to limit of the started orders created before createdBefore,
+// oldest first, from cursor on, along with the cursor of the next batch, empty after the last one.
+func ListStartedOrdersBatch(ctx context.Context, createdBefore time.Time, cursor string, limit int) ([]*Order, string, error) {
+	q := datastore.NewQuery(EntityOrder).
+		Filter("status =", OrderStatusStarted).
+		Filter("created <=", createdBefore).
+		Order("created").
+		Limit(limit)
+
+	if cursor != "" {
+		start, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		q = q.Start(start)
+	}
+
+	orders := make([]*Order, 0, limit)
+	t := datastore.Run(ctx, q)
+	for {
+		order := &Order{}
+		key, err := t.Next(order)
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		order.Id = key.ID
+		err = json.Unmarshal(order.ProductsSerial, &order.Products)
+		if err != nil {
+			return nil, "", err
+		}
+
+		orders = append(orders, order)
+	}
+
+	if len(orders) < limit {
+		return orders, "", nil
+	}
+
+	next, err := t.Cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return orders, next.String(), nil
+}
+
+// ListRemindedOrders returns the orders that were sent at least one abandoned checkout reminder.
+func ListRemindedOrders(ctx context.Context) ([]*Order, error) {
+	return listOrders(ctx, datastore.NewQuery(EntityOrder).Filter("recovery_emails_sent >", 0))
+}
+
+func listOrders(ctx context.Context, q *originalDataStore.Query) ([]*Order, error) {
+	orders := make([]*Order, 0)
+	keys, err := datastore.GetAll(ctx, q, &orders)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, key := range keys {
+		orders[index].Id = key.IntID()
+		products := make([]*Product, 0)
+		err = json.Unmarshal(orders[index].ProductsSerial, &products)
+		if err != nil {
+			return nil, err
+		}
+
+		orders[index].Products = products
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,52 @@
+package entities
+
+import (
+	"time"
+)
+
+const (
+	OrderEventPaid       = "paid"
+	OrderEventProcessing = "processing"
+	OrderEventShipped    = "shipped"
+	OrderEventDelivered  = "delivered"
+	OrderEventRefunded   = "refunded"
+	OrderEventCancelled  = "cancelled"
+)
+
+// OrderEvents lists every order lifecycle event customers can be notified about.
+var OrderEvents = []string{
+	OrderEventPaid,
+	OrderEventProcessing,
+	OrderEventShipped,
+	OrderEventDelivered,
+	OrderEventRefunded,
+	OrderEventCancelled,
+}
+
+// OrderNotification records a notification sent to the customer about an order event.
+type OrderNotification struct {
+	Event          string    `json:"event"`
+	Template       string    `json:"template"`
+	Email          string    `json:"email"`
+	EmailMessageId int64     `json:"email_message_id"`
+	Date           time.Time `json:"date"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// OrderEventForStatus returns the event an order moving into status triggers, or "" if it triggers none.
+func OrderEventForStatus(status string) string {
+	switch status {
+	case OrderStatusProcessing:
+		return OrderEventProcessing
+	case OrderStatusShipped:
+		return OrderEventShipped
+	case OrderStatusDelivered:
+		return OrderEventDelivered
+	case OrderStatusRefunded:
+		return OrderEventRefunded
+	case OrderStatusCancelled:
+		return OrderEventCancelled
+	}
+
+	return ""
+}
@@ -0,0 +1,35 @@
+package entities
+
+import "testing"
+
+func TestOrderEventForStatus(t *testing.T) {
+	tests := map[string]string{
+		OrderStatusStarted:    "",
+		OrderStatusPending:    "",
+		OrderStatusProcessing: OrderEventProcessing,
+		OrderStatusShipped:    OrderEventShipped,
+		OrderStatusProcessed:  "",
+		OrderStatusDelivered:  OrderEventDelivered,
+		OrderStatusRefunded:   OrderEventRefunded,
+		OrderStatusCancelled:  OrderEventCancelled,
+	}
+
+	for status, want := range tests {
+		if got := OrderEventForStatus(status); got != want {
+			t.Errorf("%s: got %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestNotificationEnabled(t *testing.T) {
+	settings := &ServerSettings{NotifyOrderShipped: true}
+	for _, event := range OrderEvents {
+		if got := settings.NotificationEnabled(event); got != (event == OrderEventShipped) {
+			t.Errorf("%s: got %v", event, got)
+		}
+	}
+
+	if settings.NotificationEnabled("unknown") {
+		t.Errorf("an unknown event is enabled")
+	}
+}
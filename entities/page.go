@@ -213,6 +213,23 @@ func UpdatePage(ctx context.Context, page *Page) error {
 	return nil
 }
 
+func GetPage(ctx context.Context, pageId int64) (*Page, error) {
+	key := datastore.NewKey(ctx, EntityPage, "", pageId, nil)
+	page := &Page{}
+	err := datastore.Get(ctx, key, page)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrPageNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	page.SetMissingDefaults()
+	page.Id = key.IntID()
+	return page, nil
+}
+
 func GetPageByPath(ctx context.Context, path string) (*Page, error) {
 	pages := make([]*Page, 0)
 	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityPage).
@@ -108,6 +108,13 @@ func UpdatePost(ctx context.Context, post *Post) error {
 	return nil
 }
 
+// StorePost saves post as it is, unlike UpdatePost which stamps it as edited.
+func StorePost(ctx context.Context, post *Post) error {
+	key := datastore.NewKey(ctx, EntityPost, "", post.Id, nil)
+	_, err := datastore.Put(ctx, key, post)
+	return err
+}
+
 func ListPosts(ctx context.Context, published bool, limit int) ([]*Post, error) {
 	posts := make([]*Post, 0)
 	if limit == 0 {
@@ -140,6 +147,23 @@ func ListPosts(ctx context.Context, published bool, limit int) ([]*Post, error)
 	return posts, err
 }
 
+func GetPost(ctx context.Context, postId int64) (*Post, error) {
+	key := datastore.NewKey(ctx, EntityPost, "", postId, nil)
+	post := &Post{}
+	err := datastore.Get(ctx, key, post)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrPostNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	post.SetMissingDefaults()
+	post.Id = key.IntID()
+	return post, nil
+}
+
 func GetPostByPath(ctx context.Context, path string) (*Post, error) {
 	posts := make([]*Post, 0)
 	keys, err := datastore.GetAll(ctx, datastore.NewQuery(EntityPost).
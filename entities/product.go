@@ -167,13 +167,18 @@ func NewProduct(name string) *Product {
 	}
 }
 
+// ProductPath is the path a product named name is given when it is created.
+func ProductPath(name string) string {
+	path := strings.TrimSpace(name)
+	path = strings.ToLower(path)
+	path = strings.Replace(path, " ", "-", -1)
+	path = strings.Replace(path, "'", "", -1)
+	return path
+}
+
 func CreateProduct(ctx context.Context, name string) (*Product, error) {
 	p := NewProduct(name)
-	p.Path = name
-	p.Path = strings.TrimSpace(p.Path)
-	p.Path = strings.ToLower(p.Path)
-	p.Path = strings.Replace(p.Path, " ", "-", -1)
-	p.Path = strings.Replace(p.Path, "'", "", -1)
+	p.Path = ProductPath(name)
 	key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, EntityProduct, nil), p)
 	if err != nil {
 		return nil, err
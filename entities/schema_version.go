@@ -0,0 +1,45 @@
+package entities
+
+import (
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const EntitySchemaVersion = "schema_version"
+
+// SchemaVersion is the migration the data is at, along with the progress of the migration that
+// is running, so an interrupted migration resumes where it stopped.
+type SchemaVersion struct {
+	Version   int       `datastore:"version,noindex" json:"version"`     // last migration applied
+	Migrating int       `datastore:"migrating,noindex" json:"migrating"` // migration in progress, 0 when none is
+	Cursor    string    `datastore:"cursor,noindex" json:"cursor"`       // where the migration in progress resumes
+	Processed int       `datastore:"processed,noindex" json:"processed"`
+	Changed   int       `datastore:"changed,noindex" json:"changed"`
+	Updated   time.Time `datastore:"updated,noindex" json:"updated"`
+}
+
+func schemaVersionKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, EntitySchemaVersion, "current", 0, nil)
+}
+
+// GetSchemaVersion returns the schema version, version 0 when no migration ran yet.
+func GetSchemaVersion(ctx context.Context) (*SchemaVersion, error) {
+	version := &SchemaVersion{}
+	err := datastore.Get(ctx, schemaVersionKey(ctx), version)
+	if err == datastore.ErrNoSuchEntity {
+		return &SchemaVersion{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return version, nil
+}
+
+func PutSchemaVersion(ctx context.Context, version *SchemaVersion) error {
+	version.Updated = time.Now()
+	_, err := datastore.Put(ctx, schemaVersionKey(ctx), version)
+	return err
+}
@@ -4,8 +4,12 @@ import (
 	"github.com/jcarm010/kodimerce/datastore"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
+	"time"
 )
 
+// EntityServerSettings holds the settings in a single entity, named active-settings.
+const EntityServerSettings = "server-settings"
+
 var (
 	ErrSettingsNotFound = errors.New("not found")
 )
@@ -51,6 +55,10 @@ type ServerSettings struct {
 	SMTPPort     int    `json:"smtp_port"`
 	SMTPUserName string `json:"smtp_user_name"`
 	SMTPPassword string `json:"smtp_password"`
+	SMTPTLSMode  string `json:"smtp_tls_mode"` //posible: starttls, tls, none
+
+	EmailBackend string `json:"email_backend"` //posible: sendgrid, smtp, mailbox. Empty picks sendgrid when SendGridKey is set, smtp otherwise
+	MailboxDir   string `json:"mailbox_dir"`   //when set the mailbox backend keeps emails in this directory instead of memory
 
 	EmailSender string `json:"email_sender"`
 	SendGridKey string `json:"send_grid_key"`
@@ -67,11 +75,84 @@ type ServerSettings struct {
 
 	DescriptionBlogABout string `json:"description_blog_about"`
 	WwwRedirect          bool   `json:"www_redirect"`
+
+	OIDCIssuer        string `json:"oidc_issuer"`
+	OIDCClientId      string `json:"oidc_client_id"`
+	OIDCClientSecret  string `json:"oidc_client_secret"`
+	OIDCAutoProvision bool   `json:"oidc_auto_provision"`
+	OIDCDefaultRole   string `json:"oidc_default_role"`
+
+	MetricsToken string `json:"metrics_token"`
+
+	NotificationsConfigured bool `json:"notifications_configured"` //false on settings stored before the switches existed, they are all turned on then
+	NotifyOrderPaid         bool `json:"notify_order_paid"`
+	NotifyOrderProcessing   bool `json:"notify_order_processing"`
+	NotifyOrderShipped      bool `json:"notify_order_shipped"`
+	NotifyOrderDelivered    bool `json:"notify_order_delivered"`
+	NotifyOrderRefunded     bool `json:"notify_order_refunded"`
+	NotifyOrderCancelled    bool `json:"notify_order_cancelled"`
+
+	AbandonedCheckoutDelayMinutes  int    `json:"abandoned_checkout_delay_minutes"`  //how long a started order waits before the first reminder
+	AbandonedCheckoutIntervalHours int    `json:"abandoned_checkout_interval_hours"` //how long to wait between reminders
+	AbandonedCheckoutMaxReminders  int    `json:"abandoned_checkout_max_reminders"`  //0 turns reminders off
+	SigningSecret                  string `json:"signing_secret"`                    //signs links sent in emails, such as recovery and unsubscribe links
+	CronToken                      string `json:"cron_token"`                        //bearer token accepted by /cron endpoints besides App Engine cron
+
+	SessionTTLHours int `json:"session_ttl_hours"` //0 keeps sessions forever
+
+	ImageWidths  string `json:"image_widths"`  //comma separated widths image variants are made in, such as 320,640,1280
+	ImageFormat  string `json:"image_format"`  //format of the variants in srcset: webp, jpeg, png or auto
+	ImageQuality int    `json:"image_quality"` //1 to 100, 0 uses the default
+
+	UploadAllowedTypes string `json:"upload_allowed_types"` //comma separated mime types uploads can have, such as image/*,application/pdf
+	UploadMaxSizes     string `json:"upload_max_sizes"`     //comma separated limits in MB per mime type, such as image/*=20,*=10
+	ClamAVAddress      string `json:"clamav_address"`       //clamd socket uploads are scanned with, a unix socket path or host:port
+	UploadDirect       bool   `json:"upload_direct"`        //hand out signed urls so large files go straight to storage
+}
+
+// SessionTTL is how long a login lasts, 0 when it never expires.
+func (s *ServerSettings) SessionTTL() time.Duration {
+	return time.Duration(s.SessionTTLHours) * time.Hour
+}
+
+func (s *ServerSettings) OIDCEnabled() bool {
+	return s.OIDCIssuer != "" && s.OIDCClientId != ""
+}
+
+// NotificationEnabled tells whether customers are emailed about the order event.
+func (s *ServerSettings) NotificationEnabled(event string) bool {
+	switch event {
+	case OrderEventPaid:
+		return s.NotifyOrderPaid
+	case OrderEventProcessing:
+		return s.NotifyOrderProcessing
+	case OrderEventShipped:
+		return s.NotifyOrderShipped
+	case OrderEventDelivered:
+		return s.NotifyOrderDelivered
+	case OrderEventRefunded:
+		return s.NotifyOrderRefunded
+	case OrderEventCancelled:
+		return s.NotifyOrderCancelled
+	}
+
+	return false
+}
+
+// EnableAllNotifications turns on the notification of every order event.
+func (s *ServerSettings) EnableAllNotifications() {
+	s.NotificationsConfigured = true
+	s.NotifyOrderPaid = true
+	s.NotifyOrderProcessing = true
+	s.NotifyOrderShipped = true
+	s.NotifyOrderDelivered = true
+	s.NotifyOrderRefunded = true
+	s.NotifyOrderCancelled = true
 }
 
 func GetServerSettings(ctx context.Context) (*ServerSettings, error) {
 	dbSettings := &ServerSettings{}
-	key := datastore.NewKey(ctx, "server-settings", "active-settings", 0, nil)
+	key := datastore.NewKey(ctx, EntityServerSettings, "active-settings", 0, nil)
 	err := datastore.Get(ctx, key, dbSettings)
 	if err == datastore.ErrNoSuchEntity {
 		return nil, ErrSettingsNotFound
@@ -81,7 +162,7 @@ func GetServerSettings(ctx context.Context) (*ServerSettings, error) {
 }
 
 func StoreServerSettings(ctx context.Context, serverSettings *ServerSettings) (error) {
-	key := datastore.NewKey(ctx, "server-settings", "active-settings", 0, nil)
+	key := datastore.NewKey(ctx, EntityServerSettings, "active-settings", 0, nil)
 	_, err := datastore.Put(ctx, key, serverSettings)
 	return err
 }
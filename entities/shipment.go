@@ -0,0 +1,278 @@
+package entities
+
+import (
+	originalDataStore "cloud.google.com/go/datastore"
+	"encoding/json"
+	"errors"
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const EntityShipment = "shipment"
+
+var (
+	ErrShipmentNotFound      = errors.New("Shipment not found.")
+	ErrShipmentEmpty         = errors.New("A shipment needs at least one item.")
+	ErrShipmentInvalidLine   = errors.New("Shipment item does not match a line of the order.")
+	ErrShipmentOverQuantity  = errors.New("Shipment items exceed the quantity left to ship.")
+	ErrShipmentInvalidAmount = errors.New("Shipment item quantities must be positive.")
+)
+
+// CarrierTrackingUrls maps a carrier to the url customers can track a package at. The
+// {tracking_number} placeholder is replaced with the shipment's tracking number.
+var CarrierTrackingUrls = map[string]string{
+	"ups":   "https://www.ups.com/track?tracknum={tracking_number}",
+	"usps":  "https://tools.usps.com/go/TrackConfirmAction?tLabels={tracking_number}",
+	"fedex": "https://www.fedex.com/fedextrack/?trknbr={tracking_number}",
+	"dhl":   "https://www.dhl.com/en/express/tracking.html?AWB={tracking_number}",
+}
+
+// Shipment is a package sent for an order. An order may be fulfilled by several shipments,
+// each one carrying some of the quantity of some of its lines.
+type Shipment struct {
+	Id             int64          `datastore:"-" json:"id"`
+	OrderId        int64          `datastore:"-" json:"order_id"`
+	Carrier        string         `datastore:"carrier,noindex" json:"carrier"`
+	TrackingNumber string         `datastore:"tracking_number" json:"tracking_number"`
+	TrackingUrl    string         `datastore:"tracking_url,noindex" json:"tracking_url"`
+	Items          []ShipmentItem `datastore:"items,noindex" json:"items"`
+	ShipDate       time.Time      `datastore:"ship_date" json:"ship_date"`
+	Created        time.Time      `datastore:"created" json:"created"`
+}
+
+// ShipmentItem is the quantity shipped of a line of the order. Line is the index of the
+// product in Order.ProductIds.
+type ShipmentItem struct {
+	Line      int   `datastore:"line,noindex" json:"line"`
+	ProductId int64 `datastore:"product_id,noindex" json:"product_id"`
+	Quantity  int64 `datastore:"quantity,noindex" json:"quantity"`
+}
+
+// CarrierTrackingUrl builds the tracking url of a package from the carrier's url template.
+// It returns "" when the carrier is unknown.
+func CarrierTrackingUrl(carrier string, trackingNumber string) string {
+	urlTemplate, exists := CarrierTrackingUrls[strings.ToLower(strings.TrimSpace(carrier))]
+	if !exists || trackingNumber == "" {
+		return ""
+	}
+
+	return strings.Replace(urlTemplate, "{tracking_number}", url.QueryEscape(trackingNumber), -1)
+}
+
+// ShippedQuantities returns how much of each line of order the shipments carry.
+func ShippedQuantities(order *Order, shipments []*Shipment) []int64 {
+	shipped := make([]int64, len(order.ProductIds))
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			if item.Line >= 0 && item.Line < len(shipped) {
+				shipped[item.Line] += item.Quantity
+			}
+		}
+	}
+
+	return shipped
+}
+
+// FulfillmentStatus returns OrderStatusProcessed when shipments carry every line of order,
+// OrderStatusShipped when they carry only part of it and "" when nothing was shipped.
+func FulfillmentStatus(order *Order, shipments []*Shipment) string {
+	shipped := ShippedQuantities(order, shipments)
+	anyShipped := false
+	allShipped := true
+	for line, quantity := range shipped {
+		if quantity > 0 {
+			anyShipped = true
+		}
+
+		if line < len(order.Quantities) && quantity < order.Quantities[line] {
+			allShipped = false
+		}
+	}
+
+	if !anyShipped {
+		return ""
+	}
+
+	if allShipped {
+		return OrderStatusProcessed
+	}
+
+	return OrderStatusShipped
+}
+
+// ValidateShipment checks that shipment carries quantities left to ship of existing lines of order.
+// others are the order's other shipments.
+func ValidateShipment(order *Order, shipment *Shipment, others []*Shipment) error {
+	if len(shipment.Items) == 0 {
+		return ErrShipmentEmpty
+	}
+
+	shipped := ShippedQuantities(order, others)
+	for index, item := range shipment.Items {
+		if item.Line < 0 || item.Line >= len(order.ProductIds) || item.Line >= len(order.Quantities) {
+			return ErrShipmentInvalidLine
+		}
+
+		if item.Quantity <= 0 {
+			return ErrShipmentInvalidAmount
+		}
+
+		shipped[item.Line] += item.Quantity
+		if shipped[item.Line] > order.Quantities[item.Line] {
+			return ErrShipmentOverQuantity
+		}
+
+		shipment.Items[index].ProductId = order.ProductIds[item.Line]
+	}
+
+	return nil
+}
+
+func shipmentKey(ctx context.Context, orderId int64, id int64) *datastore.Key {
+	orderKey := datastore.NewKey(ctx, EntityOrder, "", orderId, nil)
+	if id == 0 {
+		return datastore.NewIncompleteKey(ctx, EntityShipment, orderKey)
+	}
+
+	return datastore.NewKey(ctx, EntityShipment, "", id, orderKey)
+}
+
+// SaveShipment creates the shipment when its Id is 0 and updates it otherwise, then moves the
+// order to the status FulfillmentStatus gives, copying the shipment's tracking to it. The order
+// and its shipments are read in the transaction the shipment is written in, so shipments saved
+// at the same time can't carry more than the order has. It returns the order as it was saved
+// along with the status it had before.
+func SaveShipment(ctx context.Context, shipment *Shipment) (*Order, string, error) {
+	orderKey := datastore.NewKey(ctx, EntityOrder, "", shipment.OrderId, nil)
+	key := shipmentKey(ctx, shipment.OrderId, shipment.Id)
+	if shipment.Id == 0 {
+		var err error
+		key, err = datastore.AllocateKey(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var order *Order
+	var previousStatus string
+	err := datastore.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		order = &Order{}
+		err := tx.Get(orderKey, order)
+		if err != nil {
+			return err
+		}
+
+		order.Id = shipment.OrderId
+		shipments := make([]*Shipment, 0)
+		keys, err := tx.GetAll(datastore.NewQuery(EntityShipment).Ancestor((*originalDataStore.Key)(orderKey)), &shipments)
+		if err != nil {
+			return err
+		}
+
+		for index, storedKey := range keys {
+			shipments[index].Id = storedKey.IntID()
+		}
+
+		previousStatus = order.Status
+		err = applyShipment(order, shipments, shipment, time.Now())
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Put(key, shipment)
+		if err != nil {
+			return err
+		}
+
+		if order.Status != previousStatus {
+			_, err = tx.Put(orderKey, order)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	shipment.Id = key.IntID()
+	err = json.Unmarshal(order.ProductsSerial, &order.Products)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return order, previousStatus, nil
+}
+
+// applyShipment checks shipment against the other shipments of order, fills in its dates and
+// moves the order to its fulfillment status. shipments are every stored shipment of the order,
+// the one being updated included.
+func applyShipment(order *Order, shipments []*Shipment, shipment *Shipment, now time.Time) error {
+	others := make([]*Shipment, 0, len(shipments))
+	var existing *Shipment
+	for _, other := range shipments {
+		if shipment.Id != 0 && other.Id == shipment.Id {
+			existing = other
+		} else {
+			others = append(others, other)
+		}
+	}
+
+	if shipment.Id != 0 && existing == nil {
+		return ErrShipmentNotFound
+	}
+
+	err := ValidateShipment(order, shipment, others)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		shipment.Created = now
+	} else {
+		shipment.Created = existing.Created
+		if shipment.ShipDate.IsZero() {
+			shipment.ShipDate = existing.ShipDate
+		}
+	}
+
+	if shipment.ShipDate.IsZero() {
+		shipment.ShipDate = shipment.Created
+	}
+
+	status := FulfillmentStatus(order, append(others, shipment))
+	if status != "" && status != order.Status {
+		order.Status = status
+		order.Carrier = shipment.Carrier
+		order.TrackingNumber = shipment.TrackingNumber
+		order.TrackingUrl = shipment.TrackingUrl
+	}
+
+	return nil
+}
+
+// ListShipments returns the shipments of an order, oldest first.
+func ListShipments(ctx context.Context, orderId int64) ([]*Shipment, error) {
+	shipments := make([]*Shipment, 0)
+	orderKey := datastore.NewKey(ctx, EntityOrder, "", orderId, nil)
+	q := datastore.NewQuery(EntityShipment).Ancestor((*originalDataStore.Key)(orderKey))
+	keys, err := datastore.GetAll(ctx, q, &shipments)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, key := range keys {
+		shipments[index].Id = key.IntID()
+		shipments[index].OrderId = orderId
+	}
+
+	sort.Slice(shipments, func(i, j int) bool {
+		return shipments[i].ShipDate.Before(shipments[j].ShipDate)
+	})
+
+	return shipments, nil
+}
@@ -0,0 +1,105 @@
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func testOrder() *Order {
+	return &Order{Id: 1, Status: OrderStatusProcessing, ProductIds: []int64{10, 20}, Quantities: []int64{2, 1}}
+}
+
+func TestCarrierTrackingUrl(t *testing.T) {
+	if got := CarrierTrackingUrl(" UPS ", "1Z 99"); got != "https://www.ups.com/track?tracknum=1Z+99" {
+		t.Errorf("got %s", got)
+	}
+
+	if got := CarrierTrackingUrl("pigeon", "1"); got != "" {
+		t.Errorf("got %s for an unknown carrier", got)
+	}
+}
+
+func TestApplyShipment(t *testing.T) {
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	order := testOrder()
+	first := &Shipment{Carrier: "ups", TrackingNumber: "1", Items: []ShipmentItem{{Line: 0, Quantity: 1}}}
+	err := applyShipment(order, nil, first, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != OrderStatusShipped || order.TrackingNumber != "1" {
+		t.Errorf("got status %s and tracking %s", order.Status, order.TrackingNumber)
+	}
+
+	if !first.Created.Equal(now) || !first.ShipDate.Equal(now) || first.Items[0].ProductId != 10 {
+		t.Errorf("got %+v", first)
+	}
+
+	first.Id = 5
+	second := &Shipment{Carrier: "usps", TrackingNumber: "2", Items: []ShipmentItem{{Line: 0, Quantity: 1}, {Line: 1, Quantity: 1}}}
+	err = applyShipment(order, []*Shipment{first}, second, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != OrderStatusProcessed || order.Carrier != "usps" {
+		t.Errorf("got status %s and carrier %s", order.Status, order.Carrier)
+	}
+}
+
+func TestApplyShipmentUpdate(t *testing.T) {
+	created := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	order := testOrder()
+	order.Status = OrderStatusShipped
+	stored := []*Shipment{
+		{Id: 5, Created: created, ShipDate: created, Items: []ShipmentItem{{Line: 0, Quantity: 2}}},
+		{Id: 6, Created: created, ShipDate: created, Items: []ShipmentItem{{Line: 1, Quantity: 1}}},
+	}
+
+	// the shipment being updated doesn't count against itself
+	update := &Shipment{Id: 5, Items: []ShipmentItem{{Line: 0, Quantity: 1}}}
+	err := applyShipment(order, stored, update, created.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !update.Created.Equal(created) || !update.ShipDate.Equal(created) {
+		t.Errorf("the dates weren't kept: %+v", update)
+	}
+
+	if order.Status != OrderStatusShipped {
+		t.Errorf("got status %s", order.Status)
+	}
+
+	err = applyShipment(order, stored, &Shipment{Id: 9, Items: []ShipmentItem{{Line: 0, Quantity: 1}}}, created)
+	if err != ErrShipmentNotFound {
+		t.Errorf("got %v, want ErrShipmentNotFound", err)
+	}
+}
+
+func TestApplyShipmentRejects(t *testing.T) {
+	stored := []*Shipment{{Id: 5, Items: []ShipmentItem{{Line: 0, Quantity: 2}}}}
+	tests := []struct {
+		items []ShipmentItem
+		want  error
+	}{
+		{nil, ErrShipmentEmpty},
+		{[]ShipmentItem{{Line: 2, Quantity: 1}}, ErrShipmentInvalidLine},
+		{[]ShipmentItem{{Line: 1, Quantity: 0}}, ErrShipmentInvalidAmount},
+		{[]ShipmentItem{{Line: 0, Quantity: 1}}, ErrShipmentOverQuantity},
+		{[]ShipmentItem{{Line: 1, Quantity: 1}, {Line: 1, Quantity: 1}}, ErrShipmentOverQuantity},
+	}
+
+	for _, test := range tests {
+		order := testOrder()
+		err := applyShipment(order, stored, &Shipment{Items: test.items}, time.Now())
+		if err != test.want {
+			t.Errorf("%+v: got %v, want %v", test.items, err, test.want)
+		}
+
+		if order.Status != OrderStatusProcessing {
+			t.Errorf("%+v: the order moved to %s", test.items, order.Status)
+		}
+	}
+}
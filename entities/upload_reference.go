@@ -0,0 +1,94 @@
+package entities
+
+import (
+	"fmt"
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+)
+
+const EntityUploadReference = "upload_reference"
+
+// UploadReference records that a product, post, page or gallery shows an upload, either by its key
+// or by its file name, in which case it shows every upload with that name. They are rewritten
+// whenever what shows the uploads is saved, so finding what shows an upload is an indexed query.
+type UploadReference struct {
+	Owner    string `datastore:"owner" json:"-"` // <type>:<id>, so an owner's references can be replaced
+	Type     string `datastore:"type,noindex" json:"type"`
+	Id       int64  `datastore:"id,noindex" json:"id"`
+	Title    string `datastore:"title,noindex" json:"title"`
+	BlobKey  string `datastore:"blob_key" json:"-"`
+	Filename string `datastore:"filename" json:"-"`
+}
+
+func uploadReferenceOwner(ownerType string, ownerId int64) string {
+	return fmt.Sprintf("%s:%d", ownerType, ownerId)
+}
+
+func uploadReferenceKey(ctx context.Context, reference *UploadReference) *datastore.Key {
+	name := reference.Owner + " key:" + reference.BlobKey
+	if reference.BlobKey == "" {
+		name = reference.Owner + " name:" + reference.Filename
+	}
+
+	return datastore.NewKey(ctx, EntityUploadReference, name, 0, nil)
+}
+
+// SetUploadReferences replaces the references of the owner with references, which must all have
+// its type and id.
+func SetUploadReferences(ctx context.Context, ownerType string, ownerId int64, references []*UploadReference) error {
+	owner := uploadReferenceOwner(ownerType, ownerId)
+	existing, err := datastore.GetAll(ctx, datastore.NewQuery(EntityUploadReference).Filter("owner=", owner).KeysOnly(), nil)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*datastore.Key, len(references))
+	kept := map[string]bool{}
+	for index, reference := range references {
+		reference.Owner = owner
+		keys[index] = uploadReferenceKey(ctx, reference)
+		kept[keys[index].Name] = true
+	}
+
+	if len(references) > 0 {
+		_, err = datastore.PutMulti(ctx, keys, references)
+		if err != nil {
+			return err
+		}
+	}
+
+	// put first and delete after, so the references that stay are never missing
+	stale := make([]*datastore.Key, 0)
+	for _, key := range existing {
+		if !kept[key.Name] {
+			stale = append(stale, key)
+		}
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	return datastore.DeleteMulti(ctx, stale)
+}
+
+// ListUploadReferences returns the references to the upload with blobKey, by its key or by filename.
+func ListUploadReferences(ctx context.Context, blobKey string, filename string) ([]*UploadReference, error) {
+	references := make([]*UploadReference, 0)
+	_, err := datastore.GetAll(ctx, datastore.NewQuery(EntityUploadReference).Filter("blob_key=", blobKey), &references)
+	if err != nil {
+		return nil, err
+	}
+
+	if filename == "" {
+		return references, nil
+	}
+
+	byName := make([]*UploadReference, 0)
+	_, err = datastore.GetAll(ctx, datastore.NewQuery(EntityUploadReference).Filter("filename=", filename), &byName)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(references, byName...), nil
+}
@@ -0,0 +1,118 @@
+package entities
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/datastore"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const EntityUploadSession = "upload_session"
+
+var (
+	ErrUploadSessionNotFound = errors.New("Upload session not found.")
+	ErrUploadOffsetMismatch  = errors.New("Chunk doesn't start at the upload offset.")
+)
+
+// UploadSession is a file being uploaded in chunks, or straight to storage with a signed url when
+// Direct is set. It becomes an upload once all of its Size bytes are stored.
+type UploadSession struct {
+	Id          string    `datastore:"-" json:"id"`
+	Filename    string    `datastore:"filename,noindex" json:"filename"`
+	ContentType string    `datastore:"content_type,noindex" json:"content_type"` // as declared by the client, the stored type is sniffed
+	Size        int64     `datastore:"size,noindex" json:"size"`
+	Offset      int64     `datastore:"offset,noindex" json:"offset"` // how many bytes are stored
+	Chunks      []string  `datastore:"chunks,noindex" json:"-"`      // object names of the stored chunks, in order
+	Direct      bool      `datastore:"direct,noindex" json:"direct"`
+	Created     time.Time `datastore:"created,noindex" json:"created"`
+	Updated     time.Time `datastore:"updated" json:"updated"`
+}
+
+// Complete tells whether every byte of the file is stored.
+func (s *UploadSession) Complete() bool {
+	return s.Offset >= s.Size
+}
+
+func CreateUploadSession(ctx context.Context, session *UploadSession) error {
+	session.Created = time.Now()
+	session.Updated = session.Created
+	key := datastore.NewKey(ctx, EntityUploadSession, session.Id, 0, nil)
+	_, err := datastore.Put(ctx, key, session)
+	return err
+}
+
+func GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	session := &UploadSession{}
+	key := datastore.NewKey(ctx, EntityUploadSession, id, 0, nil)
+	err := datastore.Get(ctx, key, session)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrUploadSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	session.Id = id
+	return session, nil
+}
+
+// AppendUploadChunk records a chunk of size bytes stored in objectName at offset. It fails with
+// ErrUploadOffsetMismatch when another chunk was recorded at that offset first.
+func AppendUploadChunk(ctx context.Context, id string, offset int64, size int64, objectName string) (*UploadSession, error) {
+	key := datastore.NewKey(ctx, EntityUploadSession, id, 0, nil)
+	session := &UploadSession{}
+	err := datastore.RunInTransaction(ctx, func(transaction *datastore.Transaction) error {
+		*session = UploadSession{}
+		err := transaction.Get(key, session)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrUploadSessionNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if session.Offset != offset {
+			return ErrUploadOffsetMismatch
+		}
+
+		session.Offset += size
+		session.Chunks = append(session.Chunks, objectName)
+		session.Updated = time.Now()
+		_, err = transaction.Put(key, session)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	session.Id = id
+	return session, nil
+}
+
+func DeleteUploadSession(ctx context.Context, id string) error {
+	err := datastore.Delete(ctx, datastore.NewKey(ctx, EntityUploadSession, id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+
+	return err
+}
+
+// ListStaleUploadSessions returns the ids of the sessions that haven't received a chunk since before.
+func ListStaleUploadSessions(ctx context.Context, before time.Time) ([]string, error) {
+	q := datastore.NewQuery(EntityUploadSession).Filter("updated<", before).KeysOnly()
+	keys, err := datastore.GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.Name)
+	}
+
+	return ids, nil
+}
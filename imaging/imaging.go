@@ -0,0 +1,152 @@
+// Package imaging turns uploaded images into the smaller variants pages show: resized to the
+// configured widths, converted to WebP, without the metadata cameras and phones add.
+//
+// WebP variants need cgo, the encoder is libwebp built by chai2010/webp. Without cgo, such as
+// with CGO_ENABLED=0, CanEncode reports WebP as unsupported and pages get the original format.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+
+	// FormatAuto picks WebP for browsers that accept it and the original format otherwise.
+	FormatAuto = "auto"
+
+	DefaultQuality = 80
+)
+
+var (
+	// ErrUnsupportedFormat is returned for formats variants can't be encoded to. There is no AVIF
+	// encoder that builds without libavif, so AVIF is recognized but not produced.
+	ErrUnsupportedFormat = errors.New("Unsupported image format.")
+	ErrNotAnImage        = errors.New("Not an image.")
+	ErrImageTooLarge     = errors.New("The image is too large.")
+
+	// MaxPixels is the largest image variants are made of or uploads are decoded to clean, decoding
+	// takes 4 bytes per pixel. The frames of a GIF count together.
+	MaxPixels = 50 * 1000 * 1000
+
+	// MaxGIFFrames is the most frames a GIF may have to be cleaned.
+	MaxGIFFrames = 1000
+)
+
+// Info describes an image.
+type Info struct {
+	Format string
+	Width  int
+	Height int
+}
+
+// ContentType is the mime type of an image format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// FormatOf returns the format of an image content type, empty when variants can't be made from it.
+func FormatOf(contentType string) string {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "image/jpeg", "image/jpg", "image/pjpeg":
+		return FormatJPEG
+	case "image/png":
+		return FormatPNG
+	case "image/gif":
+		return FormatGIF
+	case "image/webp":
+		return FormatWebP
+	default:
+		return ""
+	}
+}
+
+// CanEncode tells whether variants can be encoded to format.
+func CanEncode(format string) bool {
+	switch format {
+	case FormatJPEG, FormatPNG:
+		return true
+	case FormatWebP:
+		return canEncodeWebP
+	default:
+		return false
+	}
+}
+
+// DecodeInfo reads the format and dimensions of an image without decoding it, turned the way
+// its EXIF orientation says it is shown.
+func DecodeInfo(data []byte) (*Info, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotAnImage
+	}
+
+	info := &Info{Format: format, Width: config.Width, Height: config.Height}
+	if format == FormatJPEG && swapsDimensions(exifOrientation(data)) {
+		info.Width, info.Height = info.Height, info.Width
+	}
+
+	return info, nil
+}
+
+// Decode decodes an image and turns it the way its EXIF orientation says it is shown.
+func Decode(data []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrNotAnImage
+	}
+
+	if format == FormatJPEG {
+		img = orient(img, exifOrientation(data))
+	}
+
+	return img, format, nil
+}
+
+// Resize scales img down to width, keeping its aspect ratio. Images are never scaled up.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width <= 0 || width >= bounds.Dx() {
+		return img
+	}
+
+	height := (bounds.Dy()*width + bounds.Dx()/2) / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img in format. Quality applies to JPEG and WebP, from 1 to 100.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	if quality <= 0 || quality > 100 {
+		quality = DefaultQuality
+	}
+
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatWebP:
+		return encodeWebP(w, img, quality)
+	default:
+		return ErrUnsupportedFormat
+	}
+}
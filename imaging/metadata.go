@@ -0,0 +1,396 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"strings"
+)
+
+const (
+	orientationTag = 0x0112
+
+	// flags of the VP8X chunk of a WebP image that say it has EXIF or XMP chunks
+	webpExifFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+
+	// pngMetadataChunks are the chunks that carry EXIF data, text such as the camera or the
+	// author, and timestamps.
+	pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+)
+
+// Sanitize removes the metadata of an uploaded image, GPS positions included. JPEG photos
+// taken sideways are rotated so they show the right way without their EXIF orientation and GIFs
+// are encoded again without their comments and application data, every other image keeps its
+// pixels untouched. Images that can't be cleaned, such as formats other than JPEG, PNG, GIF and
+// WebP, fail with ErrUnsupportedFormat. Files that aren't images are returned as they are, with a
+// nil Info.
+func Sanitize(data []byte) ([]byte, *Info, error) {
+	info, err := DecodeInfo(data)
+	if err != nil {
+		if strings.HasPrefix(http.DetectContentType(data), "image/") {
+			return nil, nil, ErrUnsupportedFormat
+		}
+
+		return data, nil, nil
+	}
+
+	switch info.Format {
+	case FormatJPEG:
+		if orientation := exifOrientation(data); orientation > 1 {
+			img, err := decodeOriginal(data)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			buf := &bytes.Buffer{}
+			err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 95})
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return buf.Bytes(), info, nil
+		}
+
+		return stripJPEG(data), info, nil
+	case FormatPNG:
+		return stripPNG(data), info, nil
+	case FormatGIF:
+		stripped, err := stripGIF(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return stripped, info, nil
+	case FormatWebP:
+		stripped, err := stripWebP(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return stripped, info, nil
+	default:
+		return nil, nil, ErrUnsupportedFormat
+	}
+}
+
+// jpegSegments calls fn with the marker and the bytes of every segment before the image data,
+// and returns the offset where the image data starts.
+func jpegSegments(data []byte, fn func(marker byte, segment []byte)) int {
+	offset := 2 // SOI
+	for offset+4 <= len(data) && data[offset] == 0xFF {
+		marker := data[offset+1]
+		if marker == 0xDA { // start of scan, the rest is image data
+			return offset
+		}
+
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+
+		fn(marker, data[offset:end])
+		offset = end
+	}
+
+	return offset
+}
+
+// stripJPEG drops the EXIF, XMP and IPTC segments and comments. Color profiles are kept.
+func stripJPEG(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	start := jpegSegments(data, func(marker byte, segment []byte) {
+		switch marker {
+		case 0xE1, 0xED, 0xFE: // APP1 EXIF and XMP, APP13 IPTC, COM
+			return
+		}
+
+		out = append(out, segment...)
+	})
+
+	return append(out, data[start:]...)
+}
+
+// exifOrientation returns the EXIF orientation of a JPEG photo, 1 when it has none.
+func exifOrientation(data []byte) int {
+	orientation := 1
+	jpegSegments(data, func(marker byte, segment []byte) {
+		if marker != 0xE1 || len(segment) < 4+len(exifHeader) || !bytes.Equal(segment[4:4+len(exifHeader)], exifHeader) {
+			return
+		}
+
+		if value := tiffOrientation(segment[4+len(exifHeader):]); value != 0 {
+			orientation = value
+		}
+	})
+
+	return orientation
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF structure EXIF
+// data is stored in, 0 when it isn't there.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for index := 0; index < entries; index++ {
+		entry := ifd + 2 + index*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+
+		if order.Uint16(tiff[entry:]) == orientationTag {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value < 1 || value > 8 {
+				return 0
+			}
+
+			return value
+		}
+	}
+
+	return 0
+}
+
+// stripPNG drops the chunks in pngMetadataChunks.
+func stripPNG(data []byte) []byte {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	offset := len(pngSignature)
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length // length, type, data and crc
+		if length < 0 || end > len(data) {
+			return data
+		}
+
+		if !pngMetadataChunks[string(data[offset+4:offset+8])] {
+			out = append(out, data[offset:end]...)
+		}
+
+		offset = end
+	}
+
+	return out
+}
+
+// stripGIF encodes a GIF again from its frames, which leaves out the comment and application
+// extensions XMP and other metadata are kept in. The loop count survives. GIFs with more than
+// MaxGIFFrames frames or MaxPixels pixels across their frames fail with ErrImageTooLarge before
+// they are decoded.
+func stripGIF(data []byte) ([]byte, error) {
+	frames, pixels, ok := gifFrames(data)
+	if !ok {
+		return nil, ErrNotAnImage
+	}
+
+	if frames > MaxGIFFrames || pixels > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotAnImage
+	}
+
+	buf := &bytes.Buffer{}
+	err = gif.EncodeAll(buf, decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// gifFrames walks the blocks of a GIF without decoding them and returns how many frames it has
+// and how many pixels they add up to. ok is false when the blocks don't follow the GIF layout.
+func gifFrames(data []byte) (frames int, pixels int, ok bool) {
+	if len(data) < 13 {
+		return 0, 0, false
+	}
+
+	// header and logical screen descriptor, then the global color table
+	offset := 13
+	if data[10]&0x80 != 0 {
+		offset += 3 << (data[10]&0x07 + 1)
+	}
+
+	for offset < len(data) {
+		switch data[offset] {
+		case 0x21: // extension, its label and then its sub-blocks
+			offset = skipGIFSubBlocks(data, offset+2)
+		case 0x2C: // image descriptor, its local color table and the LZW code size before the sub-blocks
+			if offset+10 > len(data) {
+				return frames, pixels, false
+			}
+
+			width := int(binary.LittleEndian.Uint16(data[offset+5:]))
+			height := int(binary.LittleEndian.Uint16(data[offset+7:]))
+			flags := data[offset+9]
+			offset += 10
+			if flags&0x80 != 0 {
+				offset += 3 << (flags&0x07 + 1)
+			}
+
+			frames++
+			pixels += width * height
+			if frames > MaxGIFFrames || pixels > MaxPixels {
+				return frames, pixels, true
+			}
+
+			offset = skipGIFSubBlocks(data, offset+1)
+		case 0x3B: // trailer
+			return frames, pixels, true
+		default:
+			return frames, pixels, false
+		}
+
+		if offset < 0 {
+			return frames, pixels, false
+		}
+	}
+
+	// a missing trailer is left to the decoder
+	return frames, pixels, true
+}
+
+// skipGIFSubBlocks returns the offset after the sub-blocks that start at offset, -1 when they run
+// past the end of data.
+func skipGIFSubBlocks(data []byte, offset int) int {
+	for offset < len(data) {
+		size := int(data[offset])
+		offset++
+		if size == 0 {
+			return offset
+		}
+
+		offset += size
+	}
+
+	return -1
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP image and clears the VP8X flags that
+// announce them. Images that don't follow the RIFF layout fail with ErrUnsupportedFormat
+// rather than keep what their chunks carry.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	offset := 12
+	for offset < len(data) {
+		if offset+8 > len(data) {
+			return nil, ErrUnsupportedFormat
+		}
+
+		fourCC := string(data[offset : offset+4])
+		length := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := offset + 8 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrUnsupportedFormat
+		}
+
+		// chunks are padded to an even length
+		if length%2 == 1 && end < len(data) {
+			end++
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[offset:end]...)
+			if length > 0 {
+				chunk[8] &^= webpExifFlag | webpXMPFlag
+			}
+
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[offset:end]...)
+		}
+
+		offset = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// swapsDimensions tells whether an orientation turns the image on its side.
+func swapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// orient turns img the way an EXIF orientation says it is shown.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if swapsDimensions(orientation) {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // mirrored on its left side
+				sx, sy = y, x
+			case 6: // on its left side, turn clockwise
+				sx, sy = y, h-1-x
+			case 7: // mirrored on its right side
+				sx, sy = w-1-y, h-1-x
+			case 8: // on its right side, turn counterclockwise
+				sx, sy = w-1-y, x
+			}
+
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
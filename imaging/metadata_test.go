@@ -0,0 +1,310 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// a 1x1 lossless WebP
+const webpPixel = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+func testJPEG(t *testing.T, width, height int, orientation int) []byte {
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, testImage(width, height), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a big endian TIFF with one IFD holding the orientation
+	tiff := []byte{'M', 'M', 0, 0x2A, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, 0, 0, 0, 0}
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 4, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func riffChunk(fourCC string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+// testWebP wraps the 1x1 WebP in an extended file with an odd sized EXIF chunk and an XMP chunk.
+func testWebP(t *testing.T) []byte {
+	pixel, err := base64.StdEncoding.DecodeString(webpPixel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpExifFlag | webpXMPFlag
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	data = append(data, riffChunk("VP8X", vp8x)...)
+	data = append(data, pixel[12:]...)
+	data = append(data, riffChunk("EXIF", []byte("GPS 25.7617 N"))...)
+	data = append(data, riffChunk("XMP ", []byte("<x:xmpmeta>secret</x:xmpmeta>"))...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestSanitizeJPEGStripsEXIF(t *testing.T) {
+	data := testJPEG(t, 8, 4, 1)
+	sanitized, info, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sanitized, exifHeader) {
+		t.Error("the EXIF segment was kept")
+	}
+
+	if info == nil || info.Format != FormatJPEG || info.Width != 8 || info.Height != 4 {
+		t.Errorf("got info %+v", info)
+	}
+}
+
+func TestSanitizeJPEGRotates(t *testing.T) {
+	sanitized, info, err := Sanitize(testJPEG(t, 8, 4, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != 4 || info.Height != 8 {
+		t.Errorf("got info %+v, want 4x8", info)
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(sanitized))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Width != 4 || config.Height != 8 {
+		t.Errorf("got a %dx%d image, want 4x8", config.Width, config.Height)
+	}
+
+	if exifOrientation(sanitized) != 1 {
+		t.Error("the rotated image kept its orientation")
+	}
+}
+
+func TestSanitizePNGStripsText(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, testImage(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+	data := append([]byte{}, encoded[:ihdrEnd]...)
+	data = append(data, pngChunk("tEXt", []byte("Comment\x00secret"))...)
+	data = append(data, encoded[ihdrEnd:]...)
+
+	sanitized, info, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sanitized, []byte("secret")) {
+		t.Error("the tEXt chunk was kept")
+	}
+
+	if !bytes.Equal(sanitized, encoded) {
+		t.Error("the other chunks changed")
+	}
+
+	if info.Format != FormatPNG {
+		t.Errorf("got format %q", info.Format)
+	}
+}
+
+func TestSanitizeWebPStripsChunks(t *testing.T) {
+	sanitized, info, err := Sanitize(testWebP(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Format != FormatWebP || info.Width != 1 || info.Height != 1 {
+		t.Errorf("got info %+v", info)
+	}
+
+	for _, leaked := range []string{"EXIF", "XMP ", "GPS", "secret"} {
+		if bytes.Contains(sanitized, []byte(leaked)) {
+			t.Errorf("%q was kept", leaked)
+		}
+	}
+
+	if flags := sanitized[20]; flags&(webpExifFlag|webpXMPFlag) != 0 {
+		t.Errorf("the VP8X flags still announce metadata: %#x", flags)
+	}
+
+	if size := binary.LittleEndian.Uint32(sanitized[4:]); int(size) != len(sanitized)-8 {
+		t.Errorf("the RIFF size is %d, want %d", size, len(sanitized)-8)
+	}
+
+	_, err = DecodeInfo(sanitized)
+	if err != nil {
+		t.Errorf("the sanitized image doesn't decode: %s", err)
+	}
+}
+
+func TestStripWebPRejectsMalformed(t *testing.T) {
+	data := testWebP(t)
+	tests := map[string][]byte{
+		"truncated chunk":  data[:len(data)-4],
+		"truncated header": data[:len(data)-len("XMP ")-30],
+		"not riff":         append([]byte("RIFX"), data[4:]...),
+	}
+
+	for name, test := range tests {
+		_, err := stripWebP(test)
+		if err != ErrUnsupportedFormat {
+			t.Errorf("%s: got %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+}
+
+func TestSanitizeGIFDropsComments(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, &gif.GIF{Image: []*image.Paletted{img}, Delay: []int{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a comment extension just before the trailer
+	encoded := buf.Bytes()
+	comment := append([]byte{0x21, 0xFE, 6}, "secret"...)
+	comment = append(comment, 0)
+	data := append(append(append([]byte{}, encoded[:len(encoded)-1]...), comment...), 0x3B)
+
+	sanitized, info, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sanitized, []byte("secret")) {
+		t.Error("the comment was kept")
+	}
+
+	if info.Format != FormatGIF || info.Width != 2 {
+		t.Errorf("got info %+v", info)
+	}
+}
+
+func TestSanitizeRejectsOtherImages(t *testing.T) {
+	// a BMP header, an image the image package can't decode and so can't clean
+	bmp := append([]byte("BM"), make([]byte, 60)...)
+	_, _, err := Sanitize(bmp)
+	if err != ErrUnsupportedFormat {
+		t.Errorf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestSanitizeKeepsOtherFiles(t *testing.T) {
+	data := []byte("%PDF-1.4 not an image")
+	sanitized, info, err := Sanitize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info != nil || !bytes.Equal(sanitized, data) {
+		t.Errorf("got %q and %+v, want the file unchanged", sanitized, info)
+	}
+}
+
+func testGIF(t *testing.T, frames int, width, height int) []byte {
+	animation := &gif.GIF{}
+	for index := 0; index < frames; index++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White}))
+		animation.Delay = append(animation.Delay, 10)
+	}
+
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, animation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	frames, pixels, ok := gifFrames(testGIF(t, 3, 10, 4))
+	if !ok || frames != 3 || pixels != 120 {
+		t.Errorf("got %d frames, %d pixels, %v", frames, pixels, ok)
+	}
+
+	data := testGIF(t, 1, 10, 4)
+	if _, _, ok := gifFrames(data[:len(data)-6]); ok {
+		t.Error("a truncated frame was accepted")
+	}
+
+	if _, _, ok := gifFrames([]byte("GIF89a")); ok {
+		t.Error("a header alone was accepted")
+	}
+}
+
+func TestSanitizeChecksPixelsBeforeDecoding(t *testing.T) {
+	maxPixels, maxGIFFrames := MaxPixels, MaxGIFFrames
+	t.Cleanup(func() {
+		MaxPixels, MaxGIFFrames = maxPixels, maxGIFFrames
+	})
+
+	animation := testGIF(t, 3, 10, 10)
+	if _, _, err := Sanitize(animation); err != nil {
+		t.Fatal(err)
+	}
+
+	MaxPixels = 299
+	if _, _, err := Sanitize(animation); err != ErrImageTooLarge {
+		t.Errorf("got %v for the frames adding up past MaxPixels, want ErrImageTooLarge", err)
+	}
+
+	if _, _, err := Sanitize(testJPEG(t, 20, 20, 6)); err != ErrImageTooLarge {
+		t.Errorf("got %v for a rotated JPEG past MaxPixels, want ErrImageTooLarge", err)
+	}
+
+	MaxPixels, MaxGIFFrames = maxPixels, 2
+	if _, _, err := Sanitize(animation); err != ErrImageTooLarge {
+		t.Errorf("got %v for too many frames, want ErrImageTooLarge", err)
+	}
+}
@@ -0,0 +1,170 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jcarm010/kodimerce/storage"
+	"image"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VariantsPrefix is where the variants of uploads are stored, one folder per upload key.
+const VariantsPrefix = "variants/"
+
+// DefaultWidths are the widths variants are made in when none are configured.
+var DefaultWidths = []int{320, 640, 960, 1280, 1920}
+
+// ParseWidths reads a comma separated list of widths such as "320,640,1280", sorted and without
+// duplicates. It returns DefaultWidths when there are no valid widths in the list.
+func ParseWidths(list string) []int {
+	seen := map[int]bool{}
+	widths := make([]int, 0)
+	for _, field := range strings.Split(list, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || width <= 0 || seen[width] {
+			continue
+		}
+
+		seen[width] = true
+		widths = append(widths, width)
+	}
+
+	if len(widths) == 0 {
+		return DefaultWidths
+	}
+
+	sort.Ints(widths)
+	return widths
+}
+
+// VariantWidth rounds a requested width up to one of widths, so a page asking for any width
+// can't fill the bucket with variants. It returns 0, the original width, when the request is as
+// wide as the image or wider than every width.
+func VariantWidth(widths []int, requested int, imageWidth int) int {
+	if requested <= 0 {
+		return 0
+	}
+
+	for _, width := range widths {
+		if width >= requested {
+			if imageWidth > 0 && width >= imageWidth {
+				return 0
+			}
+
+			return width
+		}
+	}
+
+	return 0
+}
+
+// Variant is an image derived from an upload.
+type Variant struct {
+	Key    string
+	Width  int // 0 keeps the original width
+	Format string
+}
+
+func (v *Variant) ObjectName() string {
+	width := "original"
+	if v.Width > 0 {
+		width = strconv.Itoa(v.Width)
+	}
+
+	return fmt.Sprintf("%s%s/%s.%s", VariantsPrefix, v.Key, width, v.Format)
+}
+
+// GetVariant returns the variant of the image stored in objectName, making it and storing it
+// for the next time when it doesn't exist yet.
+func GetVariant(ctx context.Context, variant *Variant, objectName string, quality int) ([]byte, error) {
+	stored, err := readObject(ctx, variant.ObjectName())
+	if err == nil {
+		return stored, nil
+	}
+
+	if err != storage.ErrObjectNotExist {
+		return nil, err
+	}
+
+	original, err := readObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeOriginal(original)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = Encode(buf, Resize(img, variant.Width), variant.Format, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.PutObject(ctx, variant.ObjectName(), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeOriginal decodes the original of a variant. Originals may be any size, so their
+// dimensions are checked against MaxPixels before decoding allocates for every pixel.
+func decodeOriginal(data []byte) (image.Image, error) {
+	info, err := DecodeInfo(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Width*info.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := Decode(data)
+	return img, err
+}
+
+// DeleteVariants deletes every variant of an upload.
+func DeleteVariants(ctx context.Context, key string) error {
+	objects, err := storage.ListObjects(ctx, VariantsPrefix+key+"/")
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		err = storage.DeleteObject(ctx, object.Name)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VariantKey returns the upload key of a variant object name, empty when it isn't one.
+func VariantKey(objectName string) string {
+	if !strings.HasPrefix(objectName, VariantsPrefix) {
+		return ""
+	}
+
+	return strings.SplitN(strings.TrimPrefix(objectName, VariantsPrefix), "/", 2)[0]
+}
+
+func readObject(ctx context.Context, objectName string) ([]byte, error) {
+	rc, err := storage.GetObject(objectName).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	return ioutil.ReadAll(rc)
+}
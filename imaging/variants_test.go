@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func TestParseWidths(t *testing.T) {
+	tests := map[string][]int{
+		"640, 320,640,x,-1": {320, 640},
+		"":                  DefaultWidths,
+		"0,abc":             DefaultWidths,
+	}
+
+	for list, want := range tests {
+		if got := ParseWidths(list); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseWidths(%q) = %v, want %v", list, got, want)
+		}
+	}
+}
+
+func TestVariantWidth(t *testing.T) {
+	widths := []int{320, 640, 1280}
+	tests := []struct {
+		requested, imageWidth, want int
+	}{
+		{0, 2000, 0},
+		{100, 2000, 320},
+		{321, 2000, 640},
+		{1280, 2000, 1280},
+		{1281, 2000, 0},
+		{500, 600, 0},
+	}
+
+	for _, test := range tests {
+		if got := VariantWidth(widths, test.requested, test.imageWidth); got != test.want {
+			t.Errorf("VariantWidth(%d, %d) = %d, want %d", test.requested, test.imageWidth, got, test.want)
+		}
+	}
+}
+
+func TestDecodeOriginalChecksPixels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, testImage(10, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxPixels := MaxPixels
+	t.Cleanup(func() {
+		MaxPixels = maxPixels
+	})
+
+	img, err := decodeOriginal(buf.Bytes())
+	if err != nil || img.Bounds().Dx() != 10 {
+		t.Fatalf("got %v, %v", img, err)
+	}
+
+	MaxPixels = 99
+	_, err = decodeOriginal(buf.Bytes())
+	if err != ErrImageTooLarge {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestCanEncodeWebP(t *testing.T) {
+	if CanEncode(FormatWebP) != canEncodeWebP {
+		t.Error("CanEncode doesn't follow the build")
+	}
+
+	if CanEncode(FormatGIF) || !CanEncode(FormatJPEG) {
+		t.Error("unexpected formats")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build cgo
+// +build cgo
+
+package imaging
+
+import (
+	"github.com/chai2010/webp"
+	"image"
+	"io"
+)
+
+// canEncodeWebP is true in cgo builds, chai2010/webp compiles the libwebp encoder with cgo.
+// Builds with CGO_ENABLED=0 can't make WebP variants, see webp_nocgo.go.
+const canEncodeWebP = true
+
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+}
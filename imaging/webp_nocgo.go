@@ -0,0 +1,17 @@
+//go:build !cgo
+// +build !cgo
+
+package imaging
+
+import (
+	"image"
+	"io"
+)
+
+// canEncodeWebP is false without cgo, there is no WebP encoder in pure Go. WebP uploads still
+// decode, so they get JPEG and PNG variants.
+const canEncodeWebP = false
+
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	return ErrUnsupportedFormat
+}
@@ -0,0 +1,313 @@
+package jobs
+
+import (
+	"github.com/jcarm010/kodimerce/contentsearch"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/imaging"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/mediaarchive"
+	"github.com/jcarm010/kodimerce/migrations"
+	"github.com/jcarm010/kodimerce/orders"
+	"github.com/jcarm010/kodimerce/paypal"
+	"github.com/jcarm010/kodimerce/productsearch"
+	"github.com/jcarm010/kodimerce/recovery"
+	"github.com/jcarm010/kodimerce/settings"
+	"github.com/jcarm010/kodimerce/storage"
+	"github.com/jcarm010/kodimerce/uploads"
+	"golang.org/x/net/context"
+	"strings"
+	"time"
+)
+
+const UploadsPrefix = "uploads/"
+
+// ReconcileAfter is how long a started order with a PayPal payment waits before its payment is
+// checked, so orders still going through checkout are left alone.
+var ReconcileAfter = time.Hour
+
+// ReconcileBatchSize is how many started orders reconcile-orders reads at a time.
+var ReconcileBatchSize = 100
+
+// reconcileStore is what reconcile-orders reads the orders from and checks them against.
+type reconcileStore interface {
+	GetGlobalSettings(ctx context.Context) entities.ServerSettings
+	ListStartedOrdersBatch(ctx context.Context, createdBefore time.Time, cursor string, limit int) ([]*entities.Order, string, error)
+	GetPaymentState(ctx context.Context, order *entities.Order) (string, error)
+	MarkPaid(ctx context.Context, data *emailer.TemplateData) error
+}
+
+type datastoreReconcile struct{}
+
+func (datastoreReconcile) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return settings.GetGlobalSettings(ctx)
+}
+
+func (datastoreReconcile) ListStartedOrdersBatch(ctx context.Context, createdBefore time.Time, cursor string, limit int) ([]*entities.Order, string, error) {
+	return entities.ListStartedOrdersBatch(ctx, createdBefore, cursor, limit)
+}
+
+func (datastoreReconcile) GetPaymentState(ctx context.Context, order *entities.Order) (string, error) {
+	return paypal.GetPaymentState(ctx, order)
+}
+
+func (datastoreReconcile) MarkPaid(ctx context.Context, data *emailer.TemplateData) error {
+	return orders.MarkPaid(ctx, data)
+}
+
+// OrphanedUploadAge is how old a stored file without an upload entity must be before it is
+// deleted. Files are stored before their entity, so fresh ones may still be on their way.
+var OrphanedUploadAge = 24 * time.Hour
+
+func init() {
+	Register(&Job{
+		Name:        "expire-sessions",
+		Description: "Deletes login sessions older than the session ttl setting and abandoned single sign-on logins.",
+		Interval:    24 * time.Hour,
+		Run:         expireSessions,
+	})
+
+	Register(&Job{
+		Name:        "reconcile-orders",
+		Description: "Marks as paid the started orders whose PayPal payment went through.",
+		Interval:    time.Hour,
+		Run: func(ctx context.Context) (interface{}, error) {
+			return reconcileOrders(ctx, datastoreReconcile{})
+		},
+	})
+
+	Register(&Job{
+		Name:        "rebuild-search-index",
+		Description: "Rebuilds the search indexes of uploads, products and content from the datastore.",
+		Interval:    24 * time.Hour,
+		Lease:       30 * time.Minute,
+		Run: func(ctx context.Context) (interface{}, error) {
+			err := entities.InitSearchAPI(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			err = productsearch.Rebuild(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, contentsearch.Rebuild(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "prune-uploads",
+		Description: "Deletes stored files and image variants no upload refers to.",
+		Interval:    24 * time.Hour,
+		Lease:       30 * time.Minute,
+		Run:         pruneUploads,
+	})
+
+	Register(&Job{
+		Name:        "export-media",
+		Description: "Stores a zip archive of every upload and its metadata in media-exports/ when one was requested.",
+		Interval:    10 * time.Minute,
+		Lease:       time.Hour,
+		Run: func(ctx context.Context) (interface{}, error) {
+			result, err := mediaarchive.ExportRequested(ctx)
+			if result == nil && err == nil {
+				return "no export requested", nil
+			}
+
+			return result, err
+		},
+	})
+
+	Register(&Job{
+		Name:        "import-media",
+		Description: "Imports the zip archives queued in media-imports/ into the media library.",
+		Interval:    10 * time.Minute,
+		Lease:       time.Hour,
+		Run: func(ctx context.Context) (interface{}, error) {
+			serverSettings := settings.GetGlobalSettings(ctx)
+			return mediaarchive.ImportQueued(ctx, uploads.NewPolicy(&serverSettings))
+		},
+	})
+
+	Register(&Job{
+		Name:        "prune-upload-sessions",
+		Description: "Deletes upload sessions that stopped receiving chunks, with the chunks they stored.",
+		Interval:    24 * time.Hour,
+		Run:         pruneUploadSessions,
+	})
+
+	Register(&Job{
+		Name:        "migrate",
+		Description: "Applies the data migrations that haven't run yet, resuming the one that was interrupted.",
+		Interval:    10 * time.Minute,
+		Lease:       time.Hour,
+		Run: func(ctx context.Context) (interface{}, error) {
+			return migrations.Run(ctx, migrations.OptionsFrom(ctx))
+		},
+	})
+
+	Register(&Job{
+		Name:        "abandoned-checkouts",
+		Description: "Emails the abandoned checkout reminders that are due.",
+		Interval:    30 * time.Minute,
+		Run: func(ctx context.Context) (interface{}, error) {
+			return recovery.RunAbandonedCheckouts(ctx, HostRoot(ctx))
+		},
+	})
+
+	Register(&Job{
+		Name:        "email-queue",
+		Description: "Delivers the queued emails that are due, for instances that can't keep the email worker running.",
+		Run: func(ctx context.Context) (interface{}, error) {
+			sent, failed, err := emailer.ProcessQueue(ctx, 100)
+			return map[string]int{"sent": sent, "failed": failed}, err
+		},
+	})
+}
+
+func expireSessions(ctx context.Context) (interface{}, error) {
+	// logins that never came back from the identity provider expire whether sessions do or not
+	logins, err := entities.DeleteExpiredOIDCLogins(ctx, time.Now().Add(-entities.OIDCLoginTimeout))
+	result := map[string]int{"oidc_logins": logins}
+	if err != nil {
+		return result, err
+	}
+
+	serverSettings := settings.GetGlobalSettings(ctx)
+	ttl := serverSettings.SessionTTL()
+	if ttl <= 0 {
+		return result, nil
+	}
+
+	result["expired"], result["stamped"], err = entities.ExpireUserSessions(ctx, ttl)
+	return result, err
+}
+
+func reconcileOrders(ctx context.Context, s reconcileStore) (interface{}, error) {
+	serverSettings := s.GetGlobalSettings(ctx)
+	hostRoot := HostRoot(ctx)
+	if hostRoot == "" {
+		hostRoot = strings.TrimRight(serverSettings.CompanyUrl, "/")
+	}
+
+	// orders are read a batch at a time, the ones marked paid leave the query without moving the cursor
+	createdBefore := time.Now().Add(-ReconcileAfter)
+	result := map[string]int{"checked": 0, "paid": 0, "failed": 0}
+	cursor := ""
+	for {
+		started, next, err := s.ListStartedOrdersBatch(ctx, createdBefore, cursor, ReconcileBatchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, order := range started {
+			reconcileOrder(ctx, s, serverSettings, hostRoot, order, result)
+		}
+
+		if next == "" {
+			return result, nil
+		}
+
+		cursor = next
+	}
+}
+
+// reconcileOrder marks order paid when its PayPal payment went through, counting it in result.
+func reconcileOrder(ctx context.Context, s reconcileStore, serverSettings entities.ServerSettings, hostRoot string, order *entities.Order, result map[string]int) {
+	if order.PaypalPaymentId == "" || order.PaypalPayerId == "" {
+		return
+	}
+
+	result["checked"]++
+	state, err := s.GetPaymentState(ctx, order)
+	if err != nil {
+		log.Errorf(ctx, "Error getting PayPal payment of order[%v]: %+v", order.Id, err)
+		result["failed"]++
+		return
+	}
+
+	if state != "approved" {
+		return
+	}
+
+	log.Warningf(ctx, "PayPal payment of order[%v] went through but the order was still started, marking it paid", order.Id)
+	err = s.MarkPaid(ctx, orders.TemplateData(serverSettings, hostRoot, order))
+	if err != nil {
+		log.Errorf(ctx, "Error marking order[%v] paid: %+v", order.Id, err)
+		result["failed"]++
+		return
+	}
+
+	result["paid"]++
+}
+
+func pruneUploadSessions(ctx context.Context) (interface{}, error) {
+	ids, err := entities.ListStaleUploadSessions(ctx, time.Now().Add(-uploads.StaleSessionAge))
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]int{"deleted": 0, "failed": 0}
+	for _, id := range ids {
+		err = uploads.DeleteSession(ctx, id)
+		if err != nil {
+			log.Errorf(ctx, "Error deleting upload session %s: %+v", id, err)
+			result["failed"]++
+			continue
+		}
+
+		result["deleted"]++
+	}
+
+	return result, nil
+}
+
+func pruneUploads(ctx context.Context) (interface{}, error) {
+	referenced, keys, err := entities.ListUploadObjectNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for objectName := range referenced {
+		known[storage.ObjectName(objectName)] = true
+	}
+
+	objects, err := storage.ListObjects(ctx, UploadsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := storage.ListObjects(ctx, imaging.VariantsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// variants belong to their upload rather than to an object name
+	for _, variant := range variants {
+		if keys[imaging.VariantKey(variant.Name)] {
+			known[variant.Name] = true
+		}
+	}
+
+	objects = append(objects, variants...)
+	result := map[string]int{"checked": len(objects), "deleted": 0, "failed": 0}
+	for _, object := range objects {
+		if known[object.Name] || time.Since(object.Created) < OrphanedUploadAge {
+			continue
+		}
+
+		err = storage.DeleteObject(ctx, object.Name)
+		if err != nil {
+			log.Errorf(ctx, "Error deleting orphaned upload %s: %+v", object.Name, err)
+			result["failed"]++
+			continue
+		}
+
+		log.Infof(ctx, "Deleted orphaned upload %s", object.Name)
+		result["deleted"]++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeReconcile struct {
+	settings        entities.ServerSettings
+	listBatch       func(createdBefore time.Time, cursor string, limit int) ([]*entities.Order, string, error)
+	getPaymentState func(order *entities.Order) (string, error)
+	markPaid        func(data *emailer.TemplateData) error
+}
+
+func (f *fakeReconcile) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return f.settings
+}
+
+func (f *fakeReconcile) ListStartedOrdersBatch(ctx context.Context, createdBefore time.Time, cursor string, limit int) ([]*entities.Order, string, error) {
+	return f.listBatch(createdBefore, cursor, limit)
+}
+
+func (f *fakeReconcile) GetPaymentState(ctx context.Context, order *entities.Order) (string, error) {
+	return f.getPaymentState(order)
+}
+
+func (f *fakeReconcile) MarkPaid(ctx context.Context, data *emailer.TemplateData) error {
+	return f.markPaid(data)
+}
+
+func TestReconcileOrdersInBatches(t *testing.T) {
+	batches := map[string][]*entities.Order{
+		"": {
+			{Id: 1, PaypalPaymentId: "pay1", PaypalPayerId: "payer"},
+			{Id: 2},
+		},
+		"cursor1": {
+			{Id: 3, PaypalPaymentId: "pay3", PaypalPayerId: "payer"},
+			{Id: 4, PaypalPaymentId: "pay4", PaypalPayerId: "payer"},
+		},
+		"cursor2": {
+			{Id: 5, PaypalPaymentId: "pay5", PaypalPayerId: "payer"},
+		},
+	}
+
+	next := map[string]string{"": "cursor1", "cursor1": "cursor2", "cursor2": ""}
+	cursors := make([]string, 0)
+	store := &fakeReconcile{settings: entities.ServerSettings{CompanyUrl: "https://shop.com/"}}
+	store.listBatch = func(createdBefore time.Time, cursor string, limit int) ([]*entities.Order, string, error) {
+		if limit != ReconcileBatchSize || time.Since(createdBefore) < ReconcileAfter {
+			t.Errorf("got limit %d and created before %v", limit, createdBefore)
+		}
+
+		cursors = append(cursors, cursor)
+		return batches[cursor], next[cursor], nil
+	}
+
+	store.getPaymentState = func(order *entities.Order) (string, error) {
+		switch order.Id {
+		case 3:
+			return "", errors.New("paypal down")
+		case 4:
+			return "created", nil
+		default:
+			return "approved", nil
+		}
+	}
+
+	paid := make([]int64, 0)
+	store.markPaid = func(data *emailer.TemplateData) error {
+		if data.HostRoot != "https://shop.com" {
+			t.Errorf("got host root %s", data.HostRoot)
+		}
+
+		paid = append(paid, data.Order.Id)
+		return nil
+	}
+
+	result, err := reconcileOrders(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cursors, []string{"", "cursor1", "cursor2"}) {
+		t.Errorf("read batches at %v", cursors)
+	}
+
+	if !reflect.DeepEqual(paid, []int64{1, 5}) {
+		t.Errorf("marked %v paid", paid)
+	}
+
+	want := map[string]int{"checked": 4, "paid": 2, "failed": 1}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got %v, want %v", result, want)
+	}
+}
+
+func TestReconcileOrdersStopsOnErrors(t *testing.T) {
+	store := &fakeReconcile{
+		listBatch: func(createdBefore time.Time, cursor string, limit int) ([]*entities.Order, string, error) {
+			return nil, "", errors.New("datastore down")
+		},
+	}
+
+	_, err := reconcileOrders(context.Background(), store)
+	if err == nil {
+		t.Error("the error wasn't returned")
+	}
+}
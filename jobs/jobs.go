@@ -0,0 +1,246 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"golang.org/x/net/context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TriggerCron      = "cron"
+	TriggerScheduler = "scheduler"
+	TriggerAdmin     = "admin"
+	TriggerCLI       = "cli"
+)
+
+// DefaultLease is how long a run holds the job's lock when the job does not say.
+var DefaultLease = 10 * time.Minute
+
+// SchedulerTick is how often the in-process scheduler looks for due jobs.
+var SchedulerTick = time.Minute
+
+var ErrJobNotFound = errors.New("Job not found.")
+
+// Job is a named task that runs on a schedule.
+type Job struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Interval is how often the in-process scheduler runs the job, 0 leaves it to cron or to the admin.
+	Interval time.Duration `json:"interval"`
+	// Lease is how long a run may take before another instance may run the job again.
+	Lease time.Duration `json:"lease"`
+	// Run does the work. What it returns is kept in the run history.
+	Run func(ctx context.Context) (interface{}, error) `json:"-"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*Job{}
+
+	// owner tells this instance apart when it holds a lock
+	owner = fmt.Sprintf("%s-%d-%s", hostname(), os.Getpid(), uuid.New().String()[:8])
+)
+
+// lockStore keeps the job locks and the run history.
+type lockStore interface {
+	AcquireJobLock(ctx context.Context, job string, owner string, lease time.Duration, minInterval time.Duration) (*entities.JobLock, error)
+	ReleaseJobLock(ctx context.Context, job string, owner string, status string) error
+	CreateJobRun(ctx context.Context, run *entities.JobRun) error
+	UpdateJobRun(ctx context.Context, run *entities.JobRun) error
+}
+
+type datastoreLocks struct{}
+
+func (datastoreLocks) AcquireJobLock(ctx context.Context, job string, owner string, lease time.Duration, minInterval time.Duration) (*entities.JobLock, error) {
+	return entities.AcquireJobLock(ctx, job, owner, lease, minInterval)
+}
+
+func (datastoreLocks) ReleaseJobLock(ctx context.Context, job string, owner string, status string) error {
+	return entities.ReleaseJobLock(ctx, job, owner, status)
+}
+
+func (datastoreLocks) CreateJobRun(ctx context.Context, run *entities.JobRun) error {
+	return entities.CreateJobRun(ctx, run)
+}
+
+func (datastoreLocks) UpdateJobRun(ctx context.Context, run *entities.JobRun) error {
+	return entities.UpdateJobRun(ctx, run)
+}
+
+type hostRootKey struct{}
+
+// Register adds job to the registry, replacing the job with the same name.
+func Register(job *Job) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[job.Name] = job
+}
+
+func Get(name string) (*Job, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	job, exists := registry[name]
+	return job, exists
+}
+
+// List returns the registered jobs sorted by name.
+func List() []*Job {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]*Job, 0, len(registry))
+	for _, job := range registry {
+		list = append(list, job)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// WithHostRoot tells jobs that link back to the store which root to use, such as the host a cron
+// request came in through. Jobs fall back to the company url setting without it.
+func WithHostRoot(ctx context.Context, hostRoot string) context.Context {
+	return context.WithValue(ctx, hostRootKey{}, hostRoot)
+}
+
+func HostRoot(ctx context.Context) string {
+	hostRoot, _ := ctx.Value(hostRootKey{}).(string)
+	return hostRoot
+}
+
+// Run runs the job called name unless another instance is running it, and records the run.
+// The scheduler trigger also skips jobs that started less than their interval ago.
+func Run(ctx context.Context, name string, trigger string) (*entities.JobRun, error) {
+	return runWithLocks(ctx, datastoreLocks{}, name, trigger)
+}
+
+func runWithLocks(ctx context.Context, locks lockStore, name string, trigger string) (*entities.JobRun, error) {
+	job, exists := Get(name)
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+
+	lease := job.Lease
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+
+	var minInterval time.Duration
+	if trigger == TriggerScheduler {
+		minInterval = job.Interval
+	}
+
+	_, err := locks.AcquireJobLock(ctx, name, owner, lease, minInterval)
+	if err != nil {
+		if err == entities.ErrJobLocked || err == entities.ErrJobNotDue {
+			metrics.JobRuns.Inc(name, "skipped")
+		}
+
+		return nil, err
+	}
+
+	run := &entities.JobRun{
+		Job:     name,
+		Trigger: trigger,
+		Owner:   owner,
+		Status:  entities.JobRunStatusRunning,
+		Started: time.Now(),
+	}
+
+	err = locks.CreateJobRun(ctx, run)
+	if err != nil {
+		log.Errorf(ctx, "Error recording run of job %s: %+v", name, err)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, lease)
+	result, err := runJob(runCtx, job)
+	cancel()
+
+	run.Finished = time.Now()
+	run.Status = entities.JobRunStatusSucceeded
+	if err != nil {
+		run.Status = entities.JobRunStatusFailed
+		run.Error = err.Error()
+		log.Errorf(ctx, "Job %s failed: %+v", name, err)
+	}
+
+	if result != nil {
+		bts, marshalErr := json.Marshal(result)
+		if marshalErr == nil {
+			run.Result = string(bts)
+		}
+	}
+
+	metrics.JobRuns.Inc(name, run.Status)
+	metrics.JobDuration.Observe(run.Finished.Sub(run.Started).Seconds(), name)
+	if run.Id != 0 {
+		updateErr := locks.UpdateJobRun(ctx, run)
+		if updateErr != nil {
+			log.Errorf(ctx, "Error recording run of job %s: %+v", name, updateErr)
+		}
+	}
+
+	releaseErr := locks.ReleaseJobLock(ctx, name, owner, run.Status)
+	if releaseErr != nil {
+		log.Errorf(ctx, "Error releasing lock of job %s: %+v", name, releaseErr)
+	}
+
+	return run, err
+}
+
+func runJob(ctx context.Context, job *Job) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+
+	return job.Run(ctx)
+}
+
+// StartScheduler runs the jobs that have an interval in the background, for deployments without
+// App Engine cron. Instances share the datastore locks so each job runs once per interval overall.
+func StartScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(SchedulerTick)
+		defer ticker.Stop()
+		for {
+			for _, job := range List() {
+				if job.Interval <= 0 {
+					continue
+				}
+
+				_, err := Run(ctx, job.Name, TriggerScheduler)
+				if err != nil && err != entities.ErrJobLocked && err != entities.ErrJobNotDue {
+					log.Errorf(ctx, "Scheduled run of job %s failed: %+v", job.Name, err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return name
+}
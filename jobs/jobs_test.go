@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+type fakeLocks struct {
+	acquireErr  error
+	minInterval time.Duration
+	released    string
+	runs        []entities.JobRun
+}
+
+func (f *fakeLocks) AcquireJobLock(ctx context.Context, job string, owner string, lease time.Duration, minInterval time.Duration) (*entities.JobLock, error) {
+	f.minInterval = minInterval
+	return &entities.JobLock{}, f.acquireErr
+}
+
+func (f *fakeLocks) ReleaseJobLock(ctx context.Context, job string, owner string, status string) error {
+	f.released = status
+	return nil
+}
+
+func (f *fakeLocks) CreateJobRun(ctx context.Context, run *entities.JobRun) error {
+	run.Id = 1
+	return nil
+}
+
+func (f *fakeLocks) UpdateJobRun(ctx context.Context, run *entities.JobRun) error {
+	f.runs = append(f.runs, *run)
+	return nil
+}
+
+func register(t *testing.T, job *Job) {
+	Register(job)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		delete(registry, job.Name)
+	})
+}
+
+func TestRun(t *testing.T) {
+	locks := &fakeLocks{}
+	register(t, &Job{
+		Name:     "test-job",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (interface{}, error) {
+			return map[string]int{"done": 3}, nil
+		},
+	})
+
+	run, err := runWithLocks(context.Background(), locks, "test-job", TriggerAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Status != entities.JobRunStatusSucceeded || run.Result != `{"done":3}` || run.Trigger != TriggerAdmin {
+		t.Errorf("unexpected run %+v", run)
+	}
+
+	if locks.minInterval != 0 || locks.released != entities.JobRunStatusSucceeded || len(locks.runs) != 1 {
+		t.Errorf("admin runs should ignore the interval and release the lock: %+v", locks)
+	}
+
+	if _, err := runWithLocks(context.Background(), locks, "test-job", TriggerScheduler); err != nil || locks.minInterval != time.Hour {
+		t.Errorf("scheduled runs should wait for the interval, got %v and %v", locks.minInterval, err)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	locks := &fakeLocks{}
+	register(t, &Job{
+		Name: "test-panic",
+		Run: func(ctx context.Context) (interface{}, error) {
+			panic("boom")
+		},
+	})
+
+	failure := errors.New("Something broke.")
+	register(t, &Job{
+		Name: "test-error",
+		Run: func(ctx context.Context) (interface{}, error) {
+			return nil, failure
+		},
+	})
+
+	run, err := runWithLocks(context.Background(), locks, "test-panic", TriggerCron)
+	if err == nil || run.Status != entities.JobRunStatusFailed || run.Error != "job panicked: boom" {
+		t.Errorf("got %+v, %v", run, err)
+	}
+
+	run, err = runWithLocks(context.Background(), locks, "test-error", TriggerCron)
+	if err != failure || run.Error != "Something broke." || locks.released != entities.JobRunStatusFailed {
+		t.Errorf("got %+v, %v", run, err)
+	}
+
+	locks.acquireErr = entities.ErrJobLocked
+	run, err = runWithLocks(context.Background(), locks, "test-error", TriggerCron)
+	if run != nil || err != entities.ErrJobLocked {
+		t.Errorf("a locked job ran: %+v, %v", run, err)
+	}
+
+	if _, err := runWithLocks(context.Background(), locks, "missing", TriggerCron); err != ErrJobNotFound {
+		t.Errorf("got %v for a missing job", err)
+	}
+}
+
+func TestHostRoot(t *testing.T) {
+	if got := HostRoot(context.Background()); got != "" {
+		t.Errorf("got %q without a host root", got)
+	}
+
+	if got := HostRoot(WithHostRoot(context.Background(), "https://shop.com")); got != "https://shop.com" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -1,18 +1,23 @@
 package km
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/contentsearch"
+	"github.com/jcarm010/kodimerce/datastore"
 	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/imaging"
 	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/notifications"
+	"github.com/jcarm010/kodimerce/productsearch"
 	"github.com/jcarm010/kodimerce/search_api"
-	"github.com/jcarm010/kodimerce/settings"
 	"github.com/jcarm010/kodimerce/storage"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/blobstore"
+	"github.com/jcarm010/kodimerce/uploads"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"mime/multipart"
 	"net/http"
@@ -45,7 +50,7 @@ func (c *AdminContext) Auth(w web.ResponseWriter, r *web.Request, next web.NextM
 		return
 	}
 
-	userSession, err := entities.GetUserSession(c.Context, sessionToken)
+	userSession, err := entities.GetUserSession(c.Context, sessionToken, c.Settings.SessionTTL())
 	if err != nil {
 		log.Errorf(c.Context, "Error getting session: %+v", err)
 		if r.Method == "GET" {
@@ -78,6 +83,7 @@ func (c *AdminContext) Auth(w web.ResponseWriter, r *web.Request, next web.NextM
 		return
 	}
 
+	log.SetUser(c.Context, user.Email)
 	log.Debugf(c.Context, "Authenticated user: %+v", user.Email)
 	c.User = user
 	next(w, r)
@@ -109,6 +115,7 @@ func (c *AdminContext) CreateProduct(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexProduct(product.Id)
 	c.ServeJson(http.StatusOK, product)
 }
 
@@ -154,7 +161,7 @@ func (c *AdminContext) UpdateProduct(w web.ResponseWriter, r *web.Request) {
 
 	log.Infof(c.Context, "pricingOptions: %+v:", product.PricingOptions)
 	if product.Path == "" {
-		product.Path = fmt.Sprintf("%s", product.Id)
+		product.Path = fmt.Sprintf("%v", product.Id)
 	}
 
 	if product.Pictures == nil {
@@ -167,6 +174,18 @@ func (c *AdminContext) UpdateProduct(w web.ResponseWriter, r *web.Request) {
 		c.ServeJson(http.StatusInternalServerError, "Unexpected value storing product")
 		return
 	}
+
+	c.indexProduct(product.Id)
+	c.indexReferences(uploads.ReferenceProduct, product.Id)
+}
+
+// indexProduct updates the storefront search with the changes made to a product. Failures are
+// only logged, the index is reloaded from the datastore on its own.
+func (c *AdminContext) indexProduct(productId int64) {
+	err := productsearch.IndexProduct(c.Context, productId)
+	if err != nil {
+		log.Errorf(c.Context, "Error indexing product %v: %+v", productId, err)
+	}
 }
 
 func (c *AdminContext) GetCategory(w web.ResponseWriter, r *web.Request) {
@@ -226,6 +245,11 @@ func (c *AdminContext) UpdateCategory(w web.ResponseWriter, r *web.Request) {
 		c.ServeJson(http.StatusInternalServerError, "Unexpected value storing category")
 		return
 	}
+
+	err = productsearch.Rebuild(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error rebuilding product search: %+v", err)
+	}
 }
 
 func (c *AdminContext) SetCategoryProducts(w web.ResponseWriter, r *web.Request) {
@@ -272,6 +296,10 @@ func (c *AdminContext) SetCategoryProducts(w web.ResponseWriter, r *web.Request)
 		c.ServeJson(http.StatusInternalServerError, "Unexpected value storing category products")
 		return
 	}
+
+	for _, cp := range categoryProducts {
+		c.indexProduct(cp.ProductId)
+	}
 }
 
 func (c *AdminContext) UnsetCategoryProducts(w web.ResponseWriter, r *web.Request) {
@@ -311,38 +339,59 @@ func (c *AdminContext) UnsetCategoryProducts(w web.ResponseWriter, r *web.Reques
 		c.ServeJson(http.StatusInternalServerError, "Unexpected value deleting category products")
 		return
 	}
+
+	for _, cp := range categoryProducts {
+		c.indexProduct(cp.ProductId)
+	}
 }
 
+// GetGalleryUploadUrl returns where files are uploaded. Given a filename and size it starts an
+// upload session instead, see CreateUploadSession, which can upload straight to storage.
 func (c *AdminContext) GetGalleryUploadUrl(w web.ResponseWriter, r *web.Request) {
+	if r.FormValue("filename") != "" {
+		c.CreateUploadSession(w, r)
+		return
+	}
+
 	uploadURL := "/admin/gallery/upload"
 	log.Infof(c.Context, "Upload url: %+v", uploadURL)
 	c.ServeJson(http.StatusOK, uploadURL)
 }
 
-func createStorageFile(ctx context.Context, objectName string, file *multipart.FileHeader) (md5Hash string, err error) {
+// readUpload reads an uploaded file, checking its size and sniffed type against the policy
+// before reading it all.
+func readUpload(file *multipart.FileHeader, policy *uploads.Policy) (data []byte, contentType string, rejection *uploads.Rejection, err error) {
 	f, err := file.Open()
 	if err != nil {
-		return "", err
+		return nil, "", nil, err
 	}
 
 	defer func() {
 		_ = f.Close()
 	}()
 
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+	head := make([]byte, uploads.SniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", nil, err
+	}
+
+	contentType = uploads.Sniff(head[:n])
+	rejection = policy.Allow(contentType, file.Size)
+	if rejection != nil {
+		return nil, contentType, rejection, nil
 	}
 
-	md5Hash = fmt.Sprintf("%x", h.Sum(nil))
-	_, err = f.Seek(0,0)
+	rest, err := ioutil.ReadAll(f)
 	if err != nil {
-		return "", err
+		return nil, "", nil, err
 	}
 
-	return md5Hash, storage.PutObject(ctx, objectName, f)
+	return append(head[:n], rest...), contentType, nil, nil
 }
 
+// PostGalleryUpload stores the uploaded files that pass the upload policy, see Policy.Store. When
+// any file is rejected the response lists the stored files and why each of the others was rejected.
 func (c *AdminContext) PostGalleryUpload(w web.ResponseWriter, r *web.Request) {
 	err := r.ParseMultipartForm(32 << 20 /*32 MB*/)
 	if err != nil {
@@ -351,45 +400,51 @@ func (c *AdminContext) PostGalleryUpload(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	policy := uploads.NewPolicy(&c.Settings)
 	storedFiles := make([]*search_api.BlobInfo, 0)
+	rejections := make([]*uploads.Rejection, 0)
+	status := http.StatusUnprocessableEntity
 	files := r.MultipartForm.File["file"]
 	for _, file := range files {
-		key := RandStringRunes(20)
-		objectName := "uploads/" + key + "/" + file.Filename
-		md5Hash, err := createStorageFile(r.Context(), objectName, file)
+		data, contentType, rejection, err := readUpload(file, policy)
 		if err != nil {
-			log.Errorf(c.Context, "Error storing file: %+v", err)
-			c.ServeJson(http.StatusBadRequest, "Could not store upload.")
-			return
+			log.Errorf(c.Context, "Error reading file %s: %+v", file.Filename, err)
+			rejection = &uploads.Rejection{Reason: uploads.ReasonStoreFailed, Message: "The file could not be read."}
 		}
 
-
-		log.Infof(r.Context(), "Size: %+v", file.Size)
-		log.Infof(r.Context(), "Header: %+v", file.Header)
-		searchBlob := &search_api.BlobInfo {
-			BlobKey:      key,
-			ContentType:  file.Header.Get("Content-Type"),
-			CreationTime: time.Now(),
-			Filename:     file.Filename,
-			MD5:          md5Hash,
-			ObjectName:   objectName,
-			Size:         file.Size,
+		var upload *search_api.BlobInfo
+		if rejection == nil {
+			upload, rejection, err = policy.Store(c.Context, &search_api.BlobInfo{Filename: file.Filename}, data)
+			if err != nil {
+				log.Errorf(c.Context, "Error storing file %s: %+v", file.Filename, err)
+				rejection = &uploads.Rejection{Reason: uploads.ReasonStoreFailed, Message: "The file could not be stored."}
+				status = http.StatusInternalServerError
+			}
 		}
 
-		log.Infof(r.Context(), "==================File: \n%+v", searchBlob)
-		err = entities.PutUpload(r.Context(), searchBlob)
-		if err != nil {
-			log.Errorf(c.Context, "Error storing file in datastore: %+v", err)
-			c.ServeJson(http.StatusBadRequest, "Could not store upload.")
-			return
+		if rejection != nil {
+			log.Warningf(c.Context, "Rejected upload %s of type %s: %s", file.Filename, contentType, rejection.Message)
+			rejection.Filename = file.Filename
+			rejections = append(rejections, rejection)
+			continue
 		}
 
-		storedFiles = append(storedFiles, searchBlob)
+		storedFiles = append(storedFiles, upload)
+	}
+
+	if len(rejections) > 0 {
+		c.ServeJson(status, map[string]interface{}{
+			"uploaded": storedFiles,
+			"rejected": rejections,
+		})
+		return
 	}
 
 	c.ServeJson(http.StatusOK, storedFiles)
 }
 
+// DeleteGalleryUpload deletes an upload, its file and its variants. Uploads that products, posts,
+// pages or galleries still show aren't deleted unless force=true, the response lists them.
 func (c *AdminContext) DeleteGalleryUpload(w web.ResponseWriter, r *web.Request) {
 	key := r.URL.Query().Get("k")
 	if key == "" {
@@ -397,20 +452,87 @@ func (c *AdminContext) DeleteGalleryUpload(w web.ResponseWriter, r *web.Request)
 		return
 	}
 
-	err := blobstore.Delete(c.Context, appengine.BlobKey(key))
+	upload, err := entities.GetUpload(c.Context, key)
+	if err != nil {
+		log.Errorf(c.Context, "Error getting upload %s: %+v", key, err)
+		c.ServeJson(http.StatusNotFound, "Upload not found.")
+		return
+	}
+
+	refs, err := uploads.ReferencesOf(c.Context, upload)
+	if err != nil {
+		log.Errorf(c.Context, "Error finding references to upload %s: %+v", key, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error finding where the file is used.")
+		return
+	}
+
+	if len(refs) > 0 {
+		if r.URL.Query().Get("force") != "true" {
+			c.ServeJson(http.StatusConflict, map[string]interface{}{
+				"message":    fmt.Sprintf("The file is still used in %d places.", len(refs)),
+				"references": refs,
+			})
+			return
+		}
+
+		log.Warningf(c.Context, "Deleting upload %s which is still used in %d places", key, len(refs))
+	}
+
+	// uploads imported under a second key share the file, it is deleted with the last of them
+	shared, err := entities.CountUploadsOfObject(c.Context, upload.ObjectName)
 	if err != nil {
-		log.Errorf(c.Context, "Error removing file: %+v", err)
+		log.Errorf(c.Context, "Error counting uploads of file %s: %+v", upload.ObjectName, err)
 		c.ServeJson(http.StatusInternalServerError, "Unexpected error removing file")
 		return
 	}
 
-	searchClient := search_api.NewClient(c.Context)
-	err = searchClient.DeleteIndex(key)
+	if shared <= 1 {
+		err = storage.DeleteObject(c.Context, upload.ObjectName)
+		if err != nil && err != storage.ErrObjectNotExist {
+			log.Errorf(c.Context, "Error removing file: %+v", err)
+			c.ServeJson(http.StatusInternalServerError, "Unexpected error removing file")
+			return
+		}
+	}
+
+	err = imaging.DeleteVariants(c.Context, key)
 	if err != nil {
-		log.Errorf(c.Context, "Error removing file from search api: %+v", err)
-		c.ServeJson(http.StatusInternalServerError, "Unexpected error removing file from search api")
+		log.Errorf(c.Context, "Error removing variants of upload %s: %+v", key, err)
+	}
+
+	err = entities.DeleteUpload(c.Context, key)
+	if err != nil {
+		log.Errorf(c.Context, "Error removing upload %s: %+v", key, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error removing file")
 		return
 	}
+
+	c.ServeJson(http.StatusOK, map[string]interface{}{"references": refs})
+}
+
+// GetGalleryUploadReferences lists the products, posts, pages and galleries that show an upload.
+func (c *AdminContext) GetGalleryUploadReferences(w web.ResponseWriter, r *web.Request) {
+	key := r.URL.Query().Get("k")
+	if key == "" {
+		c.ServeJson(http.StatusBadRequest, "Missing upload key.")
+		return
+	}
+
+	upload, err := entities.GetUpload(c.Context, key)
+	if err != nil {
+		log.Errorf(c.Context, "Error getting upload %s: %+v", key, err)
+		c.ServeJson(http.StatusNotFound, "Upload not found.")
+		return
+	}
+
+	references, err := uploads.ReferencesOf(c.Context, upload)
+	if err != nil {
+		log.Errorf(c.Context, "Error finding references to upload %s: %+v", key, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error finding where the file is used.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, references)
 }
 
 func (c *AdminContext) GetGalleryUploads(w web.ResponseWriter, r *web.Request) {
@@ -428,7 +550,12 @@ func (c *AdminContext) GetGalleryUploads(w web.ResponseWriter, r *web.Request) {
 		}
 	}
 
-	blobs, err := entities.ListUploads(c.Context, cursor, int(limit), search)
+	filters := search_api.BlobFilters{Folder: uploads.NormalizeFolder(q.Get("folder"))}
+	if tags := uploads.ParseTags(q.Get("tag")); len(tags) > 0 {
+		filters.Tag = tags[0]
+	}
+
+	blobs, err := entities.ListUploads(c.Context, cursor, int(limit), search, filters)
 	if err != nil {
 		log.Errorf(c.Context, "Error fetching blobs: %+v", err)
 		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting uploads")
@@ -438,6 +565,40 @@ func (c *AdminContext) GetGalleryUploads(w web.ResponseWriter, r *web.Request) {
 	c.ServeJson(http.StatusOK, blobs)
 }
 
+// PutGalleryUpload replaces the title, alt text, caption, folder and tags of an upload. Tags are
+// comma separated.
+func (c *AdminContext) PutGalleryUpload(w web.ResponseWriter, r *web.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Errorf(c.Context, "Error parsing form: %+v", err)
+		c.ServeJson(http.StatusBadRequest, "Could not parse upload details.")
+		return
+	}
+
+	key := r.PathParams["key"]
+	metadata := &entities.UploadMetadata{
+		Title:   strings.TrimSpace(r.FormValue("title")),
+		Alt:     strings.TrimSpace(r.FormValue("alt")),
+		Caption: strings.TrimSpace(r.FormValue("caption")),
+		Folder:  uploads.NormalizeFolder(r.FormValue("folder")),
+		Tags:    uploads.ParseTags(strings.Join(r.Form["tags"], ",")),
+	}
+
+	upload, err := c.store.UpdateUploadMetadata(c.Context, key, metadata)
+	if err == datastore.ErrNoSuchEntity {
+		c.ServeJson(http.StatusNotFound, "Upload not found.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error updating upload %s: %+v", key, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error updating the upload.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, upload)
+}
+
 func (c *AdminContext) InitSearchAPI(w web.ResponseWriter, r *web.Request) {
 	err := entities.InitSearchAPI(c.Context)
 	if err != nil {
@@ -464,22 +625,7 @@ func (c *ServerContext) GetGalleryUploadByName(w web.ResponseWriter, r *web.Requ
 		return
 	}
 
-	contentType := upload.ContentType
-	w.Header().Add("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", name))
-	w.Header().Add("Cache-Control", "max-age=2593000")
-	w.Header().Add("Content-Type", contentType)
-	w.Header().Add("Content-Length", fmt.Sprintf("%v", upload.Size))
-	rc, err := storage.GetObject(upload.ObjectName).NewReader(r.Context())
-	if err != nil {
-		log.Errorf(c.Context, "Error getting upload storage object: %s", err)
-		c.ServeJson(http.StatusNotFound, "Upload not found.")
-		return
-	}
-
-	defer func() {
-		_ = rc.Close()
-	}()
-	_, _ = io.Copy(w, rc)
+	c.serveUpload(w, r, upload)
 }
 
 func (c *ServerContext) GetGalleryUpload(w web.ResponseWriter, r *web.Request) {
@@ -499,15 +645,52 @@ func (c *ServerContext) GetGalleryUpload(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
-	name := upload.Filename
-	contentType := upload.ContentType
+	c.serveUpload(w, r, upload)
+}
+
+// serveUpload serves an upload with http.ServeContent, which answers HEAD, range and
+// conditional requests. Images are resized and converted when the request asks for a width with w
+// or a format with fmt, see imaging.GetVariant.
+func (c *ServerContext) serveUpload(w web.ResponseWriter, r *web.Request, upload *search_api.BlobInfo) {
 	cacheUntil := time.Now().AddDate(0, 2, 0).Format(http.TimeFormat)
-	w.Header().Add("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", name))
+	w.Header().Add("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", upload.Filename))
 	w.Header().Add("Cache-Control", "max-age=2593000")
-	w.Header().Add("Content-Type", contentType)
 	w.Header().Set("Expires", cacheUntil)
-	w.Header().Add("Content-Length", fmt.Sprintf("%v", upload.Size))
-	rc, err := storage.GetObject(upload.ObjectName).NewReader(r.Context())
+	w.Header().Set("Accept-Ranges", "bytes")
+	if strings.ToLower(r.URL.Query().Get("fmt")) == imaging.FormatAuto {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	variant, err := c.uploadVariant(r, upload)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if variant != nil && upload.MD5 != "" {
+		etag := fmt.Sprintf("\"%s-%v-%s\"", upload.MD5, variant.Width, variant.Format)
+		w.Header().Set("Etag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			// answered before the variant is loaded or made, ServeContent would need it first
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if variant != nil {
+		data, err := imaging.GetVariant(c.Context, variant, upload.ObjectName, c.Settings.ImageQuality)
+		if err == nil {
+			w.Header().Set("Content-Type", imaging.ContentType(variant.Format))
+			http.ServeContent(w, r.Request, "", upload.CreationTime, bytes.NewReader(data))
+			return
+		}
+
+		// the original is better than no image at all
+		log.Errorf(c.Context, "Error making variant %s of upload: %+v", variant.ObjectName(), err)
+		w.Header().Del("Etag")
+	}
+
+	content, attrs, err := storage.NewObjectReadSeeker(r.Context(), upload.ObjectName)
 	if err != nil {
 		log.Errorf(c.Context, "Error getting upload storage object: %s", err)
 		c.ServeJson(http.StatusNotFound, "Upload not found.")
@@ -515,9 +698,82 @@ func (c *ServerContext) GetGalleryUpload(w web.ResponseWriter, r *web.Request) {
 	}
 
 	defer func() {
-		_ = rc.Close()
+		_ = content.Close()
 	}()
-	_, _ = io.Copy(w, rc)
+
+	etag := upload.MD5
+	if etag == "" {
+		etag = attrs.Etag
+	}
+
+	modified := upload.CreationTime
+	if modified.IsZero() {
+		modified = attrs.Updated
+	}
+
+	w.Header().Set("Content-Type", upload.ContentType)
+	w.Header().Set("Etag", fmt.Sprintf("\"%s\"", strings.Trim(etag, "\"")))
+	http.ServeContent(w, r.Request, "", modified, content)
+}
+
+// etagMatches tells whether an If-None-Match header lists etag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uploadVariant reads the w and fmt parameters, it returns nil when the original should be served.
+func (c *ServerContext) uploadVariant(r *web.Request, upload *search_api.BlobInfo) (*imaging.Variant, error) {
+	q := r.URL.Query()
+	widthStr, format := q.Get("w"), strings.ToLower(q.Get("fmt"))
+	originalFormat := imaging.FormatOf(upload.ContentType)
+	if (widthStr == "" && format == "") || originalFormat == "" {
+		return nil, nil
+	}
+
+	requestedWidth := 0
+	if widthStr != "" {
+		var err error
+		requestedWidth, err = strconv.Atoi(widthStr)
+		if err != nil || requestedWidth <= 0 {
+			return nil, fmt.Errorf("Invalid width: %s", widthStr)
+		}
+	}
+
+	switch format {
+	case "":
+		// resized gifs are png, they lose their animation
+		format = originalFormat
+		if format == imaging.FormatGIF {
+			format = imaging.FormatPNG
+		}
+	case imaging.FormatAuto:
+		format = originalFormat
+		if strings.Contains(r.Header.Get("Accept"), "image/webp") && imaging.CanEncode(imaging.FormatWebP) {
+			format = imaging.FormatWebP
+		} else if format != imaging.FormatJPEG {
+			format = imaging.FormatPNG
+		}
+	case "jpg":
+		format = imaging.FormatJPEG
+	}
+
+	if !imaging.CanEncode(format) {
+		return nil, fmt.Errorf("Unsupported format: %s", format)
+	}
+
+	width := imaging.VariantWidth(imaging.ParseWidths(c.Settings.ImageWidths), requestedWidth, upload.Width)
+	if width == 0 && format == originalFormat {
+		return nil, nil
+	}
+
+	return &imaging.Variant{Key: upload.BlobKey, Width: width, Format: format}, nil
 }
 
 func (c *AdminContext) GetOrders(w web.ResponseWriter, r *web.Request) {
@@ -553,6 +809,9 @@ func (c *AdminContext) OverrideOrder(w web.ResponseWriter, r *web.Request) {
 	paypalPayerId := r.FormValue("paypal_payer_id")
 	addressVerifiedStr := r.FormValue("address_verified")
 	status := r.FormValue("status")
+	carrier := r.FormValue("carrier")
+	trackingNumber := r.FormValue("tracking_number")
+	trackingUrl := r.FormValue("tracking_url")
 
 	log.Infof(c.Context, "Updating order idStr[%s] shippingName[%s] shippingLine1[%s] shippingLine2[%s] city[%s] state[%s] postalCode[%s] countryCode[%s] email[%s] phone[%s] checkoutStep[%s] paypalPayerId[%s] addressVerifiedStr[%s] status[%s]",
 		idStr, shippingName, shippingLine1, shippingLine2, city, state, postalCode, countryCode, email, phone, checkoutStep, paypalPayerId, addressVerifiedStr, status)
@@ -576,7 +835,7 @@ func (c *AdminContext) OverrideOrder(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
-	order, err := entities.GetOrder(c.Context, orderId)
+	order, err := c.store.GetOrder(c.Context, orderId)
 	if err != nil {
 		log.Errorf(c.Context, "Error finding order: %+v", err)
 		c.ServeJson(http.StatusBadRequest, "Could not find order. Please try again later.")
@@ -595,15 +854,35 @@ func (c *AdminContext) OverrideOrder(w web.ResponseWriter, r *web.Request) {
 	order.CheckoutStep = checkoutStep
 	order.PaypalPayerId = paypalPayerId
 	order.AddressVerified = addressVerifiedStr == "true"
+	previousStatus := order.Status
 	order.Status = status
+	// forms that don't know about shipments leave the tracking alone
+	if _, present := r.Form["carrier"]; present {
+		order.Carrier = carrier
+	}
 
-	err = entities.UpdateOrder(c.Context, order)
+	if _, present := r.Form["tracking_number"]; present {
+		order.TrackingNumber = trackingNumber
+	}
+
+	if _, present := r.Form["tracking_url"]; present {
+		order.TrackingUrl = trackingUrl
+	}
+
+	err = c.store.UpdateOrder(c.Context, order)
 	if err != nil {
 		log.Errorf(c.Context, "Error updating order: %+v", err)
 		c.ServeJson(http.StatusBadRequest, "Could not update order. Please try again later.")
 		return
 	}
 
+	if event := entities.OrderEventForStatus(status); event != "" && status != previousStatus {
+		_, err = notifications.NotifyOrderEvent(c.Context, event, c.EmailTemplateData(r, order))
+		if err != nil {
+			log.Errorf(c.Context, "Couldn't notify %s of order[%v]: %+v", event, order.Id, err)
+		}
+	}
+
 	c.ServeJson(http.StatusOK, "")
 }
 
@@ -643,6 +922,7 @@ func (c *AdminContext) CreatePost(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexContent(contentsearch.IndexPost, post.Id)
 	c.ServeJson(http.StatusOK, post)
 }
 
@@ -666,6 +946,9 @@ func (c *AdminContext) UpdatePost(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexContent(contentsearch.IndexPost, data.Post.Id)
+	c.indexReferences(uploads.ReferencePost, data.Post.Id)
+
 	c.ServeJson(http.StatusOK, "")
 }
 
@@ -699,6 +982,7 @@ func (c *AdminContext) CreateGallery(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexContent(contentsearch.IndexGallery, gallery.Id)
 	c.ServeJson(http.StatusOK, gallery)
 }
 
@@ -721,6 +1005,9 @@ func (c *AdminContext) UpdateGallery(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexContent(contentsearch.IndexGallery, data.Gallery.Id)
+	c.indexReferences(uploads.ReferenceGallery, data.Gallery.Id)
+
 	c.ServeJson(http.StatusOK, "")
 }
 
@@ -743,6 +1030,7 @@ func (c *AdminContext) CreatePage(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexContent(contentsearch.IndexPage, page.Id)
 	c.ServeJson(http.StatusOK, page)
 }
 
@@ -777,9 +1065,30 @@ func (c *AdminContext) UpdatePage(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	c.indexContent(contentsearch.IndexPage, data.Page.Id)
+	c.indexReferences(uploads.ReferencePage, data.Page.Id)
+
 	c.ServeJson(http.StatusOK, "")
 }
 
+// indexContent updates the site search with the changes made to a post, page or gallery.
+// Failures are only logged, the index is reloaded from the datastore on its own.
+func (c *AdminContext) indexContent(index func(ctx context.Context, id int64) error, id int64) {
+	err := index(c.Context, id)
+	if err != nil {
+		log.Errorf(c.Context, "Error indexing content %v: %+v", id, err)
+	}
+}
+
+// indexReferences records the uploads a product, post, page or gallery shows after it is saved.
+// Failures are only logged, saving what shows the uploads matters more.
+func (c *AdminContext) indexReferences(referenceType string, id int64) {
+	err := uploads.IndexReferences(c.Context, referenceType, id)
+	if err != nil {
+		log.Errorf(c.Context, "Error indexing the uploads %s %v shows: %+v", referenceType, id, err)
+	}
+}
+
 func (c *AdminContext) SaveLastVisitedPath(w web.ResponseWriter, r *web.Request) {
 	data := struct {
 		LastPath string `json:"last_path"`
@@ -804,24 +1113,57 @@ func (c *AdminContext) SaveLastVisitedPath(w web.ResponseWriter, r *web.Request)
 	c.ServeJson(http.StatusOK, "")
 }
 
+// UpdateGeneralSettings changes the settings the request sends and keeps the stored value of the
+// rest, so forms that don't know about a setting or leave secrets out don't wipe them. Notifications
+// only count as configured once one of their switches is sent.
 func (c *AdminContext) UpdateGeneralSettings(w web.ResponseWriter, r *web.Request) {
-	newGeneralSettings := entities.ServerSettings{}
-	err := c.ParseJsonRequest(&newGeneralSettings)
+	fields := map[string]json.RawMessage{}
+	err := c.ParseJsonRequest(&fields)
 	if err != nil {
 		log.Errorf(c.Context, "Could not parse settings: %s", err)
 		c.ServeJson(http.StatusBadRequest, "Could not parse settings.")
 		return
 	}
 
-	log.Infof(c.Context, "GeneralSettings: %+v", newGeneralSettings)
-	err = entities.StoreServerSettings(c.Context, &newGeneralSettings)
+	newGeneralSettings, err := c.store.GetServerSettings(c.Context)
+	if err == entities.ErrSettingsNotFound {
+		current := c.Settings
+		newGeneralSettings = &current
+	} else if err != nil {
+		log.Errorf(c.Context, "Error loading settings: %s", err)
+		c.ServeJson(http.StatusInternalServerError, "Error loading settings.")
+		return
+	}
+
+	names := make([]string, 0, len(fields))
+	notificationsSent := false
+	for name := range fields {
+		names = append(names, name)
+		notificationsSent = notificationsSent || strings.HasPrefix(name, "notify_")
+	}
+
+	sort.Strings(names)
+	bts, _ := json.Marshal(fields)
+	err = json.Unmarshal(bts, newGeneralSettings)
+	if err != nil {
+		log.Errorf(c.Context, "Could not parse settings: %s", err)
+		c.ServeJson(http.StatusBadRequest, "Could not parse settings.")
+		return
+	}
+
+	log.Infof(c.Context, "Updating settings: %s", strings.Join(names, ", "))
+	if notificationsSent {
+		newGeneralSettings.NotificationsConfigured = true
+	}
+
+	err = c.store.StoreServerSettings(c.Context, newGeneralSettings)
 	if err != nil {
 		log.Errorf(c.Context, "Error storing settings: %s", err)
 		c.ServeJson(http.StatusBadRequest, "Error storing settings.")
 		return
 	}
 
-	generalSettings := settings.GetAndReloadGlobalSettings(c.Context)
+	generalSettings := c.store.ReloadSettings(c.Context)
 	c.ServeJson(http.StatusOK, generalSettings)
 }
 
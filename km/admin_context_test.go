@@ -0,0 +1,219 @@
+package km
+
+import (
+	"context"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search_api"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOverrideOrderKeepsMissingTracking(t *testing.T) {
+	stored := &entities.Order{Id: 7, Status: "paid", Carrier: "ups", TrackingNumber: "1Z999", TrackingUrl: "https://ups.com/1Z999"}
+	var updated *entities.Order
+	store := &fakeStore{
+		getOrder: func(ctx context.Context, orderId int64) (*entities.Order, error) {
+			order := *stored
+			return &order, nil
+		},
+		updateOrder: func(ctx context.Context, order *entities.Order) error {
+			updated = order
+			return nil
+		},
+	}
+
+	router := web.New(ServerContext{}).Middleware((*ServerContext).initTestContext).Middleware(withStore(store))
+	router.Subrouter(AdminContext{}, "/admin").Post("/order", (*AdminContext).OverrideOrder)
+	override := func(form url.Values) {
+		updated = nil
+		r := httptest.NewRequest("POST", "/admin/order", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || updated == nil {
+			t.Fatalf("got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	form := url.Values{"id": {"7"}, "shipping_name": {"Ana"}, "email": {"ana@example.com"}, "status": {"paid"}}
+	override(form)
+	if updated.Carrier != "ups" || updated.TrackingNumber != "1Z999" || updated.TrackingUrl != "https://ups.com/1Z999" {
+		t.Errorf("the tracking changed: %+v", updated)
+	}
+
+	if updated.ShippingName != "Ana" {
+		t.Errorf("got shipping name %q", updated.ShippingName)
+	}
+
+	form.Set("tracking_number", "1Z000")
+	form.Set("tracking_url", "")
+	override(form)
+	if updated.Carrier != "ups" || updated.TrackingNumber != "1Z000" || updated.TrackingUrl != "" {
+		t.Errorf("only the tracking number and url should change: %+v", updated)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := map[string]bool{
+		`"abc"`:           true,
+		`W/"abc"`:         true,
+		`"xyz", "abc"`:    true,
+		`*`:               true,
+		`"abcd"`:          false,
+		`abc`:             false,
+		``:                false,
+		`"xyz",W/"other"`: false,
+	}
+
+	for header, want := range tests {
+		if got := etagMatches(header, `"abc"`); got != want {
+			t.Errorf("etagMatches(%s) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestServeUploadVariantNotModified(t *testing.T) {
+	upload := &search_api.BlobInfo{BlobKey: "key", Filename: "photo.jpg", ContentType: "image/jpeg", MD5: "md5", Width: 2000}
+	router := web.New(ServerContext{}).
+		Middleware((*ServerContext).initTestContext).
+		Get("/upload", func(c *ServerContext, w web.ResponseWriter, r *web.Request) {
+			c.serveUpload(w, r, upload)
+		})
+
+	serve := func(query string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/upload?"+query, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("fmt=png", `"other", "md5-0-png"`)
+	if w.Code != http.StatusNotModified || w.Header().Get("Etag") != `"md5-0-png"` || w.Body.Len() != 0 {
+		t.Errorf("got %d with etag %s", w.Code, w.Header().Get("Etag"))
+	}
+
+	if w.Header().Get("Accept-Ranges") != "bytes" || w.Header().Get("Cache-Control") == "" {
+		t.Errorf("a not modified response lost its caching headers: %v", w.Header())
+	}
+
+	if w := serve("w=abc", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d for an invalid width", w.Code)
+	}
+}
+
+func TestPutGalleryUpload(t *testing.T) {
+	var saved *entities.UploadMetadata
+	store := &fakeStore{updateUploadMetadata: func(ctx context.Context, key string, metadata *entities.UploadMetadata) (*search_api.BlobInfo, error) {
+		if key != "key1" {
+			return nil, datastore.ErrNoSuchEntity
+		}
+
+		saved = metadata
+		return &search_api.BlobInfo{BlobKey: key, Title: metadata.Title}, nil
+	}}
+
+	router := web.New(ServerContext{}).Middleware((*ServerContext).initTestContext).Middleware(withStore(store))
+	router.Subrouter(AdminContext{}, "/admin").Put("/gallery/upload/:key", (*AdminContext).PutGalleryUpload)
+	put := func(key string, form url.Values) int {
+		r := httptest.NewRequest("PUT", "/admin/gallery/upload/"+key, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	form := url.Values{
+		"title":  {" Red shoes "},
+		"alt":    {"A pair of red shoes"},
+		"folder": {"/Products//Shoes/"},
+		"tags":   {"Summer, red", "sale"},
+	}
+
+	if code := put("key1", form); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+
+	want := &entities.UploadMetadata{Title: "Red shoes", Alt: "A pair of red shoes", Folder: "products/shoes", Tags: []string{"summer", "red", "sale"}}
+	if !reflect.DeepEqual(saved, want) {
+		t.Errorf("got %+v, want %+v", saved, want)
+	}
+
+	if code := put("missing", form); code != http.StatusNotFound {
+		t.Errorf("got %d for a missing upload", code)
+	}
+}
+
+func TestUpdateGeneralSettingsMergesSentFields(t *testing.T) {
+	stored := &entities.ServerSettings{
+		CompanyName:             "Old name",
+		SigningSecret:           "signing",
+		CronToken:               "cron",
+		MetricsToken:            "metrics",
+		OIDCClientSecret:        "oidc",
+		NotificationsConfigured: true,
+		NotifyOrderPaid:         true,
+		NotifyOrderShipped:      true,
+	}
+
+	var saved *entities.ServerSettings
+	store := &fakeStore{
+		getServerSettings: func(ctx context.Context) (*entities.ServerSettings, error) {
+			copied := *stored
+			return &copied, nil
+		},
+		storeServerSettings: func(ctx context.Context, serverSettings *entities.ServerSettings) error {
+			saved = serverSettings
+			return nil
+		},
+		reloadSettings: func(ctx context.Context) entities.ServerSettings {
+			return *saved
+		},
+	}
+
+	router := web.New(ServerContext{}).Middleware((*ServerContext).initTestContext).Middleware(withStore(store))
+	router.Subrouter(AdminContext{}, "/admin").Put("/settings", (*AdminContext).UpdateGeneralSettings)
+	put := func(body string) int {
+		saved = nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/admin/settings", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := put(`{"company_name": "New name", "tax_percent": 7}`); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+
+	want := *stored
+	want.CompanyName = "New name"
+	want.TaxPercent = 7
+	if !reflect.DeepEqual(*saved, want) {
+		t.Errorf("got %+v, want %+v", *saved, want)
+	}
+
+	if code := put(`{"notify_order_paid": false}`); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+
+	if saved.NotifyOrderPaid || !saved.NotifyOrderShipped || !saved.NotificationsConfigured {
+		t.Errorf("only the sent switch should change: %+v", saved)
+	}
+
+	stored.NotificationsConfigured = false
+	if code := put(`{"company_name": "Other name"}`); code != http.StatusOK || saved.NotificationsConfigured {
+		t.Errorf("got %d, notifications configured without sending a switch", code)
+	}
+
+	if code := put(`{"tax_percent": "seven"}`); code != http.StatusBadRequest || saved != nil {
+		t.Errorf("got %d for an invalid value", code)
+	}
+}
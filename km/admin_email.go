@@ -0,0 +1,79 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"net/http"
+	"strconv"
+)
+
+func (c *AdminContext) GetEmailMessages(w web.ResponseWriter, r *web.Request) {
+	q := r.URL.Query()
+	var limit int64 = 50
+	var err error
+	if q.Get("limit") != "" {
+		limit, err = strconv.ParseInt(q.Get("limit"), 10, 64)
+		if err != nil {
+			log.Errorf(c.Context, "Error parsing limit to int %s", err)
+			c.ServeJson(http.StatusBadRequest, "Error parsing limit to int")
+			return
+		}
+	}
+
+	messages, err := entities.ListEmailMessages(c.Context, q.Get("status"), int(limit))
+	if err != nil {
+		log.Errorf(c.Context, "Error listing email messages: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error listing emails.")
+		return
+	}
+
+	// bodies can be large, they are only returned when a single message is inspected
+	for _, message := range messages {
+		message.Body = ""
+	}
+
+	c.ServeJson(http.StatusOK, messages)
+}
+
+func (c *AdminContext) GetEmailMessage(w web.ResponseWriter, r *web.Request) {
+	id, err := strconv.ParseInt(r.PathParams["emailId"], 10, 64)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, "Invalid email id.")
+		return
+	}
+
+	message, err := entities.GetEmailMessage(c.Context, id)
+	if err == entities.ErrEmailMessageNotFound {
+		c.ServeJson(http.StatusNotFound, "Email not found.")
+		return
+	} else if err != nil {
+		log.Errorf(c.Context, "Error getting email message[%d]: %+v", id, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting email.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, message)
+}
+
+func (c *AdminContext) ResendEmailMessage(w web.ResponseWriter, r *web.Request) {
+	id, err := strconv.ParseInt(r.PathParams["emailId"], 10, 64)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, "Invalid email id.")
+		return
+	}
+
+	message, err := emailer.Resend(c.Context, id)
+	if err == entities.ErrEmailMessageNotFound {
+		c.ServeJson(http.StatusNotFound, "Email not found.")
+		return
+	} else if err != nil {
+		log.Errorf(c.Context, "Error resending email message[%d]: %+v", id, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error resending email.")
+		return
+	}
+
+	log.Infof(c.Context, "Email[%d] requeued by %s", id, c.User.Email)
+	c.ServeJson(http.StatusOK, message)
+}
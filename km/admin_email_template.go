@@ -0,0 +1,220 @@
+package km
+
+import (
+	"fmt"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"io"
+	"net/http"
+	"time"
+)
+
+// EmailTemplatePreview is the body of the preview and send-test requests. Subject, HTML and Text
+// render unsaved changes when set, otherwise the template in use is rendered. OrderId picks a real
+// order to render with, a sample order is used when it is 0.
+type EmailTemplatePreview struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+	OrderId int64  `json:"order_id"`
+	To      string `json:"to"`
+}
+
+func (c *AdminContext) GetEmailTemplates(w web.ResponseWriter, r *web.Request) {
+	names, err := emailer.TemplateNames()
+	if err != nil {
+		log.Errorf(c.Context, "Error loading email templates: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error loading email templates.")
+		return
+	}
+
+	templates := make([]*emailer.TemplateSource, 0, len(names))
+	for _, name := range names {
+		source, err := emailer.GetTemplateSource(c.Context, name)
+		if err != nil {
+			log.Errorf(c.Context, "Error loading email template %s: %+v", name, err)
+			c.ServeJson(http.StatusInternalServerError, "Unexpected error loading email templates.")
+			return
+		}
+
+		templates = append(templates, source)
+	}
+
+	c.ServeJson(http.StatusOK, templates)
+}
+
+func (c *AdminContext) GetEmailTemplate(w web.ResponseWriter, r *web.Request) {
+	name := r.PathParams["name"]
+	source, err := emailer.GetTemplateSource(c.Context, name)
+	if err == emailer.ErrTemplateNotFound {
+		c.ServeJson(http.StatusNotFound, "Email template not found.")
+		return
+	} else if err != nil {
+		log.Errorf(c.Context, "Error loading email template %s: %+v", name, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error loading email template.")
+		return
+	}
+
+	defaultSource, err := emailer.GetDefaultTemplateSource(name)
+	if err != nil {
+		log.Errorf(c.Context, "Error loading default email template %s: %+v", name, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error loading email template.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, struct {
+		*emailer.TemplateSource
+		Default *emailer.TemplateSource `json:"default"`
+	}{source, defaultSource})
+}
+
+func (c *AdminContext) UpdateEmailTemplate(w web.ResponseWriter, r *web.Request) {
+	override := &entities.EmailTemplate{}
+	err := c.ParseJsonRequest(override)
+	if err != nil {
+		log.Errorf(c.Context, "Error parsing email template: %+v", err)
+		c.ServeJson(http.StatusBadRequest, "Could not read email template.")
+		return
+	}
+
+	override.Name = r.PathParams["name"]
+	override.UpdatedBy = c.User.Email
+	err = emailer.SaveTemplateOverride(c.Context, override)
+	if err == emailer.ErrTemplateNotFound {
+		c.ServeJson(http.StatusNotFound, "Email template not found.")
+		return
+	} else if err != nil {
+		log.Errorf(c.Context, "Error saving email template %s: %+v", override.Name, err)
+		c.ServeJson(http.StatusBadRequest, fmt.Sprintf("Could not save email template: %s", err))
+		return
+	}
+
+	c.ServeJson(http.StatusOK, override)
+}
+
+// ResetEmailTemplate deletes the override so the default template is used again.
+func (c *AdminContext) ResetEmailTemplate(w web.ResponseWriter, r *web.Request) {
+	name := r.PathParams["name"]
+	err := emailer.DeleteTemplateOverride(c.Context, name)
+	if err != nil {
+		log.Errorf(c.Context, "Error resetting email template %s: %+v", name, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error resetting email template.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, "")
+}
+
+func (c *AdminContext) PreviewEmailTemplate(w web.ResponseWriter, r *web.Request) {
+	_, rendered, status, message := c.renderEmailTemplatePreview(r)
+	if rendered == nil {
+		c.ServeJson(status, message)
+		return
+	}
+
+	c.ServeJson(http.StatusOK, rendered)
+}
+
+// SendTestEmailTemplate sends the rendered template right away to the given address, or to the admin.
+func (c *AdminContext) SendTestEmailTemplate(w web.ResponseWriter, r *web.Request) {
+	preview, rendered, status, message := c.renderEmailTemplatePreview(r)
+	if rendered == nil {
+		c.ServeJson(status, message)
+		return
+	}
+
+	to := preview.To
+	if to == "" {
+		to = c.User.Email
+	}
+
+	err := emailer.SendMessage(c.Context, &emailer.Message{
+		From:    fmt.Sprintf("%s<%s>", c.Settings.CompanyName, c.Settings.EmailSender),
+		To:      emailer.SplitAddresses(to),
+		Subject: "[Test] " + rendered.Subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+	})
+
+	if err != nil {
+		c.ServeJson(http.StatusBadGateway, fmt.Sprintf("Could not send test email: %s", err))
+		return
+	}
+
+	c.ServeJson(http.StatusOK, rendered)
+}
+
+func (c *AdminContext) renderEmailTemplatePreview(r *web.Request) (*EmailTemplatePreview, *emailer.Rendered, int, string) {
+	name := r.PathParams["name"]
+	preview := &EmailTemplatePreview{}
+	err := c.ParseJsonRequest(preview)
+	if err != nil && err != io.EOF {
+		log.Errorf(c.Context, "Error parsing preview request: %+v", err)
+		return nil, nil, http.StatusBadRequest, "Could not read preview request."
+	}
+
+	order := SampleOrder()
+	if preview.OrderId != 0 {
+		order, err = entities.GetOrder(c.Context, preview.OrderId)
+		if err != nil {
+			log.Errorf(c.Context, "Error getting order[%d] for preview: %+v", preview.OrderId, err)
+			return nil, nil, http.StatusNotFound, "Order not found."
+		}
+	}
+
+	data := c.EmailTemplateData(r, order)
+	var rendered *emailer.Rendered
+	if preview.Subject != "" || preview.HTML != "" || preview.Text != "" {
+		t, err := emailer.ParseTemplate(name, preview.Subject, preview.HTML, preview.Text)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, fmt.Sprintf("Invalid template: %s", err)
+		}
+
+		rendered, err = t.Render(data)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, fmt.Sprintf("Could not render template: %s", err)
+		}
+	} else {
+		rendered, err = emailer.RenderTemplate(c.Context, name, data)
+		if err == emailer.ErrTemplateNotFound {
+			return nil, nil, http.StatusNotFound, "Email template not found."
+		} else if err != nil {
+			return nil, nil, http.StatusBadRequest, fmt.Sprintf("Could not render template: %s", err)
+		}
+	}
+
+	return preview, rendered, http.StatusOK, ""
+}
+
+// SampleOrder is a made up order used to preview email templates.
+func SampleOrder() *entities.Order {
+	product := &entities.Product{
+		Id:         1,
+		Name:       "Sample Product",
+		Path:       "sample-product",
+		Active:     true,
+		PriceCents: 2500,
+	}
+
+	return &entities.Order{
+		Id:             1234567890,
+		ShippingName:   "Jane Doe",
+		ShippingLine1:  "123 Main St",
+		City:           "Miami",
+		State:          "FL",
+		PostalCode:     "33101",
+		CountryCode:    "US",
+		Email:          "jane.doe@example.com",
+		Phone:          "555-555-5555",
+		ProductIds:     []int64{product.Id},
+		Quantities:     []int64{2},
+		Status:         entities.OrderStatusPending,
+		CheckoutStep:   "payment",
+		Created:        time.Now(),
+		Products:       []*entities.Product{product},
+		ProductDetails: []*entities.ProductDetails{{ProductId: product.Id}},
+		TaxPercent:     7,
+	}
+}
@@ -0,0 +1,126 @@
+package km
+
+import (
+	"bytes"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/log"
+	"html/template"
+	"net/http"
+)
+
+var mailboxTemplate = template.Must(template.New("mailbox").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Mailbox</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+nav { width: 360px; overflow-y: auto; border-right: 1px solid #ddd; }
+nav a { display: block; padding: 8px 12px; border-bottom: 1px solid #eee; color: #222; text-decoration: none; }
+nav a.selected { background: #eef; }
+nav small { color: #777; display: block; }
+main { flex: 1; display: flex; flex-direction: column; }
+header { padding: 12px; border-bottom: 1px solid #ddd; }
+iframe, pre { flex: 1; border: 0; margin: 0; padding: 12px; overflow: auto; }
+</style>
+</head>
+<body>
+<nav>
+{{range .Entries}}<a href="/admin/km/mailbox?id={{.Id}}"{{if $.Selected}}{{if eq .Id $.Selected.Id}} class="selected"{{end}}{{end}}>{{.Message.Subject}}<small>{{range .Message.To}}{{.}} {{end}}- {{.Date.Format "Jan 2 15:04:05"}}</small></a>
+{{else}}<p style="padding: 12px">No emails captured yet.</p>{{end}}
+</nav>
+<main>
+{{with .Selected}}<header>
+<div><b>{{.Message.Subject}}</b></div>
+<div>From: {{.Message.From}}</div>
+<div>To: {{range .Message.To}}{{.}} {{end}}</div>
+{{if .Message.Cc}}<div>Cc: {{range .Message.Cc}}{{.}} {{end}}</div>{{end}}
+{{if .Message.Bcc}}<div>Bcc: {{range .Message.Bcc}}{{.}} {{end}}</div>{{end}}
+{{if .Message.ReplyTo}}<div>Reply-To: {{.Message.ReplyTo}}</div>{{end}}
+{{range $name, $value := .Message.Headers}}<div>{{$name}}: {{$value}}</div>{{end}}
+{{range .Message.Attachments}}<div>Attachment: {{.Filename}} ({{.ContentType}})</div>{{end}}
+</header>
+{{if .Message.HTML}}<iframe sandbox src="/admin/km/mailbox/{{.Id}}/html"></iframe>{{end}}
+{{if .Message.Text}}<pre>{{.Message.Text}}</pre>{{end}}
+{{end}}
+</main>
+</body>
+</html>`))
+
+func (c *AdminContext) currentMailbox() (*emailer.Mailbox, bool) {
+	mailbox, ok := emailer.CurrentSender(c.Settings).(*emailer.Mailbox)
+	return mailbox, ok
+}
+
+// GetMailbox renders a small viewer with every email captured by the mailbox backend.
+func (c *AdminContext) GetMailbox(w web.ResponseWriter, r *web.Request) {
+	mailbox, ok := c.currentMailbox()
+	if !ok {
+		c.ServeJson(http.StatusNotFound, "The mailbox email backend is not enabled.")
+		return
+	}
+
+	entries, err := mailbox.List()
+	if err != nil {
+		log.Errorf(c.Context, "Error listing mailbox: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error listing mailbox.")
+		return
+	}
+
+	page := struct {
+		Entries  []*emailer.MailboxEntry
+		Selected *emailer.MailboxEntry
+	}{Entries: entries}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		page.Selected, _ = mailbox.Get(id)
+	} else if len(entries) > 0 {
+		page.Selected = entries[0]
+	}
+
+	var doc bytes.Buffer
+	err = mailboxTemplate.Execute(&doc, page)
+	if err != nil {
+		log.Errorf(c.Context, "Error rendering mailbox: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error rendering mailbox.")
+		return
+	}
+
+	c.ServeHTML(http.StatusOK, doc.String())
+}
+
+// GetMailboxHTML serves the html body of a captured email so the viewer can show it in a sandboxed frame.
+func (c *AdminContext) GetMailboxHTML(w web.ResponseWriter, r *web.Request) {
+	mailbox, ok := c.currentMailbox()
+	if !ok {
+		c.ServeJson(http.StatusNotFound, "The mailbox email backend is not enabled.")
+		return
+	}
+
+	entry, err := mailbox.Get(r.PathParams["mailId"])
+	if err != nil {
+		c.ServeJson(http.StatusNotFound, "Mail not found.")
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	c.ServeHTML(http.StatusOK, entry.Message.HTML)
+}
+
+func (c *AdminContext) ClearMailbox(w web.ResponseWriter, r *web.Request) {
+	mailbox, ok := c.currentMailbox()
+	if !ok {
+		c.ServeJson(http.StatusNotFound, "The mailbox email backend is not enabled.")
+		return
+	}
+
+	err := mailbox.Clear()
+	if err != nil {
+		log.Errorf(c.Context, "Error clearing mailbox: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error clearing mailbox.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, "")
+}
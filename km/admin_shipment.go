@@ -0,0 +1,145 @@
+package km
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/notifications"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultCarrierTrackingUrlsFile = "carrier-tracking-urls.json"
+
+// CarrierTrackingUrlsFile adds carrier tracking urls to entities.CarrierTrackingUrls, or replaces
+// them: a JSON object from carrier to url template, such as
+// {"ontrac": "https://www.ontrac.com/tracking/?number={tracking_number}"}. Relative paths are read
+// from the working directory, the app root the views are read from.
+var CarrierTrackingUrlsFile = os.Getenv("CARRIER_TRACKING_URLS_FILE")
+
+func init() {
+	path := CarrierTrackingUrlsFile
+	if path == "" {
+		path = defaultCarrierTrackingUrlsFile
+	}
+
+	count, err := loadCarrierTrackingUrls(path)
+	switch {
+	case os.IsNotExist(err) && CarrierTrackingUrlsFile == "":
+		log.Debugf(context.Background(), "No %s, using the built in carrier tracking urls", path)
+	case err != nil:
+		log.Errorf(context.Background(), "Error loading carrier tracking urls from %s: %+v", path, err)
+	default:
+		log.Infof(context.Background(), "Loaded %d carrier tracking urls from %s", count, path)
+	}
+}
+
+// loadCarrierTrackingUrls reads a file of carrier tracking urls into entities.CarrierTrackingUrls
+// and returns how many it had.
+func loadCarrierTrackingUrls(path string) (int, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	trackingUrls := map[string]string{}
+	err = json.Unmarshal(raw, &trackingUrls)
+	if err != nil {
+		return 0, err
+	}
+
+	for carrier, urlTemplate := range trackingUrls {
+		entities.CarrierTrackingUrls[strings.ToLower(strings.TrimSpace(carrier))] = urlTemplate
+	}
+
+	return len(trackingUrls), nil
+}
+
+func (c *AdminContext) GetShipments(w web.ResponseWriter, r *web.Request) {
+	orderId, err := strconv.ParseInt(r.PathParams["orderId"], 10, 64)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, "Invalid order id.")
+		return
+	}
+
+	shipments, err := entities.ListShipments(c.Context, orderId)
+	if err != nil {
+		log.Errorf(c.Context, "Error listing shipments of order[%v]: %+v", orderId, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting shipments.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, shipments)
+}
+
+func (c *AdminContext) CreateShipment(w web.ResponseWriter, r *web.Request) {
+	c.saveShipment(r, 0)
+}
+
+func (c *AdminContext) UpdateShipment(w web.ResponseWriter, r *web.Request) {
+	shipmentId, err := strconv.ParseInt(r.PathParams["shipmentId"], 10, 64)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, "Invalid shipment id.")
+		return
+	}
+
+	c.saveShipment(r, shipmentId)
+}
+
+// saveShipment creates the shipment when shipmentId is 0 and updates it otherwise, then moves the
+// order to shipped or processed depending on how much of it the shipments carry.
+func (c *AdminContext) saveShipment(r *web.Request, shipmentId int64) {
+	orderId, err := strconv.ParseInt(r.PathParams["orderId"], 10, 64)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, "Invalid order id.")
+		return
+	}
+
+	shipment := &entities.Shipment{}
+	err = c.ParseJsonRequest(shipment)
+	if err != nil {
+		log.Errorf(c.Context, "Error parsing shipment: %+v", err)
+		c.ServeJson(http.StatusBadRequest, "Could not read shipment.")
+		return
+	}
+
+	shipment.Id = shipmentId
+	shipment.OrderId = orderId
+	if shipment.TrackingUrl == "" {
+		shipment.TrackingUrl = entities.CarrierTrackingUrl(shipment.Carrier, shipment.TrackingNumber)
+	}
+
+	order, previousStatus, err := c.store.SaveShipment(c.Context, shipment)
+	switch err {
+	case nil:
+	case datastore.ErrNoSuchEntity:
+		c.ServeJson(http.StatusNotFound, "Order not found.")
+		return
+	case entities.ErrShipmentNotFound:
+		c.ServeJson(http.StatusNotFound, err.Error())
+		return
+	case entities.ErrShipmentEmpty, entities.ErrShipmentInvalidLine, entities.ErrShipmentOverQuantity, entities.ErrShipmentInvalidAmount:
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	default:
+		log.Errorf(c.Context, "Error saving shipment of order[%v]: %+v", orderId, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error saving shipment.")
+		return
+	}
+
+	// the customer hears about the first package, the rest of the shipments show up on the order page
+	if order.Status != previousStatus && previousStatus != entities.OrderStatusShipped {
+		_, err = notifications.NotifyOrderEvent(c.Context, entities.OrderEventShipped, c.EmailTemplateData(r, order))
+		if err != nil {
+			log.Errorf(c.Context, "Couldn't notify shipment of order[%v]: %+v", orderId, err)
+		}
+	}
+
+	c.ServeJson(http.StatusOK, shipment)
+}
@@ -0,0 +1,102 @@
+package km
+
+import (
+	"context"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCarrierTrackingUrls(t *testing.T) {
+	original := entities.CarrierTrackingUrls
+	entities.CarrierTrackingUrls = map[string]string{"ups": "https://ups.com/{tracking_number}"}
+	t.Cleanup(func() {
+		entities.CarrierTrackingUrls = original
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "urls.json")
+	err := ioutil.WriteFile(path, []byte(`{" OnTrac ": "https://ontrac.com/{tracking_number}", "UPS": "https://ups.com/track/{tracking_number}"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := loadCarrierTrackingUrls(path)
+	if err != nil || count != 2 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+
+	if entities.CarrierTrackingUrl("ontrac", "1") != "https://ontrac.com/1" || entities.CarrierTrackingUrl("ups", "1") != "https://ups.com/track/1" {
+		t.Errorf("got %v", entities.CarrierTrackingUrls)
+	}
+
+	_, err = loadCarrierTrackingUrls(filepath.Join(dir, "missing.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("got %v for a missing file", err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(`["ups"]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadCarrierTrackingUrls(path)
+	if err == nil {
+		t.Error("an invalid file loaded")
+	}
+}
+
+func TestSaveShipmentResponses(t *testing.T) {
+	var saveErr error
+	var saved *entities.Shipment
+	store := &fakeStore{saveShipment: func(ctx context.Context, shipment *entities.Shipment) (*entities.Order, string, error) {
+		saved = shipment
+		if saveErr != nil {
+			return nil, "", saveErr
+		}
+
+		return &entities.Order{Id: shipment.OrderId, Status: entities.OrderStatusShipped}, entities.OrderStatusShipped, nil
+	}}
+
+	router := web.New(ServerContext{}).Middleware((*ServerContext).initTestContext).Middleware(withStore(store))
+	admin := router.Subrouter(AdminContext{}, "/km")
+	admin.Post("/order/:orderId/shipment", (*AdminContext).CreateShipment)
+	admin.Put("/order/:orderId/shipment/:shipmentId", (*AdminContext).UpdateShipment)
+	send := func(method string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"carrier": "ups", "tracking_number": "1Z", "items": [{"line": 0, "quantity": 1}]}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send("PUT", "/km/order/7/shipment/3")
+	if w.Code != http.StatusOK || saved.Id != 3 || saved.OrderId != 7 {
+		t.Fatalf("got %d and %+v", w.Code, saved)
+	}
+
+	if saved.TrackingUrl != entities.CarrierTrackingUrl("ups", "1Z") {
+		t.Errorf("got tracking url %q", saved.TrackingUrl)
+	}
+
+	tests := map[error]int{
+		datastore.ErrNoSuchEntity:        http.StatusNotFound,
+		entities.ErrShipmentNotFound:     http.StatusNotFound,
+		entities.ErrShipmentOverQuantity: http.StatusBadRequest,
+		context.DeadlineExceeded:         http.StatusInternalServerError,
+	}
+
+	for err, want := range tests {
+		saveErr = err
+		if w := send("POST", "/km/order/7/shipment"); w.Code != want {
+			t.Errorf("%v: got %d, want %d", err, w.Code, want)
+		}
+	}
+}
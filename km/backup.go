@@ -0,0 +1,71 @@
+package km
+
+import (
+	"fmt"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/backup"
+	"github.com/jcarm010/kodimerce/log"
+	"io"
+	"net/http"
+	"time"
+)
+
+// GetBackup streams a backup of every entity, see backup.Export. secrets=false leaves password
+// hashes and api keys out of it.
+func (c *AdminContext) GetBackup(w web.ResponseWriter, r *web.Request) {
+	options := backup.ExportOptions{ExcludeSecrets: r.URL.Query().Get("secrets") == "false"}
+	filename := fmt.Sprintf("backup-%s.jsonl", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	result, err := backup.Export(c.Context, w, options)
+	if err != nil {
+		// the backup is cut short, the status went out with the first line
+		log.Errorf(c.Context, "Error exporting backup: %+v", err)
+		return
+	}
+
+	log.Infof(c.Context, "Exported backup: %+v", result.Entities)
+}
+
+// PostBackup restores the backup in the file form value, or in the body when there is no form.
+// conflict picks what happens to entities that exist already: skip, overwrite or remap.
+func (c *AdminContext) PostBackup(w web.ResponseWriter, r *web.Request) {
+	conflict := r.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = backup.ConflictSkip
+	}
+
+	var body io.Reader = r.Body
+	err := r.ParseMultipartForm(32 << 20 /*32 MB*/)
+	if err == nil {
+		if value := r.FormValue("conflict"); value != "" {
+			conflict = value
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			c.ServeJson(http.StatusBadRequest, "Missing backup file.")
+			return
+		}
+
+		defer func() {
+			_ = file.Close()
+		}()
+
+		body = file
+	}
+
+	if !backup.ValidConflict(conflict) {
+		c.ServeJson(http.StatusBadRequest, "Invalid conflict, expected skip, overwrite or remap.")
+		return
+	}
+
+	result, err := backup.Import(c.Context, body, backup.ImportOptions{Conflict: conflict})
+	if err != nil {
+		log.Errorf(c.Context, "Error importing backup: %+v", err)
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.ServeJson(http.StatusOK, result)
+}
@@ -0,0 +1,133 @@
+package km
+
+import (
+	"fmt"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/catalog"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/settings"
+	"net/http"
+	"time"
+)
+
+// FeedMaxAge is how long the catalog feeds are cached, Google and Facebook fetch them often and
+// each fetch reads every product.
+var FeedMaxAge = time.Hour
+
+// ExportProducts downloads every product as a CSV file, see catalog.Columns.
+func (c *AdminContext) ExportProducts(w web.ResponseWriter, r *web.Request) {
+	products, err := catalog.Load(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error loading products: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting products.")
+		return
+	}
+
+	filename := fmt.Sprintf("products-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	err = catalog.Export(w, products)
+	if err != nil {
+		log.Errorf(c.Context, "Error exporting products: %+v", err)
+	}
+}
+
+// ImportProducts creates and updates products from the CSV file in the file form value, matching
+// them by path. With dry_run=true it only returns what the import would change.
+func (c *AdminContext) ImportProducts(w web.ResponseWriter, r *web.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, "Missing products file.")
+		return
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	csvFile, err := catalog.ReadCSV(file)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	products, err := catalog.Load(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error loading products: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting products.")
+		return
+	}
+
+	plan := catalog.NewPlan(products, csvFile)
+	if len(plan.Errors) > 0 {
+		c.ServeJson(http.StatusUnprocessableEntity, plan)
+		return
+	}
+
+	if r.FormValue("dry_run") == "true" {
+		c.ServeJson(http.StatusOK, plan)
+		return
+	}
+
+	err = catalog.Apply(c.Context, products, plan)
+	if err != nil {
+		log.Errorf(c.Context, "Error importing products: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error importing products, some of them may have been imported.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, plan)
+}
+
+// GetGoogleMerchantFeed lists the active products in a Google Merchant Center feed.
+func (c *ServerContext) GetGoogleMerchantFeed(w web.ResponseWriter, r *web.Request) {
+	items, ok := c.feedItems(r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	setFeedCacheControl(w)
+	err := catalog.WriteGoogleMerchant(w, c.Settings.CompanyName, c.feedHostRoot(r), items)
+	if err != nil {
+		log.Errorf(c.Context, "Error writing google merchant feed: %+v", err)
+	}
+}
+
+// GetFacebookCatalogFeed lists the active products in a Facebook catalog feed.
+func (c *ServerContext) GetFacebookCatalogFeed(w web.ResponseWriter, r *web.Request) {
+	items, ok := c.feedItems(r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	setFeedCacheControl(w)
+	err := catalog.WriteFacebookCatalog(w, items)
+	if err != nil {
+		log.Errorf(c.Context, "Error writing facebook catalog feed: %+v", err)
+	}
+}
+
+func (c *ServerContext) feedItems(r *web.Request) ([]*catalog.FeedItem, bool) {
+	products, err := catalog.Load(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error loading products: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting products.")
+		return nil, false
+	}
+
+	return catalog.FeedItems(products, c.feedHostRoot(r), c.Settings.CompanyName), true
+}
+
+func setFeedCacheControl(w web.ResponseWriter) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(FeedMaxAge.Seconds())))
+}
+
+func (c *ServerContext) feedHostRoot(r *web.Request) string {
+	if c.Settings.CompanyUrl != "" {
+		return c.Settings.CompanyUrl
+	}
+
+	return settings.ServerUrl(r.Request)
+}
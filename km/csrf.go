@@ -0,0 +1,140 @@
+package km
+
+import (
+	"bytes"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/csrf"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxCSRFFormSize is the largest url encoded body read looking for the form token. Bigger forms
+// and multipart bodies have to send the token in the X-CSRF-Token header.
+const maxCSRFFormSize = 64 << 10
+
+var (
+	// CSRFExemptPrefixes lists path prefixes that are called by third parties, such as the
+	// scheduler and webhooks, and can not carry a token. They have to authenticate the caller
+	// themselves, as AuthorizeCron does. Add the paths of webhooks here when they are routed.
+	CSRFExemptPrefixes = []string{"/cron/"}
+)
+
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// CSRF makes sure every request has a token cookie and rejects state changing requests
+// whose submitted token does not match the cookie and the user's session.
+func (c *ServerContext) CSRF(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	token := ""
+	if cookie, err := r.Cookie(csrf.CookieName); err == nil {
+		token = cookie.Value
+	}
+
+	checked := !isSafeMethod(r.Method) && !isCSRFExempt(r.URL.Path)
+
+	// Only mutations and fresh cookies need the session, avoid the lookup otherwise.
+	var session *entities.UserSession
+	if sessionCookie, err := r.Cookie("km-session"); err == nil && sessionCookie.Value != "" && (token == "" || checked) {
+		session, err = c.store.GetUserSession(c.Context, sessionCookie.Value, c.Settings.SessionTTL())
+		if err != nil {
+			log.Debugf(c.Context, "Could not load session for csrf check: %+v", err)
+			session = nil
+		}
+	}
+
+	if token == "" {
+		token = csrf.NewToken()
+		if session != nil && session.CSRFToken != "" {
+			token = session.CSRFToken
+		}
+
+		SetCSRFCookie(w, r.Request, token)
+	}
+
+	c.Context = csrf.WithToken(c.Context, token)
+	r.Request = r.Request.WithContext(c.Context)
+	if !checked {
+		next(w, r)
+		return
+	}
+
+	submitted := r.Header.Get(csrf.HeaderName)
+	if submitted == "" {
+		submitted = formCSRFToken(r.Request)
+	}
+
+	if !csrf.Equal(submitted, token) {
+		log.Warningf(c.Context, "CSRF token mismatch for %s %s", r.Method, r.URL.Path)
+		c.ServeJson(http.StatusForbidden, ErrorResponse{Error: "csrf_token_invalid", Message: "Your session has expired. Please reload the page and try again."})
+		return
+	}
+
+	if session != nil && session.CSRFToken != "" && !csrf.Equal(submitted, session.CSRFToken) {
+		log.Warningf(c.Context, "CSRF token does not belong to session for %s %s", r.Method, r.URL.Path)
+		c.ServeJson(http.StatusForbidden, ErrorResponse{Error: "csrf_token_invalid", Message: "Your session has expired. Please reload the page and try again."})
+		return
+	}
+
+	next(w, r)
+}
+
+// formCSRFToken reads the token from a url encoded body of up to maxCSRFFormSize bytes. The body
+// is put back for the handler to read.
+func formCSRFToken(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" || r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCSRFFormSize+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	if err != nil || len(body) > maxCSRFFormSize {
+		return ""
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+
+	return values.Get(csrf.FormField)
+}
+
+// SetCSRFCookie stores the token in a cookie readable by scripts so they can echo it
+// back in the X-CSRF-Token header. The cookie is only sent over https when r came over it.
+func SetCSRFCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrf.CookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: false,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isCSRFExempt(path string) bool {
+	for _, prefix := range CSRFExemptPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+package km
+
+import (
+	"crypto/tls"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/csrf"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCSRFRouter(sessions map[string]*entities.UserSession) (*web.Router, *string) {
+	store := &fakeStore{getUserSession: func(ctx context.Context, sessionToken string, ttl time.Duration) (*entities.UserSession, error) {
+		session, exists := sessions[sessionToken]
+		if !exists {
+			return nil, entities.ErrSessionExpired
+		}
+
+		return session, nil
+	}}
+
+	received := new(string)
+	handler := func(c *ServerContext, w web.ResponseWriter, r *web.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		*received = string(body)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := web.New(ServerContext{}).
+		Middleware((*ServerContext).initTestContext).
+		Middleware(withStore(store)).
+		Middleware((*ServerContext).CSRF).
+		Get("/contact", handler).
+		Post("/contact", handler).
+		Post("/order", handler).
+		Post("/cron/:job", handler)
+
+	return router, received
+}
+
+type csrfRequest struct {
+	method      string
+	path        string
+	cookie      string
+	session     string
+	header      string
+	form        url.Values
+	contentType string
+}
+
+func (c csrfRequest) serve(router *web.Router) *httptest.ResponseRecorder {
+	body := ""
+	if c.form != nil {
+		body = c.form.Encode()
+	}
+
+	r := httptest.NewRequest(c.method, c.path, strings.NewReader(body))
+	if c.contentType != "" {
+		r.Header.Set("Content-Type", c.contentType)
+	} else if c.form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if c.cookie != "" {
+		r.AddCookie(&http.Cookie{Name: csrf.CookieName, Value: c.cookie})
+	}
+
+	if c.session != "" {
+		r.AddCookie(&http.Cookie{Name: "km-session", Value: c.session})
+	}
+
+	if c.header != "" {
+		r.Header.Set(csrf.HeaderName, c.header)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestCSRF(t *testing.T) {
+	sessions := map[string]*entities.UserSession{
+		"session": {SessionToken: "session", CSRFToken: "session-token"},
+	}
+
+	tests := []struct {
+		name    string
+		request csrfRequest
+		want    int
+	}{
+		{"safe method", csrfRequest{method: "GET", path: "/contact"}, http.StatusOK},
+		{"missing token", csrfRequest{method: "POST", path: "/contact", cookie: "token"}, http.StatusForbidden},
+		{"missing cookie", csrfRequest{method: "POST", path: "/contact", header: "token"}, http.StatusForbidden},
+		{"header token", csrfRequest{method: "POST", path: "/contact", cookie: "token", header: "token"}, http.StatusOK},
+		{"wrong header token", csrfRequest{method: "POST", path: "/contact", cookie: "token", header: "other"}, http.StatusForbidden},
+		{"form token", csrfRequest{method: "POST", path: "/contact", cookie: "token", form: url.Values{csrf.FormField: {"token"}}}, http.StatusOK},
+		{"query token", csrfRequest{method: "POST", path: "/contact?csrf_token=token", cookie: "token"}, http.StatusForbidden},
+		{"multipart form", csrfRequest{method: "POST", path: "/contact", cookie: "token", form: url.Values{csrf.FormField: {"token"}}, contentType: "multipart/form-data; boundary=x"}, http.StatusForbidden},
+		{"oversized form", csrfRequest{method: "POST", path: "/contact", cookie: "token", form: url.Values{csrf.FormField: {"token"}, "message": {strings.Repeat("a", maxCSRFFormSize)}}}, http.StatusForbidden},
+		{"session token", csrfRequest{method: "POST", path: "/order", cookie: "session-token", session: "session", header: "session-token"}, http.StatusOK},
+		{"token of another session", csrfRequest{method: "POST", path: "/order", cookie: "token", session: "session", header: "token"}, http.StatusForbidden},
+		{"expired session", csrfRequest{method: "POST", path: "/order", cookie: "token", session: "expired", header: "token"}, http.StatusOK},
+		{"exempt path", csrfRequest{method: "POST", path: "/cron/reconcile-orders"}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		router, _ := newCSRFRouter(sessions)
+		if w := test.request.serve(router); w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
+
+func TestCSRFKeepsFormBody(t *testing.T) {
+	router, received := newCSRFRouter(nil)
+	form := url.Values{csrf.FormField: {"token"}, "message": {"hello"}}
+	w := csrfRequest{method: "POST", path: "/contact", cookie: "token", form: form}.serve(router)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+
+	if *received != form.Encode() {
+		t.Errorf("handler read %q, want %q", *received, form.Encode())
+	}
+}
+
+func TestCSRFCookie(t *testing.T) {
+	sessions := map[string]*entities.UserSession{
+		"session": {SessionToken: "session", CSRFToken: "session-token"},
+	}
+
+	router, _ := newCSRFRouter(sessions)
+	w := csrfRequest{method: "GET", path: "/contact", session: "session"}.serve(router)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "session-token" {
+		t.Fatalf("got cookies %v, want the token of the session", cookies)
+	}
+
+	if cookies[0].Secure {
+		t.Error("cookie set over http is secure")
+	}
+
+	r := httptest.NewRequest("GET", "https://example.com/contact", nil)
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	cookies = w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("got cookies %v, want a secure cookie over https", cookies)
+	}
+}
@@ -0,0 +1,124 @@
+package km
+
+import (
+	"crypto/subtle"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/jobs"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/settings"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// JobStatus is a registered job along with the state of its lock.
+type JobStatus struct {
+	*jobs.Job
+	Lock *entities.JobLock `json:"lock"`
+}
+
+// AuthorizeCron lets through App Engine cron requests and requests holding the configured cron token.
+// Without a token, requests only get through on App Engine.
+func (c *ServerContext) AuthorizeCron(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	// App Engine strips this header from requests that don't come from its cron service, nothing does
+	// when the server runs on its own
+	if onAppEngine() && r.Header.Get("X-Appengine-Cron") == "true" {
+		next(w, r)
+		return
+	}
+
+	token := c.Settings.CronToken
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		c.ServeJson(http.StatusUnauthorized, "")
+		return
+	}
+
+	next(w, r)
+}
+
+// onAppEngine tells whether the server runs on App Engine, which sets these variables.
+func onAppEngine() bool {
+	return os.Getenv("GAE_ENV") != "" || os.Getenv("GAE_APPLICATION") != ""
+}
+
+func (c *ServerContext) RunCronJob(w web.ResponseWriter, r *web.Request) {
+	c.runJob(r, jobs.TriggerCron)
+}
+
+func (c *ServerContext) runJob(r *web.Request, trigger string) {
+	name := r.PathParams["job"]
+	hostRoot := c.Settings.CompanyUrl
+	if hostRoot == "" {
+		hostRoot = settings.ServerUrl(r.Request)
+	}
+
+	run, err := jobs.Run(jobs.WithHostRoot(c.Context, hostRoot), name, trigger)
+	switch {
+	case err == jobs.ErrJobNotFound:
+		c.ServeJson(http.StatusNotFound, err.Error())
+	case err == entities.ErrJobLocked:
+		c.ServeJson(http.StatusConflict, err.Error())
+	case run != nil:
+		status := http.StatusOK
+		if run.Status == entities.JobRunStatusFailed {
+			status = http.StatusInternalServerError
+		}
+
+		c.ServeJson(status, run)
+	case err != nil:
+		log.Errorf(c.Context, "Error running job %s: %+v", name, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error running job.")
+	}
+}
+
+func (c *AdminContext) GetJobs(w web.ResponseWriter, r *web.Request) {
+	locks, err := entities.ListJobLocks(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error listing job locks: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting jobs.")
+		return
+	}
+
+	statuses := make([]*JobStatus, 0)
+	for _, job := range jobs.List() {
+		statuses = append(statuses, &JobStatus{Job: job, Lock: locks[job.Name]})
+	}
+
+	c.ServeJson(http.StatusOK, statuses)
+}
+
+func (c *AdminContext) GetJobRuns(w web.ResponseWriter, r *web.Request) {
+	name := r.PathParams["job"]
+	if _, exists := jobs.Get(name); !exists {
+		c.ServeJson(http.StatusNotFound, jobs.ErrJobNotFound.Error())
+		return
+	}
+
+	limit := 20
+	if r.URL.Query().Get("limit") != "" {
+		parsed, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || parsed <= 0 {
+			c.ServeJson(http.StatusBadRequest, "Invalid limit.")
+			return
+		}
+
+		limit = parsed
+	}
+
+	runs, err := entities.ListJobRuns(c.Context, name, limit)
+	if err != nil {
+		log.Errorf(c.Context, "Error listing runs of job %s: %+v", name, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting job runs.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, runs)
+}
+
+// RunJob runs a job right away, unless it is running already.
+func (c *AdminContext) RunJob(w web.ResponseWriter, r *web.Request) {
+	c.runJob(r, jobs.TriggerAdmin)
+}
@@ -0,0 +1,61 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizeCron(t *testing.T) {
+	tests := []struct {
+		name      string
+		appEngine bool
+		token     string
+		headers   map[string]string
+		want      int
+	}{
+		{"app engine cron", true, "", map[string]string{"X-Appengine-Cron": "true"}, http.StatusOK},
+		{"cron header off app engine", false, "", map[string]string{"X-Appengine-Cron": "true"}, http.StatusUnauthorized},
+		{"cron header off app engine with a token", false, "secret", map[string]string{"X-Appengine-Cron": "true"}, http.StatusUnauthorized},
+		{"token", false, "secret", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"wrong token", false, "secret", map[string]string{"Authorization": "Bearer other"}, http.StatusUnauthorized},
+		{"no token configured", false, "", map[string]string{"Authorization": "Bearer "}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GAE_ENV", "")
+			t.Setenv("GAE_APPLICATION", "")
+			if test.appEngine {
+				t.Setenv("GAE_ENV", "standard")
+			}
+
+			token := test.token
+			router := web.New(ServerContext{}).
+				Middleware((*ServerContext).initTestContext).
+				Middleware(func(c *ServerContext, w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+					c.Settings = entities.ServerSettings{CronToken: token}
+					next(w, r)
+				})
+
+			router.Subrouter(ServerContext{}, "/cron").
+				Middleware((*ServerContext).AuthorizeCron).
+				Get("/:job", func(c *ServerContext, w web.ResponseWriter, r *web.Request) {
+					w.WriteHeader(http.StatusOK)
+				})
+
+			req := httptest.NewRequest(http.MethodGet, "/cron/migrate", nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != test.want {
+				t.Errorf("got %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package km
+
+import (
+	"fmt"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/mediaarchive"
+	"github.com/jcarm010/kodimerce/storage"
+	"github.com/jcarm010/kodimerce/uploads"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MediaExport is an archive stored by the export-media job.
+type MediaExport struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// ExportGalleryUploads streams a zip archive of every upload with a manifest of their records,
+// see mediaarchive.Export. With background=true the next run of the export-media job stores the
+// archive instead, and archive=<name> downloads one of the stored archives.
+func (c *AdminContext) ExportGalleryUploads(w web.ResponseWriter, r *web.Request) {
+	q := r.URL.Query()
+	if archive := q.Get("archive"); archive != "" {
+		c.serveMediaExport(w, r, archive)
+		return
+	}
+
+	if q.Get("background") == "true" {
+		err := mediaarchive.RequestExport(c.Context)
+		if err != nil {
+			log.Errorf(c.Context, "Error requesting export: %+v", err)
+			c.ServeJson(http.StatusInternalServerError, "Unexpected error requesting the export.")
+			return
+		}
+
+		c.ServeJson(http.StatusAccepted, map[string]string{"job": "export-media"})
+		return
+	}
+
+	filename := fmt.Sprintf("media-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	result, err := mediaarchive.Export(c.Context, w)
+	if err != nil {
+		// the archive is cut short, the status went out with the first file
+		log.Errorf(c.Context, "Error exporting uploads: %+v", err)
+		return
+	}
+
+	log.Infof(c.Context, "Exported %d uploads, %d missing", result.Uploads, len(result.Missing))
+}
+
+func (c *AdminContext) serveMediaExport(w web.ResponseWriter, r *web.Request, archive string) {
+	if strings.Contains(archive, "/") || !strings.HasSuffix(archive, ".zip") {
+		c.ServeJson(http.StatusBadRequest, "Invalid archive.")
+		return
+	}
+
+	rs, attrs, err := storage.NewObjectReadSeeker(c.Context, mediaarchive.ExportsPrefix+archive)
+	if err == storage.ErrObjectNotExist {
+		c.ServeJson(http.StatusNotFound, "Archive not found.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error getting archive %s: %+v", archive, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the archive.")
+		return
+	}
+
+	defer func() {
+		_ = rs.Close()
+	}()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", archive))
+	http.ServeContent(w, r.Request, archive, attrs.Updated, rs)
+}
+
+// GetGalleryUploadExports lists the archives stored by the export-media job.
+func (c *AdminContext) GetGalleryUploadExports(w web.ResponseWriter, r *web.Request) {
+	objects, err := storage.ListObjects(c.Context, mediaarchive.ExportsPrefix)
+	if err != nil {
+		log.Errorf(c.Context, "Error listing exports: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error listing exports.")
+		return
+	}
+
+	exports := make([]*MediaExport, 0, len(objects))
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Name, ".zip") {
+			continue
+		}
+
+		exports = append(exports, &MediaExport{
+			Name:    strings.TrimPrefix(object.Name, mediaarchive.ExportsPrefix),
+			Size:    object.Size,
+			Created: object.Created,
+		})
+	}
+
+	c.ServeJson(http.StatusOK, exports)
+}
+
+// ImportGalleryUploads imports the zip archive in the file form value, see mediaarchive.Import.
+// With background=true the archive is queued for the next run of the import-media job and the
+// response has the url its result will be at.
+func (c *AdminContext) ImportGalleryUploads(w web.ResponseWriter, r *web.Request) {
+	err := r.ParseMultipartForm(32 << 20 /*32 MB*/)
+	if err != nil || len(r.MultipartForm.File["file"]) == 0 {
+		log.Errorf(c.Context, "Error parsing form: %+v", err)
+		c.ServeJson(http.StatusBadRequest, "Could not parse archive.")
+		return
+	}
+
+	header := r.MultipartForm.File["file"][0]
+	file, err := header.Open()
+	if err != nil {
+		log.Errorf(c.Context, "Error opening archive: %+v", err)
+		c.ServeJson(http.StatusBadRequest, "Could not read archive.")
+		return
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if r.FormValue("background") == "true" {
+		id := uploads.NewKey()
+		err = mediaarchive.QueueImport(c.Context, id, file)
+		if err != nil {
+			log.Errorf(c.Context, "Error queueing archive: %+v", err)
+			c.ServeJson(http.StatusInternalServerError, "Unexpected error storing the archive.")
+			return
+		}
+
+		c.ServeJson(http.StatusAccepted, map[string]string{
+			"id":  id,
+			"url": "/admin/gallery/upload/import/" + id,
+		})
+		return
+	}
+
+	result, err := mediaarchive.Import(c.Context, file, header.Size, uploads.NewPolicy(&c.Settings))
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.ServeJson(http.StatusOK, result)
+}
+
+// GetGalleryUploadImport returns the result of a background import, 202 while it is queued.
+func (c *AdminContext) GetGalleryUploadImport(w web.ResponseWriter, r *web.Request) {
+	id := r.PathParams["id"]
+	result, err := mediaarchive.GetImportResult(c.Context, id)
+	if err == storage.ErrObjectNotExist {
+		c.ServeJson(http.StatusNotFound, "Import not found.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error getting import %s: %+v", id, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the import.")
+		return
+	}
+
+	if result == nil {
+		c.ServeJson(http.StatusAccepted, map[string]string{"id": id, "status": "queued"})
+		return
+	}
+
+	c.ServeJson(http.StatusOK, result)
+}
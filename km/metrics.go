@@ -0,0 +1,81 @@
+package km
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"github.com/jcarm010/kodimerce/settings"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsAddr is the address of an optional listener that serves only /metrics, for example ":9090".
+// When it is set the metrics are not exposed on the public router. The listener is started by
+// StartMetricsListener, which the kodimerce command does when it serves the store.
+var MetricsAddr = os.Getenv("METRICS_ADDR")
+
+// StartMetricsListener serves the metrics on MetricsAddr in the background until ctx is done. It
+// does nothing when MetricsAddr is empty.
+func StartMetricsListener(ctx context.Context) {
+	if MetricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Addr: MetricsAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf(ctx, "Metrics listener on %s stopped: %+v", MetricsAddr, err)
+		}
+	}()
+}
+
+// Metrics records the count and latency of every request by method, route pattern and status.
+// Requests that match no route are grouped together so unknown paths can't blow up the label set.
+func (c *ServerContext) Metrics(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	start := time.Now()
+	next(w, r)
+	route := r.RoutePath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	status := w.StatusCode()
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	metrics.HTTPRequests.Inc(r.Method, route, strconv.Itoa(status))
+	metrics.HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+}
+
+// ServeMetrics exposes the metrics to a scraper holding the configured bearer token.
+// The endpoint does not exist unless a token is configured and no dedicated listener is used.
+func (c *ServerContext) ServeMetrics(w web.ResponseWriter, r *web.Request) {
+	token := settings.GetGlobalSettings(c.Context).MetricsToken
+	if token == "" || MetricsAddr != "" {
+		c.ServeJson(http.StatusNotFound, "")
+		return
+	}
+
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		c.ServeJson(http.StatusUnauthorized, "")
+		return
+	}
+
+	metrics.Handler().ServeHTTP(w, r.Request)
+}
@@ -0,0 +1,76 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/jobs"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/migrations"
+	"net/http"
+	"strconv"
+)
+
+// MigrationStatus is the schema version along with the registered migrations.
+type MigrationStatus struct {
+	*entities.SchemaVersion
+	Latest     int                     `json:"latest"`
+	Migrations []*migrations.Migration `json:"migrations"`
+}
+
+func (c *AdminContext) GetMigrations(w web.ResponseWriter, r *web.Request) {
+	version, err := entities.GetSchemaVersion(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error getting schema version: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the schema version.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, &MigrationStatus{
+		SchemaVersion: version,
+		Latest:        migrations.Latest(),
+		Migrations:    migrations.List(),
+	})
+}
+
+// RunMigrations runs the migrate job with the dry_run and to form values, see migrations.Options.
+// Each request runs one batch, or the number in the batches form value, so it ends well within
+// the request deadline; the result isn't complete until the migrations are done. The migrate
+// cron job finishes what is left too.
+func (c *AdminContext) RunMigrations(w web.ResponseWriter, r *web.Request) {
+	options := migrations.Options{DryRun: r.FormValue("dry_run") == "true", MaxBatches: 1}
+	if batches := r.FormValue("batches"); batches != "" {
+		parsed, err := strconv.Atoi(batches)
+		if err != nil || parsed < 1 {
+			c.ServeJson(http.StatusBadRequest, "Invalid number of batches.")
+			return
+		}
+
+		options.MaxBatches = parsed
+	}
+
+	if to := r.FormValue("to"); to != "" {
+		parsed, err := strconv.Atoi(to)
+		if err != nil || parsed < 0 {
+			c.ServeJson(http.StatusBadRequest, migrations.ErrInvalidVersion.Error())
+			return
+		}
+
+		options.To = parsed
+	}
+
+	run, err := jobs.Run(migrations.WithOptions(c.Context, options), "migrate", jobs.TriggerAdmin)
+	switch {
+	case err == entities.ErrJobLocked:
+		c.ServeJson(http.StatusConflict, err.Error())
+	case run != nil:
+		status := http.StatusOK
+		if run.Status == entities.JobRunStatusFailed {
+			status = http.StatusInternalServerError
+		}
+
+		c.ServeJson(status, run)
+	case err != nil:
+		log.Errorf(c.Context, "Error running migrations: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error running migrations.")
+	}
+}
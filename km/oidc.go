@@ -0,0 +1,170 @@
+package km
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/oidc"
+	"github.com/jcarm010/kodimerce/settings"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// oidcStateCookie ties a login to the browser that started it, it holds the hash of the state.
+const oidcStateCookie = "km-oidc-state"
+
+func (c *ServerContext) oidcConfig(r *web.Request) *oidc.Config {
+	return &oidc.Config{
+		Issuer:       c.Settings.OIDCIssuer,
+		ClientID:     c.Settings.OIDCClientId,
+		ClientSecret: c.Settings.OIDCClientSecret,
+		RedirectURL:  settings.ServerUrl(r.Request) + "/login/oidc/callback",
+	}
+}
+
+// OIDCLogin starts an authorization code flow with PKCE against the configured issuer.
+func (c *ServerContext) OIDCLogin(w web.ResponseWriter, r *web.Request) {
+	if !c.Settings.OIDCEnabled() {
+		c.ServeHTMLError(http.StatusNotFound, "Single sign-on is not enabled.")
+		return
+	}
+
+	verifier, challenge := oidc.NewPKCE()
+	login := &entities.OIDCLogin{
+		State:        oidc.RandomString(24),
+		CodeVerifier: verifier,
+		Nonce:        oidc.RandomString(24),
+	}
+
+	err := entities.CreateOIDCLogin(c.Context, login)
+	if err != nil {
+		log.Errorf(c.Context, "Error storing oidc login: %+v", err)
+		c.ServeHTMLError(http.StatusInternalServerError, "Unexpected error, please try again later.")
+		return
+	}
+
+	authUrl, err := c.oidcConfig(r).AuthCodeURL(c.Context, login.State, login.Nonce, challenge)
+	if err != nil {
+		log.Errorf(c.Context, "Error building oidc authorization url: %+v", err)
+		c.ServeHTMLError(http.StatusBadGateway, "Could not reach the identity provider, please try again later.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    oidcStateHash(login.State),
+		Path:     "/login/oidc",
+		MaxAge:   int(entities.OIDCLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r.Request, authUrl, http.StatusFound)
+}
+
+// OIDCCallback finishes the login started by OIDCLogin and creates a session for the
+// user whose email is in the id token.
+func (c *ServerContext) OIDCCallback(w web.ResponseWriter, r *web.Request) {
+	if !c.Settings.OIDCEnabled() {
+		c.ServeHTMLError(http.StatusNotFound, "Single sign-on is not enabled.")
+		return
+	}
+
+	q := r.URL.Query()
+	if providerError := q.Get("error"); providerError != "" {
+		log.Errorf(c.Context, "Identity provider returned an error[%s]: %s", providerError, q.Get("error_description"))
+		c.ServeHTMLError(http.StatusUnauthorized, "Sign-in was not completed.")
+		return
+	}
+
+	// a state that this browser didn't start is someone else's login
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(oidcStateHash(q.Get("state")))) != 1 {
+		log.Errorf(c.Context, "OIDC state[%s] was not started by this browser", q.Get("state"))
+		c.ServeHTMLError(http.StatusBadRequest, "Your sign-in has expired, please try again.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	login, err := entities.ConsumeOIDCLogin(c.Context, q.Get("state"))
+	if err != nil {
+		log.Errorf(c.Context, "Error finding oidc login for state[%s]: %+v", q.Get("state"), err)
+		c.ServeHTMLError(http.StatusBadRequest, "Your sign-in has expired, please try again.")
+		return
+	}
+
+	if time.Since(login.Created) > entities.OIDCLoginTimeout {
+		log.Errorf(c.Context, "OIDC login expired: %+v", login)
+		c.ServeHTMLError(http.StatusBadRequest, "Your sign-in has expired, please try again.")
+		return
+	}
+
+	config := c.oidcConfig(r)
+	token, err := config.Exchange(c.Context, q.Get("code"), login.CodeVerifier)
+	if err != nil {
+		log.Errorf(c.Context, "Error exchanging oidc code: %+v", err)
+		c.ServeHTMLError(http.StatusBadGateway, "Could not complete sign-in, please try again later.")
+		return
+	}
+
+	claims, err := config.Verify(c.Context, token.IDToken, login.Nonce)
+	if err != nil {
+		log.Errorf(c.Context, "Error verifying id token: %+v", err)
+		c.ServeHTMLError(http.StatusUnauthorized, "Could not complete sign-in.")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+	if email == "" || !claims.EmailVerified {
+		log.Errorf(c.Context, "ID token has no verified email: %+v", claims)
+		c.ServeHTMLError(http.StatusForbidden, "Your account does not have a verified email.")
+		return
+	}
+
+	user, err := entities.GetUser(c.Context, email)
+	if err == datastore.ErrNoSuchEntity && c.Settings.OIDCAutoProvision {
+		log.Infof(c.Context, "Provisioning oidc user[%s] with role[%s]", email, c.Settings.OIDCDefaultRole)
+		user = entities.NewUser(email)
+		if c.Settings.OIDCDefaultRole != "" {
+			user.UserType = c.Settings.OIDCDefaultRole
+		}
+
+		err = entities.CreateUser(c.Context, user)
+	}
+
+	if err == datastore.ErrNoSuchEntity {
+		log.Errorf(c.Context, "OIDC user not found: %s", email)
+		c.ServeHTMLError(http.StatusForbidden, "Your account is not allowed to sign in.")
+		return
+	} else if err != nil {
+		log.Errorf(c.Context, "Error getting oidc user[%s]: %+v", email, err)
+		c.ServeHTMLError(http.StatusInternalServerError, "Unexpected error, please try again later.")
+		return
+	}
+
+	userSession, err := entities.CreateUserSession(c.Context, email)
+	if err != nil {
+		log.Errorf(c.Context, "Error creating user session: %+v", err)
+		c.ServeHTMLError(http.StatusInternalServerError, "Unexpected error creating session.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: "km-session", Value: userSession.SessionToken, Path: "/", HttpOnly: false})
+	SetCSRFCookie(w, r.Request, userSession.CSRFToken)
+	if user.UserType == "admin" {
+		http.Redirect(w, r.Request, "/admin", http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r.Request, "/", http.StatusFound)
+}
+
+func oidcStateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
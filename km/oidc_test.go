@@ -0,0 +1,50 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOIDCCallbackRequiresTheStateCookie(t *testing.T) {
+	router := web.New(ServerContext{}).
+		Middleware((*ServerContext).initTestContext).
+		Middleware(func(c *ServerContext, w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+			c.Settings = entities.ServerSettings{OIDCIssuer: "https://issuer.example.com", OIDCClientId: "client"}
+			next(w, r)
+		}).
+		Get("/login/oidc/callback", (*ServerContext).OIDCCallback)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"no cookie", nil},
+		{"another login's cookie", &http.Cookie{Name: oidcStateCookie, Value: oidcStateHash("other-state")}},
+		{"the state itself", &http.Cookie{Name: oidcStateCookie, Value: "stolen-state"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?state=stolen-state&code=stolen-code", nil)
+			if test.cookie != nil {
+				req.AddCookie(test.cookie)
+			}
+
+			// the login is refused before the datastore or the identity provider are reached
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestOIDCStateHash(t *testing.T) {
+	if oidcStateHash("state") != oidcStateHash("state") || oidcStateHash("state") == oidcStateHash("other") || oidcStateHash("state") == "state" {
+		t.Error("the hash should be stable, differ between states and not be the state")
+	}
+}
@@ -0,0 +1,240 @@
+package km
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/ratelimit"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	RateLimitByIP      = "ip"
+	RateLimitByEmail   = "email"
+	RateLimitBySession = "session"
+)
+
+// RateLimitRule configures the token buckets applied to a single route. A request must get
+// a token from one bucket per entry in KeyBy.
+type RateLimitRule struct {
+	Limit ratelimit.Limit `json:"limit"`
+	KeyBy []string        `json:"key_by"`
+}
+
+var (
+	// RateLimitStore holds bucket and lockout state. Replace it to share state between instances.
+	RateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+	// RateLimitRules are keyed by "METHOD /path". They can be overridden with ./rate-limits.json.
+	RateLimitRules = map[string]RateLimitRule{
+		"POST /login":                {Limit: ratelimit.PerMinute(10, 5), KeyBy: []string{RateLimitByIP, RateLimitByEmail}},
+		"POST /register":             {Limit: ratelimit.PerHour(10, 3), KeyBy: []string{RateLimitByIP}},
+		"POST /contact":              {Limit: ratelimit.PerHour(10, 3), KeyBy: []string{RateLimitByIP}},
+		"POST /order/address/verify": {Limit: ratelimit.PerMinute(5, 5), KeyBy: []string{RateLimitByIP, RateLimitBySession}},
+	}
+
+	// TrustedProxies are the load balancers and proxies in front of the app, read from the comma
+	// separated addresses and CIDR ranges in $TRUSTED_PROXIES. ClientIP only believes the
+	// X-Forwarded-For hops they appended.
+	TrustedProxies []*net.IPNet
+
+	// LoginLockoutPolicy locks an email after repeated failed logins from the same address.
+	LoginLockoutPolicy = ratelimit.LockoutPolicy{
+		Threshold: 5,
+		Base:      time.Minute,
+		Max:       time.Hour,
+		Window:    24 * time.Hour,
+	}
+)
+
+func init() {
+	err := loadRateLimitRules("./rate-limits.json")
+	if err != nil {
+		log.Criticalf(context.Background(), "Error loading rate-limits.json: %+v", err)
+		os.Exit(1)
+	}
+
+	TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Criticalf(context.Background(), "Error parsing TRUSTED_PROXIES: %+v", err)
+		os.Exit(1)
+	}
+}
+
+// loadRateLimitRules overrides RateLimitRules with the rules in the file at path. A missing file
+// keeps the defaults.
+func loadRateLimitRules(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	rules := map[string]RateLimitRule{}
+	err = json.Unmarshal(raw, &rules)
+	if err != nil {
+		return err
+	}
+
+	for route, rule := range rules {
+		RateLimitRules[route] = rule
+	}
+
+	return nil
+}
+
+// parseTrustedProxies parses a comma separated list of addresses and CIDR ranges.
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid address %q.", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, proxy, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		proxies = append(proxies, proxy)
+	}
+
+	return proxies, nil
+}
+
+func (c *ServerContext) RateLimit(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	rule, exists := RateLimitRules[r.Method+" "+r.URL.Path]
+	if !exists {
+		next(w, r)
+		return
+	}
+
+	for _, keyBy := range rule.KeyBy {
+		id := rateLimitIdentity(r, keyBy)
+		if id == "" {
+			continue
+		}
+
+		key := fmt.Sprintf("%s %s:%s:%s", r.Method, r.URL.Path, keyBy, id)
+		allowed, retryAfter, err := RateLimitStore.Take(c.Context, key, rule.Limit)
+		if err != nil {
+			log.Errorf(c.Context, "Error checking rate limit[%s]: %+v", key, err)
+			continue
+		}
+
+		if !allowed {
+			log.Warningf(c.Context, "Rate limit exceeded: %s", key)
+			c.ServeTooManyRequests(retryAfter, "Too many requests. Please try again later.")
+			return
+		}
+	}
+
+	next(w, r)
+}
+
+// ServeTooManyRequests responds with a 429 and a Retry-After header rounded up to the next second.
+func (c *ServerContext) ServeTooManyRequests(retryAfter time.Duration, value interface{}) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	c.w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	c.ServeJson(http.StatusTooManyRequests, value)
+}
+
+func rateLimitIdentity(r *web.Request, keyBy string) string {
+	switch keyBy {
+	case RateLimitByIP:
+		return ClientIP(r.Request)
+	case RateLimitByEmail:
+		if err := r.ParseForm(); err != nil {
+			return ""
+		}
+
+		return strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+	case RateLimitBySession:
+		cookie, err := r.Cookie("km-session")
+		if err != nil || cookie.Value == "" {
+			return ClientIP(r.Request)
+		}
+
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// ClientIP returns the address of the client that made the request. On App Engine the front end
+// sets X-Appengine-User-Ip itself. Otherwise, when the request came through one of the
+// TrustedProxies, the X-Forwarded-For hops are walked back from the last one and the first hop
+// that isn't a trusted proxy is the client; the hops before it come from the client and can be
+// anything. Without trusted proxies X-Forwarded-For is ignored.
+func ClientIP(r *http.Request) string {
+	if onAppEngine() {
+		if ip := strings.TrimSpace(r.Header.Get("X-Appengine-User-Ip")); ip != "" {
+			return ip
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && trustedProxy(ip); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		ip = hop
+	}
+
+	return ip
+}
+
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, proxy := range TrustedProxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,172 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/ratelimit"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// initTestContext stands in for InitServerContext, which loads the settings from the datastore.
+func (c *ServerContext) initTestContext(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	c.Context = r.Request.Context()
+	c.w = w
+	c.r = r
+	next(w, r)
+}
+
+func TestClientIP(t *testing.T) {
+	oldProxies := TrustedProxies
+	defer func() {
+		TrustedProxies = oldProxies
+	}()
+
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    []*net.IPNet
+		appEngine  bool
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{"remote address", nil, false, "10.0.0.1:4321", nil, "10.0.0.1"},
+		{"untrusted forwarded for", nil, false, "10.0.0.1:4321", map[string][]string{"X-Forwarded-For": {"2.2.2.2"}}, "10.0.0.1"},
+		{"app engine header", nil, true, "10.0.0.1:4321", map[string][]string{"X-Appengine-User-Ip": {"1.1.1.1"}, "X-Forwarded-For": {"2.2.2.2"}}, "1.1.1.1"},
+		{"app engine header off app engine", nil, false, "10.0.0.1:4321", map[string][]string{"X-Appengine-User-Ip": {"1.1.1.1"}}, "10.0.0.1"},
+		{"single hop", proxies, false, "10.0.0.1:4321", map[string][]string{"X-Forwarded-For": {"2.2.2.2"}}, "2.2.2.2"},
+		{"spoofed hops", proxies, false, "10.0.0.1:4321", map[string][]string{"X-Forwarded-For": {"6.6.6.6, 2.2.2.2"}}, "2.2.2.2"},
+		{"chained proxies", proxies, false, "10.0.0.1:4321", map[string][]string{"X-Forwarded-For": {"6.6.6.6, 2.2.2.2, 192.168.0.1"}}, "2.2.2.2"},
+		{"repeated header", proxies, false, "10.0.0.1:4321", map[string][]string{"X-Forwarded-For": {"6.6.6.6", "7.7.7.7, 2.2.2.2"}}, "2.2.2.2"},
+		{"empty last hop", proxies, false, "10.0.0.1:4321", map[string][]string{"X-Forwarded-For": {"6.6.6.6, "}}, "10.0.0.1"},
+		{"request not from a proxy", proxies, false, "3.3.3.3:4321", map[string][]string{"X-Forwarded-For": {"2.2.2.2"}}, "3.3.3.3"},
+	}
+
+	t.Setenv("GAE_APPLICATION", "")
+	for _, test := range tests {
+		TrustedProxies = test.proxies
+		if test.appEngine {
+			t.Setenv("GAE_ENV", "standard")
+		} else {
+			t.Setenv("GAE_ENV", "")
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for name, values := range test.headers {
+			for _, value := range values {
+				r.Header.Add(name, value)
+			}
+		}
+
+		if got := ClientIP(r); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies(" 10.0.0.0/8,,::1 ")
+	if err != nil || len(proxies) != 2 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "::1/128" {
+		t.Errorf("got %v, %v", proxies, err)
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "proxy.example.com"} {
+		if _, err := parseTrustedProxies(value); err == nil {
+			t.Errorf("%q parsed", value)
+		}
+	}
+}
+
+func TestLoadRateLimitRules(t *testing.T) {
+	oldRules := RateLimitRules
+	defer func() {
+		RateLimitRules = oldRules
+	}()
+
+	RateLimitRules = map[string]RateLimitRule{"POST /login": {KeyBy: []string{RateLimitByIP}}}
+	dir := t.TempDir()
+	if err := loadRateLimitRules(filepath.Join(dir, "missing.json")); err != nil || len(RateLimitRules) != 1 {
+		t.Errorf("a missing file got %v, %v", RateLimitRules, err)
+	}
+
+	path := filepath.Join(dir, "rate-limits.json")
+	ioutil.WriteFile(path, []byte(`{"POST /contact": {"key_by": ["email"]}`), 0644)
+	if err := loadRateLimitRules(path); err == nil {
+		t.Error("a malformed file loaded")
+	}
+
+	ioutil.WriteFile(path, []byte(`{"POST /contact": {"key_by": ["email"]}}`), 0644)
+	if err := loadRateLimitRules(path); err != nil || len(RateLimitRules) != 2 || RateLimitRules["POST /contact"].KeyBy[0] != RateLimitByEmail {
+		t.Errorf("got %v, %v", RateLimitRules, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	oldStore, oldRules := RateLimitStore, RateLimitRules
+	defer func() {
+		RateLimitStore, RateLimitRules = oldStore, oldRules
+	}()
+
+	RateLimitStore = ratelimit.NewMemoryStore()
+	RateLimitRules = map[string]RateLimitRule{
+		"POST /login": {Limit: ratelimit.PerHour(1, 2), KeyBy: []string{RateLimitByIP, RateLimitByEmail}},
+	}
+
+	router := web.New(ServerContext{}).
+		Middleware((*ServerContext).initTestContext).
+		Middleware((*ServerContext).RateLimit).
+		Post("/login", func(c *ServerContext, w web.ResponseWriter, r *web.Request) {
+			w.WriteHeader(http.StatusOK)
+		}).
+		Get("/login", func(c *ServerContext, w web.ResponseWriter, r *web.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+	login := func(method string, ip string, email string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {email}}
+		r := httptest.NewRequest(method, "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := login("POST", "1.1.1.1", "a@example.com"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst got %d", i+1, w.Code)
+		}
+	}
+
+	w := login("POST", "1.1.1.1", "b@example.com")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the ip limit got %d, want 429", w.Code)
+	}
+
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "3600" {
+		t.Errorf("Retry-After is %q, want the seconds until the next token, 3600", retryAfter)
+	}
+
+	if w := login("POST", "2.2.2.2", "b@example.com"); w.Code != http.StatusOK {
+		t.Errorf("request from another ip got %d", w.Code)
+	}
+
+	if w := login("POST", "3.3.3.3", "a@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request over the email limit from another ip got %d, want 429", w.Code)
+	}
+
+	if w := login("GET", "1.1.1.1", "a@example.com"); w.Code != http.StatusOK {
+		t.Errorf("route without a rule got %d", w.Code)
+	}
+}
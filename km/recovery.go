@@ -0,0 +1,69 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/recovery"
+	"html"
+	"math"
+	"net/http"
+)
+
+// AbandonedCheckoutsReport sums up how abandoned checkout reminders are doing.
+type AbandonedCheckoutsReport struct {
+	RemindedOrders    int     `json:"reminded_orders"`
+	RemindersSent     int     `json:"reminders_sent"`
+	ClickedOrders     int     `json:"clicked_orders"`
+	RecoveredOrders   int     `json:"recovered_orders"`
+	RecoveredCents    int64   `json:"recovered_cents"`
+	StillAbandoned    int     `json:"still_abandoned"`
+	RecoveredOrderIds []int64 `json:"recovered_order_ids"`
+}
+
+// Unsubscribe stops abandoned checkout reminders to the signed email address.
+func (c *ServerContext) Unsubscribe(w web.ResponseWriter, r *web.Request) {
+	email := r.URL.Query().Get("email")
+	signature := r.URL.Query().Get("sig")
+	if email == "" || !recovery.VerifyEmail(c.Settings.SigningSecret, email, signature) {
+		c.ServeHTMLError(http.StatusBadRequest, "This unsubscribe link is not valid.")
+		return
+	}
+
+	err := entities.UnsubscribeEmail(c.Context, email)
+	if err != nil {
+		log.Errorf(c.Context, "Error unsubscribing %s: %+v", email, err)
+		c.ServeHTMLError(http.StatusInternalServerError, "Unexpected error, please try again later.")
+		return
+	}
+
+	c.ServeHTML(http.StatusOK, "<p>"+html.EscapeString(email)+" will no longer get reminders from "+html.EscapeString(c.Settings.CompanyName)+".</p>")
+}
+
+func (c *AdminContext) GetAbandonedCheckoutsReport(w web.ResponseWriter, r *web.Request) {
+	orders, err := entities.ListRemindedOrders(c.Context)
+	if err != nil {
+		log.Errorf(c.Context, "Error listing reminded orders: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the report.")
+		return
+	}
+
+	report := &AbandonedCheckoutsReport{RecoveredOrderIds: make([]int64, 0)}
+	for _, order := range orders {
+		report.RemindedOrders++
+		report.RemindersSent += order.RecoveryEmailsSent
+		if !order.RecoveryClickedDate.IsZero() {
+			report.ClickedOrders++
+		}
+
+		if order.Recovered {
+			report.RecoveredOrders++
+			report.RecoveredCents += int64(math.Round(order.OrderTotal() * 100))
+			report.RecoveredOrderIds = append(report.RecoveredOrderIds, order.Id)
+		} else if order.Status == entities.OrderStatusStarted {
+			report.StillAbandoned++
+		}
+	}
+
+	c.ServeJson(http.StatusOK, report)
+}
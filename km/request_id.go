@@ -0,0 +1,32 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/google/uuid"
+	"github.com/jcarm010/kodimerce/log"
+	"strings"
+	"time"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// RequestID tags the request context with an id, taken from the incoming X-Request-Id or
+// Cloud trace header when present, echoes it in the response and logs the request once it completes.
+func (c *ServerContext) RequestID(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	requestId := r.Header.Get(RequestIdHeader)
+	if requestId == "" {
+		requestId = strings.Split(r.Header.Get("X-Cloud-Trace-Context"), "/")[0]
+	}
+
+	if requestId == "" || len(requestId) > 128 {
+		requestId = uuid.New().String()
+	}
+
+	info := log.NewRequestInfo(requestId, r.Method, r.URL.Path, r.RoutePath)
+	ctx := log.WithRequest(r.Request.Context(), info)
+	r.Request = r.Request.WithContext(ctx)
+	w.Header().Set(RequestIdHeader, requestId)
+	start := time.Now()
+	next(w, r)
+	log.Infof(ctx, "%s %s %d %v", r.Method, r.URL.Path, w.StatusCode(), time.Since(start))
+}
@@ -0,0 +1,51 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	router := web.New(ServerContext{}).
+		Middleware((*ServerContext).RequestID).
+		Get("/", func(c *ServerContext, w web.ResponseWriter, r *web.Request) {
+			seen = log.RequestFromContext(r.Request.Context()).ID
+		})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"incoming id", map[string]string{RequestIdHeader: "abc"}, "abc"},
+		{"cloud trace", map[string]string{"X-Cloud-Trace-Context": "trace1/span;o=1"}, "trace1"},
+		{"too long", map[string]string{RequestIdHeader: strings.Repeat("a", 129)}, ""},
+		{"none", nil, ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		echoed := w.Header().Get(RequestIdHeader)
+		if echoed == "" || echoed != seen {
+			t.Errorf("%s: echoed %q, the context has %q", test.name, echoed, seen)
+		}
+
+		if test.want != "" && echoed != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, echoed, test.want)
+		}
+
+		if test.want == "" && len(echoed) != 36 {
+			t.Errorf("%s: got %q, want a generated uuid", test.name, echoed)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package km
+
+import (
+	"encoding/xml"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/contentsearch"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/productsearch"
+	"github.com/jcarm010/kodimerce/search"
+	"github.com/jcarm010/kodimerce/settings"
+	"net/http"
+	"unicode/utf8"
+)
+
+// OpenSearchDescription lets browsers add the site search to their search engines.
+type OpenSearchDescription struct {
+	XMLName       xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	Urls          []OpenSearchUrl `xml:"Url"`
+}
+
+type OpenSearchUrl struct {
+	Type     string `xml:"type,attr"`
+	Method   string `xml:"method,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// SearchProducts searches the active products, see productsearch.ParseOptions for the parameters.
+func (c *ServerContext) SearchProducts(w web.ResponseWriter, r *web.Request) {
+	options, err := productsearch.ParseOptions(r.URL.Query())
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := productsearch.Search(c.Context, options)
+	if err == search.ErrInvalidCursor {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error searching products: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error searching products")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, results)
+}
+
+// SearchContent searches the published posts, pages and galleries, see contentsearch.ParseOptions
+// for the parameters.
+func (c *ServerContext) SearchContent(w web.ResponseWriter, r *web.Request) {
+	options, err := contentsearch.ParseOptions(r.URL.Query())
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := contentsearch.Search(c.Context, options)
+	if err == search.ErrInvalidCursor {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error searching content: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error searching content")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, results)
+}
+
+func (c *ServerContext) GetOpenSearchDescription(w web.ResponseWriter, r *web.Request) {
+	serverUrl := settings.ServerUrl(r.Request)
+	shortName := c.Settings.CompanyName
+	if utf8.RuneCountInString(shortName) > 16 {
+		// the spec limits short names to 16 characters
+		shortName = string([]rune(shortName)[:16])
+	}
+
+	description := OpenSearchDescription{
+		ShortName:     shortName,
+		Description:   "Search " + c.Settings.CompanyName,
+		InputEncoding: "UTF-8",
+		Urls: []OpenSearchUrl{
+			{Type: "text/html", Method: "get", Template: serverUrl + "/search?q={searchTerms}"},
+			{Type: "application/json", Method: "get", Template: serverUrl + "/api/search/content?q={searchTerms}"},
+		},
+	}
+
+	bts, err := xml.MarshalIndent(description, "", "  ")
+	if err != nil {
+		log.Errorf(c.Context, "Error marshaling open search description: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(bts)
+}
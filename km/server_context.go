@@ -1,7 +1,6 @@
 package km
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/gocraft/web"
@@ -10,13 +9,14 @@ import (
 	"github.com/jcarm010/kodimerce/emailer"
 	"github.com/jcarm010/kodimerce/entities"
 	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"github.com/jcarm010/kodimerce/orders"
 	"github.com/jcarm010/kodimerce/paypal"
 	"github.com/jcarm010/kodimerce/settings"
 	"github.com/jcarm010/kodimerce/smartyaddress"
 	"github.com/jcarm010/kodimerce/view"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/net/context"
-	"html/template"
 	"io/ioutil"
 	"net/http"
 	"regexp"
@@ -43,6 +43,7 @@ type ProductSettingsPickupLocation struct {
 type ServerContext struct {
 	Context  context.Context
 	Settings entities.ServerSettings
+	store    store
 	w        web.ResponseWriter
 	r        *web.Request
 }
@@ -141,6 +142,7 @@ func (c *ServerContext) RedirectWWW(w web.ResponseWriter, r *web.Request, next w
 func (c *ServerContext) InitServerContext(w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
 	c.Context = r.Request.Context()
 	c.Settings = settings.GetGlobalSettings(c.Context)
+	c.store = datastoreStore{}
 	c.w = w
 	c.r = r
 	next(w, r)
@@ -173,21 +175,25 @@ func (c *ServerContext) SetCORS(w web.ResponseWriter, r *web.Request, next web.N
 	origin := r.Header.Get("origin")
 	serverUrl := settings.ServerUrl(r.Request)
 	c.w.Header().Add("AMP-Same-Origin", "true")
-	c.w.Header().Add("Access-Control-Allow-Credentials", "true")
 	c.w.Header().Add("Access-Control-Expose-Headers", "AMP-Access-Control-Allow-Source-Origin")
 	c.w.Header().Add("AMP-Access-Control-Allow-Source-Origin", serverUrl)
 	allowedOrigins := map[string]bool{
 		fmt.Sprintf("https://%s.cdn.ampproject.org", strings.Replace(r.Host, ".", "-", -1)): true,
 		fmt.Sprintf("https://%s.amp.cloudflare.com", strings.Replace(r.Host, ".", "-", -1)): true,
-		fmt.Sprintf(serverUrl):       true,
+		serverUrl:                    true,
 		"https://cdn.ampproject.org": true,
-		"http://localhost:8080":      true,
+	}
+
+	if strings.HasPrefix(r.Host, "localhost") {
+		allowedOrigins["http://localhost:8080"] = true
 	}
 
 	//log.Infof(c.Context, "Allowed Origins: %+v", allowedOrigins)
 	//log.Infof(c.Context, "Setting CORS for [%s]: %v", origin, allowedOrigins[origin])
 	if allowedOrigins[origin] {
 		c.w.Header().Add("Access-Control-Allow-Origin", origin)
+		c.w.Header().Add("Access-Control-Allow-Credentials", "true")
+		c.w.Header().Add("Vary", "Origin")
 	}
 
 	next(w, r)
@@ -220,7 +226,7 @@ func (c *ServerContext) RegisterUser(w web.ResponseWriter, r *web.Request) {
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Errorf(c.Context, "Error hashing password[%s]: %+v", password, err)
+		log.Errorf(c.Context, "Error hashing password: %+v", err)
 		c.ServeJson(http.StatusInternalServerError, "Unexpected error creating user.")
 		return
 	}
@@ -238,7 +244,7 @@ func (c *ServerContext) RegisterUser(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
-	err = emailer.SendEmail(
+	_, err = emailer.Enqueue(
 		c.Context,
 		fmt.Sprintf("%s<%s>", c.Settings.CompanyName, c.Settings.EmailSender),
 		user.Email,
@@ -248,7 +254,7 @@ func (c *ServerContext) RegisterUser(w web.ResponseWriter, r *web.Request) {
 	)
 
 	if err != nil {
-		log.Errorf(c.Context, "Couldn't send email: %v", err)
+		log.Errorf(c.Context, "Couldn't queue email: %v", err)
 	}
 }
 
@@ -277,9 +283,21 @@ func (c *ServerContext) LoginUser(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	// keyed by address too, so failing to log in as someone from one place doesn't lock them out everywhere
+	lockoutKey := "login:" + email + ":" + ClientIP(r.Request)
+	lockedFor, err := RateLimitStore.LockedFor(c.Context, lockoutKey)
+	if err != nil {
+		log.Errorf(c.Context, "Error checking login lockout[%s]: %+v", email, err)
+	} else if lockedFor > 0 {
+		log.Warningf(c.Context, "Login locked for user[%s]: %v", email, lockedFor)
+		c.ServeTooManyRequests(lockedFor, "Too many failed login attempts. Please try again later.")
+		return
+	}
+
 	user, err := entities.GetUser(c.Context, email)
 	if err == datastore.ErrNoSuchEntity {
 		log.Errorf(c.Context, "User not found: %s", email)
+		c.recordLoginFailure(lockoutKey)
 		c.ServeJson(http.StatusBadRequest, "User not found.")
 		return
 	} else if err != nil {
@@ -290,10 +308,16 @@ func (c *ServerContext) LoginUser(w web.ResponseWriter, r *web.Request) {
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
 		log.Errorf(c.Context, "User passwords do not match: %+v", err)
+		c.recordLoginFailure(lockoutKey)
 		c.ServeJson(http.StatusBadRequest, "User not found.")
 		return
 	}
 
+	err = RateLimitStore.Reset(c.Context, lockoutKey)
+	if err != nil {
+		log.Errorf(c.Context, "Error resetting login lockout[%s]: %+v", email, err)
+	}
+
 	userSession, err := entities.CreateUserSession(c.Context, email)
 	if err != nil {
 		log.Errorf(c.Context, "Error creating user session: %+v", err)
@@ -303,6 +327,7 @@ func (c *ServerContext) LoginUser(w web.ResponseWriter, r *web.Request) {
 
 	cookie := &http.Cookie{Name: "km-session", Value: userSession.SessionToken, HttpOnly: false}
 	http.SetCookie(w, cookie)
+	SetCSRFCookie(w, r.Request, userSession.CSRFToken)
 
 	if user.UserType == "admin" {
 		c.ServeJson(http.StatusOK, "/admin")
@@ -312,6 +337,18 @@ func (c *ServerContext) LoginUser(w web.ResponseWriter, r *web.Request) {
 	c.ServeJson(http.StatusOK, "/")
 }
 
+func (c *ServerContext) recordLoginFailure(lockoutKey string) {
+	lockedFor, err := RateLimitStore.RecordFailure(c.Context, lockoutKey, LoginLockoutPolicy)
+	if err != nil {
+		log.Errorf(c.Context, "Error recording login failure[%s]: %+v", lockoutKey, err)
+		return
+	}
+
+	if lockedFor > 0 {
+		log.Warningf(c.Context, "Locking login[%s] for %v", lockoutKey, lockedFor)
+	}
+}
+
 func (c *ServerContext) CreateOrder(w web.ResponseWriter, r *web.Request) {
 	log.Infof(c.Context, "Creating new order")
 	err := r.ParseForm()
@@ -363,6 +400,7 @@ func (c *ServerContext) CreateOrder(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
+	metrics.OrdersCreated.Inc()
 	log.Infof(c.Context, "Order Total: %v", order.OrderTotal())
 	c.ServeJson(http.StatusOK, order)
 }
@@ -446,49 +484,18 @@ func (c *ServerContext) UpdateOrder(w web.ResponseWriter, r *web.Request) {
 		return
 	}
 
-	proto := "http"
-	if r.Request.TLS != nil {
-		proto = "https"
-	}
-
-	serverRoot := fmt.Sprintf("%s://%s", proto, r.Host)
-	orderUrl := fmt.Sprintf("%s/admin/orders/%v", serverRoot, order.Id)
-	var templates = template.Must(template.ParseGlob("emailer/templates/*")) // cache this globally
-	orderUpdateEmail := struct {
-		CompanyName  string
-		OrderUrl     string
-		HostRoot     string
-		ContactEmail string
-		Order        *entities.Order
-	}{
-		CompanyName:  c.Settings.CompanyName,
-		OrderUrl:     orderUrl,
-		HostRoot:     serverRoot,
-		ContactEmail: c.Settings.CompanySupportEmail,
-		Order:        order,
-	}
-
-	var doc bytes.Buffer
-	err = templates.ExecuteTemplate(&doc, "email-order-updated-admin", orderUpdateEmail)
-
-	if err != nil {
-		log.Errorf(c.Context, "Error parsing email template: %+v", err)
-		c.ServeJson(http.StatusInternalServerError, "Unexpected Error, please try again later.")
-		return
-	}
-
 	//send a notification email to the administrator
-	err = emailer.SendEmail(
+	_, err = emailer.EnqueueTemplate(
 		c.Context,
+		"email-order-updated-admin",
+		c.EmailTemplateData(r, order),
 		fmt.Sprintf("%s<%s>", c.Settings.CompanyName, c.Settings.EmailSender),
 		c.Settings.CompanyOrdersEmail,
-		fmt.Sprintf("Order Updated - %s", order.CheckoutStep),
-		doc.String(),
 		"",
 	)
 
 	if err != nil {
-		log.Errorf(c.Context, "Couldn't send email: %v", err)
+		log.Errorf(c.Context, "Couldn't queue email: %v", err)
 	}
 
 	c.ServeJson(http.StatusOK, "")
@@ -698,94 +705,17 @@ func (c *ServerContext) ExecutePaypalPayment(w web.ResponseWriter, r *web.Reques
 		return
 	}
 
-	order.Status = entities.OrderStatusPending
-	err = entities.UpdateOrder(c.Context, order)
+	err = orders.MarkPaid(c.Context, c.EmailTemplateData(r, order))
 	if err != nil {
 		log.Errorf(c.Context, "Error updating order status: %+v", err)
 		c.ServeJson(http.StatusInternalServerError, "Unexpecting error executing payment")
 		return
 	}
+}
 
-	for _, productId := range order.ProductIds {
-		err = entities.DecreaseProductInventory(c.Context, productId, 1)
-		if err != nil {
-			log.Errorf(c.Context, "Error decresing inventory for productId[%v]: %+v", productId, err)
-		}
-	}
-
-	proto := "http"
-	if r.Request.TLS != nil {
-		proto = "https"
-	}
-	serverRoot := fmt.Sprintf("%s://%s", proto, r.Host)
-	confirmationUrl := fmt.Sprintf("%s/order?id=%v", serverRoot, order.Id)
-
-	var templates = template.Must(template.ParseGlob("emailer/templates/*")) // cache this globally
-	confirmationEmail := struct {
-		CompanyName     string
-		ConfirmationUrl string
-		HostRoot        string
-		ContactEmail    string
-	}{
-		CompanyName:     c.Settings.CompanyName,
-		ConfirmationUrl: confirmationUrl,
-		HostRoot:        serverRoot,
-		ContactEmail:    c.Settings.CompanySupportEmail,
-	}
-
-	var doc bytes.Buffer
-	err = templates.ExecuteTemplate(&doc, "email-order", confirmationEmail)
-
-	if err != nil {
-		log.Errorf(c.Context, "Error parsing email template: %+v", err)
-		c.ServeJson(http.StatusInternalServerError, "Unexpected Error, please try again later.")
-		return
-	}
-
-	//send a notification email to the buyer
-	err = emailer.SendEmail(
-		c.Context,
-		fmt.Sprintf("%s<%s>", c.Settings.CompanyName, c.Settings.EmailSender),
-		order.Email,
-		"Order Confirmation",
-		doc.String(),
-		c.Settings.CompanyOrdersEmail,
-	)
-
-	if err != nil {
-		log.Errorf(c.Context, "Couldn't send email: %v", err)
-	}
-
-	//send a notification email to the seller
-	notificationEmail := struct {
-		CompanyName     string
-		ConfirmationUrl string
-		HostRoot        string
-		ContactEmail    string
-		Order           *entities.Order
-	}{
-		CompanyName:     c.Settings.CompanyName,
-		ConfirmationUrl: confirmationUrl,
-		HostRoot:        serverRoot,
-		ContactEmail:    c.Settings.CompanySupportEmail,
-		Order:           order,
-	}
-
-	var ndoc bytes.Buffer
-	err = templates.ExecuteTemplate(&ndoc, "email-order-admin", notificationEmail)
-	if err != nil {
-		log.Errorf(c.Context, "Error parsing admin email template: %+v", err)
-		return
-	}
-
-	err = emailer.SendEmail(
-		c.Context,
-		fmt.Sprintf("%s<%s>", c.Settings.CompanyName, c.Settings.EmailSender),
-		c.Settings.CompanyOrdersEmail,
-		"Order Pending",
-		ndoc.String(),
-		"",
-	)
+// EmailTemplateData returns the data email templates about order are rendered with.
+func (c *ServerContext) EmailTemplateData(r *web.Request, order *entities.Order) *emailer.TemplateData {
+	return orders.TemplateData(c.Settings, settings.ServerUrl(r.Request), order)
 }
 
 func (c *ServerContext) GetProducts(w web.ResponseWriter, r *web.Request) {
@@ -910,7 +840,7 @@ func (c *ServerContext) PostContactMessage(w web.ResponseWriter, r *web.Request)
 	}
 
 	body := fmt.Sprintf("Customer %s (%s%s) has sent you a message: %s", name, email, phonePart, message)
-	err := emailer.SendEmail(
+	_, err := emailer.Enqueue(
 		c.Context,
 		fmt.Sprintf("%s<%s>", c.Settings.CompanyName, c.Settings.EmailSender),
 		c.Settings.CompanySupportEmail,
@@ -920,7 +850,7 @@ func (c *ServerContext) PostContactMessage(w web.ResponseWriter, r *web.Request)
 	)
 
 	if err != nil {
-		log.Errorf(c.Context, "Error Sending email: %+v", err)
+		log.Errorf(c.Context, "Error queueing email: %+v", err)
 		c.ServeJson(http.StatusInternalServerError, "Could not send message at this time. Please try again later.")
 		return
 	}
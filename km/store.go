@@ -0,0 +1,79 @@
+package km
+
+import (
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search_api"
+	"github.com/jcarm010/kodimerce/settings"
+	"github.com/jcarm010/kodimerce/storage"
+	"golang.org/x/net/context"
+	"io"
+	"time"
+)
+
+// store is what the handlers read and write through the context rather than calling the datastore
+// and object storage directly, so they can be tested against a fake one. InitServerContext sets datastoreStore.
+type store interface {
+	GetUserSession(ctx context.Context, sessionToken string, ttl time.Duration) (*entities.UserSession, error)
+	GetOrder(ctx context.Context, orderId int64) (*entities.Order, error)
+	UpdateOrder(ctx context.Context, order *entities.Order) error
+	SaveShipment(ctx context.Context, shipment *entities.Shipment) (*entities.Order, string, error)
+	GetUploadSession(ctx context.Context, id string) (*entities.UploadSession, error)
+	AppendUploadChunk(ctx context.Context, id string, offset int64, size int64, objectName string) (*entities.UploadSession, error)
+	PutObject(ctx context.Context, objectName string, reader io.Reader) error
+	DeleteObject(ctx context.Context, objectName string) error
+	UpdateUploadMetadata(ctx context.Context, key string, metadata *entities.UploadMetadata) (*search_api.BlobInfo, error)
+	GetServerSettings(ctx context.Context) (*entities.ServerSettings, error)
+	StoreServerSettings(ctx context.Context, serverSettings *entities.ServerSettings) error
+	ReloadSettings(ctx context.Context) entities.ServerSettings
+}
+
+// datastoreStore is the store of the running server.
+type datastoreStore struct{}
+
+func (datastoreStore) GetUserSession(ctx context.Context, sessionToken string, ttl time.Duration) (*entities.UserSession, error) {
+	return entities.GetUserSession(ctx, sessionToken, ttl)
+}
+
+func (datastoreStore) GetOrder(ctx context.Context, orderId int64) (*entities.Order, error) {
+	return entities.GetOrder(ctx, orderId)
+}
+
+func (datastoreStore) UpdateOrder(ctx context.Context, order *entities.Order) error {
+	return entities.UpdateOrder(ctx, order)
+}
+
+func (datastoreStore) SaveShipment(ctx context.Context, shipment *entities.Shipment) (*entities.Order, string, error) {
+	return entities.SaveShipment(ctx, shipment)
+}
+
+func (datastoreStore) GetUploadSession(ctx context.Context, id string) (*entities.UploadSession, error) {
+	return entities.GetUploadSession(ctx, id)
+}
+
+func (datastoreStore) AppendUploadChunk(ctx context.Context, id string, offset int64, size int64, objectName string) (*entities.UploadSession, error) {
+	return entities.AppendUploadChunk(ctx, id, offset, size, objectName)
+}
+
+func (datastoreStore) PutObject(ctx context.Context, objectName string, reader io.Reader) error {
+	return storage.PutObject(ctx, objectName, reader)
+}
+
+func (datastoreStore) DeleteObject(ctx context.Context, objectName string) error {
+	return storage.DeleteObject(ctx, objectName)
+}
+
+func (datastoreStore) UpdateUploadMetadata(ctx context.Context, key string, metadata *entities.UploadMetadata) (*search_api.BlobInfo, error) {
+	return entities.UpdateUploadMetadata(ctx, key, metadata)
+}
+
+func (datastoreStore) GetServerSettings(ctx context.Context) (*entities.ServerSettings, error) {
+	return entities.GetServerSettings(ctx)
+}
+
+func (datastoreStore) StoreServerSettings(ctx context.Context, serverSettings *entities.ServerSettings) error {
+	return entities.StoreServerSettings(ctx, serverSettings)
+}
+
+func (datastoreStore) ReloadSettings(ctx context.Context) entities.ServerSettings {
+	return settings.GetAndReloadGlobalSettings(ctx)
+}
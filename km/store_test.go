@@ -0,0 +1,85 @@
+package km
+
+import (
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search_api"
+	"golang.org/x/net/context"
+	"io"
+	"time"
+)
+
+// fakeStore answers with its functions, a test only sets the ones its handler calls.
+type fakeStore struct {
+	getUserSession func(ctx context.Context, sessionToken string, ttl time.Duration) (*entities.UserSession, error)
+	getOrder       func(ctx context.Context, orderId int64) (*entities.Order, error)
+	updateOrder    func(ctx context.Context, order *entities.Order) error
+	saveShipment   func(ctx context.Context, shipment *entities.Shipment) (*entities.Order, string, error)
+
+	getUploadSession  func(ctx context.Context, id string) (*entities.UploadSession, error)
+	appendUploadChunk func(ctx context.Context, id string, offset int64, size int64, objectName string) (*entities.UploadSession, error)
+	putObject         func(ctx context.Context, objectName string, reader io.Reader) error
+	deleteObject      func(ctx context.Context, objectName string) error
+
+	updateUploadMetadata func(ctx context.Context, key string, metadata *entities.UploadMetadata) (*search_api.BlobInfo, error)
+
+	getServerSettings   func(ctx context.Context) (*entities.ServerSettings, error)
+	storeServerSettings func(ctx context.Context, serverSettings *entities.ServerSettings) error
+	reloadSettings      func(ctx context.Context) entities.ServerSettings
+}
+
+func (f *fakeStore) GetUserSession(ctx context.Context, sessionToken string, ttl time.Duration) (*entities.UserSession, error) {
+	return f.getUserSession(ctx, sessionToken, ttl)
+}
+
+func (f *fakeStore) GetOrder(ctx context.Context, orderId int64) (*entities.Order, error) {
+	return f.getOrder(ctx, orderId)
+}
+
+func (f *fakeStore) UpdateOrder(ctx context.Context, order *entities.Order) error {
+	return f.updateOrder(ctx, order)
+}
+
+func (f *fakeStore) SaveShipment(ctx context.Context, shipment *entities.Shipment) (*entities.Order, string, error) {
+	return f.saveShipment(ctx, shipment)
+}
+
+func (f *fakeStore) GetUploadSession(ctx context.Context, id string) (*entities.UploadSession, error) {
+	return f.getUploadSession(ctx, id)
+}
+
+func (f *fakeStore) AppendUploadChunk(ctx context.Context, id string, offset int64, size int64, objectName string) (*entities.UploadSession, error) {
+	return f.appendUploadChunk(ctx, id, offset, size, objectName)
+}
+
+func (f *fakeStore) PutObject(ctx context.Context, objectName string, reader io.Reader) error {
+	return f.putObject(ctx, objectName, reader)
+}
+
+func (f *fakeStore) DeleteObject(ctx context.Context, objectName string) error {
+	return f.deleteObject(ctx, objectName)
+}
+
+func (f *fakeStore) UpdateUploadMetadata(ctx context.Context, key string, metadata *entities.UploadMetadata) (*search_api.BlobInfo, error) {
+	return f.updateUploadMetadata(ctx, key, metadata)
+}
+
+func (f *fakeStore) GetServerSettings(ctx context.Context) (*entities.ServerSettings, error) {
+	return f.getServerSettings(ctx)
+}
+
+func (f *fakeStore) StoreServerSettings(ctx context.Context, serverSettings *entities.ServerSettings) error {
+	return f.storeServerSettings(ctx, serverSettings)
+}
+
+func (f *fakeStore) ReloadSettings(ctx context.Context) entities.ServerSettings {
+	return f.reloadSettings(ctx)
+}
+
+// withStore is a middleware that gives the handlers s instead of the datastore.
+func withStore(s store) func(c *ServerContext, w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+	return func(c *ServerContext, w web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
+		c.store = s
+		next(w, r)
+	}
+}
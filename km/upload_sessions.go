@@ -0,0 +1,394 @@
+package km
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/imaging"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/search_api"
+	"github.com/jcarm010/kodimerce/storage"
+	"github.com/jcarm010/kodimerce/uploads"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadSessionResponse tells the client where to send the rest of a file.
+type UploadSessionResponse struct {
+	*entities.UploadSession
+	Url       string `json:"url"`                  // PUT chunks here, or POST <url>/complete after a direct upload
+	UploadUrl string `json:"upload_url,omitempty"` // signed url to PUT the whole file to, for direct uploads
+}
+
+func uploadSessionResponse(session *entities.UploadSession, uploadUrl string) *UploadSessionResponse {
+	return &UploadSessionResponse{
+		UploadSession: session,
+		Url:           "/admin/gallery/upload/sessions/" + session.Id,
+		UploadUrl:     uploadUrl,
+	}
+}
+
+// CreateUploadSession starts a resumable upload of a file with the filename and size form values.
+// The file is then sent in chunks with PUT, each with an Upload-Offset header, and becomes an
+// upload when its last byte arrives. With direct=true, or the upload direct setting, the response
+// has a signed url the whole file is PUT to instead, and the upload is finished with complete.
+func (c *AdminContext) CreateUploadSession(w web.ResponseWriter, r *web.Request) {
+	filename := strings.TrimSpace(r.FormValue("filename"))
+	if filename == "" {
+		c.ServeJson(http.StatusBadRequest, "Missing filename.")
+		return
+	}
+
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size <= 0 {
+		c.ServeJson(http.StatusBadRequest, "Invalid size.")
+		return
+	}
+
+	// the declared type only rejects files early, the stored file's type is sniffed
+	contentType := strings.TrimSpace(r.FormValue("content_type"))
+	if contentType != "" {
+		rejection := uploads.NewPolicy(&c.Settings).Allow(contentType, size)
+		if rejection != nil {
+			rejection.Filename = filename
+			c.ServeJson(http.StatusUnprocessableEntity, map[string]interface{}{"rejected": []*uploads.Rejection{rejection}})
+			return
+		}
+	}
+
+	session := &entities.UploadSession{
+		Id:          RandStringRunes(32),
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Chunks:      make([]string, 0),
+	}
+
+	uploadUrl := ""
+	if c.Settings.UploadDirect || r.FormValue("direct") == "true" {
+		uploadUrl, err = storage.SignedUploadURL(uploads.SessionObject(session.Id, "file"), contentType, uploads.SignedUrlTTL)
+		if err != nil {
+			log.Warningf(c.Context, "Could not sign a direct upload url, uploading in chunks: %+v", err)
+		}
+
+		session.Direct = uploadUrl != ""
+	}
+
+	err = entities.CreateUploadSession(c.Context, session)
+	if err != nil {
+		log.Errorf(c.Context, "Error creating upload session: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error starting the upload.")
+		return
+	}
+
+	c.ServeJson(http.StatusCreated, uploadSessionResponse(session, uploadUrl))
+}
+
+// GetUploadSession tells how much of a file is stored, so an interrupted upload can resume.
+func (c *AdminContext) GetUploadSession(w web.ResponseWriter, r *web.Request) {
+	session, err := c.store.GetUploadSession(c.Context, r.PathParams["id"])
+	if err == entities.ErrUploadSessionNotFound {
+		c.ServeJson(http.StatusNotFound, "Upload session not found.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error getting upload session: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the upload.")
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.ServeJson(http.StatusOK, uploadSessionResponse(session, ""))
+}
+
+// PutUploadChunk stores the next chunk of a file. The chunk must start where the stored part of
+// the file ends, given by an Upload-Offset header or the start of a Content-Range header.
+func (c *AdminContext) PutUploadChunk(w web.ResponseWriter, r *web.Request) {
+	session, err := c.store.GetUploadSession(c.Context, r.PathParams["id"])
+	if err == entities.ErrUploadSessionNotFound {
+		c.ServeJson(http.StatusNotFound, "Upload session not found.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error getting upload session: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the upload.")
+		return
+	}
+
+	if session.Direct {
+		c.ServeJson(http.StatusBadRequest, "This file is uploaded straight to storage.")
+		return
+	}
+
+	offset, err := chunkOffset(r)
+	if err != nil {
+		c.ServeJson(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if offset != session.Offset || session.Complete() {
+		c.ServeJson(http.StatusConflict, uploadSessionResponse(session, ""))
+		return
+	}
+
+	objectName := uploads.SessionObject(session.Id, fmt.Sprintf("chunk-%016d-%s", offset, RandStringRunes(8)))
+	body := &countingReader{Reader: http.MaxBytesReader(w, r.Body, uploads.MaxChunkSize)}
+	err = c.store.PutObject(c.Context, objectName, io.LimitReader(body, session.Size-offset+1))
+	if err != nil && body.Count >= uploads.MaxChunkSize {
+		c.ServeJson(http.StatusRequestEntityTooLarge, fmt.Sprintf("Chunks can be up to %d bytes.", uploads.MaxChunkSize))
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error storing chunk of upload session %s: %+v", session.Id, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error storing the chunk.")
+		return
+	}
+
+	if body.Count == 0 || offset+body.Count > session.Size {
+		_ = c.store.DeleteObject(c.Context, objectName)
+		c.ServeJson(http.StatusBadRequest, "The chunk is empty or goes past the end of the file.")
+		return
+	}
+
+	session, err = c.store.AppendUploadChunk(c.Context, session.Id, offset, body.Count, objectName)
+	if err == entities.ErrUploadOffsetMismatch {
+		_ = c.store.DeleteObject(c.Context, objectName)
+		c.ServeJson(http.StatusConflict, err.Error())
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error recording chunk of upload session: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error storing the chunk.")
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if !session.Complete() {
+		c.ServeJson(http.StatusOK, uploadSessionResponse(session, ""))
+		return
+	}
+
+	c.finishUploadSession(session)
+}
+
+// CompleteUploadSession turns a file uploaded straight to storage into an upload. It also retries
+// chunked uploads whose last chunk arrived but couldn't be turned into an upload.
+func (c *AdminContext) CompleteUploadSession(w web.ResponseWriter, r *web.Request) {
+	session, err := c.store.GetUploadSession(c.Context, r.PathParams["id"])
+	if err == entities.ErrUploadSessionNotFound {
+		c.ServeJson(http.StatusNotFound, "Upload session not found.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error getting upload session: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error getting the upload.")
+		return
+	}
+
+	if !session.Direct && !session.Complete() {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		c.ServeJson(http.StatusConflict, uploadSessionResponse(session, ""))
+		return
+	}
+
+	c.finishUploadSession(session)
+}
+
+// DeleteUploadSession cancels an upload, deleting the chunks stored so far.
+func (c *AdminContext) DeleteUploadSession(w web.ResponseWriter, r *web.Request) {
+	err := uploads.DeleteSession(c.Context, r.PathParams["id"])
+	if err != nil {
+		log.Errorf(c.Context, "Error deleting upload session: %+v", err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error cancelling the upload.")
+		return
+	}
+
+	c.ServeJson(http.StatusOK, "Upload cancelled.")
+}
+
+// finishUploadSession puts the chunks of a session together and stores the file as an upload,
+// with the same checks as PostGalleryUpload. The session is deleted unless something failed
+// that retrying can fix.
+func (c *AdminContext) finishUploadSession(session *entities.UploadSession) {
+	fileObject := uploads.SessionObject(session.Id, "file")
+	var err error
+	if !session.Direct {
+		_, err = storage.ComposeObjects(c.Context, fileObject, session.Chunks)
+		if err != nil {
+			log.Errorf(c.Context, "Error composing upload session %s: %+v", session.Id, err)
+			c.ServeJson(http.StatusInternalServerError, "Unexpected error putting the file together.")
+			return
+		}
+	}
+
+	blob, rejection, err := c.storeSessionFile(session, fileObject)
+	if err == storage.ErrObjectNotExist {
+		c.ServeJson(http.StatusConflict, "The file hasn't been uploaded yet.")
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error storing upload session %s: %+v", session.Id, err)
+		c.ServeJson(http.StatusInternalServerError, "Unexpected error storing the file.")
+		return
+	}
+
+	err = uploads.DeleteSession(c.Context, session.Id)
+	if err != nil {
+		log.Errorf(c.Context, "Error deleting upload session %s: %+v", session.Id, err)
+	}
+
+	if rejection != nil {
+		log.Warningf(c.Context, "Rejected upload %s: %s", session.Filename, rejection.Message)
+		rejection.Filename = session.Filename
+		c.ServeJson(http.StatusUnprocessableEntity, map[string]interface{}{"rejected": []*uploads.Rejection{rejection}})
+		return
+	}
+
+	c.ServeJson(http.StatusCreated, blob)
+}
+
+// storeSessionFile checks the file of an upload session against the upload policy and stores it
+// as an upload, or returns the upload with the same content when there is one.
+func (c *AdminContext) storeSessionFile(session *entities.UploadSession, fileObject string) (*search_api.BlobInfo, *uploads.Rejection, error) {
+	object := storage.GetObject(fileObject)
+	attrs, err := object.Attrs(c.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	head, err := readObjectRange(c.Context, fileObject, 0, uploads.SniffLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policy := uploads.NewPolicy(&c.Settings)
+	contentType := uploads.Sniff(head)
+	rejection := policy.Allow(contentType, attrs.Size)
+	if rejection != nil {
+		return nil, rejection, nil
+	}
+
+	// images are small enough to strip their metadata in memory, other files are hashed as they
+	// are read and copied within storage
+	if imaging.FormatOf(contentType) != "" {
+		data, err := readObjectRange(c.Context, fileObject, 0, -1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return policy.Store(c.Context, &search_api.BlobInfo{Filename: session.Filename}, data)
+	}
+
+	rc, err := object.NewReader(c.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rejection = policy.Scan(c.Context, session.Filename, rc)
+	_ = rc.Close()
+	if rejection != nil {
+		return nil, rejection, nil
+	}
+
+	rc, err = object.NewReader(c.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash := md5.New()
+	_, err = io.Copy(hash, rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md5Hash := fmt.Sprintf("%x", hash.Sum(nil))
+	if existing := uploads.FindDuplicate(c.Context, session.Filename, md5Hash); existing != nil {
+		return existing, nil, nil
+	}
+
+	key := uploads.NewKey()
+	filename := uploads.SanitizeFilename(session.Filename)
+	objectName := uploads.Prefix + key + "/" + filename
+	_, err = storage.CopyObject(c.Context, objectName, fileObject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blob := &search_api.BlobInfo{
+		BlobKey:      key,
+		ContentType:  contentType,
+		CreationTime: time.Now(),
+		Filename:     filename,
+		MD5:          md5Hash,
+		ObjectName:   objectName,
+		Size:         attrs.Size,
+	}
+
+	return blob, nil, entities.PutUpload(c.Context, blob)
+}
+
+// readObjectRange reads length bytes of an object from offset, to its end when length is -1.
+func readObjectRange(ctx context.Context, objectName string, offset int64, length int64) ([]byte, error) {
+	rc, err := storage.GetObject(objectName).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	return ioutil.ReadAll(rc)
+}
+
+// chunkOffset reads where a chunk starts from its Upload-Offset header, or from its
+// Content-Range header, such as bytes 0-1048575/5242880.
+func chunkOffset(r *web.Request) (int64, error) {
+	if header := r.Header.Get("Upload-Offset"); header != "" {
+		offset, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("Invalid Upload-Offset: %s", header)
+		}
+
+		return offset, nil
+	}
+
+	header := r.Header.Get("Content-Range")
+	if header == "" {
+		return 0, errors.New("Missing Upload-Offset or Content-Range header.")
+	}
+
+	start := strings.SplitN(strings.TrimPrefix(header, "bytes "), "-", 2)[0]
+	offset, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("Invalid Content-Range: %s", header)
+	}
+
+	return offset, nil
+}
+
+type countingReader struct {
+	io.Reader
+	Count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.Count += int64(n)
+	return n, err
+}
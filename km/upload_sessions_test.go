@@ -0,0 +1,142 @@
+package km
+
+import (
+	"context"
+	"github.com/gocraft/web"
+	"github.com/jcarm010/kodimerce/entities"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChunkOffset(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    int64
+		fails   bool
+	}{
+		{map[string]string{"Upload-Offset": "1024"}, 1024, false},
+		{map[string]string{"Content-Range": "bytes 2048-4095/8192"}, 2048, false},
+		{map[string]string{"Upload-Offset": "5", "Content-Range": "bytes 0-1/2"}, 5, false},
+		{map[string]string{"Upload-Offset": "-1"}, 0, true},
+		{map[string]string{"Content-Range": "bytes */8192"}, 0, true},
+		{map[string]string{}, 0, true},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("PUT", "/", nil)
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+
+		offset, err := chunkOffset(&web.Request{Request: r})
+		if offset != test.want || (err != nil) != test.fails {
+			t.Errorf("%v: got %d, %v", test.headers, offset, err)
+		}
+	}
+}
+
+type fakeSessions struct {
+	session *entities.UploadSession
+	stored  map[string]string
+	deleted []string
+}
+
+func newSessionStore(session *entities.UploadSession) (*fakeSessions, *fakeStore) {
+	fake := &fakeSessions{session: session, stored: map[string]string{}}
+	store := &fakeStore{
+		getUploadSession: func(ctx context.Context, id string) (*entities.UploadSession, error) {
+			if id != fake.session.Id {
+				return nil, entities.ErrUploadSessionNotFound
+			}
+
+			copied := *fake.session
+			return &copied, nil
+		},
+		appendUploadChunk: func(ctx context.Context, id string, offset int64, size int64, objectName string) (*entities.UploadSession, error) {
+			if offset != fake.session.Offset {
+				return nil, entities.ErrUploadOffsetMismatch
+			}
+
+			fake.session.Offset += size
+			fake.session.Chunks = append(fake.session.Chunks, objectName)
+			copied := *fake.session
+			return &copied, nil
+		},
+		putObject: func(ctx context.Context, objectName string, reader io.Reader) error {
+			bts, err := ioutil.ReadAll(reader)
+			fake.stored[objectName] = string(bts)
+			return err
+		},
+		deleteObject: func(ctx context.Context, objectName string) error {
+			fake.deleted = append(fake.deleted, objectName)
+			return nil
+		},
+	}
+
+	return fake, store
+}
+
+func putChunk(store store, id string, offset string, body string) *httptest.ResponseRecorder {
+	router := web.New(ServerContext{}).Middleware((*ServerContext).initTestContext).Middleware(withStore(store))
+	router.Subrouter(AdminContext{}, "/admin").Put("/gallery/upload/sessions/:id", (*AdminContext).PutUploadChunk)
+	r := httptest.NewRequest("PUT", "/admin/gallery/upload/sessions/"+id, strings.NewReader(body))
+	r.Header.Set("Upload-Offset", offset)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestPutUploadChunk(t *testing.T) {
+	fake, store := newSessionStore(&entities.UploadSession{Id: "s1", Filename: "movie.mp4", Size: 10, Chunks: []string{}})
+
+	w := putChunk(store, "s1", "0", "abcd")
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "4" || fake.session.Offset != 4 {
+		t.Fatalf("got %d with offset %s: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body.String())
+	}
+
+	if len(fake.session.Chunks) != 1 || fake.stored[fake.session.Chunks[0]] != "abcd" {
+		t.Errorf("the chunk was not stored: %v", fake.stored)
+	}
+
+	w = putChunk(store, "s1", "0", "abcd")
+	if w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "4" {
+		t.Errorf("a repeated chunk got %d with offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	w = putChunk(store, "s1", "4", "efghijklmnop")
+	if w.Code != http.StatusBadRequest || fake.session.Offset != 4 || len(fake.deleted) != 1 {
+		t.Errorf("a chunk past the end got %d, offset %d, deleted %v", w.Code, fake.session.Offset, fake.deleted)
+	}
+
+	w = putChunk(store, "s1", "4", "")
+	if w.Code != http.StatusBadRequest || len(fake.deleted) != 2 {
+		t.Errorf("an empty chunk got %d", w.Code)
+	}
+
+	if w := putChunk(store, "s2", "0", "abcd"); w.Code != http.StatusNotFound {
+		t.Errorf("a missing session got %d", w.Code)
+	}
+}
+
+func TestPutUploadChunkRefusesDirectSessions(t *testing.T) {
+	fake, store := newSessionStore(&entities.UploadSession{Id: "s1", Size: 10, Direct: true})
+	if w := putChunk(store, "s1", "0", "abcd"); w.Code != http.StatusBadRequest || len(fake.stored) != 0 {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestPutUploadChunkLosesRace(t *testing.T) {
+	fake, store := newSessionStore(&entities.UploadSession{Id: "s1", Size: 10})
+	store.appendUploadChunk = func(ctx context.Context, id string, offset int64, size int64, objectName string) (*entities.UploadSession, error) {
+		return nil, entities.ErrUploadOffsetMismatch
+	}
+
+	w := putChunk(store, "s1", "0", "abcd")
+	if w.Code != http.StatusConflict || len(fake.deleted) != 1 {
+		t.Errorf("a chunk recorded by another request first got %d, deleted %v", w.Code, fake.deleted)
+	}
+}
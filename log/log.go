@@ -2,32 +2,160 @@ package log
 
 import (
 	"context"
-	nLog "log"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelCritical
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	mu       sync.RWMutex
+	out      io.Writer = os.Stderr
+	minLevel           = ParseLevel(os.Getenv("LOG_LEVEL"))
+	secrets  []string
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarning:
+		return "WARNING"
+	case LevelError:
+		return "ERROR"
+	case LevelCritical:
+		return "CRITICAL"
+	}
+
+	return "DEFAULT"
+}
+
+// ParseLevel parses a level name such as "info" or "WARNING". Unknown names map to Debug.
+func ParseLevel(name string) Level {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "INFO":
+		return LevelInfo
+	case "WARN", "WARNING":
+		return LevelWarning
+	case "ERROR":
+		return LevelError
+	case "CRITICAL":
+		return LevelCritical
+	}
+
+	return LevelDebug
+}
+
+// SetMinLevel drops every message below level.
+func SetMinLevel(level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = level
+}
+
+// SetOutput changes where log entries are written. Entries go to stderr by default.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// SetSecrets replaces the values that are redacted from every message. Empty values are ignored.
+func SetSecrets(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	secrets = make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+}
+
+type entry struct {
+	Severity  string `json:"severity"`
+	Time      string `json:"time"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Route     string `json:"route,omitempty"`
+	User      string `json:"user,omitempty"`
+}
+
+func write(ctx context.Context, level Level, format string, args ...interface{}) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if level < minLevel {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+	for _, secret := range secrets {
+		message = strings.Replace(message, secret, redacted, -1)
+	}
+
+	e := entry{
+		Severity: level.String(),
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		Message:  message,
+	}
+
+	if info := RequestFromContext(ctx); info != nil {
+		e.RequestID = info.ID
+		e.Method = info.Method
+		e.Route = info.Route()
+		e.User = info.User()
+	}
+
+	bts, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintf(out, "%s %s\n", e.Severity, message)
+		return
+	}
+
+	out.Write(append(bts, '\n'))
+}
+
 // Debugf formats its arguments according to the format, analogous to fmt.Printf,
 // and records the text as a log message at Debug level. The message will be associated
 // with the request linked with the provided context.
 func Debugf(ctx context.Context, format string, args ...interface{}) {
-	nLog.Printf(format, args...)
+	write(ctx, LevelDebug, format, args...)
 }
 
 // Infof is like Debugf, but at Info level.
 func Infof(ctx context.Context, format string, args ...interface{}) {
-	nLog.Printf(format, args...)
+	write(ctx, LevelInfo, format, args...)
 }
 
 // Warningf is like Debugf, but at Warning level.
 func Warningf(ctx context.Context, format string, args ...interface{}) {
-	nLog.Printf(format, args...)
+	write(ctx, LevelWarning, format, args...)
 }
 
 // Errorf is like Debugf, but at Error level.
 func Errorf(ctx context.Context, format string, args ...interface{}) {
-	nLog.Printf(format, args...)
+	write(ctx, LevelError, format, args...)
 }
 
 // Criticalf is like Debugf, but at Critical level.
 func Criticalf(ctx context.Context, format string, args ...interface{}) {
-	nLog.Printf(format, args...)
+	write(ctx, LevelCritical, format, args...)
 }
@@ -0,0 +1,115 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// capture sends the log to a buffer for the test, at minimum level min.
+func capture(t *testing.T, min Level) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	mu.Lock()
+	oldOut, oldMin, oldSecrets := out, minLevel, secrets
+	mu.Unlock()
+	SetOutput(buf)
+	SetMinLevel(min)
+	t.Cleanup(func() {
+		mu.Lock()
+		out, minLevel, secrets = oldOut, oldMin, oldSecrets
+		mu.Unlock()
+	})
+
+	return buf
+}
+
+func entries(t *testing.T, buf *bytes.Buffer) []entry {
+	list := make([]entry, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		e := entry{}
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			t.Fatalf("%q is not a json entry: %s", line, err)
+		}
+
+		list = append(list, e)
+	}
+
+	return list
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]Level{"": LevelDebug, "info": LevelInfo, " WARN ": LevelWarning, "Warning": LevelWarning, "error": LevelError, "CRITICAL": LevelCritical, "loud": LevelDebug}
+	for name, want := range tests {
+		if got := ParseLevel(name); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMinLevel(t *testing.T) {
+	buf := capture(t, LevelWarning)
+	ctx := context.Background()
+	Debugf(ctx, "debug")
+	Infof(ctx, "info")
+	Warningf(ctx, "warning %d", 1)
+	Errorf(ctx, "error")
+	Criticalf(ctx, "critical")
+
+	list := entries(t, buf)
+	if len(list) != 3 {
+		t.Fatalf("got %d entries, want 3", len(list))
+	}
+
+	if list[0].Severity != "WARNING" || list[0].Message != "warning 1" || list[2].Severity != "CRITICAL" {
+		t.Errorf("got %+v", list)
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	buf := capture(t, LevelDebug)
+	SetSecrets("hunter2", "", "sk_live_123")
+	Infof(context.Background(), "password %s and key %s", "hunter2", "sk_live_123")
+
+	list := entries(t, buf)
+	if len(list) != 1 || list[0].Message != "password [REDACTED] and key [REDACTED]" {
+		t.Errorf("got %+v", list)
+	}
+}
+
+func TestRequestCorrelation(t *testing.T) {
+	buf := capture(t, LevelDebug)
+	route := ""
+	info := NewRequestInfo("req-1", "POST", "/km/product/5", func() string { return route })
+	ctx := WithRequest(context.Background(), info)
+
+	Infof(ctx, "before routing")
+	route = "/km/product/:id"
+	SetUser(ctx, "admin@shop.com")
+	Infof(ctx, "after routing")
+	SetUser(context.Background(), "nobody")
+	Infof(context.Background(), "no request")
+
+	list := entries(t, buf)
+	if len(list) != 3 {
+		t.Fatalf("got %d entries", len(list))
+	}
+
+	if list[0].RequestID != "req-1" || list[0].Method != "POST" || list[0].Route != "/km/product/5" || list[0].User != "" {
+		t.Errorf("got %+v", list[0])
+	}
+
+	if list[1].Route != "/km/product/:id" || list[1].User != "admin@shop.com" {
+		t.Errorf("got %+v", list[1])
+	}
+
+	if list[2].RequestID != "" || list[2].User != "" {
+		t.Errorf("got %+v", list[2])
+	}
+}
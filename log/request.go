@@ -0,0 +1,73 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+type requestKey struct{}
+
+// RequestInfo identifies the request a log entry belongs to. The route is resolved
+// lazily because the router only knows it after the root middleware has run.
+type RequestInfo struct {
+	ID     string
+	Method string
+	Path   string
+
+	mu      sync.Mutex
+	routeFn func() string
+	user    string
+}
+
+func NewRequestInfo(id string, method string, path string, routeFn func() string) *RequestInfo {
+	return &RequestInfo{
+		ID:      id,
+		Method:  method,
+		Path:    path,
+		routeFn: routeFn,
+	}
+}
+
+// Route returns the matched route pattern, or the raw path if the request has not been routed yet.
+func (r *RequestInfo) Route() string {
+	if r.routeFn != nil {
+		if route := r.routeFn(); route != "" {
+			return route
+		}
+	}
+
+	return r.Path
+}
+
+func (r *RequestInfo) User() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.user
+}
+
+// WithRequest returns a copy of ctx that carries info. Every message logged with the
+// returned context is tagged with the request id, route and user.
+func WithRequest(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestKey{}, info)
+}
+
+func RequestFromContext(ctx context.Context) *RequestInfo {
+	if ctx == nil {
+		return nil
+	}
+
+	info, _ := ctx.Value(requestKey{}).(*RequestInfo)
+	return info
+}
+
+// SetUser records the authenticated user on the request linked with ctx.
+func SetUser(ctx context.Context, user string) {
+	info := RequestFromContext(ctx)
+	if info == nil {
+		return
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.user = user
+}
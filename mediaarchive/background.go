@@ -0,0 +1,163 @@
+package mediaarchive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/storage"
+	"github.com/jcarm010/kodimerce/uploads"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// exportRequest marks that an export was requested, the export-media job picks it up.
+const exportRequest = ExportsPrefix + "requested"
+
+// RequestExport asks the export-media job to export the media library to storage, for libraries
+// too large to export within a request.
+func RequestExport(ctx context.Context) error {
+	return storage.PutObject(ctx, exportRequest, strings.NewReader(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// ExportRequested runs the export asked for with RequestExport, if any. It returns nil when
+// none was.
+func ExportRequested(ctx context.Context) (*ExportResult, error) {
+	_, err := storage.GetObject(exportRequest).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ExportToStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.DeleteObject(ctx, exportRequest)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ExportToStorage exports the media library to an archive in ExportsPrefix.
+func ExportToStorage(ctx context.Context) (*ExportResult, error) {
+	objectName := ExportsPrefix + "media-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// cancelling the context before closing the writer discards what was written
+	w := storage.NewWriter(ctx, objectName)
+	result, err := Export(ctx, w)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	result.Archive = objectName
+	return result, nil
+}
+
+// QueueImport stores an archive to be imported by ImportQueued.
+func QueueImport(ctx context.Context, id string, r io.Reader) error {
+	return storage.PutObject(ctx, ImportsPrefix+id+".zip", r)
+}
+
+// ImportQueued imports the archives stored by QueueImport, leaving the result of each import
+// where GetImportResult finds it.
+func ImportQueued(ctx context.Context, policy *uploads.Policy) (interface{}, error) {
+	objects, err := storage.ListObjects(ctx, ImportsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := make([]*ImportResult, 0)
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Name, ".zip") {
+			continue
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(object.Name, ImportsPrefix), ".zip")
+		result, err := importObject(ctx, object.Name, policy)
+		if err != nil {
+			log.Errorf(ctx, "Error importing archive %s: %+v", id, err)
+			result = &ImportResult{Error: err.Error(), Finished: time.Now()}
+		}
+
+		result.Id = id
+		bts, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.PutObject(ctx, ImportsPrefix+id+".json", bytes.NewReader(bts))
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.DeleteObject(ctx, object.Name)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return nil, err
+		}
+
+		imported = append(imported, result)
+	}
+
+	return imported, nil
+}
+
+func importObject(ctx context.Context, objectName string, policy *uploads.Policy) (*ImportResult, error) {
+	rs, _, err := storage.NewObjectReadSeeker(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rs.Close()
+	}()
+
+	return Import(ctx, rs, rs.Size(), policy)
+}
+
+// GetImportResult returns the result of a background import, nil while it is queued. It fails
+// with storage.ErrObjectNotExist when there is no such import.
+func GetImportResult(ctx context.Context, id string) (*ImportResult, error) {
+	rc, err := storage.GetObject(ImportsPrefix + id + ".json").NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		_, err = storage.GetObject(ImportsPrefix + id + ".zip").Attrs(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	bts, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{}
+	return result, json.Unmarshal(bts, result)
+}
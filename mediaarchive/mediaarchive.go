@@ -0,0 +1,344 @@
+// Package mediaarchive moves the media library between stores as a zip archive: every uploaded
+// file under files/<key>/<filename> and a manifest.json with the upload records, so imported
+// uploads keep their keys, file names and metadata and the pages linking to them keep working.
+package mediaarchive
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/search_api"
+	"github.com/jcarm010/kodimerce/storage"
+	"github.com/jcarm010/kodimerce/uploads"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ManifestName    = "manifest.json"
+	ManifestVersion = 1
+	FilesPrefix     = "files/"
+
+	// ExportsPrefix is where background exports store their archives.
+	ExportsPrefix = "media-exports/"
+
+	// ImportsPrefix is where archives wait to be imported in the background, as <id>.zip, and
+	// where the result of each import is left, as <id>.json.
+	ImportsPrefix = "media-imports/"
+)
+
+var ErrInvalidArchive = errors.New("The file is not a zip archive.")
+
+// uploadStore is where keepKey records the keys of uploads that were already stored.
+type uploadStore interface {
+	GetUpload(ctx context.Context, key string) (*search_api.BlobInfo, error)
+	PutUpload(ctx context.Context, info *search_api.BlobInfo) error
+}
+
+type datastoreUploads struct{}
+
+func (datastoreUploads) GetUpload(ctx context.Context, key string) (*search_api.BlobInfo, error) {
+	return entities.GetUpload(ctx, key)
+}
+
+func (datastoreUploads) PutUpload(ctx context.Context, info *search_api.BlobInfo) error {
+	return entities.PutUpload(ctx, info)
+}
+
+// Manifest lists the uploads in an archive.
+type Manifest struct {
+	Version  int              `json:"version"`
+	Exported time.Time        `json:"exported"`
+	Uploads  []*ManifestEntry `json:"uploads"`
+}
+
+// ManifestEntry is the record of an upload along with where its file is in the archive.
+type ManifestEntry struct {
+	Path string `json:"path"`
+	*search_api.BlobInfo
+}
+
+type ExportResult struct {
+	Archive string   `json:"archive,omitempty"` // object name, for exports stored in the background
+	Uploads int      `json:"uploads"`
+	Missing []string `json:"missing"` // keys of the uploads whose file isn't stored
+}
+
+type ImportResult struct {
+	Id         string               `json:"id,omitempty"`
+	Imported   int                  `json:"imported"`
+	Duplicates int                  `json:"duplicates"` // files that were already uploaded
+	Rejected   []*uploads.Rejection `json:"rejected"`
+	Error      string               `json:"error,omitempty"` // why a background import didn't run
+	Finished   time.Time            `json:"finished"`
+}
+
+// Export writes every upload and the manifest to w as a zip archive. Uploads whose file is
+// missing are left out of the archive.
+func Export(ctx context.Context, w io.Writer) (*ExportResult, error) {
+	blobs, err := entities.ListAllUploads(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].CreationTime.Before(blobs[j].CreationTime)
+	})
+
+	result := &ExportResult{Missing: make([]string, 0)}
+	manifest := &Manifest{Version: ManifestVersion, Exported: time.Now(), Uploads: make([]*ManifestEntry, 0, len(blobs))}
+	zw := zip.NewWriter(w)
+	for _, blob := range blobs {
+		entry := &ManifestEntry{Path: entryPath(blob), BlobInfo: blob}
+		err = exportFile(ctx, zw, entry)
+		if err == storage.ErrObjectNotExist {
+			log.Warningf(ctx, "Upload %s has no stored file, leaving it out of the export", blob.BlobKey)
+			result.Missing = append(result.Missing, blob.BlobKey)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Uploads = append(manifest.Uploads, entry)
+	}
+
+	fw, err := zw.Create(ManifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := json.NewEncoder(fw)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Uploads = len(manifest.Uploads)
+	return result, zw.Close()
+}
+
+func exportFile(ctx context.Context, zw *zip.Writer, entry *ManifestEntry) error {
+	rc, err := storage.GetObject(entry.ObjectName).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     entry.Path,
+		Method:   compression(entry.ContentType),
+		Modified: entry.CreationTime,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, rc)
+	return err
+}
+
+// compression stores media as it is, it is compressed already.
+func compression(contentType string) uint16 {
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/pdf"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return zip.Store
+		}
+	}
+
+	return zip.Deflate
+}
+
+func entryPath(blob *search_api.BlobInfo) string {
+	filename := blob.Filename
+	if filename == "" {
+		filename = path.Base(blob.ObjectName)
+	}
+
+	return FilesPrefix + blob.BlobKey + "/" + filename
+}
+
+// Import stores every file in a zip archive as an upload, going through the upload policy like
+// any other upload. Files listed in the manifest keep their key, unless another upload has it,
+// along with their file name and metadata, even when they were already uploaded under another
+// key. Other files are named after their entry.
+func Import(ctx context.Context, r io.ReaderAt, size int64, policy *uploads.Policy) (*ImportResult, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+
+	entries := map[string]*ManifestEntry{}
+	for _, f := range zr.File {
+		if f.Name != ManifestName {
+			continue
+		}
+
+		manifest, err := readManifest(f)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range manifest.Uploads {
+			if entry.BlobInfo != nil {
+				entries[entry.Path] = entry
+			}
+		}
+	}
+
+	result := &ImportResult{Rejected: make([]*uploads.Rejection, 0)}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || f.Name == ManifestName || hidden(f.Name) {
+			continue
+		}
+
+		upload := &search_api.BlobInfo{Filename: path.Base(f.Name)}
+		if entry := entries[f.Name]; entry != nil {
+			upload = &search_api.BlobInfo{
+				BlobKey:      entry.BlobKey,
+				Filename:     entry.Filename,
+				CreationTime: entry.CreationTime,
+				Title:        entry.Title,
+				Alt:          entry.Alt,
+				Caption:      entry.Caption,
+				Folder:       uploads.NormalizeFolder(entry.Folder),
+				Tags:         entry.Tags,
+			}
+		}
+
+		if upload.Filename == "" {
+			upload.Filename = path.Base(f.Name)
+		}
+
+		stored, rejection, err := importFile(ctx, f, upload, policy)
+		if err != nil {
+			log.Errorf(ctx, "Error importing %s: %+v", f.Name, err)
+			rejection = &uploads.Rejection{Reason: uploads.ReasonStoreFailed, Message: "The file could not be stored."}
+		}
+
+		switch {
+		case rejection != nil:
+			rejection.Filename = f.Name
+			result.Rejected = append(result.Rejected, rejection)
+		case stored.Duplicate:
+			result.Duplicates++
+			if entry := entries[f.Name]; entry != nil && entry.BlobKey != "" {
+				err = keepKey(ctx, datastoreUploads{}, upload, stored)
+				if err != nil {
+					log.Errorf(ctx, "Error keeping key %s of %s: %+v", upload.BlobKey, f.Name, err)
+				}
+			}
+		default:
+			result.Imported++
+		}
+	}
+
+	result.Finished = time.Now()
+	return result, nil
+}
+
+// keepKey records an upload under the key an archive gave a file that was already uploaded
+// as existing, sharing its stored file, so the urls with that key keep working. Keys another
+// upload has are left alone.
+func keepKey(ctx context.Context, store uploadStore, upload *search_api.BlobInfo, existing *search_api.BlobInfo) error {
+	if upload.BlobKey == existing.BlobKey {
+		return nil
+	}
+
+	_, err := store.GetUpload(ctx, upload.BlobKey)
+	if err == nil {
+		return nil
+	}
+
+	if err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	alias := *upload
+	alias.Filename = uploads.SanitizeFilename(upload.Filename)
+	alias.ObjectName = existing.ObjectName
+	alias.ContentType = existing.ContentType
+	alias.MD5 = existing.MD5
+	alias.Size = existing.Size
+	alias.Width = existing.Width
+	alias.Height = existing.Height
+	if alias.CreationTime.IsZero() {
+		alias.CreationTime = time.Now()
+	}
+
+	return store.PutUpload(ctx, &alias)
+}
+
+func importFile(ctx context.Context, f *zip.File, upload *search_api.BlobInfo, policy *uploads.Policy) (*search_api.BlobInfo, *uploads.Rejection, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	// the declared size can't be trusted, so reading stops past the largest size allowed
+	var reader io.Reader = rc
+	maxSize := policy.MaxSize()
+	if maxSize > 0 {
+		reader = io.LimitReader(rc, maxSize+1)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, &uploads.Rejection{Reason: uploads.ReasonTooLarge, Message: fmt.Sprintf("The file is larger than %d bytes.", maxSize)}, nil
+	}
+
+	return policy.Store(ctx, upload, data)
+}
+
+func readManifest(f *zip.File) (*Manifest, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	manifest := &Manifest{}
+	err = json.NewDecoder(rc).Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %s", err)
+	}
+
+	return manifest, nil
+}
+
+// hidden tells whether an entry is one of the files archivers add, such as __MACOSX/ or .DS_Store.
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+
+	return false
+}
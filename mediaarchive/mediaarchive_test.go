@@ -0,0 +1,150 @@
+package mediaarchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/search_api"
+	"github.com/jcarm010/kodimerce/uploads"
+	"testing"
+	"time"
+)
+
+type fakeUploads map[string]*search_api.BlobInfo
+
+func (f fakeUploads) GetUpload(ctx context.Context, key string) (*search_api.BlobInfo, error) {
+	if key == "broken" {
+		return nil, errors.New("datastore down")
+	}
+
+	if blob := f[key]; blob != nil {
+		return blob, nil
+	}
+
+	return nil, datastore.ErrNoSuchEntity
+}
+
+func (f fakeUploads) PutUpload(ctx context.Context, info *search_api.BlobInfo) error {
+	f[info.BlobKey] = info
+	return nil
+}
+
+func TestKeepKey(t *testing.T) {
+	existing := &search_api.BlobInfo{
+		BlobKey:     "existing",
+		Filename:    "photo.jpg",
+		ObjectName:  uploads.Prefix + "existing/photo.jpg",
+		ContentType: "image/jpeg",
+		MD5:         "abc",
+		Size:        100,
+		Width:       4,
+		Height:      3,
+	}
+
+	taken := &search_api.BlobInfo{BlobKey: "taken", ObjectName: uploads.Prefix + "taken/other.png"}
+	stored := fakeUploads{"existing": existing, "taken": taken}
+
+	ctx := context.Background()
+	created := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	err := keepKey(ctx, stored, &search_api.BlobInfo{BlobKey: "manifest", Filename: "my photo.jpg", Title: "Beach", CreationTime: created}, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := stored["manifest"]
+	if alias == nil {
+		t.Fatal("the manifest key wasn't recorded")
+	}
+
+	if alias.ObjectName != existing.ObjectName || alias.MD5 != "abc" || alias.Size != 100 || alias.Width != 4 || alias.ContentType != "image/jpeg" {
+		t.Errorf("the alias doesn't share the file: %+v", alias)
+	}
+
+	if alias.Title != "Beach" || alias.Filename != uploads.SanitizeFilename("my photo.jpg") || !alias.CreationTime.Equal(created) {
+		t.Errorf("the alias lost the manifest metadata: %+v", alias)
+	}
+
+	err = keepKey(ctx, stored, &search_api.BlobInfo{BlobKey: "taken", Filename: "photo.jpg"}, existing)
+	if err != nil || stored["taken"] != taken {
+		t.Errorf("a taken key was replaced: %v", err)
+	}
+
+	err = keepKey(ctx, stored, &search_api.BlobInfo{BlobKey: "existing"}, existing)
+	if err != nil || stored["existing"] != existing {
+		t.Errorf("the existing upload was replaced: %v", err)
+	}
+
+	err = keepKey(ctx, stored, &search_api.BlobInfo{BlobKey: "broken"}, existing)
+	if err == nil {
+		t.Error("a failed lookup wasn't returned")
+	}
+}
+
+func TestImportRejectsFiles(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	manifest := &Manifest{Version: ManifestVersion, Uploads: []*ManifestEntry{
+		{Path: FilesPrefix + "key1/notes.html", BlobInfo: &search_api.BlobInfo{BlobKey: "key1", Filename: "notes.html"}},
+	}}
+
+	for name, content := range map[string]string{
+		FilesPrefix + "key1/notes.html": "<html><body>not allowed</body></html>",
+		"__MACOSX/._notes.html":         "ignored",
+		".DS_Store":                     "ignored",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = w.Write([]byte(content))
+	}
+
+	w, err := zw.Create(ManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.NewEncoder(w).Encode(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &uploads.Policy{AllowedTypes: uploads.DefaultAllowedTypes, MaxSizes: uploads.DefaultMaxSizes}
+	result, err := Import(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Imported != 0 || result.Duplicates != 0 || len(result.Rejected) != 1 {
+		t.Fatalf("got %+v", result)
+	}
+
+	rejection := result.Rejected[0]
+	if rejection.Filename != FilesPrefix+"key1/notes.html" || rejection.Reason != uploads.ReasonTypeNotAllowed {
+		t.Errorf("got %+v", rejection)
+	}
+}
+
+func TestImportInvalidArchive(t *testing.T) {
+	data := []byte("not a zip")
+	_, err := Import(context.Background(), bytes.NewReader(data), int64(len(data)), &uploads.Policy{})
+	if err != ErrInvalidArchive {
+		t.Errorf("got %v, want ErrInvalidArchive", err)
+	}
+}
+
+func TestEntryPath(t *testing.T) {
+	blob := &search_api.BlobInfo{BlobKey: "key1", Filename: "photo.jpg", ObjectName: uploads.Prefix + "key1/photo.jpg"}
+	if got := entryPath(blob); got != FilesPrefix+"key1/photo.jpg" {
+		t.Errorf("got %s", got)
+	}
+}
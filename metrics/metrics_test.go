@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func text(c collector) string {
+	var buf bytes.Buffer
+	c.write(&buf)
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "A test counter.", "outcome")
+	c.Inc("sent")
+	c.Add(2, "sent")
+	c.Add(-5, "sent")
+	c.Inc("failed")
+
+	want := "# HELP test_counter_total A test counter.\n" +
+		"# TYPE test_counter_total counter\n" +
+		"test_counter_total{outcome=\"failed\"} 1\n" +
+		"test_counter_total{outcome=\"sent\"} 3\n"
+	if got := text(c); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "A test histogram.", []float64{0.1, 1}, "op")
+	h.Observe(0.05, "get")
+	h.Observe(0.5, "get")
+	h.Observe(3, "get")
+
+	want := "# HELP test_duration_seconds A test histogram.\n" +
+		"# TYPE test_duration_seconds histogram\n" +
+		"test_duration_seconds_bucket{op=\"get\",le=\"0.1\"} 1\n" +
+		"test_duration_seconds_bucket{op=\"get\",le=\"1\"} 2\n" +
+		"test_duration_seconds_bucket{op=\"get\",le=\"+Inf\"} 3\n" +
+		"test_duration_seconds_sum{op=\"get\"} 3.55\n" +
+		"test_duration_seconds_count{op=\"get\"} 3\n"
+	if got := text(h); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatLabelsEscapesValues(t *testing.T) {
+	got := formatLabels([]string{"route"}, []string{"/a\"b"}, "", "")
+	if want := `{route="/a\"b"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	got = formatLabels([]string{"path"}, []string{"C:\\a\tb\nc é"}, "le", "+Inf")
+	if want := `{path="C:\\a` + "\t" + `b\nc é",le="+Inf"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if got := formatLabels(nil, nil, "", ""); got != "" {
+		t.Errorf("got %q for no labels", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewCounterVec("test_handler_total", "Served by the handler.").Inc()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	if !strings.Contains(w.Body.String(), "test_handler_total 1\n") {
+		t.Errorf("the handler did not expose the counter:\n%s", w.Body.String())
+	}
+}
+
+func TestObserveDatastoreIgnoresExpectedErrors(t *testing.T) {
+	notFound := errors.New("Not found.")
+	ObserveDatastore("test_get", time.Now(), notFound, notFound)
+	ObserveDatastore("test_get", time.Now(), errors.New("Unavailable."), notFound)
+	ObserveDatastore("test_get", time.Now(), nil)
+
+	if !strings.Contains(text(DatastoreCalls), `km_datastore_calls_total{op="test_get"} 3`) {
+		t.Errorf("calls not counted:\n%s", text(DatastoreCalls))
+	}
+
+	if !strings.Contains(text(DatastoreErrors), `km_datastore_errors_total{op="test_get"} 1`) {
+		t.Errorf("errors not counted once:\n%s", text(DatastoreErrors))
+	}
+}
+
+func TestInstrumentTransportCountsServerErrors(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: InstrumentTransport("test_service", nil)}
+	for _, status = range []int{http.StatusOK, http.StatusNotFound, http.StatusBadGateway} {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	if !strings.Contains(text(OutboundDuration), `km_outbound_request_duration_seconds_count{service="test_service"} 3`) {
+		t.Errorf("calls not observed:\n%s", text(OutboundDuration))
+	}
+
+	if !strings.Contains(text(OutboundFailures), `km_outbound_request_failures_total{service="test_service"} 1`) {
+		t.Errorf("only the 5xx response should count as a failure:\n%s", text(OutboundFailures))
+	}
+}
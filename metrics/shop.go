@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	HTTPRequests = NewCounterVec("km_http_requests_total", "HTTP requests by route and status.", "method", "route", "status")
+	HTTPDuration = NewHistogramVec("km_http_request_duration_seconds", "HTTP request latency by route.", DefaultBuckets, "method", "route")
+
+	DatastoreCalls    = NewCounterVec("km_datastore_calls_total", "Datastore calls by operation.", "op")
+	DatastoreErrors   = NewCounterVec("km_datastore_errors_total", "Datastore calls that failed by operation.", "op")
+	DatastoreDuration = NewHistogramVec("km_datastore_call_duration_seconds", "Datastore call latency by operation.", DefaultBuckets, "op")
+
+	OutboundDuration = NewHistogramVec("km_outbound_request_duration_seconds", "Latency of calls to third party services.", DefaultBuckets, "service")
+	OutboundFailures = NewCounterVec("km_outbound_request_failures_total", "Failed calls to third party services.", "service")
+
+	EmailsSent = NewCounterVec("km_emails_total", "Emails by outcome.", "outcome")
+
+	OrdersCreated = NewCounterVec("km_orders_created_total", "Orders created.")
+	OrdersPaid    = NewCounterVec("km_orders_paid_total", "Orders paid.")
+	RevenueCents  = NewCounterVec("km_revenue_cents_total", "Revenue of paid orders in cents, taxes included.")
+
+	AbandonedCheckoutEmails = NewCounterVec("km_abandoned_checkout_emails_total", "Abandoned checkout reminders by outcome.", "outcome")
+	OrdersRecovered         = NewCounterVec("km_orders_recovered_total", "Orders paid after an abandoned checkout reminder.")
+
+	JobRuns     = NewCounterVec("km_job_runs_total", "Background job runs by outcome.", "job", "outcome")
+	JobDuration = NewHistogramVec("km_job_duration_seconds", "Background job run time.", []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600}, "job")
+)
+
+// ObserveDatastore records the outcome of a datastore call that started at start.
+// Pass ignore for errors that are part of normal operation, such as a missing entity.
+func ObserveDatastore(op string, start time.Time, err error, ignore ...error) {
+	DatastoreCalls.Inc(op)
+	DatastoreDuration.Observe(time.Since(start).Seconds(), op)
+	if err == nil {
+		return
+	}
+
+	for _, ignored := range ignore {
+		if err == ignored {
+			return
+		}
+	}
+
+	DatastoreErrors.Inc(op)
+}
+
+// ObserveOutbound records the outcome of a call to a third party service that started at start.
+func ObserveOutbound(service string, start time.Time, err error) {
+	OutboundDuration.Observe(time.Since(start).Seconds(), service)
+	if err != nil {
+		OutboundFailures.Inc(service)
+	}
+}
+
+// InstrumentTransport wraps base so every request made through it is recorded as an
+// outbound call to service. Responses with a 5xx status count as failures.
+func InstrumentTransport(service string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &instrumentedTransport{service: service, base: base}
+}
+
+type instrumentedTransport struct {
+	service string
+	base    http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	failed := err
+	if err == nil && resp.StatusCode >= 500 {
+		failed = &statusError{status: resp.StatusCode}
+	}
+
+	ObserveOutbound(t.service, start, failed)
+	return resp, err
+}
+
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return "status " + strconv.Itoa(e.status)
+}
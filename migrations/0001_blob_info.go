@@ -0,0 +1,101 @@
+package migrations
+
+import (
+	originalDataStore "cloud.google.com/go/datastore"
+	"context"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/search_api"
+	"google.golang.org/api/iterator"
+)
+
+// Kinds the App Engine blobstore migration left behind.
+const (
+	entityBlobInfo       = "__BlobInfo__"
+	entityBlobKeyMapping = "_blobmigrator_BlobKeyMapping"
+)
+
+type blobKeyMapping struct {
+	FileName   string `datastore:"gcs_filename"`
+	NewBlobKey string `datastore:"new_blob_key"`
+	OldBlobKey string `datastore:"old_blob_key"`
+}
+
+func init() {
+	Register(&Migration{
+		Version:     1,
+		Name:        "blob-info",
+		Description: "Copies the blobstore records into file_uploads, pointing them at the files the blobstore migration moved to cloud storage.",
+		Step:        migrateBlobInfo,
+	})
+}
+
+func migrateBlobInfo(ctx context.Context, cursor string, dryRun bool) (*Batch, error) {
+	query, err := batchQuery(entityBlobInfo, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &Batch{}
+	t := datastore.Run(ctx, query)
+	for {
+		var blobInfo search_api.BlobInfo
+		key, err := t.Next(&blobInfo)
+		if err == iterator.Done {
+			break
+		}
+
+		if _, mismatch := err.(*originalDataStore.ErrFieldMismatch); err != nil && !mismatch {
+			return nil, err
+		}
+
+		batch.Processed++
+		mappings := make([]blobKeyMapping, 0)
+		_, err = datastore.GetAll(ctx, datastore.NewQuery(entityBlobKeyMapping).Filter("old_blob_key =", key.Name).Limit(1), &mappings)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(mappings) == 0 {
+			log.Infof(ctx, "No key mapping for blob %s", key.Name)
+			continue
+		}
+
+		mapping := mappings[0]
+		_, err = entities.GetUpload(ctx, mapping.OldBlobKey)
+		if err == nil {
+			// migrated already, it may have been edited since
+			continue
+		}
+
+		if err != datastore.ErrNoSuchEntity {
+			return nil, err
+		}
+
+		batch.Changed++
+		if dryRun {
+			continue
+		}
+
+		err = entities.PutUpload(ctx, &search_api.BlobInfo{
+			BlobKey:      mapping.OldBlobKey,
+			OldBlobKey:   mapping.OldBlobKey,
+			NewBlobKey:   mapping.NewBlobKey,
+			ContentType:  blobInfo.ContentType,
+			CreationTime: blobInfo.CreationTime,
+			Filename:     blobInfo.Filename,
+			Size:         blobInfo.Size,
+			MD5:          blobInfo.MD5,
+			UploadId:     blobInfo.UploadId,
+			ObjectName:   mapping.FileName,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	batch.Next, err = nextCursor(t, batch.Processed)
+	return batch, err
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	Register(&Migration{
+		Version:     2,
+		Name:        "category-defaults",
+		Description: "Stores the thumbnail and path categories made before they existed were given on every read.",
+		Step:        migrateCategoryDefaults,
+	})
+}
+
+func migrateCategoryDefaults(ctx context.Context, cursor string, dryRun bool) (*Batch, error) {
+	query, err := batchQuery(entities.EntityCategory, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &Batch{}
+	t := datastore.Run(ctx, query)
+	for {
+		var category entities.Category
+		key, err := t.Next(&category)
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		batch.Processed++
+		if category.Thumbnail != "" && category.Path != "" {
+			continue
+		}
+
+		batch.Changed++
+		if dryRun {
+			continue
+		}
+
+		category.Id = key.ID
+		category.SetMissingDefaults()
+		err = entities.UpdateCategory(ctx, &category)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	batch.Next, err = nextCursor(t, batch.Processed)
+	return batch, err
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	Register(&Migration{
+		Version:     3,
+		Name:        "post-updated-date",
+		Description: "Stores the updated date of posts that were never edited, their published date.",
+		Step:        migratePostUpdatedDate,
+	})
+}
+
+func migratePostUpdatedDate(ctx context.Context, cursor string, dryRun bool) (*Batch, error) {
+	query, err := batchQuery(entities.EntityPost, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &Batch{}
+	t := datastore.Run(ctx, query)
+	for {
+		var post entities.Post
+		key, err := t.Next(&post)
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		batch.Processed++
+		if !post.UpdatedDate.IsZero() || post.PublishedDate.IsZero() {
+			continue
+		}
+
+		batch.Changed++
+		if dryRun {
+			continue
+		}
+
+		post.Id = key.ID
+		post.SetMissingDefaults()
+		err = entities.StorePost(ctx, &post)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	batch.Next, err = nextCursor(t, batch.Processed)
+	return batch, err
+}
@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/uploads"
+	"google.golang.org/api/iterator"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(&Migration{
+		Version:     4,
+		Name:        "upload-references",
+		Description: "Records the uploads every product, post, page and gallery shows, so deleting an upload doesn't read them all.",
+		Step:        migrateUploadReferences,
+	})
+}
+
+// referenceKinds are the kinds that show uploads, in the order the migration goes through them.
+var referenceKinds = []struct {
+	kind          string
+	referenceType string
+}{
+	{entities.EntityProduct, uploads.ReferenceProduct},
+	{entities.EntityPost, uploads.ReferencePost},
+	{entities.EntityPage, uploads.ReferencePage},
+	{entities.EntityGallery, uploads.ReferenceGallery},
+}
+
+// migrateUploadReferences goes through the kinds one after the other. Its cursors are the index of
+// the kind and the cursor within it, "<index>:<cursor>".
+func migrateUploadReferences(ctx context.Context, cursor string, dryRun bool) (*Batch, error) {
+	index, kindCursor := 0, ""
+	if cursor != "" {
+		parts := strings.SplitN(cursor, ":", 2)
+		var err error
+		index, err = strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || index < 0 || index >= len(referenceKinds) {
+			return nil, fmt.Errorf("Invalid cursor %q.", cursor)
+		}
+
+		kindCursor = parts[1]
+	}
+
+	kind := referenceKinds[index]
+	query, err := batchQuery(kind.kind, kindCursor)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &Batch{}
+	t := datastore.Run(ctx, query.KeysOnly())
+	for {
+		key, err := t.Next(nil)
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		batch.Processed++
+		batch.Changed++
+		if dryRun {
+			continue
+		}
+
+		err = uploads.IndexReferences(ctx, kind.referenceType, key.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	next, err := nextCursor(t, batch.Processed)
+	if err != nil {
+		return nil, err
+	}
+
+	if next != "" {
+		batch.Next = fmt.Sprintf("%d:%s", index, next)
+	} else if index+1 < len(referenceKinds) {
+		batch.Next = fmt.Sprintf("%d:", index+1)
+	}
+
+	return batch, nil
+}
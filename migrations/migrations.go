@@ -0,0 +1,257 @@
+// Package migrations brings stored data up to date with the code. Migrations are numbered and run
+// in order, each one once: the schema_version entity remembers the last one applied. A migration
+// works through its entities in batches and the cursor of the next batch is saved after every
+// batch, so a migration that is interrupted resumes where it stopped. Migrations must be
+// idempotent all the same, a batch may run twice when saving its cursor fails.
+package migrations
+
+import (
+	originalDataStore "cloud.google.com/go/datastore"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/datastore"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"sort"
+	"sync"
+)
+
+// BatchSize is how many entities a migration step goes through.
+var BatchSize = 100
+
+var ErrInvalidVersion = errors.New("Invalid migration version.")
+
+// Migration changes stored data from one schema version to the next.
+type Migration struct {
+	Version     int    `json:"version"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Step migrates the batch of entities that starts at cursor, the first batch when it is empty.
+	// With dryRun it only counts the entities it would change.
+	Step func(ctx context.Context, cursor string, dryRun bool) (*Batch, error) `json:"-"`
+}
+
+// Batch is what a step did.
+type Batch struct {
+	Next      string // cursor of the next batch, empty after the last batch
+	Processed int
+	Changed   int
+}
+
+type Options struct {
+	DryRun bool `json:"dry_run"` // count what would change without changing it
+	To     int  `json:"to"`      // last migration to apply, 0 applies them all
+	// MaxBatches stops the run after that many batches, 0 doesn't. The next run resumes where it
+	// stopped, except for dry runs, which always start over and only count the batches they ran.
+	MaxBatches int `json:"max_batches"`
+}
+
+type Result struct {
+	From       int                `json:"from"`
+	To         int                `json:"to"`
+	DryRun     bool               `json:"dry_run"`
+	Complete   bool               `json:"complete"` // false when the run stopped at MaxBatches
+	Migrations []*MigrationResult `json:"migrations"`
+}
+
+type MigrationResult struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Processed int    `json:"processed"`
+	Changed   int    `json:"changed"`
+	Resumed   bool   `json:"resumed"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[int]*Migration{}
+)
+
+// versionStore keeps the schema version.
+type versionStore interface {
+	GetSchemaVersion(ctx context.Context) (*entities.SchemaVersion, error)
+	PutSchemaVersion(ctx context.Context, version *entities.SchemaVersion) error
+}
+
+type datastoreVersions struct{}
+
+func (datastoreVersions) GetSchemaVersion(ctx context.Context) (*entities.SchemaVersion, error) {
+	return entities.GetSchemaVersion(ctx)
+}
+
+func (datastoreVersions) PutSchemaVersion(ctx context.Context, version *entities.SchemaVersion) error {
+	return entities.PutSchemaVersion(ctx, version)
+}
+
+type optionsKey struct{}
+
+// Register adds a migration. Versions are unique, registering one twice is a bug.
+func Register(migration *Migration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[migration.Version]; exists || migration.Version <= 0 {
+		panic(fmt.Sprintf("migrations: invalid or duplicate version %d", migration.Version))
+	}
+
+	registry[migration.Version] = migration
+}
+
+// List returns the registered migrations in the order they run.
+func List() []*Migration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]*Migration, 0, len(registry))
+	for _, migration := range registry {
+		list = append(list, migration)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list
+}
+
+// Latest is the version the data is at once every migration ran.
+func Latest() int {
+	list := List()
+	if len(list) == 0 {
+		return 0
+	}
+
+	return list[len(list)-1].Version
+}
+
+// WithOptions passes options to the migrate job, which reads them with OptionsFrom.
+func WithOptions(ctx context.Context, options Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, options)
+}
+
+func OptionsFrom(ctx context.Context) Options {
+	options, _ := ctx.Value(optionsKey{}).(Options)
+	return options
+}
+
+// Run applies the migrations past the schema version, up to options.To. A dry run goes through
+// the same batches without changing data or the schema version, so it doesn't see what earlier
+// migrations of the run would have changed.
+func Run(ctx context.Context, options Options) (*Result, error) {
+	return run(ctx, datastoreVersions{}, List(), options)
+}
+
+// run applies migrations, which are in the order they run.
+func run(ctx context.Context, versions versionStore, migrations []*Migration, options Options) (*Result, error) {
+	if options.To < 0 {
+		return nil, ErrInvalidVersion
+	}
+
+	state, err := versions.GetSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{From: state.Version, To: state.Version, DryRun: options.DryRun, Migrations: make([]*MigrationResult, 0)}
+	batches := 0
+	for _, migration := range migrations {
+		if migration.Version <= state.Version {
+			continue
+		}
+
+		if options.To > 0 && migration.Version > options.To {
+			break
+		}
+
+		migrationResult := &MigrationResult{Version: migration.Version, Name: migration.Name}
+		result.Migrations = append(result.Migrations, migrationResult)
+		cursor := ""
+		if !options.DryRun && state.Migrating == migration.Version {
+			cursor = state.Cursor
+			migrationResult.Resumed = cursor != ""
+			migrationResult.Processed = state.Processed
+			migrationResult.Changed = state.Changed
+		}
+
+		log.Infof(ctx, "Running migration %04d %s, dry run: %v", migration.Version, migration.Name, options.DryRun)
+		for {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+
+			if options.MaxBatches > 0 && batches >= options.MaxBatches {
+				log.Infof(ctx, "Stopping migration %04d %s after %d batches", migration.Version, migration.Name, batches)
+				return result, nil
+			}
+
+			batches++
+
+			batch, err := migration.Step(ctx, cursor, options.DryRun)
+			if err != nil {
+				return result, fmt.Errorf("migration %04d %s: %s", migration.Version, migration.Name, err)
+			}
+
+			cursor = batch.Next
+			migrationResult.Processed += batch.Processed
+			migrationResult.Changed += batch.Changed
+			if !options.DryRun {
+				state = &entities.SchemaVersion{
+					Version:   state.Version,
+					Migrating: migration.Version,
+					Cursor:    cursor,
+					Processed: migrationResult.Processed,
+					Changed:   migrationResult.Changed,
+				}
+
+				if cursor == "" {
+					state = &entities.SchemaVersion{Version: migration.Version}
+				}
+
+				err = versions.PutSchemaVersion(ctx, state)
+				if err != nil {
+					return result, err
+				}
+			}
+
+			if cursor == "" {
+				break
+			}
+		}
+
+		log.Infof(ctx, "Migration %04d %s went through %d entities and changed %d", migration.Version, migration.Name, migrationResult.Processed, migrationResult.Changed)
+		if !options.DryRun {
+			result.To = migration.Version
+		}
+	}
+
+	result.Complete = true
+	return result, nil
+}
+
+// batchQuery is the query of the batch of kind that starts at cursor.
+func batchQuery(kind string, cursor string) (*originalDataStore.Query, error) {
+	query := datastore.NewQuery(kind).Limit(BatchSize)
+	if cursor != "" {
+		start, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		query = query.Start(start)
+	}
+
+	return query, nil
+}
+
+// nextCursor is the cursor of the batch after the one t went through, empty when it was the last.
+func nextCursor(t *datastore.Iterator, processed int) (string, error) {
+	if processed < BatchSize {
+		return "", nil
+	}
+
+	cursor, err := t.Cursor()
+	if err != nil {
+		return "", err
+	}
+
+	return cursor.String(), nil
+}
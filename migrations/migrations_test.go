@@ -0,0 +1,226 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"strconv"
+	"testing"
+)
+
+// fakeMigration goes through entities entities in batches of size, changing the even ones.
+type fakeMigration struct {
+	entities int
+	size     int
+	changed  map[int]bool
+	failAt   int // cursor the step fails at, -1 never
+}
+
+func (f *fakeMigration) step(ctx context.Context, cursor string, dryRun bool) (*Batch, error) {
+	start := 0
+	if cursor != "" {
+		start, _ = strconv.Atoi(cursor)
+	}
+
+	if start == f.failAt {
+		f.failAt = -1
+		return nil, errors.New("interrupted")
+	}
+
+	batch := &Batch{}
+	for i := start; i < f.entities && i < start+f.size; i++ {
+		batch.Processed++
+		if i%2 == 0 {
+			batch.Changed++
+			if !dryRun {
+				f.changed[i] = true
+			}
+		}
+	}
+
+	if start+f.size < f.entities {
+		batch.Next = strconv.Itoa(start + f.size)
+	}
+
+	return batch, nil
+}
+
+// fakeRun runs its migrations against a schema version kept in memory.
+type fakeRun struct {
+	state      *entities.SchemaVersion
+	migrations []*Migration
+}
+
+func (f *fakeRun) GetSchemaVersion(ctx context.Context) (*entities.SchemaVersion, error) {
+	copied := *f.state
+	return &copied, nil
+}
+
+func (f *fakeRun) PutSchemaVersion(ctx context.Context, version *entities.SchemaVersion) error {
+	*f.state = *version
+	return nil
+}
+
+func (f *fakeRun) run(options Options) (*Result, error) {
+	return run(context.Background(), f, f.migrations, options)
+}
+
+func newRun(migrations ...*fakeMigration) *fakeRun {
+	f := &fakeRun{state: &entities.SchemaVersion{}}
+	for index, migration := range migrations {
+		f.migrations = append(f.migrations, &Migration{Version: index + 1, Name: "fake-" + strconv.Itoa(index+1), Step: migration.step})
+	}
+
+	return f
+}
+
+func newFake(entities int) *fakeMigration {
+	return &fakeMigration{entities: entities, size: 2, changed: map[int]bool{}, failAt: -1}
+}
+
+func TestRun(t *testing.T) {
+	first, second := newFake(5), newFake(1)
+	runner := newRun(first, second)
+	state := runner.state
+	result, err := runner.run(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Complete || result.From != 0 || result.To != 2 || len(result.Migrations) != 2 {
+		t.Fatalf("got %+v", result)
+	}
+
+	if result.Migrations[0].Processed != 5 || result.Migrations[0].Changed != 3 {
+		t.Errorf("first migration: got %+v", result.Migrations[0])
+	}
+
+	if *state != (entities.SchemaVersion{Version: 2}) {
+		t.Errorf("schema version is %+v, want version 2", *state)
+	}
+
+	result, err = runner.run(Options{})
+	if err != nil || len(result.Migrations) != 0 || !result.Complete {
+		t.Errorf("second run got %+v, %v, want nothing to do", result, err)
+	}
+}
+
+func TestRunResumesInterruptedMigration(t *testing.T) {
+	migration := newFake(5)
+	migration.failAt = 4
+	runner := newRun(migration)
+	state := runner.state
+	_, err := runner.run(Options{})
+	if err == nil {
+		t.Fatal("interrupted migration didn't fail")
+	}
+
+	if state.Migrating != 1 || state.Cursor != "4" || state.Processed != 4 {
+		t.Fatalf("schema version is %+v, want migration 1 to resume at 4", *state)
+	}
+
+	result, err := runner.run(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Migrations[0].Resumed || result.Migrations[0].Processed != 5 || result.Migrations[0].Changed != 3 {
+		t.Errorf("got %+v, want the migration resumed with its totals", result.Migrations[0])
+	}
+
+	if state.Version != 1 || state.Migrating != 0 {
+		t.Errorf("schema version is %+v", *state)
+	}
+}
+
+func TestRunStopsAtMaxBatches(t *testing.T) {
+	first, second := newFake(3), newFake(1)
+	runner := newRun(first, second)
+	state := runner.state
+	runs := 0
+	for ; runs < 10; runs++ {
+		result, err := runner.run(Options{MaxBatches: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Complete {
+			break
+		}
+	}
+
+	// two batches of the first migration, then the one of the second completes the run
+	if runs != 2 {
+		t.Errorf("took %d incomplete runs, want 2", runs)
+	}
+
+	if state.Version != 2 || len(first.changed) != 2 || len(second.changed) != 1 {
+		t.Errorf("schema version %+v, changed %v and %v", *state, first.changed, second.changed)
+	}
+}
+
+func TestDryRunChangesNothing(t *testing.T) {
+	migration := newFake(5)
+	runner := newRun(migration)
+	state := runner.state
+	result, err := runner.run(Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Migrations[0].Changed != 3 || result.To != 0 {
+		t.Errorf("got %+v", result)
+	}
+
+	if len(migration.changed) != 0 || *state != (entities.SchemaVersion{}) {
+		t.Errorf("dry run changed %v and schema version %+v", migration.changed, *state)
+	}
+}
+
+func TestDryRunStartsOver(t *testing.T) {
+	migration := newFake(5)
+	runner := newRun(migration)
+	*runner.state = entities.SchemaVersion{Migrating: 1, Cursor: "4", Processed: 4, Changed: 2}
+	result, err := runner.run(Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := result.Migrations[0]; got.Resumed || got.Processed != 5 || got.Changed != 3 {
+		t.Errorf("got %+v, want the whole migration counted from the start", got)
+	}
+
+	if *runner.state != (entities.SchemaVersion{Migrating: 1, Cursor: "4", Processed: 4, Changed: 2}) {
+		t.Errorf("dry run changed the schema version to %+v", *runner.state)
+	}
+}
+
+func TestRunTo(t *testing.T) {
+	runner := newRun(newFake(1), newFake(1))
+	state := runner.state
+	result, err := runner.run(Options{To: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Migrations) != 1 || state.Version != 1 {
+		t.Errorf("got %+v and schema version %+v, want only migration 1 applied", result, *state)
+	}
+
+	_, err = runner.run(Options{To: -1})
+	if err != ErrInvalidVersion {
+		t.Errorf("got %v, want ErrInvalidVersion", err)
+	}
+}
+
+func TestRegisteredMigrations(t *testing.T) {
+	for index, migration := range List() {
+		if migration.Version != index+1 {
+			t.Errorf("migration %s has version %d, want %d", migration.Name, migration.Version, index+1)
+		}
+	}
+
+	if Latest() != len(List()) {
+		t.Errorf("latest is %d with %d migrations", Latest(), len(List()))
+	}
+}
@@ -0,0 +1,101 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/settings"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// store is what notifying touches: the settings, the email queue and the order.
+type store interface {
+	GetGlobalSettings(ctx context.Context) entities.ServerSettings
+	EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error)
+	UpdateOrder(ctx context.Context, order *entities.Order) error
+}
+
+type datastoreStore struct{}
+
+func (datastoreStore) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return settings.GetGlobalSettings(ctx)
+}
+
+func (datastoreStore) EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	return emailer.EnqueueTemplate(ctx, name, data, from, to, bcc)
+}
+
+func (datastoreStore) UpdateOrder(ctx context.Context, order *entities.Order) error {
+	return entities.UpdateOrder(ctx, order)
+}
+
+// TemplateForEvent returns the email template customers get for event. The paid event keeps
+// using the original order confirmation.
+func TemplateForEvent(event string) string {
+	if event == entities.OrderEventPaid {
+		return "email-order"
+	}
+
+	return "email-order-" + event
+}
+
+// NotifyOrderEvent emails the customer about event when its notification is enabled, records the
+// notification on the order and saves the order. It returns nil when the notification is disabled.
+func NotifyOrderEvent(ctx context.Context, event string, data *emailer.TemplateData) (*entities.OrderNotification, error) {
+	return notifyOrderEvent(ctx, datastoreStore{}, event, data)
+}
+
+func notifyOrderEvent(ctx context.Context, s store, event string, data *emailer.TemplateData) (*entities.OrderNotification, error) {
+	serverSettings := s.GetGlobalSettings(ctx)
+	order := data.Order
+	if !serverSettings.NotificationEnabled(event) {
+		log.Infof(ctx, "Notification of order event %s is disabled, order[%v]", event, order.Id)
+		return nil, nil
+	}
+
+	if order.Email == "" {
+		log.Warningf(ctx, "Order[%v] has no email to notify about %s", order.Id, event)
+		return nil, nil
+	}
+
+	bcc := ""
+	if event == entities.OrderEventPaid {
+		bcc = serverSettings.CompanyOrdersEmail
+	}
+
+	notification := &entities.OrderNotification{
+		Event:    event,
+		Template: TemplateForEvent(event),
+		Email:    order.Email,
+		Date:     time.Now(),
+	}
+
+	message, err := s.EnqueueTemplate(
+		ctx,
+		notification.Template,
+		data,
+		fmt.Sprintf("%s<%s>", serverSettings.CompanyName, serverSettings.EmailSender),
+		order.Email,
+		bcc,
+	)
+
+	if err != nil {
+		notification.Error = err.Error()
+		log.Errorf(ctx, "Couldn't queue %s notification for order[%v]: %+v", event, order.Id, err)
+	} else {
+		notification.EmailMessageId = message.Id
+	}
+
+	order.Notifications = append(order.Notifications, notification)
+	updateErr := s.UpdateOrder(ctx, order)
+	if updateErr != nil {
+		log.Errorf(ctx, "Error recording %s notification on order[%v]: %+v", event, order.Id, updateErr)
+		if err == nil {
+			err = updateErr
+		}
+	}
+
+	return notification, err
+}
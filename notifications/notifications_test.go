@@ -0,0 +1,123 @@
+package notifications
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+type enqueued struct {
+	name string
+	from string
+	to   string
+	bcc  string
+}
+
+type fakeStore struct {
+	sent       []enqueued
+	updated    int
+	enqueueErr error
+	updateErr  error
+}
+
+func (f *fakeStore) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return entities.ServerSettings{
+		CompanyName:        "Store",
+		EmailSender:        "store@example.com",
+		CompanyOrdersEmail: "orders@example.com",
+		NotifyOrderPaid:    true,
+		NotifyOrderShipped: true,
+	}
+}
+
+func (f *fakeStore) EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	if f.enqueueErr != nil {
+		return nil, f.enqueueErr
+	}
+
+	f.sent = append(f.sent, enqueued{name: name, from: from, to: to, bcc: bcc})
+	return &entities.EmailMessage{Id: 99}, nil
+}
+
+func (f *fakeStore) UpdateOrder(ctx context.Context, order *entities.Order) error {
+	f.updated++
+	return f.updateErr
+}
+
+func data(email string) *emailer.TemplateData {
+	return &emailer.TemplateData{Order: &entities.Order{Id: 7, Email: email}}
+}
+
+func TestTemplateForEvent(t *testing.T) {
+	if got := TemplateForEvent(entities.OrderEventPaid); got != "email-order" {
+		t.Errorf("paid: got %s", got)
+	}
+
+	if got := TemplateForEvent(entities.OrderEventShipped); got != "email-order-shipped" {
+		t.Errorf("shipped: got %s", got)
+	}
+}
+
+func TestNotifyOrderEvent(t *testing.T) {
+	store := &fakeStore{}
+
+	shipped := data("ana@example.com")
+	notification, err := notifyOrderEvent(context.Background(), store, entities.OrderEventShipped, shipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := enqueued{name: "email-order-shipped", from: "Store<store@example.com>", to: "ana@example.com"}
+	if len(store.sent) != 1 || store.sent[0] != want {
+		t.Fatalf("got %+v, want %+v", store.sent, want)
+	}
+
+	if notification.EmailMessageId != 99 || notification.Error != "" || len(shipped.Order.Notifications) != 1 || store.updated != 1 {
+		t.Errorf("the notification was not recorded on the order: %+v", notification)
+	}
+
+	_, err = notifyOrderEvent(context.Background(), store, entities.OrderEventPaid, data("ana@example.com"))
+	if err != nil || store.sent[1].bcc != "orders@example.com" {
+		t.Errorf("paid orders should bcc the company, got %+v, %v", store.sent[1], err)
+	}
+}
+
+func TestNotifyOrderEventSkips(t *testing.T) {
+	store := &fakeStore{}
+
+	for name, test := range map[string]struct {
+		event string
+		email string
+	}{
+		"disabled": {entities.OrderEventCancelled, "ana@example.com"},
+		"no email": {entities.OrderEventShipped, ""},
+	} {
+		notification, err := notifyOrderEvent(context.Background(), store, test.event, data(test.email))
+		if notification != nil || err != nil {
+			t.Errorf("%s: got %+v, %v", name, notification, err)
+		}
+	}
+
+	if len(store.sent) != 0 || store.updated != 0 {
+		t.Errorf("got %d emails and %d updates", len(store.sent), store.updated)
+	}
+}
+
+func TestNotifyOrderEventErrors(t *testing.T) {
+	store := &fakeStore{enqueueErr: errors.New("Queue unavailable.")}
+
+	order := data("ana@example.com")
+	notification, err := notifyOrderEvent(context.Background(), store, entities.OrderEventShipped, order)
+	if err != store.enqueueErr || notification.Error != "Queue unavailable." || store.updated != 1 {
+		t.Errorf("a failed email should still be recorded on the order, got %+v, %v", notification, err)
+	}
+
+	store.enqueueErr = nil
+	store.updateErr = errors.New("Datastore unavailable.")
+	_, err = notifyOrderEvent(context.Background(), store, entities.OrderEventShipped, order)
+	if err != store.updateErr {
+		t.Errorf("got %v, want the update error", err)
+	}
+}
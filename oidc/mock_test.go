@@ -0,0 +1,187 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MockIssuer is a minimal OpenID Connect issuer for the tests. Every authorization request is
+// approved immediately for Email.
+type MockIssuer struct {
+	URL   string
+	Email string
+
+	server      *httptest.Server
+	key         *rsa.PrivateKey
+	mu          sync.Mutex
+	codes       map[string]mockCode
+	jwksFetches int
+}
+
+type mockCode struct {
+	clientID  string
+	challenge string
+	nonce     string
+	email     string
+}
+
+// NewMockIssuer starts a mock issuer on a local port. Call Close when done.
+func NewMockIssuer(email string) (*MockIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MockIssuer{
+		Email: email,
+		key:   key,
+		codes: map[string]mockCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.serveDiscovery)
+	mux.HandleFunc("/authorize", m.serveAuthorize)
+	mux.HandleFunc("/token", m.serveToken)
+	mux.HandleFunc("/jwks", m.serveJwks)
+	m.server = httptest.NewServer(mux)
+	m.URL = m.server.URL
+	return m, nil
+}
+
+func (m *MockIssuer) Close() {
+	m.server.Close()
+}
+
+func (m *MockIssuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, Discovery{
+		Issuer:                m.URL,
+		AuthorizationEndpoint: m.URL + "/authorize",
+		TokenEndpoint:         m.URL + "/token",
+		JwksURI:               m.URL + "/jwks",
+	})
+}
+
+func (m *MockIssuer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "pkce required", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := RandomString(16)
+	m.mu.Lock()
+	m.codes[code] = mockCode{
+		clientID:  q.Get("client_id"),
+		challenge: q.Get("code_challenge"),
+		nonce:     q.Get("nonce"),
+		email:     m.Email,
+	}
+	m.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *MockIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	code, exists := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+	if !exists {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := m.sign(m.key, "mock", map[string]interface{}{
+		"iss":            m.URL,
+		"sub":            code.email,
+		"aud":            code.clientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          code.nonce,
+		"email":          code.email,
+		"email_verified": true,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, http.StatusOK, Token{
+		AccessToken: RandomString(16),
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+		ExpiresIn:   3600,
+	})
+}
+
+func (m *MockIssuer) serveJwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.jwksFetches++
+	m.mu.Unlock()
+	writeJson(w, http.StatusOK, jwks{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: "mock",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
+
+func (m *MockIssuer) sign(key *rsa.PrivateKey, kid string, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "RS256", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
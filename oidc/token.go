@@ -0,0 +1,236 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// KeyRefreshInterval is how often the key set of an issuer can be fetched again because a
+	// token names a key that isn't in it, so tokens with made up key ids can't flood the issuer.
+	KeyRefreshInterval = time.Minute
+
+	keyCache   = map[string]map[string]*rsa.PublicKey{}
+	keyFetched = map[string]time.Time{}
+	keyCacheMu sync.Mutex
+)
+
+// Claims are the id token claims used to identify a user.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience accepts both the string and the array forms of the aud claim.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an id token
+// and returns its claims.
+func (c *Config) Verify(ctx context.Context, rawIDToken string, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerBts, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	header := jwtHeader{}
+	if err := json.Unmarshal(headerBts, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id token algorithm: %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := c.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(c.Issuer, "/") {
+		return nil, fmt.Errorf("%s: unexpected issuer %s", ErrInvalidToken, claims.Issuer)
+	}
+
+	if !claims.Audience.contains(c.ClientID) {
+		return nil, fmt.Errorf("%s: unexpected audience %v", ErrInvalidToken, claims.Audience)
+	}
+
+	if time.Now().Unix() > claims.Expiry {
+		return nil, fmt.Errorf("%s: token expired", ErrInvalidToken)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%s: nonce mismatch", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// publicKey returns the issuer key with the given id, refreshing the key set if the key is
+// unknown so that rotated keys are picked up. The key set is refreshed at most once every
+// KeyRefreshInterval.
+func (c *Config) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	keyCacheMu.Lock()
+	key := keyCache[c.Issuer][kid]
+	fetched := keyFetched[c.Issuer]
+	refresh := key == nil && (fetched.IsZero() || time.Since(fetched) >= KeyRefreshInterval)
+	if refresh {
+		keyFetched[c.Issuer] = time.Now()
+	}
+
+	keyCacheMu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	if !refresh {
+		return nil, fmt.Errorf("%s: unknown key id %s", ErrInvalidToken, kid)
+	}
+
+	discovery, err := Discover(ctx, c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, discovery.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	bts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status on jwks response[%s]: %s", resp.Status, bts)
+	}
+
+	set := jwks{}
+	if err := json.Unmarshal(bts, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	keyCacheMu.Lock()
+	keyCache[c.Issuer] = keys
+	keyCacheMu.Unlock()
+	key = keys[kid]
+	if key == nil {
+		return nil, fmt.Errorf("%s: unknown key id %s", ErrInvalidToken, kid)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,231 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIssuer(t *testing.T) (*MockIssuer, *Config) {
+	issuer, err := NewMockIssuer("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(issuer.Close)
+	return issuer, &Config{Issuer: issuer.URL, ClientID: "client", RedirectURL: "https://store.example.com/login/oidc/callback"}
+}
+
+func validClaims(issuer *MockIssuer) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   issuer.URL,
+		"sub":   "user",
+		"aud":   "client",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": "nonce",
+		"email": "user@example.com",
+	}
+}
+
+func TestVerify(t *testing.T) {
+	issuer, config := newTestIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		claims  func(claims map[string]interface{})
+		nonce   string
+		tamper  func(token string) string
+		wantErr bool
+	}{
+		{name: "valid", nonce: "nonce"},
+		{name: "audience list", nonce: "nonce", claims: func(claims map[string]interface{}) {
+			claims["aud"] = []string{"other", "client"}
+		}},
+		{name: "issuer with trailing slash", nonce: "nonce", claims: func(claims map[string]interface{}) {
+			claims["iss"] = issuer.URL + "/"
+		}},
+		{name: "bad signature", key: otherKey, nonce: "nonce", wantErr: true},
+		{name: "tampered payload", nonce: "nonce", wantErr: true, tamper: func(token string) string {
+			parts := strings.Split(token, ".")
+			forged, _ := issuer.sign(otherKey, "mock", map[string]interface{}{"iss": issuer.URL, "aud": "client", "sub": "admin"})
+			return parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+		}},
+		{name: "wrong audience", nonce: "nonce", wantErr: true, claims: func(claims map[string]interface{}) {
+			claims["aud"] = "other"
+		}},
+		{name: "wrong issuer", nonce: "nonce", wantErr: true, claims: func(claims map[string]interface{}) {
+			claims["iss"] = "https://evil.example.com"
+		}},
+		{name: "expired", nonce: "nonce", wantErr: true, claims: func(claims map[string]interface{}) {
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+		}},
+		{name: "nonce mismatch", nonce: "other", wantErr: true},
+		{name: "missing nonce", nonce: "nonce", wantErr: true, claims: func(claims map[string]interface{}) {
+			delete(claims, "nonce")
+		}},
+		{name: "malformed", nonce: "nonce", wantErr: true, tamper: func(token string) string {
+			return "not-a-token"
+		}},
+	}
+
+	for _, test := range tests {
+		claims := validClaims(issuer)
+		if test.claims != nil {
+			test.claims(claims)
+		}
+
+		key := issuer.key
+		if test.key != nil {
+			key = test.key
+		}
+
+		token, err := issuer.sign(key, "mock", claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.tamper != nil {
+			token = test.tamper(token)
+		}
+
+		verified, err := config.Verify(context.Background(), token, test.nonce)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: verified %+v, want an error", test.name, verified)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if verified.Email != "user@example.com" {
+			t.Errorf("%s: got email %q", test.name, verified.Email)
+		}
+	}
+}
+
+func TestVerifyRejectsOtherAlgorithms(t *testing.T) {
+	_, config := newTestIssuer(t)
+	// {"alg":"none"} and {"alg":"HS256"}
+	for _, header := range []string{"eyJhbGciOiJub25lIn0", "eyJhbGciOiJIUzI1NiJ9"} {
+		_, err := config.Verify(context.Background(), header+".e30.", "")
+		if err == nil {
+			t.Errorf("accepted a token with header %s", header)
+		}
+	}
+}
+
+func TestUnknownKeyRefetchIsRateLimited(t *testing.T) {
+	issuer, config := newTestIssuer(t)
+	token, err := issuer.sign(issuer.key, "mock", validClaims(issuer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = config.Verify(context.Background(), token, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown, err := issuer.sign(issuer.key, "unknown", validClaims(issuer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err = config.Verify(context.Background(), unknown, "nonce")
+		if err == nil {
+			t.Fatal("verified a token signed with an unknown key")
+		}
+	}
+
+	if issuer.jwksFetches != 1 {
+		t.Errorf("fetched the key set %d times, want once within the refresh interval", issuer.jwksFetches)
+	}
+
+	keyCacheMu.Lock()
+	keyFetched[config.Issuer] = time.Now().Add(-KeyRefreshInterval)
+	keyCacheMu.Unlock()
+	_, _ = config.Verify(context.Background(), unknown, "nonce")
+	if issuer.jwksFetches != 2 {
+		t.Errorf("fetched the key set %d times, want it refreshed after the interval", issuer.jwksFetches)
+	}
+
+	// known keys never wait for a refresh
+	_, err = config.Verify(context.Background(), token, "nonce")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	issuer, config := newTestIssuer(t)
+	verifier, challenge := NewPKCE()
+	authURL, err := config.AuthCodeURL(context.Background(), "state", "nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if redirect.Query().Get("state") != "state" {
+		t.Errorf("got state %q", redirect.Query().Get("state"))
+	}
+
+	_, err = config.Exchange(context.Background(), redirect.Query().Get("code"), "wrong-verifier")
+	if err == nil {
+		t.Error("exchanged a code with the wrong pkce verifier")
+	}
+
+	resp, err = client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+	redirect, _ = url.Parse(resp.Header.Get("Location"))
+	token, err := config.Exchange(context.Background(), redirect.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := config.Verify(context.Background(), token.IDToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Email != issuer.Email {
+		t.Errorf("got email %q, want %q", claims.Email, issuer.Email)
+	}
+}
@@ -0,0 +1,110 @@
+package orders
+
+import (
+	"fmt"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"github.com/jcarm010/kodimerce/notifications"
+	"github.com/jcarm010/kodimerce/settings"
+	"golang.org/x/net/context"
+	"math"
+)
+
+// store is what marking an order paid touches: the settings, the order, the inventory and the emails.
+type store interface {
+	GetGlobalSettings(ctx context.Context) entities.ServerSettings
+	UpdateOrder(ctx context.Context, order *entities.Order) error
+	DecreaseProductInventory(ctx context.Context, productId int64, quantity int) error
+	NotifyOrderEvent(ctx context.Context, event string, data *emailer.TemplateData) (*entities.OrderNotification, error)
+	EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error)
+}
+
+type datastoreStore struct{}
+
+func (datastoreStore) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return settings.GetGlobalSettings(ctx)
+}
+
+func (datastoreStore) UpdateOrder(ctx context.Context, order *entities.Order) error {
+	return entities.UpdateOrder(ctx, order)
+}
+
+func (datastoreStore) DecreaseProductInventory(ctx context.Context, productId int64, quantity int) error {
+	return entities.DecreaseProductInventory(ctx, productId, quantity)
+}
+
+func (datastoreStore) NotifyOrderEvent(ctx context.Context, event string, data *emailer.TemplateData) (*entities.OrderNotification, error) {
+	return notifications.NotifyOrderEvent(ctx, event, data)
+}
+
+func (datastoreStore) EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	return emailer.EnqueueTemplate(ctx, name, data, from, to, bcc)
+}
+
+// TemplateData returns the data email templates about order are rendered with. hostRoot is the
+// root of the links in the email.
+func TemplateData(serverSettings entities.ServerSettings, hostRoot string, order *entities.Order) *emailer.TemplateData {
+	return &emailer.TemplateData{
+		CompanyName:     serverSettings.CompanyName,
+		ConfirmationUrl: fmt.Sprintf("%s/order?id=%v", hostRoot, order.Id),
+		OrderUrl:        fmt.Sprintf("%s/admin/orders/%v", hostRoot, order.Id),
+		HostRoot:        hostRoot,
+		ContactEmail:    serverSettings.CompanySupportEmail,
+		Order:           order,
+	}
+}
+
+// MarkPaid moves an order whose payment went through to pending, takes its products out of the
+// inventory and emails the buyer and the seller. Only a failure to save the order is returned,
+// the rest is logged.
+func MarkPaid(ctx context.Context, data *emailer.TemplateData) error {
+	return markPaid(ctx, datastoreStore{}, data)
+}
+
+func markPaid(ctx context.Context, s store, data *emailer.TemplateData) error {
+	order := data.Order
+	metrics.OrdersPaid.Inc()
+	metrics.RevenueCents.Add(math.Round(order.OrderTotal() * 100))
+	if order.RecoveryEmailsSent > 0 {
+		order.Recovered = true
+		metrics.OrdersRecovered.Inc()
+	}
+
+	order.Status = entities.OrderStatusPending
+	err := s.UpdateOrder(ctx, order)
+	if err != nil {
+		return err
+	}
+
+	for _, productId := range order.ProductIds {
+		err = s.DecreaseProductInventory(ctx, productId, 1)
+		if err != nil {
+			log.Errorf(ctx, "Error decresing inventory for productId[%v]: %+v", productId, err)
+		}
+	}
+
+	//send a notification email to the buyer
+	_, err = s.NotifyOrderEvent(ctx, entities.OrderEventPaid, data)
+	if err != nil {
+		log.Errorf(ctx, "Couldn't notify buyer: %v", err)
+	}
+
+	//send a notification email to the seller
+	serverSettings := s.GetGlobalSettings(ctx)
+	_, err = s.EnqueueTemplate(
+		ctx,
+		"email-order-admin",
+		data,
+		fmt.Sprintf("%s<%s>", serverSettings.CompanyName, serverSettings.EmailSender),
+		serverSettings.CompanyOrdersEmail,
+		"",
+	)
+
+	if err != nil {
+		log.Errorf(ctx, "Couldn't queue admin email: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package orders
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+)
+
+type fakeShop struct {
+	updated   []string
+	decreased []int64
+	notified  []string
+	emailed   []string
+	updateErr error
+}
+
+func (f *fakeShop) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return entities.ServerSettings{CompanyName: "Store", EmailSender: "store@example.com", CompanyOrdersEmail: "orders@example.com"}
+}
+
+func (f *fakeShop) UpdateOrder(ctx context.Context, order *entities.Order) error {
+	f.updated = append(f.updated, order.Status)
+	return f.updateErr
+}
+
+func (f *fakeShop) DecreaseProductInventory(ctx context.Context, productId int64, quantity int) error {
+	f.decreased = append(f.decreased, productId)
+	return errors.New("Inventory unavailable.")
+}
+
+func (f *fakeShop) NotifyOrderEvent(ctx context.Context, event string, data *emailer.TemplateData) (*entities.OrderNotification, error) {
+	f.notified = append(f.notified, event)
+	return nil, nil
+}
+
+func (f *fakeShop) EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	f.emailed = append(f.emailed, name+" "+from+" "+to)
+	return &entities.EmailMessage{}, nil
+}
+
+func TestTemplateData(t *testing.T) {
+	data := TemplateData(entities.ServerSettings{CompanyName: "Store", CompanySupportEmail: "help@example.com"}, "https://shop.com", &entities.Order{Id: 7})
+	if data.ConfirmationUrl != "https://shop.com/order?id=7" || data.OrderUrl != "https://shop.com/admin/orders/7" || data.ContactEmail != "help@example.com" {
+		t.Errorf("got %+v", data)
+	}
+}
+
+func TestMarkPaid(t *testing.T) {
+	shop := &fakeShop{}
+
+	order := &entities.Order{Id: 7, Status: entities.OrderStatusStarted, ProductIds: []int64{10, 20}, RecoveryEmailsSent: 1}
+	if err := markPaid(context.Background(), shop, &emailer.TemplateData{Order: order}); err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != entities.OrderStatusPending || !order.Recovered {
+		t.Errorf("got status %s, recovered %v", order.Status, order.Recovered)
+	}
+
+	if !reflect.DeepEqual(shop.updated, []string{entities.OrderStatusPending}) || !reflect.DeepEqual(shop.decreased, []int64{10, 20}) {
+		t.Errorf("got updates %v and inventory decreases %v", shop.updated, shop.decreased)
+	}
+
+	if !reflect.DeepEqual(shop.notified, []string{entities.OrderEventPaid}) || !reflect.DeepEqual(shop.emailed, []string{"email-order-admin Store<store@example.com> orders@example.com"}) {
+		t.Errorf("got notifications %v and emails %v", shop.notified, shop.emailed)
+	}
+}
+
+func TestMarkPaidStopsWhenTheOrderIsNotSaved(t *testing.T) {
+	shop := &fakeShop{updateErr: errors.New("Datastore unavailable.")}
+
+	order := &entities.Order{Id: 7, ProductIds: []int64{10}}
+	if err := markPaid(context.Background(), shop, &emailer.TemplateData{Order: order}); err != shop.updateErr {
+		t.Errorf("got %v", err)
+	}
+
+	if order.Recovered || len(shop.decreased) != 0 || len(shop.notified) != 0 || len(shop.emailed) != 0 {
+		t.Errorf("an unsaved order went on: %+v", shop)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/jcarm010/kodimerce/entities"
 	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
 	"github.com/jcarm010/kodimerce/settings"
 	"golang.org/x/net/context"
 	"io/ioutil"
@@ -39,8 +40,7 @@ type RedirectUrls struct {
 	CancelUrl string `json:"cancel_url"`
 }
 
-func getAccessToken(ctx context.Context) (string, error) {
-	generalSettings := settings.GetGlobalSettings(ctx)
+func getAccessToken(ctx context.Context, generalSettings entities.ServerSettings) (string, error) {
 	u, err := url.Parse(generalSettings.PayPalApiUrl)
 	if err != nil {
 		return "", err
@@ -106,6 +106,7 @@ func getClient (ctx context.Context) *http.Client {
 		client.Transport = tr
 	}
 
+	client.Transport = metrics.InstrumentTransport("paypal", client.Transport)
 	return client
 }
 
@@ -225,7 +226,7 @@ func CreatePayment(ctx context.Context, order *entities.Order, companyUrl string
 		return "", err
 	}
 
-	accessToken, err := getAccessToken(ctx)
+	accessToken, err := getAccessToken(ctx, globalSettings)
 	if err != nil {
 		return "", err
 	}
@@ -283,7 +284,7 @@ func ExecutePayment(ctx context.Context, order *entities.Order) (error) {
 		return err
 	}
 
-	accessToken, err := getAccessToken(ctx)
+	accessToken, err := getAccessToken(ctx, globalSettings)
 	if err != nil {
 		return err
 	}
@@ -309,3 +310,56 @@ func ExecutePayment(ctx context.Context, order *entities.Order) (error) {
 
 	return nil
 }
+
+// GetPaymentState returns the state PayPal has for the payment of order, "approved" once the
+// payment was executed.
+func GetPaymentState(ctx context.Context, order *entities.Order) (string, error) {
+	return getPaymentState(ctx, settings.GetGlobalSettings(ctx), order)
+}
+
+func getPaymentState(ctx context.Context, globalSettings entities.ServerSettings, order *entities.Order) (string, error) {
+	u, err := url.Parse(globalSettings.PayPalApiUrl)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = path.Join(u.Path, "payments/payment/" + order.PaypalPaymentId)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	accessToken, err := getAccessToken(ctx, globalSettings)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	client := getClient(ctx)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+	bts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != 200 {
+		return "", errors.New(fmt.Sprintf("Paypal responded with status[%s]: %s", resp.Status, bts))
+	}
+
+	payment := &struct {
+		State string `json:"state"`
+	}{}
+
+	err = json.Unmarshal(bts, payment)
+	if err != nil {
+		return "", err
+	}
+
+	return payment.State, nil
+}
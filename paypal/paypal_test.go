@@ -0,0 +1,65 @@
+package paypal
+
+import (
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func fakePayPal(t *testing.T, status int, payment string) entities.ServerSettings {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/oauth2/token":
+			if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.Write([]byte(`{"access_token":"token"}`))
+		case "/v1/payments/payment/PAY-1":
+			if r.Method != http.MethodGet || r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.WriteHeader(status)
+			w.Write([]byte(payment))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return entities.ServerSettings{PayPalApiUrl: server.URL + "/v1", PayPalApiClientId: "client", PayPalApiClientSecret: "secret"}
+}
+
+func TestGetPaymentState(t *testing.T) {
+	serverSettings := fakePayPal(t, http.StatusOK, `{"id":"PAY-1","state":"approved"}`)
+	state, err := getPaymentState(context.Background(), serverSettings, &entities.Order{PaypalPaymentId: "PAY-1"})
+	if err != nil || state != "approved" {
+		t.Errorf("got %q, %v", state, err)
+	}
+}
+
+func TestGetPaymentStateBadStatus(t *testing.T) {
+	serverSettings := fakePayPal(t, http.StatusInternalServerError, `{"name":"INTERNAL_SERVICE_ERROR"}`)
+	if state, err := getPaymentState(context.Background(), serverSettings, &entities.Order{PaypalPaymentId: "PAY-1"}); err == nil {
+		t.Errorf("got %q without an error", state)
+	}
+}
+
+func TestGetPaymentStateInvalidBody(t *testing.T) {
+	serverSettings := fakePayPal(t, http.StatusOK, `not json`)
+	if state, err := getPaymentState(context.Background(), serverSettings, &entities.Order{PaypalPaymentId: "PAY-1"}); err == nil {
+		t.Errorf("got %q without an error", state)
+	}
+}
+
+func TestGetPaymentStateUnknownPayment(t *testing.T) {
+	serverSettings := fakePayPal(t, http.StatusOK, `{}`)
+	if _, err := getPaymentState(context.Background(), serverSettings, &entities.Order{PaypalPaymentId: "PAY-2"}); err == nil {
+		t.Error("expected an error for a payment PayPal doesn't know")
+	}
+}
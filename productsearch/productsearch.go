@@ -0,0 +1,404 @@
+// Package productsearch is the storefront's product search. Active products are indexed with
+// their category names so shoppers can search, filter and sort the catalog.
+package productsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FieldCategory = "category"
+	FieldInStock  = "in_stock"
+	FieldPrice    = "price"
+	FieldCreated  = "created"
+
+	SortRelevance = "relevance"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortNewest    = "newest"
+
+	MaxLimit = 100
+)
+
+// IndexTTL is how long the products index is used before it is loaded again from the datastore,
+// which is how stock changes made by orders show up.
+var IndexTTL = 5 * time.Minute
+
+// PriceBuckets are the price range facets, in cents.
+var PriceBuckets = []search.Bucket{
+	{Label: "Under $25", Max: search.Float(2500)},
+	{Label: "$25 to $50", Min: search.Float(2500), Max: search.Float(5000)},
+	{Label: "$50 to $100", Min: search.Float(5000), Max: search.Float(10000)},
+	{Label: "$100 to $200", Min: search.Float(10000), Max: search.Float(20000)},
+	{Label: "$200 and up", Min: search.Float(20000)},
+}
+
+var defaultSearch = newProductSearch(datastoreCatalog{})
+
+// catalog is where the products and their categories are read from.
+type catalog interface {
+	GetProduct(ctx context.Context, id int64) (*entities.Product, error)
+	GetProductCategories(ctx context.Context, id int64) ([]*entities.Category, error)
+	ListProducts(ctx context.Context) ([]*entities.Product, error)
+	ListCategories(ctx context.Context) ([]*entities.Category, error)
+	GetCategoryProducts(ctx context.Context) ([]*entities.CategoryProduct, error)
+}
+
+type datastoreCatalog struct{}
+
+func (datastoreCatalog) GetProduct(ctx context.Context, id int64) (*entities.Product, error) {
+	return entities.GetProduct(ctx, id)
+}
+
+func (datastoreCatalog) GetProductCategories(ctx context.Context, id int64) ([]*entities.Category, error) {
+	return entities.GetProductCategories(ctx, id)
+}
+
+func (datastoreCatalog) ListProducts(ctx context.Context) ([]*entities.Product, error) {
+	return entities.ListProducts(ctx)
+}
+
+func (datastoreCatalog) ListCategories(ctx context.Context) ([]*entities.Category, error) {
+	return entities.ListCategories(ctx)
+}
+
+func (datastoreCatalog) GetCategoryProducts(ctx context.Context) ([]*entities.CategoryProduct, error) {
+	return entities.GetCategoryProducts(ctx)
+}
+
+// productSearch is the index of the active products of a catalog.
+type productSearch struct {
+	catalog catalog
+	loader  *search.Loader
+
+	// categoryNames maps the category paths used as facet values to the names shown to shoppers.
+	categoryNames   map[string]string
+	categoryNamesMu sync.RWMutex
+}
+
+func newProductSearch(c catalog) *productSearch {
+	p := &productSearch{catalog: c, categoryNames: map[string]string{}}
+	index := search.NewMemoryIndex(map[string]float64{
+		"name":             4,
+		"category":         2,
+		"meta_description": 1.5,
+		"description":      1,
+	})
+
+	p.loader = search.NewLoader(index, IndexTTL, p.load)
+	return p
+}
+
+// Options are the search parameters a shopper can send.
+type Options struct {
+	Text       string
+	Categories []string // category paths
+	MinPrice   *float64 // cents
+	MaxPrice   *float64 // cents, exclusive
+	InStock    bool
+	Sort       string
+	Limit      int
+	Cursor     string
+}
+
+// ParseOptions reads the options from the query string: q, category (repeated), min_price and
+// max_price in dollars, in_stock=true, sort, limit and cursor.
+func ParseOptions(values map[string][]string) (*Options, error) {
+	get := func(name string) string {
+		if len(values[name]) == 0 {
+			return ""
+		}
+
+		return strings.TrimSpace(values[name][0])
+	}
+
+	options := &Options{
+		Text:   get("q"),
+		Sort:   get("sort"),
+		Cursor: get("cursor"),
+	}
+
+	for _, category := range values["category"] {
+		if category = strings.TrimSpace(category); category != "" {
+			options.Categories = append(options.Categories, category)
+		}
+	}
+
+	var err error
+	options.MinPrice, err = parsePrice(get("min_price"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid min_price: %s", get("min_price"))
+	}
+
+	options.MaxPrice, err = parsePrice(get("max_price"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid max_price: %s", get("max_price"))
+	}
+
+	options.InStock = get("in_stock") == "true"
+	switch options.Sort {
+	case "":
+		options.Sort = SortRelevance
+	case SortRelevance, SortPriceAsc, SortPriceDesc, SortNewest:
+	default:
+		return nil, fmt.Errorf("Invalid sort: %s", options.Sort)
+	}
+
+	if limit := get("limit"); limit != "" {
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit <= 0 {
+			return nil, fmt.Errorf("Invalid limit: %s", limit)
+		}
+
+		if options.Limit > MaxLimit {
+			options.Limit = MaxLimit
+		}
+	}
+
+	return options, nil
+}
+
+func parsePrice(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	dollars, err := strconv.ParseFloat(value, 64)
+	if err != nil || dollars < 0 {
+		return nil, fmt.Errorf("invalid price %q", value)
+	}
+
+	return search.Float(dollars * 100), nil
+}
+
+func (o *Options) query() *search.Query {
+	q := &search.Query{
+		Text:        o.Text,
+		Filters:     map[string][]string{},
+		Facets:      []string{FieldCategory, FieldInStock},
+		RangeFacets: []search.RangeFacet{{Field: FieldPrice, Buckets: PriceBuckets}},
+		Limit:       o.Limit,
+		Cursor:      o.Cursor,
+	}
+
+	if len(o.Categories) > 0 {
+		q.Filters[FieldCategory] = o.Categories
+	}
+
+	if o.InStock {
+		q.Filters[FieldInStock] = []string{"true"}
+	}
+
+	if o.MinPrice != nil || o.MaxPrice != nil {
+		q.Ranges = append(q.Ranges, search.Range{Field: FieldPrice, Min: o.MinPrice, Max: o.MaxPrice})
+	}
+
+	switch o.Sort {
+	case SortPriceAsc:
+		q.Sort = []search.Sort{{Field: FieldPrice}}
+	case SortPriceDesc:
+		q.Sort = []search.Sort{{Field: FieldPrice, Desc: true}}
+	case SortNewest:
+		q.Sort = []search.Sort{{Field: FieldCreated, Desc: true}}
+	default:
+		q.Sort = []search.Sort{{Field: search.ScoreField, Desc: true}, {Field: FieldCreated, Desc: true}}
+	}
+
+	return q
+}
+
+// CategoryFacet is a category shoppers can narrow the results to.
+type CategoryFacet struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+}
+
+// Results are the products of one page of results. Products are copies and can be changed.
+type Results struct {
+	Products    []*entities.Product  `json:"products"`
+	Total       int                  `json:"total"`
+	Cursor      string               `json:"cursor"`
+	Categories  []CategoryFacet      `json:"categories"`
+	InStock     int                  `json:"in_stock"`
+	PriceRanges []search.BucketCount `json:"price_ranges"`
+}
+
+func Search(ctx context.Context, options *Options) (*Results, error) {
+	return defaultSearch.search(ctx, options)
+}
+
+func (p *productSearch) search(ctx context.Context, options *Options) (*Results, error) {
+	result, err := p.loader.Search(ctx, options.query())
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		Products:    make([]*entities.Product, 0, len(result.Hits)),
+		Total:       result.Total,
+		Cursor:      result.Cursor,
+		Categories:  make([]CategoryFacet, 0, len(result.Facets[FieldCategory])),
+		PriceRanges: result.RangeFacets[FieldPrice],
+	}
+
+	p.categoryNamesMu.RLock()
+	for _, facet := range result.Facets[FieldCategory] {
+		name, exists := p.categoryNames[facet.Value]
+		if !exists {
+			name = facet.Value
+		}
+
+		results.Categories = append(results.Categories, CategoryFacet{
+			Path:     facet.Value,
+			Name:     name,
+			Count:    facet.Count,
+			Selected: contains(options.Categories, facet.Value),
+		})
+	}
+	p.categoryNamesMu.RUnlock()
+
+	for _, facet := range result.Facets[FieldInStock] {
+		if facet.Value == "true" {
+			results.InStock = facet.Count
+		}
+	}
+
+	for _, hit := range result.Hits {
+		product := *hit.Source.(*entities.Product)
+		results.Products = append(results.Products, &product)
+	}
+
+	return results, nil
+}
+
+// Rebuild loads every product into the index again.
+func Rebuild(ctx context.Context) error {
+	return defaultSearch.loader.Rebuild(ctx)
+}
+
+// IndexProduct loads a product and its categories and updates its document, removing it when
+// it isn't active.
+func IndexProduct(ctx context.Context, productId int64) error {
+	return defaultSearch.indexProduct(ctx, productId)
+}
+
+func (p *productSearch) indexProduct(ctx context.Context, productId int64) error {
+	product, err := p.catalog.GetProduct(ctx, productId)
+	if err != nil {
+		return err
+	}
+
+	if !product.Active {
+		return p.loader.Delete(documentId(productId))
+	}
+
+	categories, err := p.catalog.GetProductCategories(ctx, productId)
+	if err != nil {
+		return err
+	}
+
+	p.setCategoryNames(categories, false)
+	return p.loader.Put(productDocument(product, categories))
+}
+
+func (p *productSearch) load(ctx context.Context) ([]*search.Document, error) {
+	products, err := p.catalog.ListProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := p.catalog.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryProducts, err := p.catalog.GetCategoryProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.setCategoryNames(categories, true)
+	categoriesById := map[int64]*entities.Category{}
+	for _, category := range categories {
+		categoriesById[category.Id] = category
+	}
+
+	productCategories := map[int64][]*entities.Category{}
+	for _, cp := range categoryProducts {
+		if category, exists := categoriesById[cp.CategoryId]; exists {
+			productCategories[cp.ProductId] = append(productCategories[cp.ProductId], category)
+		}
+	}
+
+	docs := make([]*search.Document, 0, len(products))
+	for _, product := range products {
+		if product.Active {
+			docs = append(docs, productDocument(product, productCategories[product.Id]))
+		}
+	}
+
+	return docs, nil
+}
+
+func productDocument(product *entities.Product, categories []*entities.Category) *search.Document {
+	categoryNames := make([]string, 0, len(categories))
+	categoryPaths := make([]string, 0, len(categories))
+	for _, category := range categories {
+		categoryNames = append(categoryNames, category.Name)
+		categoryPaths = append(categoryPaths, category.Path)
+	}
+
+	return &search.Document{
+		Id: documentId(product.Id),
+		Text: map[string]string{
+			"name":             product.Name,
+			"category":         strings.Join(categoryNames, " "),
+			"meta_description": product.MetaDescription,
+			"description":      search.StripTags(string(product.Description)),
+		},
+		Keywords: map[string][]string{
+			FieldCategory: categoryPaths,
+			FieldInStock:  {strconv.FormatBool(!product.OutOfStock())},
+		},
+		Numbers: map[string]float64{
+			FieldPrice:   float64(product.GetPriceCents()),
+			FieldCreated: search.Time(product.Created),
+		},
+		Source: product,
+	}
+}
+
+func (p *productSearch) setCategoryNames(categories []*entities.Category, replace bool) {
+	p.categoryNamesMu.Lock()
+	defer p.categoryNamesMu.Unlock()
+	if replace {
+		p.categoryNames = map[string]string{}
+	}
+
+	for _, category := range categories {
+		p.categoryNames[category.Path] = category.Name
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func documentId(productId int64) string {
+	return strconv.FormatInt(productId, 10)
+}
@@ -0,0 +1,166 @@
+package productsearch
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeCatalog struct {
+	products   map[int64]*entities.Product
+	categories []*entities.Category
+	byProduct  map[int64][]*entities.Category
+}
+
+func (f *fakeCatalog) GetProduct(ctx context.Context, id int64) (*entities.Product, error) {
+	return f.products[id], nil
+}
+
+func (f *fakeCatalog) GetProductCategories(ctx context.Context, id int64) ([]*entities.Category, error) {
+	return f.byProduct[id], nil
+}
+
+func (f *fakeCatalog) ListProducts(ctx context.Context) ([]*entities.Product, error) {
+	return []*entities.Product{f.products[1], f.products[2], f.products[3], f.products[4]}, nil
+}
+
+func (f *fakeCatalog) ListCategories(ctx context.Context) ([]*entities.Category, error) {
+	return f.categories, nil
+}
+
+func (f *fakeCatalog) GetCategoryProducts(ctx context.Context) ([]*entities.CategoryProduct, error) {
+	return []*entities.CategoryProduct{{CategoryId: 10, ProductId: 1}, {CategoryId: 10, ProductId: 2}, {CategoryId: 11, ProductId: 3}}, nil
+}
+
+func newCatalog(t *testing.T) (*productSearch, map[int64]*entities.Product) {
+	now := time.Now()
+	products := map[int64]*entities.Product{
+		1: {Id: 1, Name: "Red shirt", Active: true, PriceCents: 2000, Quantity: 3, Created: now.Add(-2 * time.Hour)},
+		2: {Id: 2, Name: "Blue shirt", Active: true, PriceCents: 6000, Quantity: 0, Created: now.Add(-time.Hour)},
+		3: {Id: 3, Name: "Leather boots", Active: true, PriceCents: 12000, IsInfinite: true, Created: now, Description: "<p>Red <b>laces</b></p>"},
+		4: {Id: 4, Name: "Hidden shirt", Active: false, PriceCents: 1000, Quantity: 1, Created: now},
+	}
+
+	shirts := &entities.Category{Id: 10, Name: "Shirts", Path: "shirts"}
+	shoes := &entities.Category{Id: 11, Name: "Shoes", Path: "shoes"}
+	p := newProductSearch(&fakeCatalog{
+		products:   products,
+		categories: []*entities.Category{shirts, shoes},
+		byProduct:  map[int64][]*entities.Category{1: {shirts}, 2: {shirts}, 3: {shoes}},
+	})
+
+	if err := p.loader.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return p, products
+}
+
+func productIds(results *Results) []int64 {
+	ids := make([]int64, 0, len(results.Products))
+	for _, product := range results.Products {
+		ids = append(ids, product.Id)
+	}
+
+	return ids
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions(map[string][]string{
+		"q":         {" shirt "},
+		"category":  {"shirts", " ", "shoes"},
+		"min_price": {"10.5"},
+		"in_stock":  {"true"},
+		"sort":      {SortPriceDesc},
+		"limit":     {"500"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Text != "shirt" || !reflect.DeepEqual(options.Categories, []string{"shirts", "shoes"}) || *options.MinPrice != 1050 ||
+		options.MaxPrice != nil || !options.InStock || options.Sort != SortPriceDesc || options.Limit != MaxLimit {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	for name, values := range map[string]map[string][]string{
+		"bad price":      {"max_price": {"ten"}},
+		"negative price": {"min_price": {"-1"}},
+		"bad sort":       {"sort": {"name"}},
+		"bad limit":      {"limit": {"0"}},
+	} {
+		if _, err := ParseOptions(values); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	if options, _ := ParseOptions(nil); options.Sort != SortRelevance {
+		t.Errorf("got sort %s by default", options.Sort)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	p, _ := newCatalog(t)
+	tests := []struct {
+		name    string
+		options Options
+		want    []int64
+	}{
+		{"relevance", Options{Text: "red"}, []int64{1, 3}},
+		{"inactive products are left out", Options{Text: "shirt", Sort: SortPriceAsc}, []int64{1, 2}},
+		{"category", Options{Categories: []string{"shoes"}}, []int64{3}},
+		{"in stock", Options{InStock: true, Sort: SortNewest}, []int64{3, 1}},
+		{"price range", Options{MinPrice: search.Float(2500), MaxPrice: search.Float(12000), Sort: SortPriceDesc}, []int64{2}},
+	}
+
+	for _, test := range tests {
+		results, err := p.search(context.Background(), &test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := productIds(results); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	results, _ := p.search(context.Background(), &Options{Categories: []string{"shirts"}})
+	want := []CategoryFacet{{Path: "shirts", Name: "Shirts", Count: 2, Selected: true}}
+	if !reflect.DeepEqual(results.Categories, want) || results.InStock != 1 {
+		t.Errorf("got categories %+v and %d in stock", results.Categories, results.InStock)
+	}
+
+	if len(results.PriceRanges) != len(PriceBuckets) || results.PriceRanges[0].Count != 1 || results.PriceRanges[2].Count != 1 {
+		t.Errorf("got price ranges %+v", results.PriceRanges)
+	}
+
+	results.Products[0].Name = "Changed"
+	if again, _ := p.search(context.Background(), &Options{Categories: []string{"shirts"}}); again.Products[0].Name == "Changed" {
+		t.Errorf("results share the indexed products")
+	}
+}
+
+func TestIndexProduct(t *testing.T) {
+	p, products := newCatalog(t)
+	products[4].Active = true
+	if err := p.indexProduct(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if results, _ := p.search(context.Background(), &Options{Text: "hidden"}); !reflect.DeepEqual(productIds(results), []int64{4}) {
+		t.Errorf("an activated product is not searchable")
+	}
+
+	products[1].Active = false
+	if err := p.indexProduct(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if results, _ := p.search(context.Background(), &Options{Text: "red"}); !reflect.DeepEqual(productIds(results), []int64{3}) {
+		t.Errorf("a deactivated product is still searchable: %v", productIds(results))
+	}
+}
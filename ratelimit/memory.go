@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"golang.org/x/net/context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is the number of writes between sweeps of stale entries.
+const sweepEvery = 1024
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+type lockout struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	window      time.Duration
+}
+
+// MemoryStore is a Store that keeps its state in process memory. State is not
+// shared between instances, so limits are enforced per instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	lockouts map[string]*lockout
+	writes   int
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  map[string]*bucket{},
+		lockouts: map[string]*lockout{},
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.maybeSweep(now)
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		s.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	if limit.Rate <= 0 {
+		return false, time.Hour, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+func (s *MemoryStore) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.maybeSweep(now)
+	l, exists := s.lockouts[key]
+	if !exists || (policy.Window > 0 && now.Sub(l.lastFailure) > policy.Window) {
+		l = &lockout{}
+		s.lockouts[key] = l
+	}
+
+	l.failures++
+	l.lastFailure = now
+	l.window = policy.Window
+	lock := policy.LockDuration(l.failures)
+	if lock > 0 {
+		l.lockedUntil = now.Add(lock)
+	}
+
+	return lock, nil
+}
+
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, exists := s.lockouts[key]
+	if !exists {
+		return 0, nil
+	}
+
+	remaining := l.lockedUntil.Sub(s.now())
+	if remaining < 0 {
+		return 0, nil
+	}
+
+	return remaining, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lockouts, key)
+	return nil
+}
+
+// maybeSweep drops full buckets and expired lockouts so the maps do not grow without bound.
+// It must be called with the lock held.
+func (s *MemoryStore) maybeSweep(now time.Time) {
+	s.writes++
+	if s.writes < sweepEvery {
+		return
+	}
+
+	s.writes = 0
+	for key, b := range s.buckets {
+		if b.limit.Rate <= 0 {
+			continue
+		}
+
+		refill := time.Duration(float64(b.limit.Burst) / b.limit.Rate * float64(time.Second))
+		if now.Sub(b.updated) > refill {
+			delete(s.buckets, key)
+		}
+	}
+
+	for key, l := range s.lockouts {
+		if now.After(l.lockedUntil) && (l.window <= 0 || now.Sub(l.lastFailure) > l.window) {
+			delete(s.lockouts, key)
+		}
+	}
+}
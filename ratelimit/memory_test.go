@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func newTestStore() (*MemoryStore, *time.Time) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time {
+		return now
+	}
+
+	return store, &now
+}
+
+func TestTakeEmptiesAndRefillsBucket(t *testing.T) {
+	store, now := newTestStore()
+	ctx := context.Background()
+	limit := PerMinute(6, 2)
+	for i := 0; i < 2; i++ {
+		allowed, _, _ := store.Take(ctx, "key", limit)
+		if !allowed {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+
+	allowed, retryAfter, _ := store.Take(ctx, "key", limit)
+	if allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+
+	if retryAfter != 10*time.Second {
+		t.Errorf("retry after %v, want 10s", retryAfter)
+	}
+
+	allowed, _, _ = store.Take(ctx, "other", limit)
+	if !allowed {
+		t.Error("another key shared the empty bucket")
+	}
+
+	*now = now.Add(10 * time.Second)
+	allowed, _, _ = store.Take(ctx, "key", limit)
+	if !allowed {
+		t.Error("bucket didn't refill")
+	}
+}
+
+func TestLockoutAfterFailures(t *testing.T) {
+	store, now := newTestStore()
+	ctx := context.Background()
+	policy := LockoutPolicy{Threshold: 2, Base: time.Minute, Max: time.Hour, Window: time.Hour}
+	lockedFor, _ := store.RecordFailure(ctx, "login", policy)
+	if lockedFor != 0 {
+		t.Fatalf("locked for %v after one failure", lockedFor)
+	}
+
+	lockedFor, _ = store.RecordFailure(ctx, "login", policy)
+	if lockedFor != time.Minute {
+		t.Fatalf("locked for %v after reaching the threshold, want 1m", lockedFor)
+	}
+
+	*now = now.Add(20 * time.Second)
+	lockedFor, _ = store.LockedFor(ctx, "login")
+	if lockedFor != 40*time.Second {
+		t.Errorf("locked for %v after 20s, want 40s", lockedFor)
+	}
+
+	*now = now.Add(time.Minute)
+	lockedFor, _ = store.LockedFor(ctx, "login")
+	if lockedFor != 0 {
+		t.Errorf("still locked for %v after the lock ran out", lockedFor)
+	}
+
+	lockedFor, _ = store.RecordFailure(ctx, "login", policy)
+	if lockedFor != 2*time.Minute {
+		t.Errorf("locked for %v after another failure, want the lock doubled to 2m", lockedFor)
+	}
+}
+
+func TestLockoutForgetsOldFailuresAndResets(t *testing.T) {
+	store, now := newTestStore()
+	ctx := context.Background()
+	policy := LockoutPolicy{Threshold: 2, Base: time.Minute, Max: time.Hour, Window: time.Hour}
+	store.RecordFailure(ctx, "login", policy)
+	*now = now.Add(2 * time.Hour)
+	lockedFor, _ := store.RecordFailure(ctx, "login", policy)
+	if lockedFor != 0 {
+		t.Errorf("locked for %v, failures outside the window should be forgotten", lockedFor)
+	}
+
+	store.RecordFailure(ctx, "login", policy)
+	err := store.Reset(ctx, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockedFor, _ = store.LockedFor(ctx, "login")
+	if lockedFor != 0 {
+		t.Errorf("locked for %v after a reset", lockedFor)
+	}
+}
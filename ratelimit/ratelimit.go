@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"golang.org/x/net/context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket. Rate is the number of tokens added back to the
+// bucket every second and Burst is the maximum number of tokens the bucket can hold.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// PerMinute returns a limit that allows n requests per minute with the given burst.
+func PerMinute(n int, burst int) Limit {
+	return Limit{Rate: float64(n) / 60.0, Burst: burst}
+}
+
+// PerHour returns a limit that allows n requests per hour with the given burst.
+func PerHour(n int, burst int) Limit {
+	return Limit{Rate: float64(n) / 3600.0, Burst: burst}
+}
+
+// LockoutPolicy describes how long a key is locked after repeated failures.
+// Once Threshold failures are recorded the key is locked for Base, and every
+// additional failure doubles the lock up to Max. Failures older than Window are forgotten.
+// In JSON the durations are in seconds.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+}
+
+type lockoutPolicyJSON struct {
+	Threshold int   `json:"threshold"`
+	Base      int64 `json:"base"`
+	Max       int64 `json:"max"`
+	Window    int64 `json:"window"`
+}
+
+func (p LockoutPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lockoutPolicyJSON{
+		Threshold: p.Threshold,
+		Base:      int64(p.Base / time.Second),
+		Max:       int64(p.Max / time.Second),
+		Window:    int64(p.Window / time.Second),
+	})
+}
+
+func (p *LockoutPolicy) UnmarshalJSON(data []byte) error {
+	policy := lockoutPolicyJSON{}
+	err := json.Unmarshal(data, &policy)
+	if err != nil {
+		return err
+	}
+
+	*p = LockoutPolicy{
+		Threshold: policy.Threshold,
+		Base:      time.Duration(policy.Base) * time.Second,
+		Max:       time.Duration(policy.Max) * time.Second,
+		Window:    time.Duration(policy.Window) * time.Second,
+	}
+
+	return nil
+}
+
+// LockDuration returns how long a key should be locked after the given number of failures.
+func (p LockoutPolicy) LockDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	lock := time.Duration(float64(p.Base) * math.Pow(2, float64(failures-p.Threshold)))
+	if lock > p.Max || lock <= 0 {
+		lock = p.Max
+	}
+
+	return lock
+}
+
+// Store keeps the state of token buckets and lockouts. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// Take removes a token from the bucket identified by key. When the bucket is empty it
+	// returns false and how long the caller should wait before a token is available.
+	Take(ctx context.Context, key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+	// RecordFailure registers a failure for key and returns how long the key is now locked for.
+	RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (lockedFor time.Duration, err error)
+	// LockedFor returns how long key remains locked, or zero if it is not locked.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets every failure recorded for key.
+	Reset(ctx context.Context, key string) error
+}
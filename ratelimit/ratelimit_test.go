@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLockDuration(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 10 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := policy.LockDuration(test.failures); got != test.want {
+			t.Errorf("LockDuration(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestLockoutPolicyJSONInSeconds(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 5, Base: time.Minute, Max: time.Hour, Window: 24 * time.Hour}
+	bts, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"threshold":5,"base":60,"max":3600,"window":86400}`
+	if string(bts) != want {
+		t.Errorf("got %s, want %s", bts, want)
+	}
+
+	decoded := LockoutPolicy{}
+	err = json.Unmarshal(bts, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != policy {
+		t.Errorf("got %+v, want %+v", decoded, policy)
+	}
+}
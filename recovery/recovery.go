@@ -0,0 +1,224 @@
+package recovery
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/emailer"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
+	"github.com/jcarm010/kodimerce/orders"
+	"github.com/jcarm010/kodimerce/settings"
+	"golang.org/x/net/context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const TemplateAbandonedCheckout = "email-abandoned-checkout"
+
+// MaxAge is how old a started order can be and still get reminders.
+var MaxAge = 30 * 24 * time.Hour
+
+var ErrMissingHostRoot = errors.New("The company url setting is needed to link back to the store.")
+
+// store is what the abandoned checkouts job reads and writes.
+type store interface {
+	GetGlobalSettings(ctx context.Context) entities.ServerSettings
+	ListAbandonedOrders(ctx context.Context, createdAfter time.Time, createdBefore time.Time) ([]*entities.Order, error)
+	IsEmailUnsubscribed(ctx context.Context, email string) (bool, error)
+	RecordRecoveryEmail(ctx context.Context, orderId int64, sentBefore int, sentAt time.Time) error
+	EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error)
+}
+
+type datastoreStore struct{}
+
+func (datastoreStore) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return settings.GetGlobalSettings(ctx)
+}
+
+func (datastoreStore) ListAbandonedOrders(ctx context.Context, createdAfter time.Time, createdBefore time.Time) ([]*entities.Order, error) {
+	return entities.ListAbandonedOrders(ctx, createdAfter, createdBefore)
+}
+
+func (datastoreStore) IsEmailUnsubscribed(ctx context.Context, email string) (bool, error) {
+	return entities.IsEmailUnsubscribed(ctx, email)
+}
+
+func (datastoreStore) RecordRecoveryEmail(ctx context.Context, orderId int64, sentBefore int, sentAt time.Time) error {
+	return entities.RecordRecoveryEmail(ctx, orderId, sentBefore, sentAt)
+}
+
+func (datastoreStore) EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	return emailer.EnqueueTemplate(ctx, name, data, from, to, bcc)
+}
+
+// Result is what a run of the abandoned checkouts job did.
+type Result struct {
+	Checked int `json:"checked"`
+	Sent    int `json:"sent"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// Sign returns the signature of value for purpose, so a link signed for one purpose can't be used for another.
+func Sign(secret string, purpose string, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that signature was made by Sign for purpose and value.
+func Verify(secret string, purpose string, value string, signature string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(Sign(secret, purpose, value)), []byte(signature))
+}
+
+func SignOrder(secret string, orderId int64) string {
+	return Sign(secret, "recovery", fmt.Sprintf("%v", orderId))
+}
+
+func VerifyOrder(secret string, orderId int64, signature string) bool {
+	return Verify(secret, "recovery", fmt.Sprintf("%v", orderId), signature)
+}
+
+func SignEmail(secret string, email string) string {
+	return Sign(secret, "unsubscribe", strings.ToLower(strings.TrimSpace(email)))
+}
+
+func VerifyEmail(secret string, email string, signature string) bool {
+	return Verify(secret, "unsubscribe", strings.ToLower(strings.TrimSpace(email)), signature)
+}
+
+// RecoveryUrl links back to the checkout step the customer left the order at.
+func RecoveryUrl(hostRoot string, order *entities.Order, secret string) string {
+	step := order.CheckoutStep
+	if step == "" {
+		step = "shipinfo"
+	}
+
+	return fmt.Sprintf(
+		"%s/checkout/%s?order=%v&recovery=%s",
+		hostRoot,
+		url.PathEscape(step),
+		order.Id,
+		SignOrder(secret, order.Id),
+	)
+}
+
+func UnsubscribeUrl(hostRoot string, email string, secret string) string {
+	query := url.Values{}
+	query.Set("email", email)
+	query.Set("sig", SignEmail(secret, email))
+	return fmt.Sprintf("%s/unsubscribe?%s", hostRoot, query.Encode())
+}
+
+// RunAbandonedCheckouts emails a reminder to the customers of started orders that have been
+// waiting longer than the configured delay. Each order gets at most the configured number of
+// reminders, spaced by the configured interval. hostRoot is the root of the links in the email,
+// the company url setting is used when it is empty.
+func RunAbandonedCheckouts(ctx context.Context, hostRoot string) (*Result, error) {
+	return runAbandonedCheckouts(ctx, datastoreStore{}, hostRoot)
+}
+
+func runAbandonedCheckouts(ctx context.Context, s store, hostRoot string) (*Result, error) {
+	serverSettings := s.GetGlobalSettings(ctx)
+	result := &Result{}
+	if serverSettings.AbandonedCheckoutMaxReminders <= 0 {
+		log.Infof(ctx, "Abandoned checkout reminders are turned off")
+		return result, nil
+	}
+
+	if hostRoot == "" {
+		hostRoot = serverSettings.CompanyUrl
+	}
+
+	hostRoot = strings.TrimRight(hostRoot, "/")
+	if hostRoot == "" {
+		return result, ErrMissingHostRoot
+	}
+
+	now := time.Now()
+	delay := time.Duration(serverSettings.AbandonedCheckoutDelayMinutes) * time.Minute
+	interval := time.Duration(serverSettings.AbandonedCheckoutIntervalHours) * time.Hour
+	abandoned, err := s.ListAbandonedOrders(ctx, now.Add(-MaxAge), now.Add(-delay))
+	if err != nil {
+		return result, err
+	}
+
+	for _, order := range abandoned {
+		result.Checked++
+		if order.Email == "" ||
+			order.RecoveryEmailsSent >= serverSettings.AbandonedCheckoutMaxReminders ||
+			(!order.LastRecoveryEmail.IsZero() && now.Sub(order.LastRecoveryEmail) < interval) {
+			result.Skipped++
+			continue
+		}
+
+		unsubscribed, err := s.IsEmailUnsubscribed(ctx, order.Email)
+		if err != nil {
+			log.Errorf(ctx, "Error checking if %s unsubscribed: %+v", order.Email, err)
+			result.Failed++
+			metrics.AbandonedCheckoutEmails.Inc("failed")
+			continue
+		}
+
+		if unsubscribed {
+			result.Skipped++
+			metrics.AbandonedCheckoutEmails.Inc("unsubscribed")
+			continue
+		}
+
+		err = remind(ctx, s, serverSettings, hostRoot, order)
+		if err == entities.ErrOrderNotStarted || err == entities.ErrRecoveryEmailRecorded {
+			result.Skipped++
+			continue
+		}
+
+		if err != nil {
+			log.Errorf(ctx, "Error reminding order[%v] of abandoned checkout: %+v", order.Id, err)
+			result.Failed++
+			metrics.AbandonedCheckoutEmails.Inc("failed")
+			continue
+		}
+
+		result.Sent++
+		metrics.AbandonedCheckoutEmails.Inc("sent")
+	}
+
+	log.Infof(ctx, "Abandoned checkouts: %+v", result)
+	return result, nil
+}
+
+// remind counts the reminder before sending it, so an order that was paid or reminded by another
+// run in the meantime doesn't get it.
+func remind(ctx context.Context, s store, serverSettings entities.ServerSettings, hostRoot string, order *entities.Order) error {
+	sentAt := time.Now()
+	err := s.RecordRecoveryEmail(ctx, order.Id, order.RecoveryEmailsSent, sentAt)
+	if err != nil {
+		return err
+	}
+
+	order.RecoveryEmailsSent++
+	order.LastRecoveryEmail = sentAt
+	data := orders.TemplateData(serverSettings, hostRoot, order)
+	data.RecoveryUrl = RecoveryUrl(hostRoot, order, serverSettings.SigningSecret)
+	data.UnsubscribeUrl = UnsubscribeUrl(hostRoot, order.Email, serverSettings.SigningSecret)
+
+	_, err = s.EnqueueTemplate(
+		ctx,
+		TemplateAbandonedCheckout,
+		data,
+		fmt.Sprintf("%s<%s>", serverSettings.CompanyName, serverSettings.EmailSender),
+		order.Email,
+		"",
+	)
+
+	return err
+}
@@ -0,0 +1,121 @@
+package recovery
+
+import (
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	orders   []*entities.Order
+	stored   map[int64]*entities.Order
+	enqueued []string
+}
+
+func (f *fakeStore) GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return entities.ServerSettings{
+		CompanyName:                    "Store",
+		EmailSender:                    "store@example.com",
+		SigningSecret:                  "secret",
+		AbandonedCheckoutMaxReminders:  2,
+		AbandonedCheckoutIntervalHours: 24,
+	}
+}
+
+func (f *fakeStore) ListAbandonedOrders(ctx context.Context, createdAfter time.Time, createdBefore time.Time) ([]*entities.Order, error) {
+	return f.orders, nil
+}
+
+func (f *fakeStore) IsEmailUnsubscribed(ctx context.Context, email string) (bool, error) {
+	return email == "unsubscribed@example.com", nil
+}
+
+// mirrors entities.RecordRecoveryEmail against the stored copy of each order
+func (f *fakeStore) RecordRecoveryEmail(ctx context.Context, orderId int64, sentBefore int, sentAt time.Time) error {
+	stored := f.stored[orderId]
+	if stored.Status != entities.OrderStatusStarted {
+		return entities.ErrOrderNotStarted
+	}
+
+	if stored.RecoveryEmailsSent != sentBefore {
+		return entities.ErrRecoveryEmailRecorded
+	}
+
+	stored.RecoveryEmailsSent++
+	stored.LastRecoveryEmail = sentAt
+	return nil
+}
+
+func (f *fakeStore) EnqueueTemplate(ctx context.Context, name string, data interface{}, from string, to string, bcc string) (*entities.EmailMessage, error) {
+	f.enqueued = append(f.enqueued, to)
+	return &entities.EmailMessage{}, nil
+}
+
+func TestRunAbandonedCheckouts(t *testing.T) {
+	store := &fakeStore{stored: map[int64]*entities.Order{}}
+	add := func(listed entities.Order, stored entities.Order) {
+		store.orders = append(store.orders, &listed)
+		stored.Id = listed.Id
+		store.stored[listed.Id] = &stored
+	}
+
+	started := entities.Order{Status: entities.OrderStatusStarted}
+	add(entities.Order{Id: 1, Email: "first@example.com", Status: entities.OrderStatusStarted}, started)
+	// paid after it was listed
+	add(entities.Order{Id: 2, Email: "paid@example.com", Status: entities.OrderStatusStarted}, entities.Order{Status: entities.OrderStatusPending})
+	// reminded by another run after it was listed
+	add(entities.Order{Id: 3, Email: "raced@example.com", Status: entities.OrderStatusStarted}, entities.Order{Status: entities.OrderStatusStarted, RecoveryEmailsSent: 1})
+	add(entities.Order{Id: 4, Email: "unsubscribed@example.com", Status: entities.OrderStatusStarted}, started)
+	add(entities.Order{Id: 5, Email: "done@example.com", Status: entities.OrderStatusStarted, RecoveryEmailsSent: 2}, entities.Order{Status: entities.OrderStatusStarted, RecoveryEmailsSent: 2})
+	add(entities.Order{Id: 6, Email: "recent@example.com", Status: entities.OrderStatusStarted, RecoveryEmailsSent: 1, LastRecoveryEmail: time.Now().Add(-time.Hour)}, started)
+	add(entities.Order{Id: 7, Status: entities.OrderStatusStarted}, started)
+	result, err := runAbandonedCheckouts(context.Background(), store, "https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Result{Checked: 7, Sent: 1, Skipped: 6}
+	if *result != want {
+		t.Errorf("got %+v, want %+v", *result, want)
+	}
+
+	if strings.Join(store.enqueued, ",") != "first@example.com" {
+		t.Errorf("emailed %v, want only first@example.com", store.enqueued)
+	}
+
+	if stored := store.stored[1]; stored.RecoveryEmailsSent != 1 || stored.LastRecoveryEmail.IsZero() {
+		t.Errorf("reminder wasn't recorded: %+v", stored)
+	}
+
+	if stored := store.stored[2]; stored.RecoveryEmailsSent != 0 {
+		t.Errorf("recorded a reminder for a paid order: %+v", stored)
+	}
+}
+
+func TestRunAbandonedCheckoutsNeedsHostRoot(t *testing.T) {
+	_, err := runAbandonedCheckouts(context.Background(), &fakeStore{}, "")
+	if err != ErrMissingHostRoot {
+		t.Errorf("got %v, want ErrMissingHostRoot", err)
+	}
+}
+
+func TestVerifyOrder(t *testing.T) {
+	signature := SignOrder("secret", 42)
+	if !VerifyOrder("secret", 42, signature) {
+		t.Error("rejected the signature of the order")
+	}
+
+	if VerifyOrder("secret", 43, signature) {
+		t.Error("accepted the signature of another order")
+	}
+
+	if VerifyOrder("other", 42, signature) {
+		t.Error("accepted a signature made with another secret")
+	}
+
+	if Verify("secret", "unsubscribe", "42", signature) {
+		t.Error("accepted a signature made for another purpose")
+	}
+}
@@ -12,10 +12,13 @@ import (
 func init() {
 	rand.Seed(time.Now().UnixNano())
 	router := web.New(km.ServerContext{}).
-		Middleware(web.LoggerMiddleware).
+		Middleware((*km.ServerContext).RequestID).
+		Middleware((*km.ServerContext).Metrics).
 		Middleware((*km.ServerContext).InitServerContext).
 		Middleware((*km.ServerContext).SetRedirects).
-		Middleware((*km.ServerContext).SetCORS)
+		Middleware((*km.ServerContext).SetCORS).
+		Middleware((*km.ServerContext).RateLimit).
+		Middleware((*km.ServerContext).CSRF)
 
 	router = router.Middleware((*km.ServerContext).RedirectWWW)
 
@@ -28,12 +31,15 @@ func init() {
 		Get("/product/:productId", views.ProductView).
 		Get("/store", views.StoreView).
 		Get("/store/:category", views.StoreView).
+		Get("/search", views.SearchView).
 		Get("/gallery", views.GalleriesView).
 		Get("/gallery/:galleryPath", views.GalleryView).
 		Get("/register", views.RegisterView).
 		Post("/register", (*km.ServerContext).RegisterUser).
 		Get("/login", views.LoginView).
 		Post("/login", (*km.ServerContext).LoginUser).
+		Get("/login/oidc", (*km.ServerContext).OIDCLogin).
+		Get("/login/oidc/callback", (*km.ServerContext).OIDCCallback).
 		Get("/cart", views.CartView).
 		Get("/checkout", views.RenderCheckoutView).
 		Get("/checkout/:step", views.RenderCheckoutView).
@@ -48,13 +54,24 @@ func init() {
 		Get("/gallery/upload/name/:name", (*km.ServerContext).GetGalleryUploadByName).
 		Get("/gallery/upload/:key", (*km.ServerContext).GetGalleryUpload).
 		Get("/sitemap.xml", (*km.ServerContext).GetSiteMap).
+		Get("/opensearch.xml", (*km.ServerContext).GetOpenSearchDescription).
+		Get("/feeds/google-merchant.xml", (*km.ServerContext).GetGoogleMerchantFeed).
+		Get("/feeds/facebook-catalog.csv", (*km.ServerContext).GetFacebookCatalogFeed).
+		Get("/metrics", (*km.ServerContext).ServeMetrics).
+		Get("/unsubscribe", (*km.ServerContext).Unsubscribe).
 		Get("/blog", views.BlogView).
 		Get("/blog/rss", views.GetBlogRss).
 		Get("/amp/:path", views.GetAmpDynamicPage).
 		Get("/:*", views.GetDynamicPage)
 
 	router.Subrouter(km.ServerContext{}, "/api").
-		Get("/product", (*km.ServerContext).GetProducts)
+		Get("/product", (*km.ServerContext).GetProducts).
+		Get("/search", (*km.ServerContext).SearchProducts).
+		Get("/search/content", (*km.ServerContext).SearchContent)
+
+	router.Subrouter(km.ServerContext{}, "/cron").
+		Middleware((*km.ServerContext).AuthorizeCron).
+		Get("/:job", (*km.ServerContext).RunCronJob)
 
 	router.Subrouter(km.AdminContext{}, "/admin").
 		Middleware((*km.AdminContext).Auth).
@@ -65,6 +82,8 @@ func init() {
 		Get("/km/product", (*km.AdminContext).GetProducts).
 		Post("/km/product", (*km.AdminContext).CreateProduct).
 		Put("/km/product", (*km.AdminContext).UpdateProduct).
+		Get("/km/product/export", (*km.AdminContext).ExportProducts).
+		Post("/km/product/import", (*km.AdminContext).ImportProducts).
 		Get("/km/category", (*km.AdminContext).GetCategory).
 		Post("/km/category", (*km.AdminContext).CreateCategory).
 		Put("/km/category", (*km.AdminContext).UpdateCategory).
@@ -81,10 +100,44 @@ func init() {
 		Post("/gallery/upload", (*km.AdminContext).PostGalleryUpload).
 		Get("/gallery/upload/init", (*km.AdminContext).InitSearchAPI).
 		Delete("/gallery/upload", (*km.AdminContext).DeleteGalleryUpload).
+		Put("/gallery/upload/:key", (*km.AdminContext).PutGalleryUpload).
+		Get("/gallery/upload/references", (*km.AdminContext).GetGalleryUploadReferences).
+		Get("/gallery/upload/export", (*km.AdminContext).ExportGalleryUploads).
+		Get("/gallery/upload/exports", (*km.AdminContext).GetGalleryUploadExports).
+		Post("/gallery/upload/import", (*km.AdminContext).ImportGalleryUploads).
+		Get("/gallery/upload/import/:id", (*km.AdminContext).GetGalleryUploadImport).
+		Post("/gallery/upload/sessions", (*km.AdminContext).CreateUploadSession).
+		Get("/gallery/upload/sessions/:id", (*km.AdminContext).GetUploadSession).
+		Put("/gallery/upload/sessions/:id", (*km.AdminContext).PutUploadChunk).
+		Post("/gallery/upload/sessions/:id/complete", (*km.AdminContext).CompleteUploadSession).
+		Delete("/gallery/upload/sessions/:id", (*km.AdminContext).DeleteUploadSession).
 		Get("/gallery/upload/url", (*km.AdminContext).GetGalleryUploadUrl).
 		Get("/order", (*km.AdminContext).GetOrders).
 		Put("/order", (*km.AdminContext).OverrideOrder).
+		Get("/km/order/:orderId/shipment", (*km.AdminContext).GetShipments).
+		Post("/km/order/:orderId/shipment", (*km.AdminContext).CreateShipment).
+		Put("/km/order/:orderId/shipment/:shipmentId", (*km.AdminContext).UpdateShipment).
 		Put("/settings", (*km.AdminContext).UpdateGeneralSettings).
+		Get("/km/email", (*km.AdminContext).GetEmailMessages).
+		Get("/km/email/:emailId", (*km.AdminContext).GetEmailMessage).
+		Post("/km/email/:emailId/resend", (*km.AdminContext).ResendEmailMessage).
+		Get("/km/email-template", (*km.AdminContext).GetEmailTemplates).
+		Get("/km/email-template/:name", (*km.AdminContext).GetEmailTemplate).
+		Put("/km/email-template/:name", (*km.AdminContext).UpdateEmailTemplate).
+		Delete("/km/email-template/:name", (*km.AdminContext).ResetEmailTemplate).
+		Post("/km/email-template/:name/preview", (*km.AdminContext).PreviewEmailTemplate).
+		Post("/km/email-template/:name/test", (*km.AdminContext).SendTestEmailTemplate).
+		Get("/km/mailbox", (*km.AdminContext).GetMailbox).
+		Get("/km/mailbox/:mailId/html", (*km.AdminContext).GetMailboxHTML).
+		Delete("/km/mailbox", (*km.AdminContext).ClearMailbox).
+		Get("/km/report/abandoned-checkouts", (*km.AdminContext).GetAbandonedCheckoutsReport).
+		Get("/km/job", (*km.AdminContext).GetJobs).
+		Get("/km/job/:job/run", (*km.AdminContext).GetJobRuns).
+		Post("/km/job/:job/run", (*km.AdminContext).RunJob).
+		Get("/km/backup", (*km.AdminContext).GetBackup).
+		Post("/km/backup", (*km.AdminContext).PostBackup).
+		Get("/km/migration", (*km.AdminContext).GetMigrations).
+		Post("/km/migration/run", (*km.AdminContext).RunMigrations).
 		Get("/", views.AdminView).
 		/* Write new admin endpoints above. These two need to be the last admin endpoints. */
 		Get("/:page", views.AdminView).
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"github.com/jcarm010/kodimerce/log"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+// Loader is an index filled from the datastore. It loads every document the first time it is
+// searched, and again in the background once it is older than TTL, while searches keep using the
+// documents it has.
+//
+// Every instance keeps its own index. Changes made through this instance are applied right away with
+// Put and Delete, but changes made through other instances only show up here after the next reload,
+// so results can be up to TTL behind the datastore.
+type Loader struct {
+	Index Index
+	Load  func(ctx context.Context) ([]*Document, error)
+	TTL   time.Duration
+
+	rebuildMu sync.Mutex // held while documents are loaded, so one reload runs at a time
+
+	mu         sync.Mutex
+	loaded     time.Time
+	refreshing bool
+	changes    map[string]*Document // put (or deleted, when nil) while a reload loads, nil otherwise
+}
+
+func NewLoader(index Index, ttl time.Duration, load func(ctx context.Context) ([]*Document, error)) *Loader {
+	return &Loader{Index: index, Load: load, TTL: ttl}
+}
+
+// Rebuild loads every document again.
+func (l *Loader) Rebuild(ctx context.Context) error {
+	l.rebuildMu.Lock()
+	defer l.rebuildMu.Unlock()
+	return l.rebuild(ctx)
+}
+
+func (l *Loader) rebuild(ctx context.Context) error {
+	l.mu.Lock()
+	l.changes = map[string]*Document{}
+	l.mu.Unlock()
+
+	docs, err := l.Load(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changes := l.changes
+	l.changes = nil
+	if err != nil {
+		return err
+	}
+
+	// documents changed while loading may be missing from or stale in what was loaded
+	for id, doc := range changes {
+		docs = withChange(docs, id, doc)
+	}
+
+	err = l.Index.Reset(docs)
+	if err != nil {
+		return err
+	}
+
+	l.loaded = time.Now()
+	return nil
+}
+
+func withChange(docs []*Document, id string, doc *Document) []*Document {
+	kept := docs[:0]
+	for _, existing := range docs {
+		if existing.Id != id {
+			kept = append(kept, existing)
+		}
+	}
+
+	if doc != nil {
+		kept = append(kept, doc)
+	}
+
+	return kept
+}
+
+func (l *Loader) ensureLoaded(ctx context.Context) error {
+	l.mu.Lock()
+	if l.loaded.IsZero() {
+		l.mu.Unlock()
+		return l.loadFirst(ctx)
+	}
+
+	if l.TTL > 0 && time.Since(l.loaded) >= l.TTL && !l.refreshing {
+		l.refreshing = true
+		go l.refresh()
+	}
+
+	l.mu.Unlock()
+	return nil
+}
+
+// loadFirst loads the index the first time it is searched. Searches wait for it since there is
+// nothing to answer them with yet.
+func (l *Loader) loadFirst(ctx context.Context) error {
+	l.rebuildMu.Lock()
+	defer l.rebuildMu.Unlock()
+	l.mu.Lock()
+	loaded := !l.loaded.IsZero()
+	l.mu.Unlock()
+	if loaded {
+		// another search loaded it while this one waited
+		return nil
+	}
+
+	return l.rebuild(ctx)
+}
+
+// refresh reloads an expired index. It does not use the context of the search that noticed the
+// index expired, since that request may be done before the reload is.
+func (l *Loader) refresh() {
+	ctx := context.Background()
+	err := l.Rebuild(ctx)
+	if err != nil {
+		log.Errorf(ctx, "Failed to reload the search index: %+v", err)
+	}
+
+	l.mu.Lock()
+	l.refreshing = false
+	l.mu.Unlock()
+}
+
+func (l *Loader) Search(ctx context.Context, q *Query) (*Result, error) {
+	err := l.ensureLoaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Index.Search(q)
+}
+
+func (l *Loader) Put(doc *Document) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.changes != nil {
+		l.changes[doc.Id] = doc
+	}
+
+	return l.Index.Put(doc)
+}
+
+func (l *Loader) Delete(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.changes != nil {
+		l.changes[id] = nil
+	}
+
+	return l.Index.Delete(id)
+}
@@ -0,0 +1,101 @@
+package search
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestLoader(t *testing.T) {
+	loads := 0
+	var loadErr error
+	loader := NewLoader(NewMemoryIndex(nil), time.Hour, func(ctx context.Context) ([]*Document, error) {
+		loads++
+		return []*Document{{Id: "1", Text: map[string]string{"name": "shirt"}}}, loadErr
+	})
+
+	for i := 0; i < 2; i++ {
+		result, err := loader.Search(context.Background(), &Query{Text: "shirt"})
+		if err != nil || result.Total != 1 {
+			t.Fatalf("got %+v, %v", result, err)
+		}
+	}
+
+	if loads != 1 {
+		t.Errorf("loaded %d times within the ttl", loads)
+	}
+
+	loader.Put(&Document{Id: "2", Text: map[string]string{"name": "shirt"}})
+	if result, _ := loader.Search(context.Background(), &Query{Text: "shirt"}); result.Total != 2 {
+		t.Errorf("a put document is not searchable")
+	}
+
+	loader.loaded = time.Now().Add(-2 * time.Hour)
+	loader.Search(context.Background(), &Query{Text: "shirt"})
+	waitRefreshed(loader)
+	if result, _ := loader.Search(context.Background(), &Query{Text: "shirt"}); result.Total != 1 || loads != 2 {
+		t.Errorf("an expired index was not reloaded")
+	}
+
+	loadErr = errors.New("Datastore unavailable.")
+	if err := loader.Rebuild(context.Background()); err != loadErr {
+		t.Errorf("got %v", err)
+	}
+}
+
+func waitRefreshed(l *Loader) {
+	for {
+		l.mu.Lock()
+		refreshing := l.refreshing
+		l.mu.Unlock()
+		if !refreshing {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoaderReloadsInTheBackground(t *testing.T) {
+	docs := []*Document{{Id: "1", Text: map[string]string{"name": "shirt"}}}
+	loading := make(chan bool)
+	release := make(chan bool)
+	loader := NewLoader(NewMemoryIndex(nil), time.Hour, func(ctx context.Context) ([]*Document, error) {
+		if loading != nil {
+			loading <- true
+			<-release
+		}
+
+		return docs, nil
+	})
+
+	first := loading
+	loading = nil
+	if result, err := loader.Search(context.Background(), &Query{Text: "shirt"}); err != nil || result.Total != 1 {
+		t.Fatalf("got %+v, %v", result, err)
+	}
+
+	// the reload loads what was in the datastore before the put and delete below
+	loading = first
+	docs = []*Document{{Id: "1", Text: map[string]string{"name": "shirt"}}, {Id: "3", Text: map[string]string{"name": "shirt"}}}
+	loader.loaded = time.Now().Add(-2 * time.Hour)
+	if result, err := loader.Search(context.Background(), &Query{Text: "shirt"}); err != nil || result.Total != 1 {
+		t.Fatalf("an expired search got %+v, %v, want the loaded documents", result, err)
+	}
+
+	<-loading
+	if result, _ := loader.Search(context.Background(), &Query{Text: "shirt"}); result.Total != 1 {
+		t.Errorf("a search while reloading got %d results", result.Total)
+	}
+
+	loader.Put(&Document{Id: "2", Text: map[string]string{"name": "shirt"}})
+	loader.Delete("1")
+	close(release)
+	waitRefreshed(loader)
+
+	result, _ := loader.Search(context.Background(), &Query{Text: "shirt"})
+	if result.Total != 2 || result.Hits[0].Id == "1" || result.Hits[1].Id == "1" {
+		t.Errorf("got %+v, want the reloaded documents with the put and delete made while loading", result)
+	}
+}
@@ -0,0 +1,478 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	DefaultLimit = 20
+
+	prefixBoost = 0.6
+	fuzzyBoost  = 0.4
+)
+
+// MemoryIndex keeps the documents and an inverted index of their words in memory. It suits the
+// size of a store's catalog or media library, and is rebuilt when the process starts.
+type MemoryIndex struct {
+	mu         sync.RWMutex
+	weights    map[string]float64
+	docs       map[string]*memoryEntry
+	postings   map[string]map[string]float64 // word -> document id -> weight
+	vocabulary []string                      // sorted words, nil when it needs to be rebuilt
+}
+
+type memoryEntry struct {
+	doc   *Document
+	words map[string]float64
+}
+
+// NewMemoryIndex creates an index where a match on a text field counts as much as its weight.
+// Fields without a weight count 1.
+func NewMemoryIndex(weights map[string]float64) *MemoryIndex {
+	return &MemoryIndex{
+		weights:  weights,
+		docs:     map[string]*memoryEntry{},
+		postings: map[string]map[string]float64{},
+	}
+}
+
+func (m *MemoryIndex) Put(doc *Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(doc)
+	return nil
+}
+
+func (m *MemoryIndex) put(doc *Document) {
+	m.delete(doc.Id)
+	entry := &memoryEntry{doc: doc, words: map[string]float64{}}
+	for field, text := range doc.Text {
+		weight, exists := m.weights[field]
+		if !exists {
+			weight = 1
+		}
+
+		seen := map[string]bool{}
+		for _, word := range Tokenize(text) {
+			// a word counts once per field so long descriptions don't drown out names
+			if seen[word] {
+				continue
+			}
+
+			seen[word] = true
+			entry.words[word] += weight
+		}
+	}
+
+	for word, weight := range entry.words {
+		posting, exists := m.postings[word]
+		if !exists {
+			posting = map[string]float64{}
+			m.postings[word] = posting
+			m.vocabulary = nil
+		}
+
+		posting[doc.Id] = weight
+	}
+
+	m.docs[doc.Id] = entry
+}
+
+func (m *MemoryIndex) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delete(id)
+	return nil
+}
+
+func (m *MemoryIndex) delete(id string) {
+	entry, exists := m.docs[id]
+	if !exists {
+		return
+	}
+
+	for word := range entry.words {
+		posting := m.postings[word]
+		delete(posting, id)
+		if len(posting) == 0 {
+			delete(m.postings, word)
+			m.vocabulary = nil
+		}
+	}
+
+	delete(m.docs, id)
+}
+
+// Reset indexes docs on the side and then swaps them in, so searches are not blocked while they are indexed.
+func (m *MemoryIndex) Reset(docs []*Document) error {
+	fresh := NewMemoryIndex(m.weights)
+	for _, doc := range docs {
+		fresh.put(doc)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = fresh.docs
+	m.postings = fresh.postings
+	m.vocabulary = nil
+	return nil
+}
+
+func (m *MemoryIndex) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.docs)
+}
+
+func (m *MemoryIndex) Search(q *Query) (*Result, error) {
+	offset, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	vocabulary := m.sortedVocabulary()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	scores := m.score(Tokenize(q.Text), vocabulary)
+	hits := make([]*Hit, 0, len(scores))
+	facets := map[string]map[string]int{}
+	for _, field := range q.Facets {
+		facets[field] = map[string]int{}
+	}
+
+	rangeFacets := map[string][]BucketCount{}
+	for _, facet := range q.RangeFacets {
+		counts := make([]BucketCount, len(facet.Buckets))
+		for index, bucket := range facet.Buckets {
+			counts[index].Bucket = bucket
+		}
+
+		rangeFacets[facet.Field] = counts
+	}
+
+	for id, score := range scores {
+		doc := m.docs[id].doc
+		if !matchesFilters(doc, q) {
+			continue
+		}
+
+		for field, counts := range facets {
+			for _, value := range doc.Keywords[field] {
+				counts[value]++
+			}
+		}
+
+		for field, counts := range rangeFacets {
+			value, exists := doc.Numbers[field]
+			if !exists {
+				continue
+			}
+
+			for index := range counts {
+				if inRange(value, counts[index].Min, counts[index].Max) {
+					counts[index].Count++
+				}
+			}
+		}
+
+		hits = append(hits, &Hit{Id: id, Score: score, Source: doc.Source})
+	}
+
+	sortHits(hits, q.Sort, m.docs)
+	result := &Result{Total: len(hits), Hits: make([]*Hit, 0)}
+	if offset < len(hits) {
+		end := offset + limit
+		if end > len(hits) {
+			end = len(hits)
+		}
+
+		result.Hits = hits[offset:end]
+		if end < len(hits) {
+			result.Cursor = encodeCursor(end)
+		}
+	}
+
+	if len(facets) > 0 {
+		result.Facets = map[string][]FacetCount{}
+		for field, counts := range facets {
+			result.Facets[field] = sortFacetCounts(counts)
+		}
+	}
+
+	if len(rangeFacets) > 0 {
+		result.RangeFacets = rangeFacets
+	}
+
+	return result, nil
+}
+
+// sortedVocabulary returns every indexed word, sorted. Changes to the index replace the slice
+// instead of modifying it, so it can be read after the lock is released.
+func (m *MemoryIndex) sortedVocabulary() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.vocabulary == nil {
+		m.vocabulary = make([]string, 0, len(m.postings))
+		for word := range m.postings {
+			m.vocabulary = append(m.vocabulary, word)
+		}
+
+		sort.Strings(m.vocabulary)
+	}
+
+	return m.vocabulary
+}
+
+// score returns the relevance of every document that matches all the words. Every document
+// matches when there are no words.
+func (m *MemoryIndex) score(words []string, vocabulary []string) map[string]float64 {
+	scores := map[string]float64{}
+	if len(words) == 0 {
+		for id := range m.docs {
+			scores[id] = 0
+		}
+
+		return scores
+	}
+
+	for index, word := range uniqueWords(words) {
+		wordScores := m.matchWord(word, vocabulary)
+		if index == 0 {
+			scores = wordScores
+			continue
+		}
+
+		for id, score := range scores {
+			wordScore, matched := wordScores[id]
+			if !matched {
+				delete(scores, id)
+				continue
+			}
+
+			scores[id] = score + wordScore
+		}
+	}
+
+	return scores
+}
+
+// matchWord scores the documents containing word, a word starting with it or a word a typo or
+// two away from it. A document gets the score of its best match.
+func (m *MemoryIndex) matchWord(word string, vocabulary []string) map[string]float64 {
+	scores := map[string]float64{}
+	add := func(match string, boost float64) {
+		for id, weight := range m.postings[match] {
+			if score := weight * boost; score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+
+	add(word, 1)
+	start := sort.SearchStrings(vocabulary, word)
+	for _, candidate := range vocabulary[start:] {
+		if !strings.HasPrefix(candidate, word) {
+			break
+		}
+
+		if candidate != word {
+			add(candidate, prefixBoost)
+		}
+	}
+
+	maxDistance := allowedTypos(word)
+	if maxDistance == 0 {
+		return scores
+	}
+
+	wordLength := utf8.RuneCountInString(word)
+	for _, candidate := range vocabulary {
+		lengthDifference := utf8.RuneCountInString(candidate) - wordLength
+		if lengthDifference > maxDistance || -lengthDifference > maxDistance || strings.HasPrefix(candidate, word) {
+			continue
+		}
+
+		distance := editDistance(word, candidate, maxDistance)
+		if distance <= maxDistance {
+			add(candidate, fuzzyBoost/float64(distance))
+		}
+	}
+
+	return scores
+}
+
+// allowedTypos is how far a word may be from the query word and still match. Short words must
+// match exactly, "cat" would otherwise match "car" and "hat".
+func allowedTypos(word string) int {
+	length := utf8.RuneCountInString(word)
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance is the Damerau-Levenshtein distance between a and b, counting swapped letters
+// as one typo. It gives up and returns max+1 once the distance is known to be over max.
+func editDistance(a string, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	beforePrevious := make([]int, len(rb)+1)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		rowMin := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				current[j] = minInt(current[j], beforePrevious[j-2]+1)
+			}
+
+			if current[j] < rowMin {
+				rowMin = current[j]
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+
+	return previous[len(rb)]
+}
+
+func matchesFilters(doc *Document, q *Query) bool {
+	for field, values := range q.Filters {
+		if len(values) == 0 {
+			continue
+		}
+
+		if !hasAny(doc.Keywords[field], values) {
+			return false
+		}
+	}
+
+	for _, r := range q.Ranges {
+		value, exists := doc.Numbers[r.Field]
+		if !exists {
+			return false
+		}
+
+		if !inRange(value, r.Min, r.Max) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func inRange(value float64, min *float64, max *float64) bool {
+	return (min == nil || value >= *min) && (max == nil || value < *max)
+}
+
+func hasAny(values []string, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sortHits orders hits by the sort fields, then by relevance and id so pages are stable.
+// Documents without a sort field go last.
+func sortHits(hits []*Hit, sorts []Sort, docs map[string]*memoryEntry) {
+	sort.Slice(hits, func(i, j int) bool {
+		for _, s := range sorts {
+			var a, b float64
+			if s.Field == ScoreField {
+				a, b = hits[i].Score, hits[j].Score
+			} else {
+				var aExists, bExists bool
+				a, aExists = docs[hits[i].Id].doc.Numbers[s.Field]
+				b, bExists = docs[hits[j].Id].doc.Numbers[s.Field]
+				if aExists != bExists {
+					return aExists
+				}
+			}
+
+			if a != b {
+				if s.Desc {
+					return a > b
+				}
+
+				return a < b
+			}
+		}
+
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+
+		return hits[i].Id < hits[j].Id
+	})
+}
+
+func sortFacetCounts(counts map[string]int) []FacetCount {
+	facetCounts := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facetCounts = append(facetCounts, FacetCount{Value: value, Count: count})
+	}
+
+	sort.Slice(facetCounts, func(i, j int) bool {
+		if facetCounts[i].Count != facetCounts[j].Count {
+			return facetCounts[i].Count > facetCounts[j].Count
+		}
+
+		return facetCounts[i].Value < facetCounts[j].Value
+	})
+
+	return facetCounts
+}
+
+func uniqueWords(words []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(words))
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			unique = append(unique, word)
+		}
+	}
+
+	return unique
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
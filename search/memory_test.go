@@ -0,0 +1,196 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testIndex() *MemoryIndex {
+	index := NewMemoryIndex(map[string]float64{"name": 3})
+	index.Reset([]*Document{
+		{
+			Id:       "1",
+			Text:     map[string]string{"name": "Red shirt", "description": "A cotton shirt"},
+			Keywords: map[string][]string{"category": {"shirts"}, "color": {"red"}},
+			Numbers:  map[string]float64{"price": 20},
+		},
+		{
+			Id:       "2",
+			Text:     map[string]string{"name": "Blue shirt", "description": "Red stitching"},
+			Keywords: map[string][]string{"category": {"shirts"}, "color": {"blue"}},
+			Numbers:  map[string]float64{"price": 35},
+		},
+		{
+			Id:       "3",
+			Text:     map[string]string{"name": "Leather wallet", "description": "Fits every pocket"},
+			Keywords: map[string][]string{"category": {"accessories"}},
+			Numbers:  map[string]float64{"price": 50},
+		},
+		{
+			Id:   "4",
+			Text: map[string]string{"name": "Gift card"},
+		},
+	})
+
+	return index
+}
+
+func ids(result *Result) []string {
+	list := make([]string, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		list = append(list, hit.Id)
+	}
+
+	return list
+}
+
+func search(t *testing.T, index Index, q *Query) *Result {
+	t.Helper()
+	result, err := index.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestSearchText(t *testing.T) {
+	index := testIndex()
+	tests := map[string][]string{
+		"red":          {"1", "2"}, // the name weighs more than the description
+		"RED shirt":    {"1", "2"},
+		"shi":          {"1", "2"},
+		"leathre":      {"3"},
+		"walet":        {"3"},
+		"lether poket": {"3"},
+		"cat":          {},
+		"red wallet":   {},
+		"":             {"1", "2", "3", "4"},
+	}
+
+	for text, want := range tests {
+		if got := ids(search(t, index, &Query{Text: text})); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestSearchRanksExactMatchesFirst(t *testing.T) {
+	index := NewMemoryIndex(nil)
+	index.Put(&Document{Id: "fuzzy", Text: map[string]string{"name": "short"}})
+	index.Put(&Document{Id: "prefix", Text: map[string]string{"name": "shirts"}})
+	index.Put(&Document{Id: "exact", Text: map[string]string{"name": "shirt"}})
+	if got := ids(search(t, index, &Query{Text: "shirt"})); !reflect.DeepEqual(got, []string{"exact", "prefix", "fuzzy"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestSearchFiltersAndFacets(t *testing.T) {
+	result := search(t, testIndex(), &Query{
+		Filters:     map[string][]string{"category": {"shirts", "accessories"}},
+		Ranges:      []Range{{Field: "price", Min: Float(20), Max: Float(50)}},
+		Facets:      []string{"color"},
+		RangeFacets: []RangeFacet{{Field: "price", Buckets: []Bucket{{Label: "cheap", Max: Float(30)}, {Label: "other", Min: Float(30)}}}},
+		Sort:        []Sort{{Field: "price", Desc: true}},
+	})
+
+	if got := ids(result); !reflect.DeepEqual(got, []string{"2", "1"}) {
+		t.Errorf("got %v", got)
+	}
+
+	wantFacets := []FacetCount{{Value: "blue", Count: 1}, {Value: "red", Count: 1}}
+	if !reflect.DeepEqual(result.Facets["color"], wantFacets) {
+		t.Errorf("got facets %+v", result.Facets)
+	}
+
+	buckets := result.RangeFacets["price"]
+	if len(buckets) != 2 || buckets[0].Count != 1 || buckets[1].Count != 1 {
+		t.Errorf("got range facets %+v", buckets)
+	}
+}
+
+func TestSearchSortsMissingNumbersLast(t *testing.T) {
+	result := search(t, testIndex(), &Query{Sort: []Sort{{Field: "price"}}})
+	if got := ids(result); !reflect.DeepEqual(got, []string{"1", "2", "3", "4"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	index := testIndex()
+	seen := make([]string, 0)
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		result := search(t, index, &Query{Limit: 3, Cursor: cursor, Sort: []Sort{{Field: "price"}}})
+		if result.Total != 4 {
+			t.Fatalf("got a total of %d", result.Total)
+		}
+
+		seen = append(seen, ids(result)...)
+		cursor = result.Cursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(seen, []string{"1", "2", "3", "4"}) {
+		t.Errorf("got %v", seen)
+	}
+
+	if _, err := index.Search(&Query{Cursor: "not a cursor"}); err != ErrInvalidCursor {
+		t.Errorf("got %v for a bad cursor", err)
+	}
+}
+
+func TestPutReplacesAndDeleteRemoves(t *testing.T) {
+	index := testIndex()
+	index.Put(&Document{Id: "3", Text: map[string]string{"name": "Canvas bag"}})
+	if got := ids(search(t, index, &Query{Text: "leather"})); len(got) != 0 {
+		t.Errorf("the old words of a replaced document still match: %v", got)
+	}
+
+	if got := ids(search(t, index, &Query{Text: "canvas"})); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("got %v", got)
+	}
+
+	index.Delete("3")
+	if index.Count() != 3 || len(search(t, index, &Query{Text: "canvas"}).Hits) != 0 {
+		t.Errorf("the deleted document is still indexed")
+	}
+
+	if _, exists := index.postings["canvas"]; exists {
+		t.Errorf("the words of the deleted document are still in the vocabulary")
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"shirt", "shirt", 0},
+		{"shirt", "shrit", 1},
+		{"shirt", "short", 1},
+		{"wallet", "walet", 1},
+		{"leather", "lether", 1},
+		{"kitten", "sitting", 3},
+		{"café", "cafe", 1},
+	}
+
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b, 5); got != test.want {
+			t.Errorf("editDistance(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+
+	if got := editDistance("kitten", "sitting", 1); got != 2 {
+		t.Errorf("got %d, want max+1", got)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("Red-Shirt_2.JPG  Café")
+	if want := []string{"red", "shirt", "2", "jpg", "café"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
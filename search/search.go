@@ -0,0 +1,142 @@
+// Package search is a full-text index that runs anywhere, replacing the App Engine Search API.
+// Indexes live in memory and are rebuilt from the datastore, see Loader.
+package search
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ScoreField sorts by relevance when used in Query.Sort.
+const ScoreField = "_score"
+
+var ErrInvalidCursor = errors.New("Invalid search cursor.")
+
+// Document is what gets indexed.
+type Document struct {
+	Id string
+	// Text is searched by the query text, the weight of each field comes from the index.
+	Text map[string]string
+	// Keywords are matched exactly by filters and counted by facets.
+	Keywords map[string][]string
+	// Numbers are matched by ranges and used to sort. Dates are stored with Time.
+	Numbers map[string]float64
+	// Source is returned with the hits so results can be shown without loading them again.
+	Source interface{}
+}
+
+type Range struct {
+	Field string
+	Min   *float64
+	Max   *float64 // exclusive
+}
+
+// Bucket is one range of a range facet.
+type Bucket struct {
+	Label string   `json:"label"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"` // exclusive
+}
+
+// RangeFacet counts the matching documents whose number falls in each bucket.
+type RangeFacet struct {
+	Field   string
+	Buckets []Bucket
+}
+
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Query matches the documents containing every term of Text. Terms match whole words, word
+// prefixes and, when they are long enough, words one or two typos away, in that order of relevance.
+// An empty Text matches every document.
+type Query struct {
+	Text        string
+	Filters     map[string][]string // a document must have one of the values of every field
+	Ranges      []Range
+	Facets      []string
+	RangeFacets []RangeFacet
+	Sort        []Sort // relevance when empty
+	Limit       int
+	Cursor      string
+}
+
+type Hit struct {
+	Id     string      `json:"id"`
+	Score  float64     `json:"score"`
+	Source interface{} `json:"source"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type BucketCount struct {
+	Bucket
+	Count int `json:"count"`
+}
+
+type Result struct {
+	Hits        []*Hit                   `json:"hits"`
+	Total       int                      `json:"total"`
+	Cursor      string                   `json:"cursor"`
+	Facets      map[string][]FacetCount  `json:"facets,omitempty"`
+	RangeFacets map[string][]BucketCount `json:"range_facets,omitempty"`
+}
+
+// Index is a full-text index. MemoryIndex is the one used by default, the interface lets a
+// server backed index take its place.
+type Index interface {
+	Put(doc *Document) error
+	Delete(id string) error
+	// Reset replaces every document in the index.
+	Reset(docs []*Document) error
+	Search(q *Query) (*Result, error)
+	Count() int
+}
+
+// Time turns a date into a number documents can be sorted and filtered by.
+func Time(t time.Time) float64 {
+	return float64(t.Unix())
+}
+
+func Float(value float64) *float64 {
+	return &value
+}
+
+// Tokenize splits text into lower cased words. Anything that isn't a letter or a digit separates
+// words, so file names like "red-shirt_2.jpg" give "red", "shirt", "2" and "jpg".
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	bts, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(string(bts))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,139 @@
+package search
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const ellipsis = "…"
+
+var tagsRegex = regexp.MustCompile(`<[^>]*>`)
+
+// StripTags turns html into the plain text it shows.
+func StripTags(text string) string {
+	return html.UnescapeString(tagsRegex.ReplaceAllString(text, " "))
+}
+
+type wordSpan struct {
+	start, end int // byte offsets in the text
+	matched    bool
+}
+
+// Highlight escapes text and wraps the words matching the query in <mark> tags.
+func Highlight(text string, query string) template.HTML {
+	return render(text, wordSpans(text, query), 0, len(text))
+}
+
+// Snippet is the part of text around the first word matching the query, at most length letters
+// long, with the matching words highlighted. It is the start of the text when nothing matches.
+func Snippet(text string, query string, length int) template.HTML {
+	text = strings.Join(strings.Fields(text), " ")
+	spans := wordSpans(text, query)
+	if utf8.RuneCountInString(text) <= length {
+		return render(text, spans, 0, len(text))
+	}
+
+	// start a few words before the first match so it reads in context
+	first := 0
+	for index, span := range spans {
+		if span.matched {
+			first = index
+			break
+		}
+	}
+
+	start := 0
+	if len(spans) > 0 {
+		start = spans[first].start
+	}
+
+	for index := first - 1; index >= 0; index-- {
+		if utf8.RuneCountInString(text[spans[index].start:spans[first].end]) > length/3 {
+			break
+		}
+
+		start = spans[index].start
+	}
+
+	end := start
+	for _, span := range spans {
+		if span.start < start {
+			continue
+		}
+
+		if utf8.RuneCountInString(text[start:span.end]) > length {
+			break
+		}
+
+		end = span.end
+	}
+
+	snippet := render(text, spans, start, end)
+	if start > 0 {
+		snippet = ellipsis + snippet
+	}
+
+	if end < len(text) {
+		snippet += ellipsis
+	}
+
+	return snippet
+}
+
+// wordSpans finds the words of text and which of them match a word of the query, the same way
+// the index matches them: whole, by prefix or with a typo or two.
+func wordSpans(text string, query string) []wordSpan {
+	queryWords := uniqueWords(Tokenize(query))
+	spans := make([]wordSpan, 0)
+	start := -1
+	for offset, r := range text + " " {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWordRune && start < 0 {
+			start = offset
+		} else if !isWordRune && start >= 0 {
+			word := strings.ToLower(text[start:offset])
+			spans = append(spans, wordSpan{start: start, end: offset, matched: matchesAny(word, queryWords)})
+			start = -1
+		}
+	}
+
+	return spans
+}
+
+func matchesAny(word string, queryWords []string) bool {
+	for _, queryWord := range queryWords {
+		if strings.HasPrefix(word, queryWord) {
+			return true
+		}
+
+		maxDistance := allowedTypos(queryWord)
+		if maxDistance > 0 && editDistance(queryWord, word, maxDistance) <= maxDistance {
+			return true
+		}
+	}
+
+	return false
+}
+
+func render(text string, spans []wordSpan, start int, end int) template.HTML {
+	var b strings.Builder
+	position := start
+	for _, span := range spans {
+		if !span.matched || span.start < start || span.end > end {
+			continue
+		}
+
+		b.WriteString(html.EscapeString(text[position:span.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[span.start:span.end]))
+		b.WriteString("</mark>")
+		position = span.end
+	}
+
+	b.WriteString(html.EscapeString(text[position:end]))
+	return template.HTML(b.String())
+}
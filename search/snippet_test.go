@@ -0,0 +1,55 @@
+package search
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestStripTags(t *testing.T) {
+	if got := StripTags("<p>Fish &amp; <b>chips</b></p>"); strings.Join(strings.Fields(got), " ") != "Fish & chips" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text, query string
+		want        template.HTML
+	}{
+		{"Red shirts & shoes", "shirt", "Red <mark>shirts</mark> &amp; shoes"},
+		{"Leather boots", "lether", "<mark>Leather</mark> boots"},
+		{"<script>alert(1)</script>", "alert", "&lt;script&gt;<mark>alert</mark>(1)&lt;/script&gt;"},
+		{"Cat and car", "cat", "<mark>Cat</mark> and car"},
+		{"Nothing here", "", "Nothing here"},
+	}
+
+	for _, test := range tests {
+		if got := Highlight(test.text, test.query); got != test.want {
+			t.Errorf("Highlight(%q, %q) = %q, want %q", test.text, test.query, got, test.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "The first sentence is about nothing. " + strings.Repeat("Filler words go here. ", 10) +
+		"Our leather boots are made by hand. " + strings.Repeat("More filler after. ", 10)
+
+	got := string(Snippet(text, "boots", 60))
+	if !strings.HasPrefix(got, ellipsis) || !strings.HasSuffix(got, ellipsis) || !strings.Contains(got, "<mark>boots</mark>") {
+		t.Errorf("got %q", got)
+	}
+
+	plain := strings.Replace(strings.Replace(strings.Trim(got, ellipsis), "<mark>", "", -1), "</mark>", "", -1)
+	if len([]rune(plain)) > 60 || !strings.Contains(plain, "leather boots") {
+		t.Errorf("the snippet %q is too long or lost its context", plain)
+	}
+
+	if got := string(Snippet(text, "missing", 30)); !strings.HasPrefix(got, "The first sentence") || !strings.HasSuffix(got, ellipsis) {
+		t.Errorf("without a match the snippet should start the text, got %q", got)
+	}
+
+	if got := Snippet("Short  \n text", "text", 100); got != "Short <mark>text</mark>" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -14,6 +14,14 @@ type BlobInfo struct {
 	Size         int64     `datastore:"size"`
 	MD5          string    `datastore:"md5_hash"`
 	UploadId     string    `datastore:"upload_id,omitempty"`
+	Title        string    `datastore:"title,noindex"`
+	Alt          string    `datastore:"alt,noindex"`
+	Caption      string    `datastore:"caption,noindex"`
+	Folder       string    `datastore:"folder"` // slash separated path such as products/shoes, empty for the root
+	Tags         []string  `datastore:"tags"`
+	Width        int       `datastore:"width,noindex"`  // pixels, 0 when it isn't an image
+	Height       int       `datastore:"height,noindex"` // pixels, 0 when it isn't an image
+	Duplicate    bool      `datastore:"-"`              // set when an upload returns a file that was already uploaded
 
 	// ObjectName is the Google Cloud Storage name for this blob.
 	ObjectName string `datastore:"gs_object_name"`
@@ -2,127 +2,184 @@ package search_api
 
 import (
 	"context"
-	"google.golang.org/appengine/search"
-	"strconv"
+	"github.com/jcarm010/kodimerce/search"
 	"strings"
 	"time"
 )
 
 const (
 	BlobIndexName = "blobs"
+
+	FieldFolder = "folder"
+	FieldTags   = "tags"
+	fieldKey    = "key"
+	fieldName   = "filename"
+)
+
+// IndexTTL is how long the uploads index is used before it is loaded again from the datastore. Each
+// instance keeps its own index, so uploads changed through another instance can take this long to
+// show up in its searches.
+var IndexTTL = 10 * time.Minute
+
+var (
+	blobIndex = search.NewMemoryIndex(map[string]float64{
+		"title":    3,
+		"alt":      2,
+		"tags":     2,
+		"caption":  1,
+		"filename": 1,
+	})
+
+	blobLoader = search.NewLoader(blobIndex, IndexTTL, func(ctx context.Context) ([]*search.Document, error) {
+		return nil, nil
+	})
 )
 
 type Client struct {
 	Context context.Context
 }
 
-type SearchBlob struct {
-	BlobKey      string    `datastore:"blob_key"`
-	ContentType  string    `datastore:"content_type"`
-	CreationTime time.Time `datastore:"creation"`
-	Filename     string    `datastore:"filename"`
-	MD5          string    `datastore:"md5_hash"`
-	ObjectName   string    `datastore:"gs_object_name"`
-	Size         string    `datastore:"size"`
-	Title        string    `datastore:"title"`
-}
-
 func NewClient(ctx context.Context) Client {
 	return Client{
 		Context: ctx,
 	}
 }
 
-func (s Client) Init(blob SearchBlob) error {
-	index, err := search.Open(BlobIndexName)
-	if err != nil {
-		return err
-	}
+// SetLoader tells the index how to list every upload when it needs to be loaded.
+func SetLoader(load func(ctx context.Context) ([]*BlobInfo, error)) {
+	blobLoader.Load = func(ctx context.Context) ([]*search.Document, error) {
+		blobs, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	blob.Title = createTitle(blob.Filename)
-	_, err = index.Put(s.Context, blob.BlobKey, &blob)
-	if err != nil {
-		return err
-	}
+		docs := make([]*search.Document, 0, len(blobs))
+		for _, blob := range blobs {
+			docs = append(docs, blobDocument(blob))
+		}
 
-	return nil
+		return docs, nil
+	}
 }
 
-func (s Client) PutBlob(blob SearchBlob) error {
-	index, err := search.Open(BlobIndexName)
-	if err != nil {
-		return err
+func blobDocument(blob *BlobInfo) *search.Document {
+	title := blob.Title
+	if title == "" {
+		title = createTitle(blob.Filename)
 	}
 
-	blob.Title = createTitle(blob.Filename)
-	_, err = index.Put(s.Context, blob.BlobKey, &blob)
-	if err != nil {
-		return err
+	return &search.Document{
+		Id: blob.BlobKey,
+		Text: map[string]string{
+			"title":        title,
+			"alt":          blob.Alt,
+			"caption":      blob.Caption,
+			"tags":         strings.Join(blob.Tags, " "),
+			"filename":     blob.Filename,
+			"content_type": blob.ContentType,
+		},
+		Keywords: map[string][]string{
+			"content_type": {blob.ContentType},
+			FieldFolder:    {blob.Folder},
+			FieldTags:      blob.Tags,
+			fieldKey:       {blob.BlobKey},
+			fieldName:      {blob.Filename},
+		},
+		Numbers: map[string]float64{
+			"creation": search.Time(blob.CreationTime),
+		},
+		Source: blob,
 	}
+}
 
-	return nil
+// Rebuild loads every upload into the index again.
+func (s Client) Rebuild() error {
+	return blobLoader.Rebuild(s.Context)
 }
 
-func (s Client) GetBlobs(searchKey string, limit int, cursorStr string) ([]*BlobInfo, string, int, error) {
-	index, err := search.Open(BlobIndexName)
-	if err != nil {
-		return nil, "", 0, err
-	}
+func (s Client) PutBlob(blob *BlobInfo) error {
+	return blobLoader.Put(blobDocument(blob))
+}
 
-	blobs := make([]*BlobInfo, 0)
-	options := search.SearchOptions{
-		Limit:  limit,
-		Cursor: search.Cursor(cursorStr),
-	}
+// BlobFilters narrow the uploads GetBlobs returns to a folder and a tag.
+type BlobFilters struct {
+	Folder string
+	Tag    string
+}
 
-	var cursor string
-	var total int
-	for t := index.Search(s.Context, searchKey, &options); ; {
-		var temp SearchBlob
-		id, err := t.Next(&temp)
-		if err == search.Done {
-			break
-		}
+func (f *BlobFilters) Empty() bool {
+	return f.Folder == "" && f.Tag == ""
+}
 
-		if err != nil {
-			return nil, "", 0, err
-		}
+// BlobResults are the uploads matching a search, with how many of them are in each folder and
+// have each tag.
+type BlobResults struct {
+	Blobs   []*BlobInfo
+	Cursor  string
+	Total   int
+	Folders []search.FacetCount
+	Tags    []search.FacetCount
+}
 
-		sizeInt, err := strconv.Atoi(temp.Size)
-		if err != nil {
-			return nil, "", 0, err
-		}
+// GetBlobs returns the uploads matching searchKey and filters, newest first when they are as
+// relevant. Words match file names, titles, alt text, captions and tags by prefix and with
+// typos, content types match as in "image/png". An empty searchKey matches every upload.
+func (s Client) GetBlobs(searchKey string, filters BlobFilters, limit int, cursorStr string) (*BlobResults, error) {
+	q := &search.Query{
+		Text:    searchKey,
+		Filters: map[string][]string{},
+		Facets:  []string{FieldFolder, FieldTags},
+		Limit:   limit,
+		Cursor:  cursorStr,
+		Sort:    []search.Sort{{Field: search.ScoreField, Desc: true}, {Field: "creation", Desc: true}},
+	}
 
-		blob := BlobInfo{
-			BlobKey:      id,
-			ContentType:  temp.ContentType,
-			CreationTime: temp.CreationTime,
-			Filename:     temp.Filename,
-			Size:         int64(sizeInt),
-			MD5:          temp.MD5,
-		}
+	if filters.Folder != "" {
+		q.Filters[FieldFolder] = []string{filters.Folder}
+	}
 
-		blobs = append(blobs, &blob)
-		cursor = string(t.Cursor())
-		total = t.Count()
+	if filters.Tag != "" {
+		q.Filters[FieldTags] = []string{filters.Tag}
 	}
 
-	return blobs, cursor, total, nil
+	result, err := blobLoader.Search(s.Context, q)
+	if err != nil {
+		return nil, err
+	}
 
+	results := &BlobResults{
+		Blobs:   make([]*BlobInfo, 0, len(result.Hits)),
+		Cursor:  result.Cursor,
+		Total:   result.Total,
+		Folders: result.Facets[FieldFolder],
+		Tags:    result.Facets[FieldTags],
+	}
+
+	for _, hit := range result.Hits {
+		results.Blobs = append(results.Blobs, hit.Source.(*BlobInfo))
+	}
+
+	return results, nil
 }
 
-func (s Client) DeleteIndex(key string) error {
-	index, err := search.Open(BlobIndexName)
-	if err != nil {
-		return err
+// FindBlob returns the upload with key, or named filename when key is empty, from the index. It
+// is nil when there is no such upload.
+func (s Client) FindBlob(key string, filename string) (*BlobInfo, error) {
+	q := &search.Query{Limit: 1, Filters: map[string][]string{fieldKey: {key}}}
+	if key == "" {
+		q.Filters = map[string][]string{fieldName: {filename}}
 	}
 
-	err = index.Delete(s.Context, key)
-	if err != nil {
-		return err
+	result, err := blobLoader.Search(s.Context, q)
+	if err != nil || len(result.Hits) == 0 {
+		return nil, err
 	}
 
-	return nil
+	return result.Hits[0].Source.(*BlobInfo), nil
+}
+
+func (s Client) DeleteIndex(key string) error {
+	return blobLoader.Delete(key)
 }
 
 func createTitle(fileName string) string {
@@ -0,0 +1,87 @@
+package search_api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func loadBlobs(t *testing.T, blobs ...*BlobInfo) Client {
+	SetLoader(func(ctx context.Context) ([]*BlobInfo, error) {
+		return blobs, nil
+	})
+
+	client := NewClient(context.Background())
+	if err := client.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func keys(results *BlobResults) []string {
+	list := make([]string, 0, len(results.Blobs))
+	for _, blob := range results.Blobs {
+		list = append(list, blob.BlobKey)
+	}
+
+	return list
+}
+
+func TestGetBlobs(t *testing.T) {
+	now := time.Now()
+	client := loadBlobs(t,
+		&BlobInfo{BlobKey: "a", Filename: "red-shoes.jpg", ContentType: "image/jpeg", Folder: "products/shoes", Tags: []string{"red"}, CreationTime: now.Add(-time.Hour)},
+		&BlobInfo{BlobKey: "b", Filename: "IMG_2.jpg", Title: "Red boots", ContentType: "image/jpeg", Folder: "products/shoes", CreationTime: now},
+		&BlobInfo{BlobKey: "c", Filename: "manual.pdf", ContentType: "application/pdf", Tags: []string{"docs"}, CreationTime: now},
+	)
+
+	tests := []struct {
+		name    string
+		text    string
+		filters BlobFilters
+		want    []string
+	}{
+		{"everything newest first", "", BlobFilters{}, []string{"b", "c", "a"}},
+		{"matches in more fields rank higher", "red", BlobFilters{}, []string{"a", "b"}},
+		{"typo", "shoos", BlobFilters{}, []string{"a"}},
+		{"content type", "application/pdf", BlobFilters{}, []string{"c"}},
+		{"folder", "", BlobFilters{Folder: "products/shoes"}, []string{"b", "a"}},
+		{"tag", "", BlobFilters{Tag: "red"}, []string{"a"}},
+	}
+
+	for _, test := range tests {
+		results, err := client.GetBlobs(test.text, test.filters, 10, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := keys(results); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	results, _ := client.GetBlobs("", BlobFilters{}, 10, "")
+	if len(results.Folders) != 2 || results.Folders[0].Value != "products/shoes" || results.Folders[0].Count != 2 {
+		t.Errorf("got folders %+v", results.Folders)
+	}
+}
+
+func TestFindBlobAndDelete(t *testing.T) {
+	client := loadBlobs(t, &BlobInfo{BlobKey: "a", Filename: "logo.png"})
+	blob, err := client.FindBlob("", "logo.png")
+	if err != nil || blob == nil || blob.BlobKey != "a" {
+		t.Fatalf("got %+v, %v", blob, err)
+	}
+
+	client.PutBlob(&BlobInfo{BlobKey: "b", Filename: "banner.png"})
+	if blob, _ := client.FindBlob("b", ""); blob == nil {
+		t.Errorf("a put blob is not in the index")
+	}
+
+	client.DeleteIndex("a")
+	if blob, _ := client.FindBlob("a", ""); blob != nil {
+		t.Errorf("a deleted blob is still in the index")
+	}
+}
@@ -1,6 +1,8 @@
 package settings
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/jcarm010/kodimerce/entities"
 	"github.com/jcarm010/kodimerce/log"
@@ -10,34 +12,102 @@ import (
 	"strconv"
 )
 
-var (
-	globalSettings *entities.ServerSettings
-)
+// store is where the settings are kept, the datastore on the running server.
+type store interface {
+	GetServerSettings(ctx context.Context) (*entities.ServerSettings, error)
+	StoreServerSettings(ctx context.Context, serverSettings *entities.ServerSettings) error
+}
+
+type datastoreStore struct{}
+
+func (datastoreStore) GetServerSettings(ctx context.Context) (*entities.ServerSettings, error) {
+	return entities.GetServerSettings(ctx)
+}
+
+func (datastoreStore) StoreServerSettings(ctx context.Context, serverSettings *entities.ServerSettings) error {
+	return entities.StoreServerSettings(ctx, serverSettings)
+}
+
+// loader reads the settings from its store and keeps the last ones it read.
+type loader struct {
+	store  store
+	cached *entities.ServerSettings
+}
+
+var globalSettings = &loader{store: datastoreStore{}}
 
 func GetAndReloadGlobalSettings(ctx context.Context) entities.ServerSettings {
-	dbSettings, err := entities.GetServerSettings(ctx)
-	if err != nil {
-		log.Errorf(ctx, "Error getting stored server settings: %s", err)
+	return globalSettings.reload(ctx)
+}
+
+func GetGlobalSettings(ctx context.Context) entities.ServerSettings {
+	return globalSettings.get(ctx)
+}
+
+func (l *loader) reload(ctx context.Context) entities.ServerSettings {
+	dbSettings, err := l.store.GetServerSettings(ctx)
+	switch {
+	case err == entities.ErrSettingsNotFound:
 		envSettings := getEnvSettings()
 		dbSettings = &envSettings
-		if err == entities.ErrSettingsNotFound {
-			err = entities.StoreServerSettings(ctx, dbSettings)
-			if err != nil {
-				log.Errorf(ctx, "Error storing server settings: %s", err)
-			}
+		err = l.store.StoreServerSettings(ctx, dbSettings)
+		if err != nil {
+			log.Errorf(ctx, "Error storing server settings: %s", err)
+		}
+	case err != nil:
+		// the stored settings may well exist, writing defaults here would replace them
+		log.Errorf(ctx, "Error getting stored server settings: %s", err)
+		if l.cached != nil {
+			return *l.cached
+		}
+
+		return getEnvSettings()
+	}
+
+	upgraded := false
+	if !dbSettings.NotificationsConfigured {
+		// settings stored before notifications could be switched off always sent the order confirmation
+		dbSettings.EnableAllNotifications()
+		upgraded = true
+	}
+
+	if dbSettings.SigningSecret == "" {
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err == nil {
+			dbSettings.SigningSecret = hex.EncodeToString(secret)
+			upgraded = true
+		}
+	}
+
+	if upgraded {
+		err = l.store.StoreServerSettings(ctx, dbSettings)
+		if err != nil {
+			log.Errorf(ctx, "Error storing server settings: %s", err)
 		}
 	}
 
-	globalSettings = dbSettings
+	l.cached = dbSettings
+	log.SetSecrets(
+		dbSettings.PayPalApiClientSecret,
+		dbSettings.SmartyStreetsAuthToken,
+		dbSettings.SMTPPassword,
+		dbSettings.SendGridKey,
+		dbSettings.OIDCClientSecret,
+		dbSettings.MetricsToken,
+		dbSettings.SigningSecret,
+		dbSettings.CronToken,
+	)
+
 	return *dbSettings
 }
 
-func GetGlobalSettings(ctx context.Context) entities.ServerSettings {
-	if globalSettings != nil {
-		return *globalSettings
+func (l *loader) get(ctx context.Context) entities.ServerSettings {
+	if l.cached != nil {
+		return *l.cached
 	}
 
-	return GetAndReloadGlobalSettings(ctx)
+	return l.reload(ctx)
 }
 
 func getEnvSettings() entities.ServerSettings {
@@ -48,6 +118,39 @@ func getEnvSettings() entities.ServerSettings {
 		smtpPort = 587
 	}
 
+	oidcAutoProvision, _ := strconv.ParseBool(os.Getenv("OIDC_AUTO_PROVISION"))
+	abandonedCheckoutDelay, err := strconv.Atoi(os.Getenv("ABANDONED_CHECKOUT_DELAY_MINUTES"))
+	if err != nil {
+		abandonedCheckoutDelay = 60
+	}
+
+	abandonedCheckoutInterval, err := strconv.Atoi(os.Getenv("ABANDONED_CHECKOUT_INTERVAL_HOURS"))
+	if err != nil {
+		abandonedCheckoutInterval = 24
+	}
+
+	abandonedCheckoutMaxReminders, _ := strconv.Atoi(os.Getenv("ABANDONED_CHECKOUT_MAX_REMINDERS"))
+	sessionTTL, err := strconv.Atoi(os.Getenv("SESSION_TTL_HOURS"))
+	if err != nil {
+		sessionTTL = 30 * 24
+	}
+
+	imageQuality, _ := strconv.Atoi(os.Getenv("IMAGE_QUALITY"))
+	imageFormat := os.Getenv("IMAGE_FORMAT")
+	if imageFormat == "" {
+		imageFormat = "auto"
+	}
+
+	notify := func(name string) bool {
+		enabled, err := strconv.ParseBool(os.Getenv(name))
+		return enabled || err != nil
+	}
+
+	oidcDefaultRole := os.Getenv("OIDC_DEFAULT_ROLE")
+	if oidcDefaultRole == "" {
+		oidcDefaultRole = "regular"
+	}
+
 	return entities.ServerSettings{
 		Author:                    os.Getenv("AUTHOR"),
 		CompanyName:               os.Getenv("COMPANY_NAME"),
@@ -89,6 +192,10 @@ func getEnvSettings() entities.ServerSettings {
 		SMTPPort:     int(smtpPort),
 		SMTPUserName: os.Getenv("SMTP_USER_NAME"),
 		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPTLSMode:  os.Getenv("SMTP_TLS_MODE"),
+
+		EmailBackend: os.Getenv("EMAIL_BACKEND"),
+		MailboxDir:   os.Getenv("MAILBOX_DIR"),
 
 		EmailSender: os.Getenv("EMAIL_SENDER"),
 		SendGridKey: os.Getenv("SENDGRID_KEY"),
@@ -105,6 +212,38 @@ func getEnvSettings() entities.ServerSettings {
 
 		DescriptionBlogABout: os.Getenv("DESCRIPTION_BLOG_ABOUT"),
 		WwwRedirect:          wwwRedirect,
+
+		OIDCIssuer:        os.Getenv("OIDC_ISSUER"),
+		OIDCClientId:      os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCAutoProvision: oidcAutoProvision,
+		OIDCDefaultRole:   oidcDefaultRole,
+
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		NotificationsConfigured: true,
+		NotifyOrderPaid:         notify("NOTIFY_ORDER_PAID"),
+		NotifyOrderProcessing:   notify("NOTIFY_ORDER_PROCESSING"),
+		NotifyOrderShipped:      notify("NOTIFY_ORDER_SHIPPED"),
+		NotifyOrderDelivered:    notify("NOTIFY_ORDER_DELIVERED"),
+		NotifyOrderRefunded:     notify("NOTIFY_ORDER_REFUNDED"),
+		NotifyOrderCancelled:    notify("NOTIFY_ORDER_CANCELLED"),
+
+		AbandonedCheckoutDelayMinutes:  abandonedCheckoutDelay,
+		AbandonedCheckoutIntervalHours: abandonedCheckoutInterval,
+		AbandonedCheckoutMaxReminders:  abandonedCheckoutMaxReminders,
+		SigningSecret:                  os.Getenv("SIGNING_SECRET"),
+		CronToken:                      os.Getenv("CRON_TOKEN"),
+		SessionTTLHours:                sessionTTL,
+
+		ImageWidths:  os.Getenv("IMAGE_WIDTHS"),
+		ImageFormat:  imageFormat,
+		ImageQuality: imageQuality,
+
+		UploadAllowedTypes: os.Getenv("UPLOAD_ALLOWED_TYPES"),
+		UploadMaxSizes:     os.Getenv("UPLOAD_MAX_SIZES"),
+		ClamAVAddress:      os.Getenv("CLAMAV_ADDRESS"),
+		UploadDirect:       os.Getenv("UPLOAD_DIRECT") == "true",
 	}
 }
 
@@ -0,0 +1,93 @@
+package settings
+
+import (
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+// fakeStore serves stored, or fails with getErr, and records what is stored.
+type fakeStore struct {
+	stored *entities.ServerSettings
+	getErr error
+	writes []entities.ServerSettings
+}
+
+func (f *fakeStore) GetServerSettings(ctx context.Context) (*entities.ServerSettings, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+
+	copied := *f.stored
+	return &copied, nil
+}
+
+func (f *fakeStore) StoreServerSettings(ctx context.Context, serverSettings *entities.ServerSettings) error {
+	f.writes = append(f.writes, *serverSettings)
+	return nil
+}
+
+func TestReloadKeepsCachedSettingsWhenReadFails(t *testing.T) {
+	store := &fakeStore{getErr: errors.New("datastore unavailable")}
+	l := &loader{store: store, cached: &entities.ServerSettings{CompanyName: "Cached", SigningSecret: "cached-secret", NotificationsConfigured: true}}
+
+	loaded := l.reload(context.Background())
+	if loaded.CompanyName != "Cached" || loaded.SigningSecret != "cached-secret" {
+		t.Errorf("got %+v, want the cached settings", loaded)
+	}
+
+	if len(store.writes) != 0 {
+		t.Errorf("stored settings %d times after a failed read, want none", len(store.writes))
+	}
+}
+
+func TestReloadDoesNotStoreDefaultsWhenReadFails(t *testing.T) {
+	store := &fakeStore{getErr: errors.New("datastore unavailable")}
+	l := &loader{store: store}
+
+	l.reload(context.Background())
+	if len(store.writes) != 0 {
+		t.Errorf("stored settings %d times after a failed read, want none", len(store.writes))
+	}
+
+	if l.cached != nil {
+		t.Error("cached the defaults of a failed read")
+	}
+}
+
+func TestReloadStoresDefaultsWhenNotFound(t *testing.T) {
+	store := &fakeStore{getErr: entities.ErrSettingsNotFound}
+	loaded := (&loader{store: store}).reload(context.Background())
+	if len(store.writes) == 0 {
+		t.Fatal("didn't store the default settings")
+	}
+
+	if loaded.SigningSecret == "" || store.writes[len(store.writes)-1].SigningSecret != loaded.SigningSecret {
+		t.Errorf("signing secret %q wasn't generated and stored", loaded.SigningSecret)
+	}
+}
+
+func TestReloadUpgradesStoredSettings(t *testing.T) {
+	store := &fakeStore{stored: &entities.ServerSettings{CompanyName: "Stored"}}
+	loaded := (&loader{store: store}).reload(context.Background())
+	if loaded.CompanyName != "Stored" || !loaded.NotificationsConfigured || loaded.SigningSecret == "" {
+		t.Errorf("got %+v, want the stored settings upgraded", loaded)
+	}
+
+	if len(store.writes) != 1 || store.writes[0].CompanyName != "Stored" {
+		t.Errorf("got writes %+v, want the upgraded settings stored once", store.writes)
+	}
+}
+
+func TestReloadLeavesCurrentSettingsAlone(t *testing.T) {
+	store := &fakeStore{stored: &entities.ServerSettings{CompanyName: "Stored", SigningSecret: "secret", NotificationsConfigured: true}}
+	loaded := (&loader{store: store}).reload(context.Background())
+	if loaded.SigningSecret != "secret" {
+		t.Errorf("signing secret changed to %q", loaded.SigningSecret)
+	}
+
+	if len(store.writes) != 0 {
+		t.Errorf("stored settings that needed no upgrade %d times", len(store.writes))
+	}
+}
@@ -3,16 +3,24 @@ package smartyaddress
 import (
 	"errors"
 	"github.com/dustin/gojson"
+	"github.com/jcarm010/kodimerce/entities"
 	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/metrics"
 	"github.com/jcarm010/kodimerce/settings"
 	"golang.org/x/net/context"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 var ADDRESS_NOT_FOUND_ERROR error = errors.New("Address not found")
 
+var client = &http.Client{
+	Timeout:   time.Second * 30,
+	Transport: metrics.InstrumentTransport("smartystreets", nil),
+}
+
 type strategy string
 
 type Lookup struct {
@@ -108,12 +116,15 @@ type Analysis struct {
 }
 
 func CheckUSAddress(ctx context.Context, lookup *Lookup) (*Candidate, error) {
+	return checkUSAddress(ctx, client, settings.GetGlobalSettings(ctx), lookup)
+}
+
+func checkUSAddress(ctx context.Context, client *http.Client, globalSettings entities.ServerSettings, lookup *Lookup) (*Candidate, error) {
 	u, err := url.Parse("https://us-street.api.smartystreets.com/street-address")
 	if err != nil {
 		return nil, err
 	}
 
-	globalSettings := settings.GetGlobalSettings(ctx)
 	q := u.Query()
 	q.Add("auth-id", globalSettings.SmartyStreetsAuthId)
 	q.Add("auth-token", globalSettings.SmartyStreetsAuthToken)
@@ -126,7 +137,7 @@ func CheckUSAddress(ctx context.Context, lookup *Lookup) (*Candidate, error) {
 	u.RawQuery = q.Encode()
 	uri := u.String()
 	log.Infof(ctx, "Requesting uri: %s", uri)
-	r, err := http.Get(uri)
+	r, err := client.Get(uri)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,72 @@
+package smartyaddress
+
+import (
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/metrics"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var testSettings = entities.ServerSettings{SmartyStreetsAuthId: "id", SmartyStreetsAuthToken: "token"}
+
+// fakeSmarty returns a client answering every request with status and body, and the requests it got.
+func fakeSmarty(status int, body string) (*http.Client, *[]*http.Request) {
+	var requests []*http.Request
+	client := &http.Client{Transport: metrics.InstrumentTransport("smartystreets", roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req)
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: ioutil.NopCloser(strings.NewReader(body)), Request: req}, nil
+	}))}
+
+	return client, &requests
+}
+
+func outboundMetrics() string {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestCheckUSAddress(t *testing.T) {
+	client, requests := fakeSmarty(http.StatusOK, `[{"delivery_line_1":"1 Main St","components":{"zipcode":"33101"}}]`)
+	candidate, err := checkUSAddress(context.Background(), client, testSettings, &Lookup{Street: "1 main street", City: "Miami", State: "FL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if candidate.DeliveryLine1 != "1 Main St" || candidate.Components.ZIPCode != "33101" {
+		t.Errorf("got %+v", candidate)
+	}
+
+	q := (*requests)[0].URL.Query()
+	if q.Get("auth-id") != "id" || q.Get("auth-token") != "token" || q.Get("street") != "1 main street" || q.Get("city") != "Miami" {
+		t.Errorf("got query %v", q)
+	}
+}
+
+func TestCheckUSAddressNotFound(t *testing.T) {
+	client, _ := fakeSmarty(http.StatusOK, `[]`)
+	if _, err := checkUSAddress(context.Background(), client, testSettings, &Lookup{Street: "nowhere"}); err != ADDRESS_NOT_FOUND_ERROR {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCheckUSAddressFailureIsCounted(t *testing.T) {
+	client, _ := fakeSmarty(http.StatusServiceUnavailable, `busy`)
+	if _, err := checkUSAddress(context.Background(), client, testSettings, &Lookup{Street: "1 main street"}); err == nil {
+		t.Fatal("expected an error for a 503")
+	}
+
+	if text := outboundMetrics(); !strings.Contains(text, `km_outbound_request_failures_total{service="smartystreets"} 1`) {
+		t.Errorf("failure not counted:\n%s", text)
+	}
+}
@@ -3,31 +3,48 @@ package storage
 import (
 	"cloud.google.com/go/storage"
 	"context"
+	"errors"
+	"google.golang.org/api/iterator"
 	"io"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 var (
+	ErrObjectNotExist = storage.ErrObjectNotExist
+
 	storageClient *storage.Client
+	clientOnce sync.Once
 	bucketName = os.Getenv("GOOGLE_CLOUD_PROJECT") + ".appspot.com"
 )
 
-func init() {
-	var err error
-	storageClient, err = storage.NewClient(context.Background())
-	if err != nil {
-		panic(err)
-	}
+// client connects on first use, so packages that only need object names load without credentials.
+func client() *storage.Client {
+	clientOnce.Do(func() {
+		var err error
+		storageClient, err = storage.NewClient(context.Background())
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	return storageClient
 }
 
 func GetObject(objectName string) *storage.ObjectHandle {
+	return client().Bucket(bucketName).Object(ObjectName(objectName))
+}
+
+// ObjectName strips the bucket from object names stored by the blobstore, which look like /<bucket>/<name>.
+func ObjectName(objectName string) string {
 	bucketPrefix := "/" + bucketName + "/"
-	objectName = strings.TrimPrefix(objectName, bucketPrefix)
-	return storageClient.Bucket(bucketName).Object(objectName)
+	return strings.TrimPrefix(objectName, bucketPrefix)
 }
 
 func PutObject(ctx context.Context, objectName string, reader io.Reader) error {
-	wc := storageClient.Bucket(bucketName).Object(objectName).NewWriter(ctx)
+	wc := client().Bucket(bucketName).Object(objectName).NewWriter(ctx)
 	if _, err := io.Copy(wc, reader); err != nil {
 		return err
 	}
@@ -37,4 +54,197 @@ func PutObject(ctx context.Context, objectName string, reader io.Reader) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+// NewWriter returns a writer that stores objectName when it is closed.
+func NewWriter(ctx context.Context, objectName string) io.WriteCloser {
+	return client().Bucket(bucketName).Object(objectName).NewWriter(ctx)
+}
+
+// ListObjects returns the attributes of the objects whose name starts with prefix.
+func ListObjects(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	objects := make([]*storage.ObjectAttrs, 0)
+	it := client().Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, attrs)
+	}
+
+	return objects, nil
+}
+
+func DeleteObject(ctx context.Context, objectName string) error {
+	return GetObject(objectName).Delete(ctx)
+}
+
+// DeleteObjects deletes the objects whose name starts with prefix.
+func DeleteObjects(ctx context.Context, prefix string) error {
+	objects, err := ListObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		err = DeleteObject(ctx, object.Name)
+		if err != nil && err != ErrObjectNotExist {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maxComposeSources is how many objects Cloud Storage composes at once.
+const maxComposeSources = 32
+
+// ComposeObjects concatenates sources into objectName. Sources beyond what Cloud Storage composes
+// at once are appended to objectName in more compose requests.
+func ComposeObjects(ctx context.Context, objectName string, sources []string) (*storage.ObjectAttrs, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("Nothing to compose.")
+	}
+
+	var attrs *storage.ObjectAttrs
+	for composed := 0; composed < len(sources); {
+		handles := make([]*storage.ObjectHandle, 0, maxComposeSources)
+		if composed > 0 {
+			handles = append(handles, GetObject(objectName))
+		}
+
+		for composed < len(sources) && len(handles) < maxComposeSources {
+			handles = append(handles, GetObject(sources[composed]))
+			composed++
+		}
+
+		var err error
+		attrs, err = GetObject(objectName).ComposerFrom(handles...).Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return attrs, nil
+}
+
+// CopyObject copies source into objectName.
+func CopyObject(ctx context.Context, objectName string, source string) (*storage.ObjectAttrs, error) {
+	return GetObject(objectName).CopierFrom(GetObject(source)).Run(ctx)
+}
+
+// SignedUploadURL returns a url a client can PUT objectName to for the next expires, without
+// going through the server. It fails when the credentials can't sign urls.
+func SignedUploadURL(objectName string, contentType string, expires time.Duration) (string, error) {
+	return client().Bucket(bucketName).SignedURL(ObjectName(objectName), &storage.SignedURLOptions{
+		Method:      http.MethodPut,
+		ContentType: contentType,
+		Expires:     time.Now().Add(expires),
+		Scheme:      storage.SigningSchemeV4,
+	})
+}
+
+// ObjectReadSeeker reads an object from the offset it was last seeked to, opening a new range
+// reader after every seek. It lets http.ServeContent answer range requests without downloading
+// the whole object.
+type ObjectReadSeeker struct {
+	ctx       context.Context
+	openRange func(ctx context.Context, offset int64) (io.ReadCloser, error)
+	size      int64
+	offset    int64
+	reader    io.ReadCloser
+}
+
+// NewObjectReadSeeker returns a reader for an object, along with its attributes. It fails when
+// the object doesn't exist.
+func NewObjectReadSeeker(ctx context.Context, objectName string) (*ObjectReadSeeker, *storage.ObjectAttrs, error) {
+	handle := GetObject(objectName)
+	attrs, err := handle.Attrs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	openRange := func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		return handle.NewRangeReader(ctx, offset, -1)
+	}
+
+	return &ObjectReadSeeker{ctx: ctx, openRange: openRange, size: attrs.Size}, attrs, nil
+}
+
+func (o *ObjectReadSeeker) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.reader == nil {
+		reader, err := o.openRange(o.ctx, o.offset)
+		if err != nil {
+			return 0, err
+		}
+
+		o.reader = reader
+	}
+
+	n, err := o.reader.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *ObjectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+
+	if offset != o.offset {
+		o.closeReader()
+		o.offset = offset
+	}
+
+	return offset, nil
+}
+
+// ReadAt reads from off, keeping the range reader open when reads follow each other, such as
+// when archive/zip reads an entry. It moves the offset Read reads from and isn't safe for
+// concurrent use.
+func (o *ObjectReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	_, err := o.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(o, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Size is the size of the object in bytes.
+func (o *ObjectReadSeeker) Size() int64 {
+	return o.size
+}
+
+func (o *ObjectReadSeeker) Close() error {
+	o.closeReader()
+	return nil
+}
+
+func (o *ObjectReadSeeker) closeReader() {
+	if o.reader != nil {
+		_ = o.reader.Close()
+		o.reader = nil
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testReadSeeker reads content the way an object is read, counting the range readers it opens.
+func testReadSeeker(content []byte, opened *int) *ObjectReadSeeker {
+	return &ObjectReadSeeker{
+		ctx:  context.Background(),
+		size: int64(len(content)),
+		openRange: func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+			*opened++
+			return ioutil.NopCloser(bytes.NewReader(content[offset:])), nil
+		},
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	if got := ObjectName("/" + bucketName + "/uploads/a.png"); got != "uploads/a.png" {
+		t.Errorf("got %s", got)
+	}
+
+	if got := ObjectName("uploads/a.png"); got != "uploads/a.png" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestObjectReadSeeker(t *testing.T) {
+	opened := 0
+	reader := testReadSeeker([]byte("0123456789"), &opened)
+	if position, err := reader.Seek(-4, io.SeekEnd); err != nil || position != 6 {
+		t.Fatalf("got %d, %v", position, err)
+	}
+
+	bts, err := ioutil.ReadAll(reader)
+	if err != nil || string(bts) != "6789" {
+		t.Errorf("got %q, %v", bts, err)
+	}
+
+	if _, err := reader.Seek(-11, io.SeekEnd); err == nil {
+		t.Errorf("seeking before the start didn't fail")
+	}
+
+	buf := make([]byte, 3)
+	for _, offset := range []int64{0, 3} {
+		if n, err := reader.ReadAt(buf, offset); n != 3 || err != nil {
+			t.Fatalf("got %d, %v", n, err)
+		}
+	}
+
+	if string(buf) != "345" || opened != 2 {
+		t.Errorf("got %q with %d readers, consecutive reads should share one", buf, opened)
+	}
+
+	if n, err := reader.ReadAt(buf, 8); n != 2 || err != io.EOF {
+		t.Errorf("got %d, %v reading past the end", n, err)
+	}
+}
+
+func TestObjectReadSeekerServesRanges(t *testing.T) {
+	opened := 0
+	content := []byte("abcdefghijklmnopqrstuvwxyz")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Range", "bytes=20-")
+	w := httptest.NewRecorder()
+	// without a content type ServeContent reads the start of the content to sniff one
+	w.Header().Set("Content-Type", "text/plain")
+	http.ServeContent(w, r, "", time.Now(), testReadSeeker(content, &opened))
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "uvwxyz" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+
+	if got := w.Header().Get("Content-Range"); got != "bytes 20-25/26" {
+		t.Errorf("got Content-Range %s", got)
+	}
+
+	if opened != 1 {
+		t.Errorf("opened %d readers for one range", opened)
+	}
+}
@@ -0,0 +1,35 @@
+package uploads
+
+import (
+	"strings"
+)
+
+// NormalizeFolder cleans a folder path such as " /Products//shoes/ " into products/shoes. Folders
+// are lower case so the same folder isn't listed twice.
+func NormalizeFolder(folder string) string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(folder, "/") {
+		if part = strings.ToLower(strings.TrimSpace(part)); part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// ParseTags reads a comma separated list of tags, lower case and without duplicates.
+func ParseTags(list string) []string {
+	seen := map[string]bool{}
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
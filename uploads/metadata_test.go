@@ -0,0 +1,33 @@
+package uploads
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeFolder(t *testing.T) {
+	tests := map[string]string{
+		" /Products//shoes/ ": "products/shoes",
+		"../../etc":           "etc",
+		"a/./b/ .. /c":        "a/b/c",
+		"":                    "",
+		"/":                   "",
+	}
+
+	for folder, want := range tests {
+		if got := NormalizeFolder(folder); got != want {
+			t.Errorf("NormalizeFolder(%q) = %q, want %q", folder, got, want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	got := ParseTags(" Summer ,summer, red  shoes,, SALE ")
+	if want := []string{"summer", "red shoes", "sale"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := ParseTags(""); got == nil || len(got) != 0 {
+		t.Errorf("got %#v for no tags, want an empty list", got)
+	}
+}
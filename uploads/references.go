@@ -0,0 +1,269 @@
+package uploads
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/search_api"
+	"net/url"
+	"regexp"
+	"sort"
+)
+
+const (
+	ReferenceProduct = "product"
+	ReferencePost    = "post"
+	ReferencePage    = "page"
+	ReferenceGallery = "gallery"
+)
+
+// uploadUrlRegex finds the urls uploads are served from: /gallery/upload?k=<key>,
+// /gallery/upload/<key> and /gallery/upload/name/<filename>, relative or absolute.
+var uploadUrlRegex = regexp.MustCompile(`/gallery/upload(?:/name/([^\s"'<>?#&)\\]+)|/([^\s"'<>?#&)\\/]+)|\?k=([^\s"'<>#&)\\]+))`)
+
+// Reference is a product, post, page or gallery that shows an upload.
+type Reference struct {
+	Type  string `json:"type"`
+	Id    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+func (r *Reference) owner() string {
+	return fmt.Sprintf("%s:%d", r.Type, r.Id)
+}
+
+// referenceStore keeps the reference index.
+type referenceStore interface {
+	SetUploadReferences(ctx context.Context, ownerType string, ownerId int64, references []*entities.UploadReference) error
+	ListUploadReferences(ctx context.Context, blobKey string, filename string) ([]*entities.UploadReference, error)
+}
+
+type datastoreReferences struct{}
+
+func (datastoreReferences) SetUploadReferences(ctx context.Context, ownerType string, ownerId int64, references []*entities.UploadReference) error {
+	return entities.SetUploadReferences(ctx, ownerType, ownerId, references)
+}
+
+func (datastoreReferences) ListUploadReferences(ctx context.Context, blobKey string, filename string) ([]*entities.UploadReference, error) {
+	return entities.ListUploadReferences(ctx, blobKey, filename)
+}
+
+// IndexReferences records the uploads the product, post, page or gallery with id shows, replacing
+// what was recorded for it. It has to be called after they are saved.
+func IndexReferences(ctx context.Context, referenceType string, id int64) error {
+	reference, values, err := loadReference(ctx, referenceType, id)
+	if err != nil {
+		return err
+	}
+
+	return indexReferences(ctx, datastoreReferences{}, reference, values...)
+}
+
+// RebuildReferences records the uploads every product, post, page and gallery shows, for when
+// they were saved without IndexReferences, such as by restoring a backup. It reads everything.
+func RebuildReferences(ctx context.Context) error {
+	products, err := entities.ListProducts(ctx)
+	if err != nil {
+		return err
+	}
+
+	posts, err := entities.ListPosts(ctx, false, -1)
+	if err != nil {
+		return err
+	}
+
+	pages, err := entities.ListPages(ctx, false, -1)
+	if err != nil {
+		return err
+	}
+
+	galleries, err := entities.ListGalleries(ctx, false, -1)
+	if err != nil {
+		return err
+	}
+
+	s := datastoreReferences{}
+	for _, product := range products {
+		reference, values := productReference(product)
+		err = indexReferences(ctx, s, reference, values...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, post := range posts {
+		reference, values := postReference(post)
+		err = indexReferences(ctx, s, reference, values...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, page := range pages {
+		reference, values := pageReference(page)
+		err = indexReferences(ctx, s, reference, values...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, gallery := range galleries {
+		reference, values := galleryReference(gallery)
+		err = indexReferences(ctx, s, reference, values...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReferencesOf returns what shows upload, by its key or by its file name, empty when nothing does.
+func ReferencesOf(ctx context.Context, upload *search_api.BlobInfo) ([]*Reference, error) {
+	return referencesOf(ctx, datastoreReferences{}, upload.BlobKey, upload.Filename)
+}
+
+func referencesOf(ctx context.Context, s referenceStore, blobKey string, filename string) ([]*Reference, error) {
+	stored, err := s.ListUploadReferences(ctx, blobKey, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// something that shows the upload by key and by name is listed once
+	references := make([]*Reference, 0)
+	seen := map[string]bool{}
+	for _, stored := range stored {
+		reference := &Reference{Type: stored.Type, Id: stored.Id, Title: stored.Title}
+		if !seen[reference.owner()] {
+			seen[reference.owner()] = true
+			references = append(references, reference)
+		}
+	}
+
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].Type != references[j].Type {
+			return references[i].Type < references[j].Type
+		}
+
+		return references[i].Id < references[j].Id
+	})
+
+	return references, nil
+}
+
+// indexReferences records reference for every upload whose url is in values, which can be strings
+// or anything that marshals to JSON.
+func indexReferences(ctx context.Context, s referenceStore, reference *Reference, values ...interface{}) error {
+	stored := make([]*entities.UploadReference, 0)
+	seen := map[string]bool{}
+	for _, value := range values {
+		text, ok := value.(string)
+		if !ok {
+			bts, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+
+			text = string(bts)
+		}
+
+		for _, target := range uploadTargets(text) {
+			id := "key:" + target.BlobKey
+			if target.BlobKey == "" {
+				id = "name:" + target.Filename
+			}
+
+			if !seen[id] {
+				seen[id] = true
+				target.Type, target.Id, target.Title = reference.Type, reference.Id, reference.Title
+				stored = append(stored, target)
+			}
+		}
+	}
+
+	return s.SetUploadReferences(ctx, reference.Type, reference.Id, stored)
+}
+
+// uploadTargets returns the uploads whose urls are in text, by key or by file name.
+func uploadTargets(text string) []*entities.UploadReference {
+	targets := make([]*entities.UploadReference, 0)
+	for _, match := range uploadUrlRegex.FindAllStringSubmatch(text, -1) {
+		switch {
+		case match[1] != "":
+			name, err := url.PathUnescape(match[1])
+			if err != nil {
+				name = match[1]
+			}
+
+			targets = append(targets, &entities.UploadReference{Filename: name})
+		case match[2] != "":
+			targets = append(targets, &entities.UploadReference{BlobKey: match[2]})
+		case match[3] != "":
+			key, err := url.QueryUnescape(match[3])
+			if err != nil {
+				key = match[3]
+			}
+
+			targets = append(targets, &entities.UploadReference{BlobKey: key})
+		}
+	}
+
+	return targets
+}
+
+func loadReference(ctx context.Context, referenceType string, id int64) (*Reference, []interface{}, error) {
+	switch referenceType {
+	case ReferenceProduct:
+		product, err := entities.GetProduct(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reference, values := productReference(product)
+		return reference, values, nil
+	case ReferencePost:
+		post, err := entities.GetPost(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reference, values := postReference(post)
+		return reference, values, nil
+	case ReferencePage:
+		page, err := entities.GetPage(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reference, values := pageReference(page)
+		return reference, values, nil
+	case ReferenceGallery:
+		gallery, err := entities.GetGallery(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reference, values := galleryReference(gallery)
+		return reference, values, nil
+	}
+
+	return nil, nil, fmt.Errorf("Unknown reference type %q.", referenceType)
+}
+
+func productReference(product *entities.Product) (*Reference, []interface{}) {
+	return &Reference{Type: ReferenceProduct, Id: product.Id, Title: product.Name}, []interface{}{product.Pictures, string(product.Description)}
+}
+
+func postReference(post *entities.Post) (*Reference, []interface{}) {
+	return &Reference{Type: ReferencePost, Id: post.Id, Title: post.Title}, []interface{}{post.Banner, string(post.Content)}
+}
+
+// pageReference marshals the whole page, pages built from components keep their images in nested fields.
+func pageReference(page *entities.Page) (*Reference, []interface{}) {
+	return &Reference{Type: ReferencePage, Id: page.Id, Title: page.Title}, []interface{}{page}
+}
+
+func galleryReference(gallery *entities.Gallery) (*Reference, []interface{}) {
+	return &Reference{Type: ReferenceGallery, Id: gallery.Id, Title: gallery.Title}, []interface{}{gallery.Images, string(gallery.Description)}
+}
@@ -0,0 +1,92 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"github.com/jcarm010/kodimerce/entities"
+	"reflect"
+	"testing"
+)
+
+// fakeReferences keeps the references of each owner, keyed by <type>:<id>.
+type fakeReferences map[string][]*entities.UploadReference
+
+func (f fakeReferences) SetUploadReferences(ctx context.Context, ownerType string, ownerId int64, references []*entities.UploadReference) error {
+	f[(&Reference{Type: ownerType, Id: ownerId}).owner()] = references
+	return nil
+}
+
+func (f fakeReferences) ListUploadReferences(ctx context.Context, blobKey string, filename string) ([]*entities.UploadReference, error) {
+	found := make([]*entities.UploadReference, 0)
+	for _, references := range f {
+		for _, reference := range references {
+			if reference.BlobKey == blobKey || (filename != "" && reference.Filename == filename) {
+				found = append(found, reference)
+			}
+		}
+	}
+
+	return found, nil
+}
+
+func TestUploadTargets(t *testing.T) {
+	text := `<img src="/gallery/upload/name/logo.png"> <img src="https://shop.com/gallery/upload/key4?w=640">
+		<a href="/gallery/upload?k=key5&w=320">x</a> /gallery/upload/name/my%20photo.jpg`
+	want := []*entities.UploadReference{{Filename: "logo.png"}, {BlobKey: "key4"}, {BlobKey: "key5"}, {Filename: "my photo.jpg"}}
+	if got := uploadTargets(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIndexReferences(t *testing.T) {
+	store := fakeReferences{}
+	product := &Reference{Type: ReferenceProduct, Id: 1, Title: "Shirt"}
+	page := &Reference{Type: ReferencePage, Id: 2, Title: "About"}
+
+	// the product shows key1 twice but is recorded once
+	err := indexReferences(context.Background(), store, product, []string{"/gallery/upload/key1"}, `<img src="/gallery/upload/key1"> <img src="/gallery/upload/name/logo.png">`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = indexReferences(context.Background(), store, page, struct {
+		Image string `json:"image"`
+	}{"/gallery/upload/name/logo.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := store["product:1"]; len(got) != 2 || got[0].BlobKey != "key1" || got[1].Filename != "logo.png" || got[0].Title != "Shirt" {
+		t.Errorf("got %v", got)
+	}
+
+	got, err := referencesOf(context.Background(), store, "key1", "logo.png")
+	if want := []*Reference{page, product}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v, want %v listed once each", got, err, want)
+	}
+
+	// saving the product again without the upload drops its references
+	err = indexReferences(context.Background(), store, product, "no pictures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = referencesOf(context.Background(), store, "key1", "other.png")
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("got %v, %v, want an empty list", got, err)
+	}
+}
+
+type failingReferences struct {
+	fakeReferences
+}
+
+func (failingReferences) ListUploadReferences(ctx context.Context, blobKey string, filename string) ([]*entities.UploadReference, error) {
+	return nil, errors.New("Datastore unavailable.")
+}
+
+func TestReferencesOfFails(t *testing.T) {
+	if _, err := referencesOf(context.Background(), failingReferences{}, "key1", ""); err == nil {
+		t.Error("no error")
+	}
+}
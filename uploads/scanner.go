@@ -0,0 +1,131 @@
+package uploads
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ScanTimeout is how long a scan can take before the file is rejected.
+var ScanTimeout = 30 * time.Second
+
+// Scanner looks for viruses in an uploaded file. Scan returns the name of the virus found, empty
+// when the file is clean, or an error when the file couldn't be scanned.
+type Scanner interface {
+	Scan(ctx context.Context, filename string, r io.Reader) (string, error)
+}
+
+// scanner replaces the scanner configured in the settings when it is set.
+var scanner Scanner
+
+// SetScanner makes every upload go through s instead of the scanner in the settings, for
+// deployments that scan files some other way. nil goes back to the settings.
+func SetScanner(s Scanner) {
+	scanner = s
+}
+
+// NewScanner returns the scanner uploads go through: the one given to SetScanner, a ClamAV
+// scanner when its address is configured, nil otherwise.
+func NewScanner(settings *entities.ServerSettings) Scanner {
+	if scanner != nil {
+		return scanner
+	}
+
+	if settings.ClamAVAddress != "" {
+		return &ClamAVScanner{Address: settings.ClamAVAddress}
+	}
+
+	return nil
+}
+
+// clamChunkSize is how much of the file is sent to clamd at once, it must be below its StreamMaxLength.
+const clamChunkSize = 64 << 10
+
+// ClamAVScanner sends files to a clamd daemon with its INSTREAM command.
+type ClamAVScanner struct {
+	Address string // a unix socket path or host:port
+}
+
+func (s *ClamAVScanner) Scan(ctx context.Context, filename string, r io.Reader) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(s.Address, "/") {
+		network = "unix"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ScanTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, s.Address)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return "", err
+	}
+
+	chunk := make([]byte, 4+clamChunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+
+		if n == 0 {
+			break
+		}
+
+		binary.BigEndian.PutUint32(chunk, uint32(n))
+		_, err = conn.Write(chunk[:4+n])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	binary.BigEndian.PutUint32(chunk, 0)
+	_, err = conn.Write(chunk[:4])
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+
+	return parseClamReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamReply reads the answer to INSTREAM: "stream: OK", "stream: <virus> FOUND" or "<message> ERROR".
+func parseClamReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return "", errors.New(strings.TrimSuffix(reply, " ERROR"))
+	default:
+		return "", fmt.Errorf("Unexpected reply from clamd: %s", reply)
+	}
+}
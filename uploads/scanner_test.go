@@ -0,0 +1,127 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseClamReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		signature string
+		fails     bool
+	}{
+		{"stream: OK", "", false},
+		{"stream: Eicar-Test-Signature FOUND", "Eicar-Test-Signature", false},
+		{"INSTREAM size limit exceeded. ERROR", "", true},
+		{"what", "", true},
+	}
+
+	for _, test := range tests {
+		signature, err := parseClamReply(test.reply)
+		if signature != test.signature || (err != nil) != test.fails {
+			t.Errorf("parseClamReply(%q) = %q, %v", test.reply, signature, err)
+		}
+	}
+}
+
+// fakeClamd answers one INSTREAM command, finding a virus when the stream contains "EICAR".
+func fakeClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+		command := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+			return
+		}
+
+		var stream bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				return
+			}
+
+			if size == 0 {
+				break
+			}
+
+			if _, err := io.CopyN(&stream, conn, int64(size)); err != nil {
+				return
+			}
+		}
+
+		reply := "stream: OK\x00"
+		if strings.Contains(stream.String(), "EICAR") {
+			reply = "stream: Eicar-Test-Signature FOUND\x00"
+		}
+
+		_, _ = conn.Write([]byte(reply))
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamAVScanner(t *testing.T) {
+	content := strings.Repeat("x", clamChunkSize+10) + "EICAR"
+	scanner := &ClamAVScanner{Address: fakeClamd(t)}
+	signature, err := scanner.Scan(context.Background(), "a.pdf", strings.NewReader(content))
+	if err != nil || signature != "Eicar-Test-Signature" {
+		t.Errorf("got %q, %v", signature, err)
+	}
+
+	scanner = &ClamAVScanner{Address: fakeClamd(t)}
+	signature, err = scanner.Scan(context.Background(), "b.pdf", strings.NewReader("clean"))
+	if err != nil || signature != "" {
+		t.Errorf("got %q, %v for a clean file", signature, err)
+	}
+}
+
+type stubScanner struct {
+	signature string
+	err       error
+}
+
+func (s *stubScanner) Scan(ctx context.Context, filename string, r io.Reader) (string, error) {
+	return s.signature, s.err
+}
+
+func TestPolicyScan(t *testing.T) {
+	tests := []struct {
+		scanner Scanner
+		want    string
+	}{
+		{nil, ""},
+		{&stubScanner{}, ""},
+		{&stubScanner{signature: "Eicar"}, ReasonInfected},
+		{&stubScanner{err: errors.New("connection refused")}, ReasonScanFailed},
+	}
+
+	for _, test := range tests {
+		reason := ""
+		policy := &Policy{Scanner: test.scanner}
+		if rejection := policy.Scan(context.Background(), "a.pdf", strings.NewReader("a")); rejection != nil {
+			reason = rejection.Reason
+		}
+
+		if reason != test.want {
+			t.Errorf("%+v: got %q, want %q", test.scanner, reason, test.want)
+		}
+	}
+}
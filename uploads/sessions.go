@@ -0,0 +1,39 @@
+package uploads
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/storage"
+	"time"
+)
+
+const (
+	// SessionsPrefix is where the chunks of upload sessions are stored until they are complete.
+	SessionsPrefix = "upload-sessions/"
+
+	// MaxChunkSize keeps chunks under the request size App Engine accepts.
+	MaxChunkSize = 30 << 20
+)
+
+var (
+	// SignedUrlTTL is how long a signed direct upload url can be used.
+	SignedUrlTTL = time.Hour
+
+	// StaleSessionAge is how long a session waits for its next chunk before it is deleted.
+	StaleSessionAge = 24 * time.Hour
+)
+
+// SessionObject is the object name of a file of an upload session, such as one of its chunks.
+func SessionObject(id string, name string) string {
+	return SessionsPrefix + id + "/" + name
+}
+
+// DeleteSession deletes an upload session with everything stored for it.
+func DeleteSession(ctx context.Context, id string) error {
+	err := storage.DeleteObjects(ctx, SessionsPrefix+id+"/")
+	if err != nil {
+		return err
+	}
+
+	return entities.DeleteUploadSession(ctx, id)
+}
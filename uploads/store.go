@@ -0,0 +1,156 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/imaging"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/search_api"
+	"github.com/jcarm010/kodimerce/storage"
+	"time"
+)
+
+// Prefix is where uploaded files are stored, in a folder per upload key.
+const Prefix = "uploads/"
+
+const keyLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+
+// NewKey returns a random upload key.
+func NewKey() string {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	for index := range b {
+		b[index] = keyLetters[int(b[index])%len(keyLetters)]
+	}
+
+	return string(b)
+}
+
+// uploadStore is where the uploads already stored are looked up.
+type uploadStore interface {
+	GetUploadByMD5(ctx context.Context, md5Hash string) (*search_api.BlobInfo, error)
+}
+
+type datastoreUploads struct{}
+
+func (datastoreUploads) GetUploadByMD5(ctx context.Context, md5Hash string) (*search_api.BlobInfo, error) {
+	return entities.GetUploadByMD5(ctx, md5Hash)
+}
+
+func (p *Policy) uploadStore() uploadStore {
+	if p.uploads == nil {
+		return datastoreUploads{}
+	}
+
+	return p.uploads
+}
+
+// FindDuplicate returns the upload that has the content hashed to md5Hash with Duplicate set,
+// nil when filename is the first upload of it. Errors are logged and treated as no duplicate,
+// storing the file twice is better than failing the upload.
+func FindDuplicate(ctx context.Context, filename string, md5Hash string) *search_api.BlobInfo {
+	return findDuplicate(ctx, datastoreUploads{}, filename, md5Hash)
+}
+
+func findDuplicate(ctx context.Context, uploads uploadStore, filename string, md5Hash string) *search_api.BlobInfo {
+	existing, err := uploads.GetUploadByMD5(ctx, md5Hash)
+	if err != nil {
+		log.Errorf(ctx, "Error looking for duplicates of %s: %+v", filename, err)
+		return nil
+	}
+
+	if existing == nil {
+		return nil
+	}
+
+	log.Infof(ctx, "Upload %s is a duplicate of %s", filename, existing.BlobKey)
+	existing.Duplicate = true
+	return existing
+}
+
+// Store checks a file against the policy and stores it as the upload described by upload: its
+// Filename as the client sent it and, when they are known, its BlobKey, metadata and CreationTime.
+// The key is replaced when another upload has it. Images are stored without their metadata, see
+// imaging.Sanitize. A file that was already uploaded isn't stored again, the existing upload is
+// returned with Duplicate set.
+func (p *Policy) Store(ctx context.Context, upload *search_api.BlobInfo, data []byte) (*search_api.BlobInfo, *Rejection, error) {
+	head := data
+	if len(head) > SniffLength {
+		head = head[:SniffLength]
+	}
+
+	contentType := Sniff(head)
+	rejection := p.Allow(contentType, int64(len(data)))
+	if rejection == nil {
+		rejection = p.Scan(ctx, upload.Filename, bytes.NewReader(data))
+	}
+
+	if rejection != nil {
+		rejection.Filename = upload.Filename
+		return nil, rejection, nil
+	}
+
+	data, info, err := imaging.Sanitize(data)
+	if err == imaging.ErrUnsupportedFormat || err == imaging.ErrNotAnImage {
+		rejection = reject(ReasonTypeNotAllowed, "Images of type %s can't be stored without their metadata.", contentType)
+		rejection.Filename = upload.Filename
+		return nil, rejection, nil
+	}
+
+	if err == imaging.ErrImageTooLarge {
+		rejection = reject(ReasonTooLarge, "The image has more pixels than can be stored without its metadata.")
+		rejection.Filename = upload.Filename
+		return nil, rejection, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md5Hash := fmt.Sprintf("%x", md5.Sum(data))
+	if existing := findDuplicate(ctx, p.uploadStore(), upload.Filename, md5Hash); existing != nil {
+		return existing, nil, nil
+	}
+
+	stored := *upload
+	if stored.BlobKey == "" {
+		stored.BlobKey = NewKey()
+	} else if _, err := entities.GetUpload(ctx, stored.BlobKey); err == nil {
+		stored.BlobKey = NewKey()
+	}
+
+	if stored.CreationTime.IsZero() {
+		stored.CreationTime = time.Now()
+	}
+
+	stored.Filename = SanitizeFilename(upload.Filename)
+	stored.ObjectName = Prefix + stored.BlobKey + "/" + stored.Filename
+	stored.ContentType = contentType
+	stored.MD5 = md5Hash
+	stored.Size = int64(len(data))
+	stored.Width, stored.Height = 0, 0
+	if info != nil {
+		stored.Width = info.Width
+		stored.Height = info.Height
+	}
+
+	err = storage.PutObject(ctx, stored.ObjectName, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = entities.PutUpload(ctx, &stored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &stored, nil, nil
+}
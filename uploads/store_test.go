@@ -0,0 +1,113 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/jcarm010/kodimerce/imaging"
+	"github.com/jcarm010/kodimerce/search_api"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+type fakeUploads func(md5Hash string) (*search_api.BlobInfo, error)
+
+func (f fakeUploads) GetUploadByMD5(ctx context.Context, md5Hash string) (*search_api.BlobInfo, error) {
+	return f(md5Hash)
+}
+
+func TestFindDuplicate(t *testing.T) {
+	uploads := fakeUploads(func(md5Hash string) (*search_api.BlobInfo, error) {
+		switch md5Hash {
+		case "known":
+			return &search_api.BlobInfo{BlobKey: "key1", MD5: md5Hash}, nil
+		case "broken":
+			return nil, errors.New("datastore down")
+		default:
+			return nil, nil
+		}
+	})
+
+	ctx := context.Background()
+	existing := findDuplicate(ctx, uploads, "a.png", "known")
+	if existing == nil || existing.BlobKey != "key1" || !existing.Duplicate {
+		t.Errorf("got %+v, want key1 marked as a duplicate", existing)
+	}
+
+	if existing := findDuplicate(ctx, uploads, "a.png", "new"); existing != nil {
+		t.Errorf("got %+v for a new file", existing)
+	}
+
+	if existing := findDuplicate(ctx, uploads, "a.png", "broken"); existing != nil {
+		t.Errorf("got %+v when the lookup failed", existing)
+	}
+}
+
+func TestStoreReturnsDuplicate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 2, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+	uploads := fakeUploads(func(md5Hash string) (*search_api.BlobInfo, error) {
+		if md5Hash != want {
+			t.Errorf("looked up %s, want %s", md5Hash, want)
+		}
+
+		return &search_api.BlobInfo{BlobKey: "key1", MD5: md5Hash}, nil
+	})
+
+	policy := &Policy{AllowedTypes: DefaultAllowedTypes, MaxSizes: DefaultMaxSizes, uploads: uploads}
+	stored, rejection, err := policy.Store(context.Background(), &search_api.BlobInfo{Filename: "a.png"}, buf.Bytes())
+	if err != nil || rejection != nil {
+		t.Fatalf("got %v, %v", rejection, err)
+	}
+
+	if stored.BlobKey != "key1" || !stored.Duplicate {
+		t.Errorf("got %+v, want key1 marked as a duplicate", stored)
+	}
+}
+
+func TestStoreRejectsUnsanitizableImages(t *testing.T) {
+	policy := &Policy{AllowedTypes: DefaultAllowedTypes, MaxSizes: DefaultMaxSizes}
+	data := append([]byte("\x89PNG\r\n\x1a\n"), "not really a png"...)
+	stored, rejection, err := policy.Store(context.Background(), &search_api.BlobInfo{Filename: "a.png"}, data)
+	if err != nil || stored != nil {
+		t.Fatalf("got %+v, %v", stored, err)
+	}
+
+	if rejection == nil || rejection.Reason != ReasonTypeNotAllowed || rejection.Filename != "a.png" {
+		t.Errorf("got %+v", rejection)
+	}
+}
+
+func TestStoreRejectsImagesTooLargeToSanitize(t *testing.T) {
+	maxPixels := imaging.MaxPixels
+	t.Cleanup(func() {
+		imaging.MaxPixels = maxPixels
+	})
+
+	buf := &bytes.Buffer{}
+	err := gif.Encode(buf, image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black, color.White}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imaging.MaxPixels = 99
+	policy := &Policy{AllowedTypes: DefaultAllowedTypes, MaxSizes: DefaultMaxSizes}
+	stored, rejection, err := policy.Store(context.Background(), &search_api.BlobInfo{Filename: "a.gif"}, buf.Bytes())
+	if err != nil || stored != nil {
+		t.Fatalf("got %+v, %v", stored, err)
+	}
+
+	if rejection == nil || rejection.Reason != ReasonTooLarge || rejection.Filename != "a.gif" {
+		t.Errorf("got %+v", rejection)
+	}
+}
@@ -0,0 +1,275 @@
+// Package uploads decides which uploaded files are stored: their type is sniffed from their
+// bytes, checked against the allowed types and size limits, and they can be scanned for viruses.
+package uploads
+
+import (
+	"context"
+	"fmt"
+	"github.com/jcarm010/kodimerce/entities"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// SniffLength is how many bytes Sniff needs to recognize a file.
+	SniffLength = 512
+
+	MaxFilenameLength = 100
+
+	ReasonEmpty          = "empty"
+	ReasonTypeNotAllowed = "type_not_allowed"
+	ReasonTooLarge       = "too_large"
+	ReasonInfected       = "infected"
+	ReasonScanFailed     = "scan_failed"
+	ReasonStoreFailed    = "store_failed"
+)
+
+// DefaultAllowedTypes are accepted when no types are configured. SVG is left out, it can carry
+// scripts that run when it is opened.
+var DefaultAllowedTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"video/mp4",
+	"video/webm",
+	"audio/mpeg",
+}
+
+// DefaultMaxSizes are the limits when none are configured, in megabytes.
+var DefaultMaxSizes = []SizeLimit{
+	{Pattern: "image/*", Bytes: 20 << 20},
+	{Pattern: "video/*", Bytes: 100 << 20},
+	{Pattern: "*", Bytes: 10 << 20},
+}
+
+// Rejection is why a file wasn't stored. It is returned to the admin for each file.
+type Rejection struct {
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+func reject(reason string, format string, args ...interface{}) *Rejection {
+	return &Rejection{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// SizeLimit is the largest size of the files whose type matches Pattern, such as "image/*".
+type SizeLimit struct {
+	Pattern string
+	Bytes   int64
+}
+
+// Policy is what uploads must comply with.
+type Policy struct {
+	AllowedTypes []string // mime types, "type/*" allows every subtype
+	MaxSizes     []SizeLimit
+	Scanner      Scanner     // nil skips virus scans
+	uploads      uploadStore // nil looks the uploads up in the datastore
+}
+
+// NewPolicy reads the policy from the settings, see ParseTypes and ParseSizes for their format.
+func NewPolicy(settings *entities.ServerSettings) *Policy {
+	return &Policy{
+		AllowedTypes: ParseTypes(settings.UploadAllowedTypes),
+		MaxSizes:     ParseSizes(settings.UploadMaxSizes),
+		Scanner:      NewScanner(settings),
+	}
+}
+
+// ParseTypes reads a comma separated list of mime types, DefaultAllowedTypes when it is empty.
+func ParseTypes(list string) []string {
+	types := make([]string, 0)
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			types = append(types, field)
+		}
+	}
+
+	if len(types) == 0 {
+		return DefaultAllowedTypes
+	}
+
+	return types
+}
+
+// ParseSizes reads a comma separated list of limits in megabytes such as "image/*=20,*=10",
+// DefaultMaxSizes when there are no valid limits in it.
+func ParseSizes(list string) []SizeLimit {
+	limits := make([]SizeLimit, 0)
+	for _, field := range strings.Split(list, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		megabytes, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || megabytes <= 0 {
+			continue
+		}
+
+		limits = append(limits, SizeLimit{
+			Pattern: strings.ToLower(strings.TrimSpace(parts[0])),
+			Bytes:   int64(megabytes * (1 << 20)),
+		})
+	}
+
+	if len(limits) == 0 {
+		return DefaultMaxSizes
+	}
+
+	return limits
+}
+
+// Sniff returns the content type of a file from its first bytes, without parameters.
+func Sniff(head []byte) string {
+	contentType := http.DetectContentType(head)
+	return strings.TrimSpace(strings.Split(contentType, ";")[0])
+}
+
+// Allow checks a file of a sniffed content type and size against the allowed types and size
+// limits. The most specific limit applies: an exact type, then "type/*", then "*".
+func (p *Policy) Allow(contentType string, size int64) *Rejection {
+	if size == 0 {
+		return reject(ReasonEmpty, "The file is empty.")
+	}
+
+	allowed := false
+	for _, pattern := range p.AllowedTypes {
+		if matches(pattern, contentType) {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return reject(ReasonTypeNotAllowed, "Files of type %s are not allowed.", contentType)
+	}
+
+	var limit *SizeLimit
+	for index := range p.MaxSizes {
+		candidate := &p.MaxSizes[index]
+		if matches(candidate.Pattern, contentType) && (limit == nil || specificity(candidate.Pattern) > specificity(limit.Pattern)) {
+			limit = candidate
+		}
+	}
+
+	if limit != nil && size > limit.Bytes {
+		return reject(ReasonTooLarge, "The file is %s, files of type %s can be up to %s.", formatSize(size), contentType, formatSize(limit.Bytes))
+	}
+
+	return nil
+}
+
+// MaxSize is the largest file any type can have, so files can be read up to it before their type
+// is known.
+func (p *Policy) MaxSize() int64 {
+	var max int64
+	for _, limit := range p.MaxSizes {
+		if limit.Bytes > max {
+			max = limit.Bytes
+		}
+	}
+
+	return max
+}
+
+// Scan runs the virus scanner on a file. Files that can't be scanned are rejected too.
+func (p *Policy) Scan(ctx context.Context, filename string, r io.Reader) *Rejection {
+	if p.Scanner == nil {
+		return nil
+	}
+
+	signature, err := p.Scanner.Scan(ctx, filename, r)
+	if err != nil {
+		return reject(ReasonScanFailed, "The file could not be scanned for viruses.")
+	}
+
+	if signature != "" {
+		return reject(ReasonInfected, "The file contains a virus: %s.", signature)
+	}
+
+	return nil
+}
+
+func matches(pattern string, contentType string) bool {
+	switch {
+	case pattern == "*" || pattern == "*/*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == contentType
+	}
+}
+
+func specificity(pattern string) int {
+	switch {
+	case pattern == "*" || pattern == "*/*":
+		return 0
+	case strings.HasSuffix(pattern, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func formatSize(bytes int64) string {
+	if bytes >= 1<<20 {
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	}
+
+	return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+}
+
+// SanitizeFilename makes a client's file name safe to store and to put in urls: the directories
+// are dropped, the name is unicode normalized, spaces become dashes and anything that isn't a
+// letter, a digit, a dot, a dash or an underscore is removed. Letters with accents are kept.
+func SanitizeFilename(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	name = path.Base(name)
+	name = norm.NFC.String(name)
+
+	var b strings.Builder
+	lastDash := false
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_':
+			b.WriteRune(r)
+			lastDash = false
+		case (unicode.IsSpace(r) || r == '-') && !lastDash:
+			b.WriteRune('-')
+			lastDash = true
+		}
+	}
+
+	name = strings.Trim(b.String(), ".-_")
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if utf8.RuneCountInString(ext) > 10 {
+		ext = ""
+		base = name
+	}
+
+	if base == "" {
+		base = "file"
+	}
+
+	if maxBase := MaxFilenameLength - utf8.RuneCountInString(ext); utf8.RuneCountInString(base) > maxBase {
+		base = strings.TrimRight(string([]rune(base)[:maxBase]), ".-_")
+	}
+
+	return base + ext
+}
@@ -0,0 +1,100 @@
+package uploads
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAllow(t *testing.T) {
+	policy := &Policy{AllowedTypes: []string{"image/*", "application/pdf"}, MaxSizes: []SizeLimit{{Pattern: "image/png", Bytes: 10}, {Pattern: "*", Bytes: 100}}}
+	tests := []struct {
+		contentType string
+		size        int64
+		want        string
+	}{
+		{"image/png", 0, ReasonEmpty},
+		{"text/html", 5, ReasonTypeNotAllowed},
+		{"image/png", 11, ReasonTooLarge},
+		{"image/jpeg", 50, ""},
+		{"application/pdf", 101, ReasonTooLarge},
+	}
+
+	for _, test := range tests {
+		reason := ""
+		if rejection := policy.Allow(test.contentType, test.size); rejection != nil {
+			reason = rejection.Reason
+		}
+
+		if reason != test.want {
+			t.Errorf("Allow(%s, %d) = %q, want %q", test.contentType, test.size, reason, test.want)
+		}
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	policy := &Policy{MaxSizes: []SizeLimit{{Pattern: "image/*", Bytes: 30}, {Pattern: "*", Bytes: 10}}}
+	if got := policy.MaxSize(); got != 30 {
+		t.Errorf("got %d", got)
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	if got := ParseTypes(" Image/PNG, ,application/pdf"); !reflect.DeepEqual(got, []string{"image/png", "application/pdf"}) {
+		t.Errorf("got %v", got)
+	}
+
+	if got := ParseTypes(" , "); !reflect.DeepEqual(got, DefaultAllowedTypes) {
+		t.Errorf("got %v for an empty list", got)
+	}
+}
+
+func TestParseSizes(t *testing.T) {
+	got := ParseSizes("Image/*=20, *=0.5, video/*=abc, audio/*=-1, broken")
+	want := []SizeLimit{{Pattern: "image/*", Bytes: 20 << 20}, {Pattern: "*", Bytes: 512 << 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := ParseSizes("broken"); !reflect.DeepEqual(got, DefaultMaxSizes) {
+		t.Errorf("got %v without valid limits", got)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	tests := map[string]string{
+		"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR": "image/png",
+		"\xff\xd8\xff\xe0":                    "image/jpeg",
+		"%PDF-1.4":                            "application/pdf",
+		"<html><script>alert(1)</script>":     "text/html",
+		"plain words":                         "text/plain",
+	}
+
+	for head, want := range tests {
+		if got := Sniff([]byte(head)); got != want {
+			t.Errorf("Sniff(%q) = %s, want %s", head, got, want)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":                       "photo.jpg",
+		"../../etc/passwd":                "passwd",
+		`C:\Users\ana\My Photo.PNG`:       "My-Photo.PNG",
+		"  red  shirt -- final .jpg":      "red-shirt-final-.jpg",
+		"café<script>.png":                "caféscript.png",
+		"Cafe\u0301.png":                  "Café.png",
+		"...":                             "file",
+		"archive.verylongextension":       "archive.verylongextension",
+		".htaccess":                       "htaccess",
+		"a/b/?#%.gif":                     "gif",
+		strings.Repeat("a", 120) + ".jpg": strings.Repeat("a", 96) + ".jpg",
+	}
+
+	for name, want := range tests {
+		if got := SanitizeFilename(name); got != want {
+			t.Errorf("SanitizeFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
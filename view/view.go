@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/jcarm010/kodimerce/csrf"
 	"github.com/jcarm010/kodimerce/entities"
+	"github.com/jcarm010/kodimerce/imaging"
+	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/search_api"
 	"github.com/jcarm010/kodimerce/settings"
 	"golang.org/x/net/context"
 	"html/template"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -106,6 +111,7 @@ type View struct {
 	GoogleTagManagerId        string
 	FareHarborShortName       string
 	OgImagePath               string
+	CSRFToken                 string
 }
 
 func (v *View) GetBannerPath() string {
@@ -116,6 +122,57 @@ func (v *View) GetBannerPath() string {
 	return "/assets/images/og-banner.png"
 }
 
+// CSRFField renders a hidden input carrying the CSRF token for forms that post back to the server.
+func (v *View) CSRFField() template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, csrf.FormField, template.HTMLEscapeString(v.CSRFToken)))
+}
+
+// CSRFHead renders a meta tag carrying the CSRF token and a script that sends it in the
+// X-CSRF-Token header of the page's same origin fetch and XMLHttpRequest calls, such as the admin's
+// and the checkout's. Include it in the head of every page that changes data from scripts.
+func (v *View) CSRFHead() template.HTML {
+	return template.HTML(fmt.Sprintf(`<meta name="csrf-token" content="%s">`, template.HTMLEscapeString(v.CSRFToken)) +
+		fmt.Sprintf(csrfScript, csrf.CookieName, csrf.HeaderName, csrf.HeaderName))
+}
+
+// csrfScript reads the token from the cookie when it can, since logging in replaces it, and
+// falls back to the meta tag.
+const csrfScript = `<script>(function () {
+  function token() {
+    var match = document.cookie.match(/(?:^|;\s*)%s=([^;]*)/);
+    if (match) return decodeURIComponent(match[1]);
+    var meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : "";
+  }
+  function needsToken(method, url) {
+    method = (method || "GET").toUpperCase();
+    if (method === "GET" || method === "HEAD" || method === "OPTIONS") return false;
+    return new URL(url, location.href).origin === location.origin;
+  }
+  if (window.fetch) {
+    var fetch = window.fetch;
+    window.fetch = function (input, init) {
+      var request = typeof Request !== "undefined" && input instanceof Request;
+      init = init || {};
+      if (needsToken(init.method || (request ? input.method : "GET"), request ? input.url : String(input))) {
+        var headers = new Headers(init.headers || (request ? input.headers : undefined));
+        headers.set("%s", token());
+        init = Object.assign({}, init, {headers: headers});
+      }
+      return fetch.call(this, input, init);
+    };
+  }
+  var open = XMLHttpRequest.prototype.open, send = XMLHttpRequest.prototype.send;
+  XMLHttpRequest.prototype.open = function (method, url) {
+    this.kmCSRF = needsToken(method, url);
+    return open.apply(this, arguments);
+  };
+  XMLHttpRequest.prototype.send = function () {
+    if (this.kmCSRF) this.setRequestHeader("%s", token());
+    return send.apply(this, arguments);
+  };
+})();</script>`
+
 func (v *View) CurrentYear() string {
 	return fmt.Sprintf("%v", time.Now().Year())
 }
@@ -144,6 +201,91 @@ func (v *View) FullUrl(u string) string {
 	return FullUrl(u, v.Request)
 }
 
+// Srcset lists the variants of an uploaded image in the configured widths, for the srcset
+// attribute of img tags. Images that aren't uploads are listed as they are.
+func (v *View) Srcset(src string) template.Srcset {
+	if !isUploadUrl(src) {
+		return template.Srcset(src)
+	}
+
+	widths := imaging.ParseWidths(v.ServerSettings.ImageWidths)
+	candidates := make([]string, len(widths))
+	for index, width := range widths {
+		candidates[index] = fmt.Sprintf("%s %vw", v.ImageUrl(src, width), width)
+	}
+
+	return template.Srcset(strings.Join(candidates, ", "))
+}
+
+// ImageUrl is the url of the variant of an uploaded image closest to width, in the configured
+// format. Images that aren't uploads are returned as they are.
+func (v *View) ImageUrl(src string, width int) string {
+	u, err := url.Parse(src)
+	if err != nil || !isUploadUrl(src) {
+		return src
+	}
+
+	format := v.ServerSettings.ImageFormat
+	if format == "" {
+		format = imaging.FormatAuto
+	}
+
+	q := u.Query()
+	q.Set("w", strconv.Itoa(width))
+	q.Set("fmt", format)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Alt is the alt text of an image: alt when it is given, otherwise the alt text or title of the
+// upload in the media library, so pictures used without alt text still have one.
+func (v *View) Alt(src string, alt string) string {
+	if strings.TrimSpace(alt) != "" || !isUploadUrl(src) || v.Request == nil {
+		return alt
+	}
+
+	key, name := uploadOf(src)
+	blob, err := search_api.NewClient(v.Request.Context()).FindBlob(key, name)
+	if err != nil {
+		log.Errorf(v.Request.Context(), "Error finding upload %s: %+v", src, err)
+		return alt
+	}
+
+	if blob == nil {
+		return alt
+	}
+
+	if blob.Alt != "" {
+		return blob.Alt
+	}
+
+	return blob.Title
+}
+
+// uploadOf returns the key of the upload an url serves, or its file name for urls by name.
+func uploadOf(src string) (key string, name string) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", ""
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(u.Path, "/gallery/upload"), "/")
+	if strings.HasPrefix(path, "name/") {
+		return "", strings.TrimPrefix(path, "name/")
+	}
+
+	if path != "" {
+		return path, ""
+	}
+
+	return u.Query().Get("k"), ""
+}
+
+func isUploadUrl(src string) bool {
+	u, err := url.Parse(src)
+	return err == nil && strings.HasPrefix(u.Path, "/gallery/upload")
+}
+
 func DateTimeFormat(d time.Time) string {
 	return d.Format("2006-01-02T15:04:05-07:00")
 }
@@ -157,7 +299,7 @@ func DateTimeFormatHTMLAttr(d time.Time) template.HTMLAttr {
 }
 
 func FullUrl(u string, r *http.Request) string {
-	log.Printf("Url U: %s", u)
+	log.Debugf(r.Context(), "Url U: %s", u)
 	var newUrl = u
 	if strings.HasPrefix(u, "/") {
 		newUrl = settings.ServerUrl(r) + u
@@ -205,6 +347,7 @@ func NewView(request *http.Request, title string, metaDescription string, ctx co
 		GoogleAnalyticsAccountId:  globalSettings.GoogleAnalyticsAccountId,
 		GoogleTagManagerId:        globalSettings.GoogleTagManagerId,
 		FareHarborShortName:       globalSettings.FareHarborShortName,
+		CSRFToken:                 csrf.TokenFromContext(ctx),
 	}
 }
 
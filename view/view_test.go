@@ -0,0 +1,70 @@
+package view
+
+import (
+	"context"
+	"github.com/jcarm010/kodimerce/search_api"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadOf(t *testing.T) {
+	tests := []struct {
+		src, key, name string
+	}{
+		{"/gallery/upload/abc123", "abc123", ""},
+		{"/gallery/upload/name/red-shoes.jpg?w=300", "", "red-shoes.jpg"},
+		{"/gallery/upload?k=abc123", "abc123", ""},
+		{"https://shop.com/gallery/upload/abc123?fmt=webp", "abc123", ""},
+	}
+
+	for _, test := range tests {
+		key, name := uploadOf(test.src)
+		if key != test.key || name != test.name {
+			t.Errorf("uploadOf(%s) = %q, %q", test.src, key, name)
+		}
+	}
+}
+
+func TestAlt(t *testing.T) {
+	search_api.SetLoader(func(ctx context.Context) ([]*search_api.BlobInfo, error) {
+		return []*search_api.BlobInfo{
+			{BlobKey: "with-alt", Filename: "a.jpg", Alt: "Red shoes", Title: "Shoes"},
+			{BlobKey: "with-title", Filename: "b.jpg", Title: "Blue shirt"},
+		}, nil
+	})
+
+	if err := search_api.NewClient(context.Background()).Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &View{Request: httptest.NewRequest("GET", "/", nil)}
+	tests := []struct {
+		src, alt, want string
+	}{
+		{"/gallery/upload/with-alt", "", "Red shoes"},
+		{"/gallery/upload/name/b.jpg", " ", "Blue shirt"},
+		{"/gallery/upload/with-alt", "Given", "Given"},
+		{"/gallery/upload/missing", "", ""},
+		{"/assets/logo.png", "", ""},
+	}
+
+	for _, test := range tests {
+		if got := v.Alt(test.src, test.alt); got != test.want {
+			t.Errorf("Alt(%s, %q) = %q, want %q", test.src, test.alt, got, test.want)
+		}
+	}
+}
+
+func TestCSRFHead(t *testing.T) {
+	head := string((&View{CSRFToken: `a"b`}).CSRFHead())
+	if !strings.HasPrefix(head, `<meta name="csrf-token" content="a&#34;b"><script>`) {
+		t.Errorf("got %s", head)
+	}
+
+	for _, want := range []string{`km-csrf=([^;]*)`, `headers.set("X-CSRF-Token", token())`, `this.setRequestHeader("X-CSRF-Token", token())`} {
+		if !strings.Contains(head, want) {
+			t.Errorf("missing %s in %s", want, head)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"github.com/jcarm010/kodimerce/entities"
 	"github.com/jcarm010/kodimerce/km"
 	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/recovery"
 	"github.com/jcarm010/kodimerce/view"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type CheckoutStep struct {
@@ -54,6 +56,16 @@ func RenderCheckoutView(c *km.ServerContext, w web.ResponseWriter, r *web.Reques
 		return
 	}
 
+	// the link in abandoned checkout reminders is signed so clicks can be told apart from regular visits
+	signature := r.URL.Query().Get("recovery")
+	if signature != "" && order.RecoveryClickedDate.IsZero() && recovery.VerifyOrder(c.Settings.SigningSecret, orderId, signature) {
+		order.RecoveryClickedDate = time.Now()
+		err = entities.RecordRecoveryClick(c.Context, orderId, order.RecoveryClickedDate)
+		if err != nil && err != entities.ErrOrderNotStarted {
+			log.Errorf(c.Context, "Error recording recovery click of order[%v]: %+v", orderId, err)
+		}
+	}
+
 	stepName := r.PathParams["step"]
 	if stepName == "" {
 		stepName = "shipinfo"
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"github.com/gocraft/web"
 	"github.com/jcarm010/feeds"
+	"github.com/jcarm010/kodimerce/contentsearch"
 	"github.com/jcarm010/kodimerce/entities"
 	"github.com/jcarm010/kodimerce/km"
 	"github.com/jcarm010/kodimerce/log"
+	"github.com/jcarm010/kodimerce/productsearch"
+	"github.com/jcarm010/kodimerce/search"
 	"github.com/jcarm010/kodimerce/settings"
 	"github.com/jcarm010/kodimerce/view"
 	"golang.org/x/net/context"
@@ -117,7 +120,7 @@ func ProductView(c *km.ServerContext, w web.ResponseWriter, r *web.Request) {
 		log.Infof(c.Context, "Id is not a number, checking for product name.")
 		selectedProduct, err = entities.GetProductByPath(c.Context, productIdStr)
 	} else {
-		log.Infof(c.Context, "Querying productId: %s", productId)
+		log.Infof(c.Context, "Querying productId: %v", productId)
 		selectedProduct, err = entities.GetProduct(c.Context, productId)
 	}
 
@@ -157,7 +160,7 @@ func ProductView(c *km.ServerContext, w web.ResponseWriter, r *web.Request) {
 
 	p.View.OgImagePath = selectedProduct.Thumbnail
 	log.Debugf(c.Context, "CanonicalUrl: %s", p.CanonicalUrl)
-	log.Debugf(c.Context, "ProductSettings: %s", p.ProductSettings)
+	log.Debugf(c.Context, "ProductSettings: %+v", p.ProductSettings)
 	if !productFound {
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -256,6 +259,99 @@ func StoreView(c *km.ServerContext, w web.ResponseWriter, r *web.Request) {
 	c.ServeHTMLTemplate("store-page", p)
 }
 
+// SearchView renders the product search results with their facets, see productsearch.ParseOptions
+// for the parameters, along with the posts, pages and galleries matching q. Content results are
+// paged with content_cursor and narrowed with type.
+func SearchView(c *km.ServerContext, w web.ResponseWriter, r *web.Request) {
+	globalSettings := settings.GetGlobalSettings(c.Context)
+	query := r.URL.Query()
+	options, err := productsearch.ParseOptions(query)
+	if err != nil {
+		c.ServeHTML(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contentOptions, err := contentsearch.ParseOptions(query)
+	if err != nil {
+		c.ServeHTML(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contentOptions.Cursor = query.Get("content_cursor")
+	results, err := productsearch.Search(c.Context, options)
+	if err == search.ErrInvalidCursor {
+		c.ServeHTML(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error searching products: %+v", err)
+		c.ServeHTML(http.StatusInternalServerError, "Unexpected error, please try again later.")
+		return
+	}
+
+	contentResults, err := contentsearch.Search(c.Context, contentOptions)
+	if err == search.ErrInvalidCursor {
+		c.ServeHTML(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		log.Errorf(c.Context, "Error searching content: %+v", err)
+		c.ServeHTML(http.StatusInternalServerError, "Unexpected error, please try again later.")
+		return
+	}
+
+	for index, product := range results.Products {
+		if (index+1)%4 == 0 {
+			product.Last = true
+		}
+	}
+
+	nextUrl := searchPageUrl(r, "cursor", results.Cursor)
+	nextContentUrl := searchPageUrl(r, "content_cursor", contentResults.Cursor)
+
+	title := "Search | " + globalSettings.CompanyName
+	if options.Text != "" {
+		title = options.Text + " | " + globalSettings.CompanyName
+	}
+
+	p := struct {
+		*view.View
+		Options        *productsearch.Options
+		Results        *productsearch.Results
+		Products       []*entities.Product
+		NextUrl        string
+		ContentOptions *contentsearch.Options
+		Content        *contentsearch.Results
+		NextContentUrl string
+		Domain         string
+	}{
+		View:           c.NewView(title, globalSettings.MetaDescriptionStore),
+		Options:        options,
+		Results:        results,
+		Products:       results.Products,
+		NextUrl:        nextUrl,
+		ContentOptions: contentOptions,
+		Content:        contentResults,
+		NextContentUrl: nextContentUrl,
+		Domain:         settings.ServerUrl(r.Request),
+	}
+
+	c.ServeHTMLTemplate("search-page", p)
+}
+
+// searchPageUrl is the url of the search page with a cursor set, empty when there's no cursor.
+func searchPageUrl(r *web.Request, cursorParam string, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+
+	query := r.URL.Query()
+	query.Set(cursorParam, cursor)
+	return "/search?" + query.Encode()
+}
+
 func AdminView(c *km.AdminContext, w web.ResponseWriter, r *web.Request) {
 	globalSettings := settings.GetGlobalSettings(c.Context)
 	log.Infof(c.Context, "Serving admin path: %s", r.URL.Path)
@@ -341,15 +437,23 @@ func OrderReviewView(c *km.ServerContext, w web.ResponseWriter, r *web.Request)
 		return
 	}
 
+	shipments, err := entities.ListShipments(c.Context, orderId)
+	if err != nil {
+		log.Errorf(c.Context, "Error getting shipments: %+v", err)
+		shipments = make([]*entities.Shipment, 0)
+	}
+
 	log.Infof(c.Context, "Rendering orderId[%v] order[%+v]", orderId, order)
 
 	c.ServeHTMLTemplate("order-review-page", struct {
 		*view.View
 		Order      *entities.Order
+		Shipments  []*entities.Shipment
 		TaxPercent float64
 	}{
 		View:       c.NewView("Order Details | "+globalSettings.CompanyName, ""),
 		Order:      order,
+		Shipments:  shipments,
 		TaxPercent: globalSettings.TaxPercent,
 	})
 }
@@ -0,0 +1,22 @@
+package views
+
+import (
+	"github.com/gocraft/web"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchPageUrl(t *testing.T) {
+	r := &web.Request{Request: httptest.NewRequest("GET", "/search?q=red+mug&cursor=old&type=post", nil)}
+	if got := searchPageUrl(r, "cursor", "next"); got != "/search?cursor=next&q=red+mug&type=post" {
+		t.Errorf("got %s", got)
+	}
+
+	if got := searchPageUrl(r, "content_cursor", "c2"); got != "/search?content_cursor=c2&cursor=old&q=red+mug&type=post" {
+		t.Errorf("got %s", got)
+	}
+
+	if got := searchPageUrl(r, "cursor", ""); got != "" {
+		t.Errorf("got %s on the last page", got)
+	}
+}